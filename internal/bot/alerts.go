@@ -0,0 +1,227 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"log"
+)
+
+const (
+	alertBatchSize   = 100 // property events processed per check
+	alertMaxAttempts = 5   // failed attempts before a property event is given up on
+)
+
+// searchPreferencesFromSaved converts saved preferences to the format expected by searchProperties.
+func searchPreferencesFromSaved(prefs database.UserPreferences) *SearchPreferences {
+	return &SearchPreferences{
+		PropertyTypes:    prefs.PropertyTypes,
+		MinBedrooms:      prefs.MinBedrooms,
+		MaxBedrooms:      prefs.MaxBedrooms,
+		FurnishedOptions: prefs.Furnished,
+		PriceRange:       priceRange{Min: prefs.MinPrice, Max: prefs.MaxPrice}.String(),
+		Location:         prefs.Location,
+		ListingKind:      prefs.ListingKind,
+		MoveInDate:       prefs.MoveInDate,
+		TenancyMonths:    prefs.TenancyMonths,
+	}
+}
+
+// processPropertyEvents alerts users about added and updated properties that match their saved search.
+// An event is only marked as processed once every user has been checked, so events that fail are
+// retried at the next check, up to alertMaxAttempts times. A failing event is logged and recorded,
+// and doesn't hold up the events after it. Users are never alerted about the same property twice.
+func (b *Bot) processPropertyEvents(ctx context.Context) error {
+	events, err := database.GetPendingPropertyEvents(ctx, b.db, alertBatchSize)
+	if err != nil {
+		return fmt.Errorf("error getting property events: %w", err)
+	}
+	if len(events) == 0 {
+		return nil
+	}
+
+	subscribers, err := database.GetAlertSubscribers(ctx, b.db)
+	if err != nil {
+		return fmt.Errorf("error getting alert subscribers: %w", err)
+	}
+
+	for _, event := range events {
+		if err := b.processPropertyEvent(ctx, event, subscribers); err != nil {
+			log.Printf("Error processing property events: %v", err)
+			if err := database.RecordPropertyEventFailure(ctx, b.db, event.ID, err.Error(), alertMaxAttempts); err != nil {
+				return fmt.Errorf("error recording the failure of event %d: %w", event.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+// processPropertyEvent tells the users who saved the property in an event about the change, then alerts
+// the other subscribers whose saved search it matches. Who to alert is worked out before the transaction,
+// which only records the deliveries, writes the alerts to the outbox and marks the event as processed,
+// so the alerts are delivered if and only if the event was processed.
+func (b *Bot) processPropertyEvent(ctx context.Context, event database.PropertyEvent, subscribers []database.UserPreferences) error {
+	var watchers, matching []int64
+	property, err := database.GetProperty(ctx, b.db, event.PropertyID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		// The property was removed before anyone could be alerted
+	case err != nil:
+		return fmt.Errorf("error processing event %d: %w", event.ID, err)
+	default:
+		watchers, err = database.GetListingWatchers(ctx, b.db, property.ID)
+		if err != nil {
+			return fmt.Errorf("error processing event %d: %w", event.ID, err)
+		}
+		matching, err = b.matchingSubscribers(ctx, property, subscribers, watchers)
+		if err != nil {
+			return fmt.Errorf("error processing event %d: %w", event.ID, err)
+		}
+	}
+
+	return b.inTransaction(ctx, func(tx *sql.Tx) error {
+		if err := b.alertWatchers(ctx, tx, event, property, watchers); err != nil {
+			return fmt.Errorf("error processing event %d: %w", event.ID, err)
+		}
+		if err := b.alertSubscribers(ctx, tx, event, property, matching); err != nil {
+			return fmt.Errorf("error processing event %d: %w", event.ID, err)
+		}
+		if err := database.MarkPropertyEventProcessed(ctx, tx, event.ID); err != nil {
+			return fmt.Errorf("error marking event %d as processed: %w", event.ID, err)
+		}
+		return nil
+	})
+}
+
+// matchingSubscribers returns the subscribers whose saved search a property matches.
+// Subscribers who saved the property are left out, as alertWatchers tells them about it.
+func (b *Bot) matchingSubscribers(ctx context.Context, property database.Property, subscribers []database.UserPreferences, watchers []int64) ([]int64, error) {
+	watching := make(map[int64]bool, len(watchers))
+	for _, userID := range watchers {
+		watching[userID] = true
+	}
+
+	var matching []int64
+	for _, subscriber := range subscribers {
+		if watching[subscriber.UserID] {
+			continue
+		}
+		matches, err := b.matchesSavedSearch(ctx, subscriber, property.ID)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			matching = append(matching, subscriber.UserID)
+		}
+	}
+	return matching, nil
+}
+
+// alertSubscribers sends the property in an event to the subscribers whose saved search it matches.
+// Subscribers who were already alerted about the property are told if its price dropped or it was let.
+func (b *Bot) alertSubscribers(ctx context.Context, tx database.Execer, event database.PropertyEvent, property database.Property, userIDs []int64) error {
+	for _, userID := range userIDs {
+		isNew, err := database.RecordAlertDelivery(ctx, tx, userID, property.ID)
+		if err != nil {
+			return err
+		}
+		updated := event.Kind == database.PropertyUpdated
+		switch {
+		case isNew:
+			err = b.sendAlert(ctx, tx, userID, property, database.NotifyNewMatch, "🔔 New property matching your saved search!")
+		case updated && property.PricePerMonth < event.OldPrice:
+			err = b.sendAlert(ctx, tx, userID, property, database.NotifyPriceDrop,
+				fmt.Sprintf("💷 Price drop from £%d to £%d on a property matching your saved search!", event.OldPrice, property.PricePerMonth))
+		case updated && property.Status == database.StatusLetAgreed && event.OldStatus != database.StatusLetAgreed:
+			err = b.sendAlert(ctx, tx, userID, property, database.NotifyLetAgreed, "📌 A property matching your saved search has been let.")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchesSavedSearch reports whether a property matches a user's saved search, using the same
+// filters as a search. Listings the user has hidden never match. Let and withdrawn listings only
+// match if the user was already alerted about them, so they're told it was let but never sent it as new.
+func (b *Bot) matchesSavedSearch(ctx context.Context, prefs database.UserPreferences, propertyID int) (bool, error) {
+	filters := b.buildFilters(searchPreferencesFromSaved(prefs))
+	delete(filters, "listed_only")
+	filters["id"] = propertyID
+	filters["exclude_hidden_for"] = prefs.UserID
+	filters["listed_or_alerted_for"] = prefs.UserID
+
+	properties, err := database.GetProperties(ctx, b.db, filters)
+	if err != nil {
+		return false, err
+	}
+	return len(properties) > 0, nil
+}
+
+// sendAlert sends a property to a user with a headline saying why, if their notification settings allow it.
+func (b *Bot) sendAlert(ctx context.Context, tx database.Execer, userID int64, property database.Property, category, headline string) error {
+	message, keyboard := b.presentProperty(property, false)
+
+	msg := tgbotapi.NewMessage(userID, headline+"\n\n"+message)
+	msg.ParseMode = "HTML"
+	msg.ReplyMarkup = keyboard
+	sent, err := b.notify(ctx, tx, msg, category)
+	if err != nil {
+		return err
+	}
+	if sent {
+		if err := database.RecordImpression(ctx, tx, userID, property.ID); err != nil {
+			log.Printf("Error recording impression of property %d: %v", property.ID, err)
+		}
+	}
+	return nil
+}
+
+// handleAlertsCommand shows whether alerts are on for the user's saved search, with a button to change it.
+func (b *Bot) handleAlertsCommand(ctx context.Context, message *tgbotapi.Message) {
+	prefs, err := database.GetUserPreferences(ctx, b.db, message.From.ID)
+	if err != nil {
+		b.sendMessage(message.Chat.ID, "Alerts are sent for your saved search. Use /search and then /save_preferences to save one first.", nil)
+		return
+	}
+
+	text := fmt.Sprintf("Alerts for new properties matching your saved preferences are %s.", formatAlertsEnabled(prefs.AlertsEnabled))
+	button := tgbotapi.NewInlineKeyboardButtonData("Turn alerts off", callbackData("alerts", "off"))
+	if !prefs.AlertsEnabled {
+		button = tgbotapi.NewInlineKeyboardButtonData("Turn alerts on", callbackData("alerts", "on"))
+	}
+	b.sendMessage(message.Chat.ID, text, tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(button)))
+}
+
+// setAlertsEnabled turns alerts for the user's saved search on or off and confirms the change.
+func (b *Bot) setAlertsEnabled(ctx context.Context, chatID, userID int64, enabled bool) {
+	err := database.SetAlertsEnabled(ctx, b.db, userID, enabled)
+	if errors.Is(err, sql.ErrNoRows) {
+		b.sendMessage(chatID, "You don't have a saved search. Use /save_preferences to save one first.", nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating alerts for user %d: %v", userID, err)
+		b.sendMessage(chatID, "Sorry, there was an error updating your alerts. Please try again.", nil)
+		return
+	}
+
+	if enabled {
+		b.sendMessage(chatID, "🔔 Alerts are on. I'll let you know when a new property matches your saved preferences.", nil)
+	} else {
+		b.sendMessage(chatID, "🔕 Alerts are off. Use /alerts to turn them back on.", nil)
+	}
+}
+
+// formatAlertsEnabled describes the alert setting.
+func formatAlertsEnabled(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,255 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"strings"
+	"testing"
+	"time"
+)
+
+// propertyEventColumns are the columns selected for property events
+var propertyEventColumns = []string{"id", "property_id", "kind", "old_price", "old_status"}
+
+// TestProcessPropertyEvents tests that a new property is sent to subscribers whose saved search it matches,
+// and only to users who haven't been alerted about it already
+func TestProcessPropertyEvents(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{
+		api:   mockAPI,
+		db:    db,
+		state: make(map[int64]*UserState),
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM property_events WHERE processed_at IS NULL").
+		WillReturnRows(sqlmock.NewRows(propertyEventColumns).AddRow(10, 7, "added", nil, nil))
+	mock.ExpectQuery("SELECT (.+) FROM user_preferences WHERE alerts_enabled = 1").
+		WillReturnRows(sqlmock.NewRows(userPreferencesColumns).
+			AddRow(101, "{}", 0, 1500, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true).
+			AddRow(102, "{}", 0, 800, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true).
+			AddRow(103, "{}", 0, 1500, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true))
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE id = ?").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(propertyRowColumns).
+			AddRow(7, "Apartment", 1200, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available"))
+	mock.ExpectQuery("SELECT user_id FROM saved_listings").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+
+	matching := func() *sqlmock.Rows {
+		return sqlmock.NewRows(propertyRowColumns).
+			AddRow(7, "Apartment", 1200, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
+	}
+
+	// Users 101 and 103 match, but user 102's budget is too low
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE 1=1 AND id = ?").WithArgs(7, 1500, "Bath", int64(101), int64(101)).WillReturnRows(matching())
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE 1=1 AND id = ?").WithArgs(7, 800, "Bath", int64(102), int64(102)).WillReturnRows(sqlmock.NewRows(propertyRowColumns))
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE 1=1 AND id = ?").WithArgs(7, 1500, "Bath", int64(103), int64(103)).WillReturnRows(matching())
+
+	// The transaction only records the deliveries and writes the alerts
+	mock.ExpectBegin()
+	// User 101 hasn't been alerted yet
+	mock.ExpectExec("INSERT OR IGNORE INTO alert_deliveries").WithArgs(int64(101), 7).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(101)).WillReturnError(sql.ErrNoRows)
+	alert := expectOutboxMessage(mock, 101, "new_match")
+	mock.ExpectExec("INSERT INTO listing_impressions").WithArgs(int64(101), 7).WillReturnResult(sqlmock.NewResult(1, 1))
+	// User 103 was already alerted about the property
+	mock.ExpectExec("INSERT OR IGNORE INTO alert_deliveries").WithArgs(int64(103), 7).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	mock.ExpectExec("UPDATE property_events SET processed_at").WithArgs(10).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := bot.processPropertyEvents(ctx); err != nil {
+		t.Fatalf("processPropertyEvents() returned an error: %v", err)
+	}
+
+	if len(mockAPI.messages) != 0 {
+		t.Errorf("Expected alerts to be left for the outbox to deliver, got %+v", mockAPI.messages)
+	}
+	if text := alert.String(); !strings.Contains(text, "🔔 New property") || !strings.Contains(text, "Nice apartment") {
+		t.Errorf("Unexpected alert: %q", text)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestProcessPropertyEventsRemovedProperty tests that events for removed properties are skipped
+func TestProcessPropertyEventsRemovedProperty(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db}
+
+	mock.ExpectQuery("SELECT (.+) FROM property_events WHERE processed_at IS NULL").
+		WillReturnRows(sqlmock.NewRows(propertyEventColumns).AddRow(11, 8, "updated", 1300, "available"))
+	mock.ExpectQuery("SELECT (.+) FROM user_preferences WHERE alerts_enabled = 1").
+		WillReturnRows(sqlmock.NewRows(userPreferencesColumns).
+			AddRow(101, "{}", 0, 1500, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true))
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE id = ?").WithArgs(8).WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE property_events SET processed_at").WithArgs(11).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := bot.processPropertyEvents(ctx); err != nil {
+		t.Fatalf("processPropertyEvents() returned an error: %v", err)
+	}
+	if len(mockAPI.messages) != 0 {
+		t.Errorf("Expected no alerts, got %d", len(mockAPI.messages))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestProcessPropertyEventsFailure tests that an event that fails is recorded and the events after it are still processed
+func TestProcessPropertyEventsFailure(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	bot := &Bot{api: &MockBotAPI2{}, db: db}
+
+	mock.ExpectQuery("SELECT (.+) FROM property_events WHERE processed_at IS NULL").
+		WillReturnRows(sqlmock.NewRows(propertyEventColumns).AddRow(11, 8, "added", nil, nil).AddRow(12, 9, "added", nil, nil))
+	mock.ExpectQuery("SELECT (.+) FROM user_preferences WHERE alerts_enabled = 1").
+		WillReturnRows(sqlmock.NewRows(userPreferencesColumns))
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE id = ?").WithArgs(8).WillReturnError(errors.New("malformed row"))
+	mock.ExpectExec("UPDATE property_events SET attempts = attempts \\+ 1").
+		WithArgs(sqlmock.AnyArg(), alertMaxAttempts, 11).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE id = ?").WithArgs(9).WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE property_events SET processed_at").WithArgs(12).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := bot.processPropertyEvents(ctx); err != nil {
+		t.Fatalf("processPropertyEvents() returned an error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestProcessPropertyEventsPriceDrop tests that subscribers already alerted about a property are told when its price drops
+func TestProcessPropertyEventsPriceDrop(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db}
+	property := func() *sqlmock.Rows {
+		return sqlmock.NewRows(propertyRowColumns).
+			AddRow(7, "Apartment", 1200, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM property_events WHERE processed_at IS NULL").
+		WillReturnRows(sqlmock.NewRows(propertyEventColumns).AddRow(12, 7, "updated", 1300, "available"))
+	mock.ExpectQuery("SELECT (.+) FROM user_preferences WHERE alerts_enabled = 1").
+		WillReturnRows(sqlmock.NewRows(userPreferencesColumns).
+			AddRow(101, "{}", 0, 1500, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true))
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE id = ?").WithArgs(7).WillReturnRows(property())
+	mock.ExpectQuery("SELECT user_id FROM saved_listings").WithArgs(7).WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE 1=1 AND id = ?").WithArgs(7, 1500, "Bath", int64(101), int64(101)).WillReturnRows(property())
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT OR IGNORE INTO alert_deliveries").WithArgs(int64(101), 7).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(101)).WillReturnError(sql.ErrNoRows)
+	alert := expectOutboxMessage(mock, 101, "price_drop")
+	mock.ExpectExec("INSERT INTO listing_impressions").WithArgs(int64(101), 7).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE property_events SET processed_at").WithArgs(12).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := bot.processPropertyEvents(ctx); err != nil {
+		t.Fatalf("processPropertyEvents() returned an error: %v", err)
+	}
+	if !strings.Contains(alert.String(), "Price drop from £1300 to £1200") {
+		t.Errorf("Expected a price drop alert, got %q", alert.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestHandleAlertsCommand tests that the /alerts command offers to change the current setting
+func TestHandleAlertsCommand(t *testing.T) {
+	ctx := context.Background()
+	testCases := []struct {
+		name           string
+		alertsEnabled  bool
+		expectedText   string
+		expectedButton string
+	}{
+		{"Alerts on", true, "are on", callbackData("alerts", "off")},
+		{"Alerts off", false, "are off", callbackData("alerts", "on")},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			defer db.Close()
+
+			mockAPI := &MockBotAPI2{}
+			bot := &Bot{api: mockAPI, db: db}
+
+			mock.ExpectQuery("SELECT (.+) FROM user_preferences").WithArgs(int64(123)).
+				WillReturnRows(sqlmock.NewRows(userPreferencesColumns).
+					AddRow(123, "{}", 0, 1500, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), tc.alertsEnabled))
+
+			bot.handleAlertsCommand(ctx, &tgbotapi.Message{
+				Chat: &tgbotapi.Chat{ID: 123},
+				From: &tgbotapi.User{ID: 123},
+			})
+
+			if len(mockAPI.messages) != 1 {
+				t.Fatalf("Expected 1 message, got %d", len(mockAPI.messages))
+			}
+			msg := mockAPI.messages[0]
+			keyboard := msg.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+			if !strings.Contains(msg.Text, tc.expectedText) || *keyboard.InlineKeyboard[0][0].CallbackData != tc.expectedButton {
+				t.Errorf("Unexpected message %q with button %q", msg.Text, *keyboard.InlineKeyboard[0][0].CallbackData)
+			}
+		})
+	}
+}
+
+// TestSetAlertsEnabledWithoutSavedSearch tests turning alerts on before saving a search
+func TestSetAlertsEnabledWithoutSavedSearch(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db}
+
+	mock.ExpectExec("UPDATE user_preferences SET alerts_enabled").WithArgs(true, int64(123)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	bot.setAlertsEnabled(ctx, 123, 123, true)
+
+	if len(mockAPI.messages) != 1 || !strings.Contains(mockAPI.messages[0].Text, "/save_preferences") {
+		t.Errorf("Expected to be asked to save a search first, got %+v", mockAPI.messages)
+	}
+}
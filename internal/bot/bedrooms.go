@@ -0,0 +1,139 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// bedroomOptions are the bedroom counts offered by the bedroom keyboard, in display order.
+// The last option is open-ended, so "5+" means five or more bedrooms.
+var bedroomOptions = []string{"Studio", "1", "2", "3", "4", "5+"}
+
+// bedroomSelect is the bedroom keyboard.
+func bedroomSelect() multiSelect {
+	m := multiSelect{Action: "bedrooms", Columns: 3}
+	for _, option := range bedroomOptions {
+		m.Options = append(m.Options, selectOption{Value: option, Label: option})
+	}
+	return m
+}
+
+// bedroomSelection is the bedroom range in preferences. The options within the range are selected.
+type bedroomSelection struct {
+	preferences *SearchPreferences
+}
+
+// Selected reports whether the option is within the range.
+func (s bedroomSelection) Selected(option string) bool {
+	return isBedroomOptionSelected(s.preferences, option)
+}
+
+// Toggle changes the range after the user taps an option.
+func (s bedroomSelection) Toggle(option string) error {
+	return selectBedrooms(s.preferences, option)
+}
+
+// Set selects every bedroom count, or clears the range.
+func (s bedroomSelection) Set(options []string, selected bool) {
+	s.preferences.MinBedrooms, s.preferences.MaxBedrooms = nil, nil
+	if selected {
+		s.preferences.MinBedrooms = intPtr(0)
+	}
+}
+
+// parseBedroomOption converts a bedroom option such as "Studio", "2" or "5+"
+// into a bedroom count and whether the option is open-ended.
+func parseBedroomOption(option string) (int, bool, error) {
+	if option == "Studio" {
+		return 0, false, nil
+	}
+	openEnded := strings.HasSuffix(option, "+")
+	count, err := strconv.Atoi(strings.TrimSuffix(option, "+"))
+	if err != nil || count < 0 {
+		return 0, false, fmt.Errorf("invalid bedroom option %q", option)
+	}
+	return count, openEnded, nil
+}
+
+// selectBedrooms updates the bedroom range in preferences after the user taps an option.
+// The first tap selects a single count and a tap outside the current range extends it.
+// A tap inside the range starts a new selection, and tapping the only selected count, or "5+" on its own, clears it.
+// Open-ended options such as "5+" remove the upper bound.
+func selectBedrooms(preferences *SearchPreferences, option string) error {
+	count, openEnded, err := parseBedroomOption(option)
+	if err != nil {
+		return err
+	}
+
+	min, max := preferences.MinBedrooms, preferences.MaxBedrooms
+	switch {
+	case min == nil:
+		preferences.MinBedrooms = intPtr(count)
+		preferences.MaxBedrooms = bedroomUpperBound(count, openEnded)
+	case count < *min:
+		preferences.MinBedrooms = intPtr(count)
+	case openEnded && max != nil:
+		preferences.MaxBedrooms = nil
+	case !openEnded && max != nil && count > *max:
+		preferences.MaxBedrooms = intPtr(count)
+	case max != nil && *min == count && *max == count,
+		openEnded && max == nil && *min == count:
+		preferences.MinBedrooms = nil
+		preferences.MaxBedrooms = nil
+	default:
+		preferences.MinBedrooms = intPtr(count)
+		preferences.MaxBedrooms = bedroomUpperBound(count, openEnded)
+	}
+	return nil
+}
+
+// bedroomUpperBound returns the upper bound for a single selected count.
+func bedroomUpperBound(count int, openEnded bool) *int {
+	if openEnded {
+		return nil
+	}
+	return intPtr(count)
+}
+
+// isBedroomOptionSelected reports whether a bedroom option falls within the selected range.
+func isBedroomOptionSelected(preferences *SearchPreferences, option string) bool {
+	count, openEnded, err := parseBedroomOption(option)
+	if err != nil || preferences.MinBedrooms == nil {
+		return false
+	}
+	if openEnded {
+		return preferences.MaxBedrooms == nil
+	}
+	return count >= *preferences.MinBedrooms &&
+		(preferences.MaxBedrooms == nil || count <= *preferences.MaxBedrooms)
+}
+
+// formatBedroomRange returns a human-readable bedroom range, e.g. "Studio", "2-3" or "3+".
+func formatBedroomRange(min, max *int) string {
+	switch {
+	case min == nil && max == nil, max == nil && *min == 0:
+		return "Any"
+	case min == nil:
+		return "up to " + bedroomLabel(*max)
+	case max == nil:
+		return fmt.Sprintf("%d+", *min)
+	case *min == *max:
+		return bedroomLabel(*min)
+	}
+	return bedroomLabel(*min) + "-" + bedroomLabel(*max)
+}
+
+// bedroomLabel returns the display label for a bedroom count.
+func bedroomLabel(count int) string {
+	if count == 0 {
+		return "Studio"
+	}
+	return strconv.Itoa(count)
+}
+
+// intPtr returns a pointer to a copy of v.
+func intPtr(v int) *int {
+	return &v
+}
@@ -0,0 +1,75 @@
+package bot
+
+import (
+	"testing"
+)
+
+// TestSelectBedrooms tests building a bedroom range from a sequence of taps
+func TestSelectBedrooms(t *testing.T) {
+	testCases := []struct {
+		name    string
+		taps    []string
+		want    string
+		wantMin *int
+		wantMax *int
+	}{
+		{"Single option", []string{"2"}, "2", intPtr(2), intPtr(2)},
+		{"Studio", []string{"Studio"}, "Studio", intPtr(0), intPtr(0)},
+		{"Range", []string{"2", "4"}, "2-4", intPtr(2), intPtr(4)},
+		{"Range tapped backwards", []string{"4", "1"}, "1-4", intPtr(1), intPtr(4)},
+		{"Open-ended option", []string{"5+"}, "5+", intPtr(5), nil},
+		{"Open-ended range", []string{"3", "5+"}, "3+", intPtr(3), nil},
+		{"Tap inside range restarts", []string{"1", "4", "2"}, "2", intPtr(2), intPtr(2)},
+		{"Tap selected option clears", []string{"3", "3"}, "Any", nil, nil},
+		{"Tap open-ended option clears", []string{"5+", "5+"}, "Any", nil, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			prefs := NewFlexibleSearchPreferences()
+			for _, tap := range tc.taps {
+				if err := selectBedrooms(prefs, tap); err != nil {
+					t.Fatalf("selectBedrooms(%s) returned an error: %v", tap, err)
+				}
+			}
+
+			if !equalIntPtr(prefs.MinBedrooms, tc.wantMin) || !equalIntPtr(prefs.MaxBedrooms, tc.wantMax) {
+				t.Errorf("Unexpected bedroom range: %v - %v", prefs.MinBedrooms, prefs.MaxBedrooms)
+			}
+			if got := formatBedroomRange(prefs.MinBedrooms, prefs.MaxBedrooms); got != tc.want {
+				t.Errorf("formatBedroomRange() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// TestSelectBedroomsInvalidOption tests that unknown options are rejected
+func TestSelectBedroomsInvalidOption(t *testing.T) {
+	prefs := NewFlexibleSearchPreferences()
+	if err := selectBedrooms(prefs, "lots"); err == nil {
+		t.Error("selectBedrooms() did not return an error for an invalid option")
+	}
+	if prefs.MinBedrooms != nil || prefs.MaxBedrooms != nil {
+		t.Error("selectBedrooms() changed the range for an invalid option")
+	}
+}
+
+// TestIsBedroomOptionSelected tests which keyboard options are highlighted for a range
+func TestIsBedroomOptionSelected(t *testing.T) {
+	prefs := &SearchPreferences{MinBedrooms: intPtr(3)}
+
+	expected := map[string]bool{"Studio": false, "1": false, "2": false, "3": true, "4": true, "5+": true}
+	for option, want := range expected {
+		if got := isBedroomOptionSelected(prefs, option); got != want {
+			t.Errorf("isBedroomOptionSelected(%s) = %v, want %v", option, got, want)
+		}
+	}
+}
+
+// equalIntPtr is a helper function to compare optional integers
+func equalIntPtr(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
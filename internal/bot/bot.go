@@ -2,10 +2,18 @@
 package bot
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"imitation_project/internal/dispatch"
+	"imitation_project/internal/scheduler"
+	"imitation_project/internal/sendqueue"
 	"log"
+	"strings"
 	"sync"
+	"time"
 )
 
 // BotAPI is an interface that wraps the methods we use from tgbotapi.BotAPI
@@ -13,66 +21,309 @@ type BotAPI interface {
 	GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.UpdatesChannel
 	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
 	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+	StopReceivingUpdates()
+}
+
+// shutdownTimeout is how long the bot waits for work in progress to finish when it's stopped.
+const shutdownTimeout = 20 * time.Second
+
+// queuedAPI sends requests through the rate-limited send queue, and gets updates from the API directly.
+type queuedAPI struct {
+	BotAPI
+	queue *sendqueue.Queue
+}
+
+func (a queuedAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return a.queue.Send(c)
+}
+
+func (a queuedAPI) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	return a.queue.Request(c)
 }
 
 // Bot represents the Telegram bot instance.
 // It handles user interactions, maintains user states,
 // and interfaces with the database for property searches.
 type Bot struct {
-	api         BotAPI
-	db          *sql.DB
-	state       map[int64]*UserState
-	mu          sync.Mutex
-	botUserName string
+	api           BotAPI
+	db            *sql.DB
+	state         map[int64]*UserState
+	mu            sync.Mutex
+	botUserName   string
+	propertyTypes []database.PropertyType
+
+	// moveInToleranceDays is how many days after the move-in date a property may become available
+	moveInToleranceDays int
+	// relaxationStrategy is the order in which filters are relaxed, or nil for the default order
+	relaxationStrategy []string
+	// outboxPausedUntil is when Telegram's rate limit lets the outbox deliver again. Only the outbox job uses it.
+	outboxPausedUntil time.Time
+	// broadcastAPI sends notifications behind interactive replies, or is nil to send them with api
+	broadcastAPI sendqueue.API
+	// dispatcher handles each user's updates in order, one at a time, so they don't race on the user's state
+	dispatcher *dispatch.Dispatcher
+	// admins get reports of handler panics, and aren't flood limited
+	admins map[int64]bool
+	// blockedUsers' updates are ignored
+	blockedUsers map[int64]bool
+	// flood limits how many updates each user may send
+	flood floodGuard
+	// handlerMetrics times each kind of update
+	handlerMetrics handlerMetrics
 }
 
 // SearchPreferences represents the user's search criteria for properties.
 // It encapsulates various options that a user can select to filter their property search.
 type SearchPreferences struct {
 	PropertyTypes    map[string]bool
-	BedroomOptions   map[string]bool
+	MinBedrooms      *int // nil means no lower bound
+	MaxBedrooms      *int // nil means no upper bound, as in "3+"
 	FurnishedOptions map[string]bool
 	PriceRange       string
 	Location         string
+	ListingKind      string    // database.ListingWhole, database.ListingRoom or empty for either
+	MoveInDate       time.Time // zero means flexible
+	TenancyMonths    int       // zero means any tenancy length
+	Keywords         []string  // words to look for in descriptions, from the /search arguments
+	Near             string    // landmark key to measure distances from, or empty for the city centre
+	NewOnly          bool      // only show listings the user hasn't been shown before
 }
 
 // UserState represents the current state of a user's interaction with the bot.
 type UserState struct {
 	Stage       string
 	Preferences *SearchPreferences
+	// RelaxedResults holds results found with relaxed filters until the user accepts or declines them
+	RelaxedResults *searchResults
+	// Browser is the user's cursor through their latest search results
+	Browser *resultBrowser
+	// DigestDraft holds the digest the user is choosing until it is saved
+	DigestDraft *database.DigestSubscription
 }
 
 // New creates a new instance of the Bot.
 // It takes a Telegram bot token as input and returns a new Bot instance and any error encountered.
-func New(api BotAPI, db *sql.DB, botUserName string) *Bot {
+func New(ctx context.Context, api BotAPI, db *sql.DB, botUserName string) *Bot {
+	propertyTypes, err := database.GetPropertyTypes(ctx, db)
+	if err != nil {
+		log.Printf("Error loading property types, using defaults: %v", err)
+	}
+
 	return &Bot{
-		api:         api,
-		db:          db,
-		state:       make(map[int64]*UserState),
-		botUserName: botUserName,
+		api:                 api,
+		db:                  db,
+		state:               make(map[int64]*UserState),
+		botUserName:         botUserName,
+		propertyTypes:       propertyTypes,
+		moveInToleranceDays: defaultMoveInToleranceDays,
+	}
+}
+
+// SetMoveInTolerance sets how many days after the requested move-in date
+// a property may become available and still be shown.
+func (b *Bot) SetMoveInTolerance(days int) {
+	b.moveInToleranceDays = days
+}
+
+// SetAdmins sets the users who are told when a handler panics, and who aren't flood limited.
+func (b *Bot) SetAdmins(userIDs []int64) {
+	b.admins = make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		b.admins[id] = true
+	}
+}
+
+// SetBlockedUsers sets the users whose updates the bot ignores.
+func (b *Bot) SetBlockedUsers(userIDs []int64) {
+	b.blockedUsers = make(map[int64]bool, len(userIDs))
+	for _, id := range userIDs {
+		b.blockedUsers[id] = true
 	}
 }
 
-// Start begins the bot's operation.
-// It sets up the update channel and enters the main event loop to process updates.
-func (b *Bot) Start() {
+// Start begins the bot's operation using long polling. It handles updates until ctx is cancelled,
+// then shuts down as described for run.
+func (b *Bot) Start(ctx context.Context) {
 	log.Printf("Authorised an account %s", b.botUserName)
 
 	u := tgbotapi.NewUpdate(0)
 	u.Timeout = 60
 
 	updates := b.api.GetUpdatesChan(u)
+	b.run(ctx, updates, b.api.StopReceivingUpdates)
+}
+
+// run handles updates, and runs the background jobs, until ctx is cancelled. It then stops taking updates
+// with stopUpdates, handles the ones already received and waits up to shutdownTimeout for the updates being
+// handled. What's left in the outbox is delivered in that time too, before run returns.
+func (b *Bot) run(ctx context.Context, updates <-chan tgbotapi.Update, stopUpdates func()) {
+	// Work that has started may finish after ctx is cancelled, until the shutdown deadline
+	work, cancelWork := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancelWork()
+
+	queue := sendqueue.New(b.api, sendqueue.DefaultLimits)
+	queueDone := make(chan struct{})
+	go func() {
+		queue.Run(work)
+		close(queueDone)
+	}()
+	b.broadcastAPI = queue.Broadcaster()
+	b.api = queuedAPI{BotAPI: b.api, queue: queue}
+	b.dispatcher = dispatch.New(b.pipeline(), dispatch.DefaultWorkers, dispatch.DefaultQueueSize)
+
+	jobs := scheduler.New(b.db, scheduler.SystemClock{})
+	if err := b.scheduleJobs(ctx, jobs); err != nil {
+		log.Printf("Error scheduling background jobs: %v", err)
+	}
+	jobsDone := make(chan struct{})
+	go func() {
+		jobs.Run(ctx)
+		close(jobsDone)
+	}()
 
-	// Main event loop
-	for update := range updates {
-		if update.Message != nil {
-			go b.handleMessage(update.Message)
-		} else if update.CallbackQuery != nil {
-			go b.handleCallbackQuery(update.CallbackQuery)
+	b.serve(ctx, work, updates)
+	log.Printf("Shutting down")
+	// The deadline covers the whole shutdown, as draining waits while the dispatcher is full
+	deadline := time.AfterFunc(shutdownTimeout, cancelWork)
+	defer deadline.Stop()
+	stopUpdates()
+	b.drain(work, updates)
+	b.dispatcher.Close()
+
+	if !waitUntil(work, b.dispatcher.Wait) {
+		log.Printf("Stopped waiting for updates still being handled after %v", shutdownTimeout)
+	}
+	<-jobsDone
+	if err := b.flushOutbox(work); err != nil {
+		log.Printf("Error delivering the outbox before shutting down: %v", err)
+	}
+
+	cancelWork()
+	<-queueDone
+}
+
+// waitUntil calls wait, and reports whether it returned before ctx was done.
+func waitUntil(ctx context.Context, wait func()) bool {
+	done := make(chan struct{})
+	go func() {
+		wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// serve is the main event loop. It dispatches each update to be handled with the work context,
+// until ctx is cancelled or the channel is closed. When the dispatcher is full, it waits for room.
+func (b *Bot) serve(ctx, work context.Context, updates <-chan tgbotapi.Update) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			b.dispatch(work, update)
 		}
 	}
 }
 
+// drain handles the updates that were received before the bot stopped taking them. Telegram won't send them again.
+func (b *Bot) drain(work context.Context, updates <-chan tgbotapi.Update) {
+	for {
+		select {
+		case update, ok := <-updates:
+			if !ok {
+				return
+			}
+			b.dispatch(work, update)
+		default:
+			return
+		}
+	}
+}
+
+// dispatch queues an update to be handled after the earlier ones from the same user.
+func (b *Bot) dispatch(ctx context.Context, update tgbotapi.Update) {
+	if err := b.dispatcher.Dispatch(ctx, updateKey(update), update); err != nil {
+		log.Printf("Dropped update %d: %v", update.UpdateID, err)
+	}
+}
+
+// updateKey returns the user an update is from, as the updates from a user share their state and must
+// be handled one at a time. Updates without a user, such as channel posts, are keyed by their chat.
+func updateKey(update tgbotapi.Update) int64 {
+	if user := update.SentFrom(); user != nil {
+		return user.ID
+	}
+	if chat := updateChat(update); chat != nil {
+		return chat.ID
+	}
+	return 0
+}
+
+// updateChat returns the chat an update is from, or nil if it has none. Unlike Update.FromChat, it allows
+// for button presses on messages too old for Telegram to include.
+func updateChat(update tgbotapi.Update) *tgbotapi.Chat {
+	if update.CallbackQuery != nil {
+		if update.CallbackQuery.Message == nil {
+			return nil
+		}
+		return update.CallbackQuery.Message.Chat
+	}
+	return update.FromChat()
+}
+
+// logUpdateStats logs how busy the dispatcher is, so operators can tell when updates wait too long,
+// and how long each kind of update takes to handle.
+func (b *Bot) logUpdateStats() error {
+	for _, line := range b.updateStats() {
+		log.Print(line)
+	}
+	return nil
+}
+
+// updateStats describes how busy the dispatcher is and how long each kind of update takes, one line each.
+func (b *Bot) updateStats() []string {
+	var lines []string
+	if b.dispatcher != nil {
+		stats := b.dispatcher.Stats()
+		lines = append(lines, fmt.Sprintf("Updates: %d handled, %d queued, %d being handled, %d dispatches throttled, waited %v on average and %v at most",
+			stats.Handled, stats.Queued, stats.Handling, stats.Throttled, stats.AverageQueueTime(), stats.MaxQueue))
+	}
+	for _, t := range b.handlerMetrics.snapshot() {
+		lines = append(lines, fmt.Sprintf("Handler %s: %d handled, %d panicked, took %v on average and %v at most",
+			t.Kind, t.Count, t.Panics, t.Total/time.Duration(t.Count), t.Max))
+	}
+	return lines
+}
+
+// handleStatsCommand processes the /stats command, which shows admins how busy the bot is.
+func (b *Bot) handleStatsCommand(ctx context.Context, message *tgbotapi.Message) {
+	lines := b.updateStats()
+	if len(lines) == 0 {
+		lines = []string{"No updates handled yet."}
+	}
+	msg := tgbotapi.NewMessage(message.Chat.ID, strings.Join(lines, "\n"))
+	if _, err := b.api.Send(msg); err != nil {
+		log.Printf("Error sending stats: %v", err)
+	}
+}
+
+// handleUpdate handles a message or a button press, however the update was received.
+func (b *Bot) handleUpdate(ctx context.Context, update tgbotapi.Update) {
+	if update.Message != nil {
+		b.handleMessage(ctx, update.Message)
+	} else if update.CallbackQuery != nil {
+		b.handleCallbackQuery(ctx, update.CallbackQuery)
+	}
+}
+
 // getUserState retrieves the current state for a user.
 func (b *Bot) getUserState(userID int64) *UserState {
 	b.mu.Lock()
@@ -98,34 +349,11 @@ func (b *Bot) updateUserState(userID int64, state *UserState) {
 
 // handleMessage processes incoming messages.
 // It distinguishes between commands and regular messages and routes them accordingly.
-func (b *Bot) handleMessage(message *tgbotapi.Message) {
+func (b *Bot) handleMessage(ctx context.Context, message *tgbotapi.Message) {
 	if message.IsCommand() {
-		b.handleCommand(message)
+		b.handleCommand(ctx, message)
 	} else {
-		b.handleRegularMessage(message)
-	}
-}
-
-// handleCommand processes bot commands.
-// It routes different commands to their respective handler functions.
-func (b *Bot) handleCommand(message *tgbotapi.Message) {
-	switch message.Command() {
-	case "start":
-		b.handleStartCommand(message)
-	case "help":
-		b.handleHelpCommand(message)
-	case "search":
-		b.handleSearchCommand(message)
-	case "save_preferences":
-		b.handleSavePreferences(message)
-	case "view_preferences":
-		b.handleViewPreferences(message)
-	case "clear_preferences":
-		b.handleClearPreferences(message)
-	case "saved":
-		b.handleViewSavedListings(message)
-	default:
-		b.sendMessage(message.Chat.ID, "Unknown command. Type /help for available commands.", nil)
+		b.handleRegularMessage(ctx, message)
 	}
 }
 
@@ -142,42 +370,10 @@ func (b *Bot) sendMessage(chatID int64, text string, markup interface{}) {
 }
 
 // NewFlexibleSearchPreferences creates and returns a new SearchPreferences struct.
-// It initializes the PropertyTypes, BedroomOptions, and FurnishedOptions maps.
+// It initializes the PropertyTypes and FurnishedOptions maps.
 func NewFlexibleSearchPreferences() *SearchPreferences {
 	return &SearchPreferences{
 		PropertyTypes:    make(map[string]bool),
-		BedroomOptions:   make(map[string]bool),
 		FurnishedOptions: make(map[string]bool),
 	}
 }
-
-// createMultiSelectKeyboard generates an inline keyboard markup for Telegram bot.
-// It creates a keyboard with multiple selectable options and a "Done" button.
-func createMultiSelectKeyboard(options map[string]bool, prefix string) tgbotapi.InlineKeyboardMarkup {
-	var keyboard [][]tgbotapi.InlineKeyboardButton
-
-	for option, selected := range options {
-		var text string
-		if selected {
-			text = "✅ " + option
-		} else {
-			text = "☐ " + option
-		}
-		button := tgbotapi.NewInlineKeyboardButtonData(text, prefix+":"+option)
-		keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(button))
-	}
-
-	doneButton := tgbotapi.NewInlineKeyboardButtonData("Done", prefix+":done")
-	keyboard = append(keyboard, tgbotapi.NewInlineKeyboardRow(doneButton))
-
-	return tgbotapi.NewInlineKeyboardMarkup(keyboard...)
-}
-
-// updateMultiSelectOption toggles the selected state of an option in a multi-select map.
-// If the option was previously selected, it becomes unselected, and vice versa.
-func updateMultiSelectOption(options map[string]bool, option string) {
-	if options == nil {
-		options = make(map[string]bool)
-	}
-	options[option] = !options[option]
-}
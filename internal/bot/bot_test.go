@@ -1,13 +1,25 @@
 package bot
 
 import (
+	"context"
 	"github.com/DATA-DOG/go-sqlmock"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	_ "github.com/mattn/go-sqlite3"
+	"imitation_project/internal/database"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
+// propertyRowColumns are the columns returned by property queries
+var propertyRowColumns = []string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link",
+	"listing_kind", "parent_property_id", "bills_included", "housemates", "ensuite", "shared_facilities",
+	"available_from", "min_tenancy_months", "max_tenancy_months", "latitude", "longitude", "status"}
+
+// userPreferencesColumns are the columns returned by user preference queries
+var userPreferencesColumns = []string{"user_id", "property_type", "min_price", "max_price", "min_bedrooms", "max_bedrooms", "furnished", "location", "listing_kind", "move_in_date", "tenancy_months", "last_search", "alerts_enabled"}
+
 // MockBotAPI is a mock implementation of the BotAPI interface
 type MockBotAPI struct{}
 
@@ -16,6 +28,9 @@ func (m *MockBotAPI) GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.Updat
 	return make(tgbotapi.UpdatesChannel)
 }
 
+// StopReceivingUpdates mocks the method to stop getting updates
+func (m *MockBotAPI) StopReceivingUpdates() {}
+
 // Send mocks the method to send messages
 func (m *MockBotAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
 	return tgbotapi.Message{}, nil
@@ -28,6 +43,7 @@ func (m *MockBotAPI) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error
 
 // TestNew tests the New function that creates a new Bot instance
 func TestNew(t *testing.T) {
+	ctx := context.Background()
 	db, _, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -36,26 +52,26 @@ func TestNew(t *testing.T) {
 
 	mockAPI := &MockBotAPI{}
 	botUserName := "testbot"
-	b := New(mockAPI, db, botUserName)
+	b := New(ctx, mockAPI, db, botUserName)
 
 	if b == nil {
-		t.Error("New() returned nil")
+		t.Error("New(ctx) returned nil")
 	}
 
 	if b.api != mockAPI {
-		t.Error("New() did not set the api field correctly")
+		t.Error("New(ctx) did not set the api field correctly")
 	}
 
 	if b.db != db {
-		t.Error("New() did not set the db field correctly")
+		t.Error("New(ctx) did not set the db field correctly")
 	}
 
 	if b.state == nil {
-		t.Error("New() did not initialize the state map")
+		t.Error("New(ctx) did not initialize the state map")
 	}
 
 	if b.botUserName != botUserName {
-		t.Errorf("New() did not set the botUserName correctly. Got %s, want %s", b.botUserName, botUserName)
+		t.Errorf("New(ctx) did not set the botUserName correctly. Got %s, want %s", b.botUserName, botUserName)
 	}
 }
 
@@ -212,10 +228,8 @@ func TestAskPriceRange(t *testing.T) {
 	}
 }
 
-// TestValidatePriceRange tests the validatePriceRange method
+// TestValidatePriceRange tests that typed price ranges are accepted or rejected by parsePrice
 func TestValidatePriceRange(t *testing.T) {
-	bot := &Bot{}
-
 	// Test cases for price range validation
 	testCases := []struct {
 		input    string
@@ -223,7 +237,7 @@ func TestValidatePriceRange(t *testing.T) {
 	}{
 		{"1000 - 2000", true},
 		{"500-1500", true},
-		{"1000", false},
+		{"1000", true},
 		{"1000 - ", false},
 		{" - 2000", false},
 		{"abc - def", false},
@@ -231,9 +245,9 @@ func TestValidatePriceRange(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		result := bot.validatePriceRange(tc.input)
-		if result != tc.expected {
-			t.Errorf("validatePriceRange(%s) = %v, want %v", tc.input, result, tc.expected)
+		_, err := parsePrice(tc.input)
+		if result := err == nil; result != tc.expected {
+			t.Errorf("parsePrice(%s) valid = %v, want %v", tc.input, result, tc.expected)
 		}
 	}
 }
@@ -339,3 +353,75 @@ func TestAskLocation(t *testing.T) {
 		t.Errorf("Expected user state stage to be 'awaiting_location', but got '%s'", state.Stage)
 	}
 }
+
+// TestRunShutsDown tests that stopping the bot stops it taking updates, handles the updates already
+// received and delivers the outbox before returning
+func TestRunShutsDown(t *testing.T) {
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialise database: %v", err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	message := database.OutboxMessage{UserID: 456, Category: "new_match", Text: "🔔 New property"}
+	if err := database.AddOutboxMessage(ctx, db, message, time.Now()); err != nil {
+		t.Fatalf("Failed to add outbox message: %v", err)
+	}
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db, state: make(map[int64]*UserState)}
+	updates := make(chan tgbotapi.Update, 1)
+	updates <- tgbotapi.Update{Message: &tgbotapi.Message{
+		Text:     "/help",
+		Chat:     &tgbotapi.Chat{ID: 123},
+		From:     &tgbotapi.User{ID: 123},
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: 5}},
+	}}
+	cancel()
+
+	stopped := false
+	done := make(chan struct{})
+	go func() {
+		bot.run(ctx, updates, func() { stopped = true })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected run to return once the bot was stopped")
+	}
+
+	if !stopped {
+		t.Error("Expected the bot to stop taking updates")
+	}
+	if !mockAPI.MessageSent(123, "/search") {
+		t.Error("Expected the update received before stopping to be handled")
+	}
+	if !mockAPI.MessageSent(456, "New property") {
+		t.Error("Expected the outbox to be delivered before stopping")
+	}
+	if due, err := database.GetDueOutboxMessages(context.Background(), db, time.Now(), 10); err != nil || len(due) != 0 {
+		t.Errorf("Expected the outbox to be empty, got %+v, %v", due, err)
+	}
+}
+
+// TestUpdateKey tests that updates are keyed by the user they're from, or by their chat
+func TestUpdateKey(t *testing.T) {
+	testCases := []struct {
+		name     string
+		update   tgbotapi.Update
+		expected int64
+	}{
+		{"Message", tgbotapi.Update{Message: &tgbotapi.Message{From: &tgbotapi.User{ID: 7}, Chat: &tgbotapi.Chat{ID: -100}}}, 7},
+		{"Button press", tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{From: &tgbotapi.User{ID: 8}}}, 8},
+		{"Channel post", tgbotapi.Update{ChannelPost: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: -200}}}, -200},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if key := updateKey(tc.update); key != tc.expected {
+				t.Errorf("Expected key %d, got %d", tc.expected, key)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"log"
+	"time"
+)
+
+// resultBrowser is a user's cursor through their search results. The results are shown
+// one property at a time in a single message, which is edited in place as the user pages through.
+type resultBrowser struct {
+	// ID identifies the search, so buttons on the messages of earlier searches can be recognised
+	ID        int64
+	Results   searchResults
+	Index     int
+	MessageID int
+	// Saved holds the IDs of the properties saved while browsing
+	Saved map[int]bool
+}
+
+// newResultBrowser starts browsing the results from the first property.
+func newResultBrowser(results searchResults) *resultBrowser {
+	return &resultBrowser{
+		ID:      time.Now().UnixNano(),
+		Results: results,
+		Saved:   make(map[int]bool),
+	}
+}
+
+// current returns the property on the current page.
+func (r *resultBrowser) current() database.Property {
+	return r.Results.Properties[r.Index]
+}
+
+// moveTo moves the cursor to the given page, keeping it within the results.
+func (r *resultBrowser) moveTo(index int) {
+	r.Index = max(0, min(index, len(r.Results.Properties)-1))
+}
+
+// remove drops a property from the results, for example when the user hides it.
+// The cursor stays on the same page, which now shows the next property.
+func (r *resultBrowser) remove(propertyID int) {
+	for i, p := range r.Results.Properties {
+		if p.ID == propertyID {
+			r.Results.Properties = append(r.Results.Properties[:i:i], r.Results.Properties[i+1:]...)
+			break
+		}
+	}
+	r.moveTo(r.Index)
+}
+
+// pageData builds the callback data for a navigation button.
+func (r *resultBrowser) pageData(index int) string {
+	return callbackData("page", r.ID, index)
+}
+
+// browserFor returns the user's result browser if the message is the one showing it.
+func (s *UserState) browserFor(messageID int) *resultBrowser {
+	if s.Browser == nil || s.Browser.MessageID != messageID {
+		return nil
+	}
+	return s.Browser
+}
+
+// renderBrowserPage formats the current property with its buttons and the navigation buttons.
+func (b *Bot) renderBrowserPage(browser *resultBrowser) (string, tgbotapi.InlineKeyboardMarkup) {
+	prop := browser.current()
+	message, keyboard := b.presentProperty(prop, false)
+	message = decorateCard(message, prop, &browser.Results)
+
+	if browser.Saved[prop.ID] {
+		keyboard.InlineKeyboard[0][0] = tgbotapi.NewInlineKeyboardButtonData("Saved ✅", callbackData("noop"))
+	}
+	if len(prop.PhotoURLs) > 0 {
+		keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("📷 Photos (%d)", len(prop.PhotoURLs)), callbackData("photos", prop.ID)),
+		))
+	}
+
+	total := len(browser.Results.Properties)
+	var navigation []tgbotapi.InlineKeyboardButton
+	if browser.Index > 0 {
+		if total > 2 {
+			navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("⏮", browser.pageData(0)))
+		}
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("◀ Previous", browser.pageData(browser.Index-1)))
+	}
+	navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d of %d", browser.Index+1, total), callbackData("noop")))
+	if browser.Index < total-1 {
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("Next ▶", browser.pageData(browser.Index+1)))
+		if total > 2 {
+			navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("⏭", browser.pageData(total-1)))
+		}
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, navigation)
+
+	return message, keyboard
+}
+
+// showBrowserPage edits the result message to show the current page.
+func (b *Bot) showBrowserPage(ctx context.Context, chatID int64, browser *resultBrowser) {
+	if len(browser.Results.Properties) == 0 {
+		edit := tgbotapi.NewEditMessageText(chatID, browser.MessageID, "There are no more results to show. Use /search to search again.")
+		if _, err := b.api.Send(edit); err != nil {
+			log.Printf("Error updating results message: %v", err)
+		}
+		return
+	}
+
+	message, keyboard := b.renderBrowserPage(browser)
+	edit := tgbotapi.NewEditMessageText(chatID, browser.MessageID, message)
+	edit.ParseMode = "HTML"
+	edit.ReplyMarkup = &keyboard
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Error updating results message: %v", err)
+		return
+	}
+	b.recordImpression(ctx, chatID, browser.current().ID)
+}
+
+// handlePageCallback moves the user's result browser to the requested page.
+// Buttons on the results of an earlier search no longer work, as the user has newer results.
+func (b *Bot) handlePageCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	browser := state.browserFor(query.Message.MessageID)
+	if browser == nil || browser.ID != args.Int64("search") {
+		return "These results are out of date. Use /search to search again."
+	}
+
+	browser.moveTo(args.Int("index"))
+	b.showBrowserPage(ctx, query.Message.Chat.ID, browser)
+	return ""
+}
+
+// sendPropertyPhotos sends a property's photos as an album.
+func (b *Bot) sendPropertyPhotos(ctx context.Context, chatID int64, propertyID int) {
+	prop, err := database.GetProperty(ctx, b.db, propertyID)
+	if err != nil {
+		log.Printf("Error getting property %d: %v", propertyID, err)
+		b.sendMessage(chatID, "Sorry, there was an error getting the photos. Please try again later.", nil)
+		return
+	}
+	if len(prop.PhotoURLs) == 0 {
+		b.sendMessage(chatID, "There are no photos of this property.", nil)
+		return
+	}
+
+	media := make([]interface{}, len(prop.PhotoURLs))
+	for i, photoURL := range prop.PhotoURLs {
+		media[i] = tgbotapi.NewInputMediaPhoto(tgbotapi.FileURL(photoURL))
+	}
+	if _, err := b.api.Send(tgbotapi.NewMediaGroup(chatID, media)); err != nil {
+		log.Printf("Error sending media group: %v", err)
+	}
+}
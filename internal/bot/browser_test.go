@@ -0,0 +1,161 @@
+package bot
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"strings"
+	"testing"
+)
+
+// newTestBrowser returns a bot with a user browsing three results in message 456
+func newTestBrowser(t *testing.T) (*Bot, *MockBotAPI2, sqlmock.Sqlmock, *resultBrowser) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{
+		api:   mockAPI,
+		db:    db,
+		state: make(map[int64]*UserState),
+	}
+
+	browser := newResultBrowser(searchResults{Properties: []database.Property{
+		{ID: 1, Type: "flat", PricePerMonth: 1000, Location: "Widcombe"},
+		{ID: 2, Type: "flat", PricePerMonth: 1100, Location: "Larkhall"},
+		{ID: 3, Type: "flat", PricePerMonth: 1200, Location: "Oldfield Park"},
+	}})
+	browser.MessageID = 456
+	bot.state[123] = &UserState{Stage: "initial", Browser: browser}
+
+	return bot, mockAPI, mock, browser
+}
+
+// browserCallback builds a callback query from the results message
+func browserCallback(data string, messageID int) *tgbotapi.CallbackQuery {
+	return &tgbotapi.CallbackQuery{
+		ID:      "query_id",
+		Data:    data,
+		From:    &tgbotapi.User{ID: 123},
+		Message: &tgbotapi.Message{MessageID: messageID, Chat: &tgbotapi.Chat{ID: 123}},
+	}
+}
+
+// TestBrowserNavigation tests that paging edits the results message in place
+func TestBrowserNavigation(t *testing.T) {
+	ctx := context.Background()
+	bot, mockAPI, mock, browser := newTestBrowser(t)
+	mock.ExpectExec("INSERT INTO listing_impressions").WithArgs(int64(123), 3).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	bot.handleCallbackQuery(ctx, browserCallback(browser.pageData(2), 456))
+
+	if browser.Index != 2 {
+		t.Errorf("Index = %d, want 2", browser.Index)
+	}
+	if len(mockAPI.messages) != 0 || len(mockAPI.editedTexts) != 1 {
+		t.Fatalf("Expected the results message to be edited, got %d messages and %d edits", len(mockAPI.messages), len(mockAPI.editedTexts))
+	}
+
+	edit := mockAPI.editedTexts[0]
+	if edit.MessageID != 456 || !strings.Contains(edit.Text, "Oldfield Park") {
+		t.Errorf("Unexpected edit: %+v", edit)
+	}
+	navigation := edit.ReplyMarkup.InlineKeyboard[len(edit.ReplyMarkup.InlineKeyboard)-1]
+	var labels []string
+	for _, button := range navigation {
+		labels = append(labels, button.Text)
+	}
+	if strings.Join(labels, " ") != "⏮ ◀ Previous 3 of 3" {
+		t.Errorf("Navigation buttons = %v, want first, previous and the counter", labels)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Impression was not recorded: %s", err)
+	}
+}
+
+// TestBrowserOutOfDatePage tests that buttons from earlier results don't change the current results
+func TestBrowserOutOfDatePage(t *testing.T) {
+	ctx := context.Background()
+	bot, mockAPI, _, browser := newTestBrowser(t)
+
+	testCases := []struct {
+		name      string
+		data      string
+		messageID int
+	}{
+		{"Earlier search", "page:1:1", 456},
+		{"Different message", browser.pageData(1), 789},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bot.handleCallbackQuery(ctx, browserCallback(tc.data, tc.messageID))
+
+			if browser.Index != 0 || len(mockAPI.editedTexts) != 0 {
+				t.Errorf("Out of date page moved the browser to %d", browser.Index)
+			}
+		})
+	}
+}
+
+// TestBrowserHide tests that hiding a result while browsing moves on to the next one
+func TestBrowserHide(t *testing.T) {
+	ctx := context.Background()
+	bot, mockAPI, mock, browser := newTestBrowser(t)
+	browser.Index = 1
+	mock.ExpectExec("INSERT OR IGNORE INTO hidden_listings").WithArgs(int64(123), 2).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO listing_impressions").WithArgs(int64(123), 3).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	bot.handleCallbackQuery(ctx, browserCallback("hide:2", 456))
+
+	if len(browser.Results.Properties) != 2 || browser.current().ID != 3 {
+		t.Errorf("Expected property 3 to be shown in place of the hidden property, got %d", browser.current().ID)
+	}
+	if len(mockAPI.editedTexts) != 1 || !strings.Contains(mockAPI.editedTexts[0].Text, "Oldfield Park") {
+		t.Errorf("Expected the results message to show the next property, got %+v", mockAPI.editedTexts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestBrowserSave tests that saving a result while browsing keeps the navigation
+func TestBrowserSave(t *testing.T) {
+	ctx := context.Background()
+	bot, mockAPI, mock, browser := newTestBrowser(t)
+	mock.ExpectExec("INSERT OR IGNORE INTO saved_listings").WillReturnResult(sqlmock.NewResult(1, 1))
+
+	bot.handleCallbackQuery(ctx, browserCallback("save:1", 456))
+
+	if !browser.Saved[1] {
+		t.Error("Expected the property to be marked as saved")
+	}
+	if len(mockAPI.editedTexts) != 1 {
+		t.Fatalf("Expected the results message to be edited, got %d edits", len(mockAPI.editedTexts))
+	}
+	keyboard := mockAPI.editedTexts[0].ReplyMarkup.InlineKeyboard
+	if keyboard[0][0].Text != "Saved ✅" || keyboard[len(keyboard)-1][0].Text != "1 of 3" {
+		t.Errorf("Unexpected keyboard after saving: %+v", keyboard)
+	}
+}
+
+// TestPageData tests that navigation callback data decodes to the search and page
+func TestPageData(t *testing.T) {
+	browser := &resultBrowser{ID: 1700000000}
+	for _, data := range []string{browser.pageData(3), "page:1700000000:3"} {
+		action, args, err := callbacks.decode(data)
+		if err != nil || action.Name != "page" || args.Int64("search") != 1700000000 || args.Int("index") != 3 {
+			t.Errorf("decode(%q) = %s, %v, %v; want page 1700000000, 3", data, action.Name, args, err)
+		}
+	}
+
+	for _, data := range []string{"page:3", "page:x:3", "page:1:y"} {
+		if _, _, err := callbacks.decode(data); err == nil {
+			t.Errorf("decode(%q) returned no error", data)
+		}
+	}
+}
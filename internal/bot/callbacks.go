@@ -0,0 +1,367 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Callback data is "action.version:field:field", such as "save.1:42". Buttons sent before the data was
+// versioned carry "action:field:field", which is read as version 0. Data too long for Telegram, or with a
+// field containing a separator, is kept on the server and the button carries a token for it instead.
+const (
+	maxCallbackData    = 64    // bytes Telegram allows in a button's callback data
+	maxCallbackTokens  = 10000 // payloads kept for tokens before the oldest are forgotten
+	callbackTokenBytes = 8     // random bytes in a token
+	callbackSeparator  = ":"
+	callbackVersionSep = "."
+	callbackTokenMark  = "~"
+)
+
+// Answers to button presses whose data can't be used.
+const (
+	callbackExpiredText   = "This button has expired. Please start again."
+	callbackMalformedText = "Sorry, I didn't understand that button. Please try again."
+)
+
+var (
+	errCallbackExpired   = errors.New("callback data has expired")
+	errCallbackMalformed = errors.New("malformed callback data")
+)
+
+var callbackActionPattern = regexp.MustCompile(`^[a-z_]+$`)
+
+// callbackFieldKind is the type of a field in callback data.
+type callbackFieldKind int
+
+const (
+	fieldString callbackFieldKind = iota
+	fieldInt
+)
+
+// callbackField describes a field in an action's callback data.
+type callbackField struct {
+	Name     string
+	Kind     callbackFieldKind
+	Values   []string // values a string field may take, or any if empty
+	Optional bool     // the field, and those after it, may be left out
+}
+
+// callbackHandler handles a button press with its decoded data. It returns the text to answer the press
+// with, if any.
+type callbackHandler func(b *Bot, ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string
+
+// callbackAction is a kind of button press and the schema of its data.
+type callbackAction struct {
+	Name    string
+	Version int // version of the data the bot encodes, raised when the fields change
+	Fields  []callbackField
+	// Upgrade converts the fields of data encoded by an older version, so buttons on old messages keep
+	// working. If it's nil, older data has the same fields.
+	Upgrade func(version int, fields []string) ([]string, error)
+	Handler callbackHandler
+}
+
+// callbackArgs are the decoded fields of a button's callback data, by name.
+type callbackArgs map[string]string
+
+// String returns a field, or "" if it was left out.
+func (a callbackArgs) String(name string) string {
+	return a[name]
+}
+
+// Int returns an integer field, or 0 if it was left out.
+func (a callbackArgs) Int(name string) int {
+	n, _ := strconv.Atoi(a[name])
+	return n
+}
+
+// Int64 returns an integer field, or 0 if it was left out.
+func (a callbackArgs) Int64(name string) int64 {
+	n, _ := strconv.ParseInt(a[name], 10, 64)
+	return n
+}
+
+// callbackRouter decodes callback data and finds the action that handles it.
+type callbackRouter struct {
+	actions map[string]callbackAction
+	tokens  callbackTokens
+}
+
+// newCallbackRouter creates a router for the actions. It panics if an action is invalid or registered twice.
+func newCallbackRouter(actions ...callbackAction) *callbackRouter {
+	r := &callbackRouter{actions: make(map[string]callbackAction)}
+	for _, a := range actions {
+		if !callbackActionPattern.MatchString(a.Name) {
+			panic(fmt.Sprintf("invalid callback action %q", a.Name))
+		}
+		if _, ok := r.actions[a.Name]; ok {
+			panic(fmt.Sprintf("callback action %q registered twice", a.Name))
+		}
+		if a.Handler == nil || a.Version < 1 {
+			panic(fmt.Sprintf("callback action %q needs a handler and a version", a.Name))
+		}
+		optional := false
+		for _, f := range a.Fields {
+			if optional && !f.Optional {
+				panic(fmt.Sprintf("callback action %q has a required field after an optional one", a.Name))
+			}
+			optional = f.Optional
+		}
+		r.actions[a.Name] = a
+	}
+	return r
+}
+
+// encode returns the callback data for a button that runs the action with the values. It panics if the
+// values don't match the action's fields, as the button could never work.
+func (r *callbackRouter) encode(name string, values ...any) string {
+	a, ok := r.actions[name]
+	if !ok {
+		panic(fmt.Sprintf("unknown callback action %q", name))
+	}
+	fields := make([]string, len(values))
+	for i, v := range values {
+		fields[i] = fmt.Sprint(v)
+	}
+	if _, err := a.parse(fields); err != nil {
+		panic(fmt.Sprintf("callback action %q: %v", name, err))
+	}
+
+	data := name + callbackVersionSep + strconv.Itoa(a.Version)
+	fits := true
+	for i, f := range fields {
+		if strings.Contains(f, callbackSeparator) {
+			// The last field is split off whole when it's decoded, so it may contain separators
+			if i < len(fields)-1 {
+				panic(fmt.Sprintf("callback action %q: field %s contains %q", name, a.Fields[i].Name, callbackSeparator))
+			}
+			fits = false
+		}
+		data += callbackSeparator + f
+	}
+	if !fits || len(data) > maxCallbackData {
+		return r.tokens.put(data)
+	}
+	return data
+}
+
+// decode returns the action for callback data and its fields. It returns errCallbackExpired for data the
+// bot can no longer read, such as a forgotten token, and errCallbackMalformed for data it never wrote.
+func (r *callbackRouter) decode(data string) (callbackAction, callbackArgs, error) {
+	if strings.HasPrefix(data, callbackTokenMark) {
+		stored, ok := r.tokens.get(data)
+		if !ok {
+			return callbackAction{}, nil, errCallbackExpired
+		}
+		return r.decodeStored(stored)
+	}
+	head, rest, hasFields := strings.Cut(data, callbackSeparator)
+	var fields []string
+	if hasFields {
+		fields = strings.Split(rest, callbackSeparator)
+	}
+	return r.decodeFields(head, fields)
+}
+
+// decodeStored decodes the data kept for a token, whose fields may contain separators.
+func (r *callbackRouter) decodeStored(data string) (callbackAction, callbackArgs, error) {
+	head, rest, hasFields := strings.Cut(data, callbackSeparator)
+	name, _, _ := strings.Cut(head, callbackVersionSep)
+	var fields []string
+	if a, ok := r.actions[name]; ok && hasFields {
+		fields = strings.SplitN(rest, callbackSeparator, max(len(a.Fields), 1))
+	}
+	return r.decodeFields(head, fields)
+}
+
+// decodeFields finds the action named by head, "action.version" or "action", and parses its fields.
+func (r *callbackRouter) decodeFields(head string, fields []string) (callbackAction, callbackArgs, error) {
+	name, versionText, versioned := strings.Cut(head, callbackVersionSep)
+	a, ok := r.actions[name]
+	if !ok {
+		return callbackAction{}, nil, fmt.Errorf("%w: unknown action %q", errCallbackMalformed, name)
+	}
+	version := 0
+	if versioned {
+		var err error
+		if version, err = strconv.Atoi(versionText); err != nil || version < 0 {
+			return callbackAction{}, nil, fmt.Errorf("%w: invalid version %q", errCallbackMalformed, versionText)
+		}
+	}
+	if version > a.Version {
+		// Written by a newer release of the bot, and this one has been rolled back to
+		return callbackAction{}, nil, fmt.Errorf("%w: %s version %d is newer than %d", errCallbackExpired, name, version, a.Version)
+	}
+	if version < a.Version && a.Upgrade != nil {
+		var err error
+		if fields, err = a.Upgrade(version, fields); err != nil {
+			return callbackAction{}, nil, fmt.Errorf("%w: upgrading %s version %d: %v", errCallbackExpired, name, version, err)
+		}
+	}
+	args, err := a.parse(fields)
+	if err != nil {
+		return callbackAction{}, nil, fmt.Errorf("%w: %s: %v", errCallbackMalformed, name, err)
+	}
+	return a, args, nil
+}
+
+// parse checks the fields against the action's schema.
+func (a callbackAction) parse(fields []string) (callbackArgs, error) {
+	if len(fields) > len(a.Fields) {
+		return nil, fmt.Errorf("%d fields, expected at most %d", len(fields), len(a.Fields))
+	}
+	args := make(callbackArgs, len(fields))
+	for i, f := range a.Fields {
+		if i >= len(fields) {
+			if !f.Optional {
+				return nil, fmt.Errorf("missing field %s", f.Name)
+			}
+			break
+		}
+		value := fields[i]
+		switch {
+		case f.Kind == fieldInt:
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				return nil, fmt.Errorf("field %s: %q isn't a number", f.Name, value)
+			}
+		case len(f.Values) > 0 && !slices.Contains(f.Values, value):
+			return nil, fmt.Errorf("field %s: unexpected value %q", f.Name, value)
+		case value == "":
+			return nil, fmt.Errorf("field %s is empty", f.Name)
+		}
+		args[f.Name] = value
+	}
+	return args, nil
+}
+
+// callbackTokens keeps callback data too long for a button, under a random token. Tokens are kept in
+// memory, so buttons with them expire when the bot restarts or once many newer ones have been made.
+// Its zero value is ready to use.
+type callbackTokens struct {
+	mu      sync.Mutex
+	byToken map[string]string
+	byData  map[string]string
+	order   []string // tokens, oldest first
+}
+
+// put returns a token for the data, reusing the token it was given before.
+func (t *callbackTokens) put(data string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if token, ok := t.byData[data]; ok {
+		return token
+	}
+	if t.byToken == nil {
+		t.byToken = make(map[string]string)
+		t.byData = make(map[string]string)
+	}
+	for len(t.order) >= maxCallbackTokens {
+		oldest := t.order[0]
+		t.order = t.order[1:]
+		delete(t.byData, t.byToken[oldest])
+		delete(t.byToken, oldest)
+	}
+
+	random := make([]byte, callbackTokenBytes)
+	if _, err := rand.Read(random); err != nil {
+		panic(fmt.Sprintf("reading random bytes: %v", err))
+	}
+	token := callbackTokenMark + base64.RawURLEncoding.EncodeToString(random)
+	t.byToken[token] = data
+	t.byData[data] = token
+	t.order = append(t.order, token)
+	return token
+}
+
+// get returns the data kept for a token.
+func (t *callbackTokens) get(token string) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	data, ok := t.byToken[token]
+	return data, ok
+}
+
+// callbacks routes the bot's button presses. It's set in init, as the handlers encode buttons with it.
+var callbacks *callbackRouter
+
+// callbackData returns the callback data for a button that runs the action with the values.
+func callbackData(action string, values ...any) string {
+	return callbacks.encode(action, values...)
+}
+
+// propertyField is the field of actions on a property.
+var propertyField = []callbackField{{Name: "property", Kind: fieldInt}}
+
+func init() {
+	callbacks = newCallbackRouter(
+		callbackAction{Name: "noop", Version: 1, Handler: func(*Bot, context.Context, *tgbotapi.CallbackQuery, *UserState, callbackArgs) string { return "" }},
+		callbackAction{Name: "use_saved_prefs", Version: 1, Fields: []callbackField{{Name: "filter", Values: []string{"new"}, Optional: true}}, Handler: (*Bot).handleUseSavedPrefsCallback},
+		callbackAction{Name: "start_new_search", Version: 1, Handler: (*Bot).handleStartNewSearchCallback},
+		callbackAction{Name: "start_preferences", Version: 1, Handler: (*Bot).handleStartPreferencesCallback},
+		callbackAction{Name: "property_type", Version: 1, Fields: selectFields(), Handler: (*Bot).handlePropertyTypeCallback},
+		callbackAction{Name: "property_group", Version: 1, Fields: []callbackField{{Name: "group"}}, Handler: (*Bot).handlePropertyGroupCallback},
+		callbackAction{Name: "listing_kind", Version: 1, Fields: []callbackField{{Name: "kind", Values: []string{database.ListingWhole, database.ListingRoom, listingKindAny}}}, Handler: (*Bot).handleListingKindCallback},
+		callbackAction{Name: "bedrooms", Version: 1, Fields: selectFields(), Handler: (*Bot).handleBedroomsCallback},
+		callbackAction{Name: "price", Version: 1, Fields: []callbackField{{Name: "range"}}, Handler: (*Bot).handlePriceCallback},
+		callbackAction{Name: "furnished", Version: 1, Fields: selectFields(append(slices.Clone(furnishedOptions), selectDone)...), Handler: (*Bot).handleFurnishedCallback},
+		callbackAction{Name: "move_in", Version: 1, Fields: []callbackField{{Name: "date"}}, Handler: (*Bot).handleMoveInCallback},
+		callbackAction{Name: "tenancy", Version: 1, Fields: []callbackField{{Name: "months"}}, Handler: (*Bot).handleTenancyCallback},
+		callbackAction{Name: "location", Version: 1, Fields: []callbackField{{Name: "area", Values: []string{"Bath"}}}, Handler: (*Bot).handleLocationCallback},
+		callbackAction{Name: "relaxed", Version: 1, Fields: []callbackField{{Name: "choice", Values: []string{"show", "decline"}}}, Handler: (*Bot).handleRelaxedCallback},
+		callbackAction{Name: "page", Version: 1, Fields: []callbackField{{Name: "search", Kind: fieldInt}, {Name: "index", Kind: fieldInt}}, Handler: (*Bot).handlePageCallback},
+		callbackAction{Name: "save", Version: 1, Fields: propertyField, Handler: (*Bot).handleSaveCallback},
+		callbackAction{Name: "delete", Version: 1, Fields: propertyField, Handler: (*Bot).handleDeleteCallback},
+		callbackAction{Name: "hide", Version: 1, Fields: propertyField, Handler: (*Bot).handleHideCallback},
+		callbackAction{Name: "unhide", Version: 1, Fields: propertyField, Handler: (*Bot).handleUnhideCallback},
+		callbackAction{Name: "similar", Version: 1, Fields: propertyField, Handler: (*Bot).handleSimilarCallback},
+		callbackAction{Name: "photos", Version: 1, Fields: propertyField, Handler: (*Bot).handlePhotosCallback},
+		callbackAction{Name: "rooms", Version: 1, Fields: []callbackField{{Name: "property", Kind: fieldInt}, {Name: "room", Kind: fieldInt, Optional: true}}, Handler: (*Bot).handleRoomsCallback},
+		callbackAction{Name: "alerts", Version: 1, Fields: []callbackField{{Name: "state", Values: []string{"on", "off"}}}, Handler: (*Bot).handleAlertsCallback},
+		callbackAction{Name: "digest", Version: 1, Fields: []callbackField{
+			{Name: "choice", Values: []string{"instant", database.DigestDaily, database.DigestWeekly, "day", "time", "tz"}},
+			{Name: "value", Optional: true},
+		}, Handler: (*Bot).handleDigestCallback},
+		callbackAction{Name: "notify", Version: 1, Fields: []callbackField{
+			{Name: "setting", Values: []string{"back", "quiet", "limit", "cat", "mute"}},
+			{Name: "option", Optional: true},
+		}, Handler: (*Bot).handleNotificationsCallback},
+		callbackAction{Name: "alert_categories", Version: 1, Fields: selectFields(), Handler: (*Bot).handleAlertCategoriesCallback},
+	)
+}
+
+// handleCallbackQuery processes a button press. Its data is decoded and checked against the action's
+// fields before the action's handler is run, and presses that can't be used are answered with why.
+func (b *Bot) handleCallbackQuery(ctx context.Context, query *tgbotapi.CallbackQuery) {
+	// Buttons on messages too old for Telegram to include can't be answered in their chat
+	if query.Message == nil {
+		b.answerCallbackQuery(query.ID, callbackExpiredText)
+		return
+	}
+	action, args, err := callbacks.decode(query.Data)
+	if err != nil {
+		requestFrom(ctx).Log.Printf("Rejecting callback data %q: %v", query.Data, err)
+		if errors.Is(err, errCallbackExpired) {
+			b.answerCallbackQuery(query.ID, callbackExpiredText)
+		} else {
+			b.answerCallbackQuery(query.ID, callbackMalformedText)
+		}
+		return
+	}
+
+	state := b.getUserState(query.From.ID)
+	answer := action.Handler(b, ctx, query, state, args)
+	b.updateUserState(query.From.ID, state)
+	b.answerCallbackQuery(query.ID, answer)
+}
@@ -0,0 +1,152 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"strings"
+	"testing"
+)
+
+// testCallbacks returns a router with an action at version 2, whose version 1 data had the fields the other way round
+func testCallbacks() *callbackRouter {
+	handler := func(*Bot, context.Context, *tgbotapi.CallbackQuery, *UserState, callbackArgs) string { return "" }
+	return newCallbackRouter(
+		callbackAction{Name: "noop", Version: 1, Handler: handler},
+		callbackAction{Name: "move", Version: 2, Fields: []callbackField{
+			{Name: "id", Kind: fieldInt},
+			{Name: "to", Values: []string{"up", "down"}},
+			{Name: "note", Optional: true},
+		}, Upgrade: func(version int, fields []string) ([]string, error) {
+			if version != 1 || len(fields) != 2 {
+				return nil, fmt.Errorf("can't upgrade %d fields from version %d", len(fields), version)
+			}
+			return []string{fields[1], fields[0]}, nil
+		}, Handler: handler},
+	)
+}
+
+// TestCallbackRoundTrip tests that encoded data decodes to the same fields, and older versions are upgraded
+func TestCallbackRoundTrip(t *testing.T) {
+	r := testCallbacks()
+
+	data := r.encode("move", 42, "up")
+	if data != "move.2:42:up" {
+		t.Errorf("Expected move.2:42:up, got %q", data)
+	}
+	testCases := []struct {
+		data string
+		note string
+	}{
+		{data, ""},
+		{"move.1:down:42", ""},
+		{r.encode("move", 42, "up", "spare room"), "spare room"},
+	}
+	for _, tc := range testCases {
+		action, args, err := r.decode(tc.data)
+		if err != nil || action.Name != "move" || args.Int("id") != 42 || args.String("note") != tc.note {
+			t.Errorf("decode(%q) = %s, %v, %v", tc.data, action.Name, args, err)
+		}
+	}
+
+	if action, args, err := r.decode("noop"); err != nil || action.Name != "noop" || len(args) != 0 {
+		t.Errorf("Expected unversioned data from old buttons to decode, got %s, %v, %v", action.Name, args, err)
+	}
+	if _, _, err := r.decode("move.3:42:up"); !errors.Is(err, errCallbackExpired) {
+		t.Errorf("Expected data from a newer version to have expired, got %v", err)
+	}
+	if _, _, err := r.decode("move:42:up"); !errors.Is(err, errCallbackExpired) {
+		t.Errorf("Expected data that can't be upgraded to have expired, got %v", err)
+	}
+}
+
+// TestCallbackMalformed tests that data not matching an action's fields is rejected
+func TestCallbackMalformed(t *testing.T) {
+	r := testCallbacks()
+	for _, data := range []string{"", "jump.1:1", "move.2:42", "move.2:x:up", "move.2:42:sideways", "move.2:42:up:note:extra", "move.x:42:up", "noop.1:1"} {
+		if _, _, err := r.decode(data); !errors.Is(err, errCallbackMalformed) {
+			t.Errorf("Expected decode(%q) to be rejected as malformed, got %v", data, err)
+		}
+	}
+
+	for _, values := range [][]any{{"x", "up"}, {42, "sideways"}, {42}, {"4:2", "up"}} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected encoding %v to panic", values)
+				}
+			}()
+			r.encode("move", values...)
+		}()
+	}
+}
+
+// TestCallbackTokens tests that data too long for a button, or with separators, is kept on the server
+func TestCallbackTokens(t *testing.T) {
+	r := testCallbacks()
+
+	long := strings.Repeat("a", maxCallbackData)
+	for _, note := range []string{long, "meet at 10:30"} {
+		data := r.encode("move", 42, "up", note)
+		if !strings.HasPrefix(data, callbackTokenMark) || len(data) > maxCallbackData {
+			t.Errorf("Expected a token for %q, got %q", note, data)
+		}
+		if again := r.encode("move", 42, "up", note); again != data {
+			t.Errorf("Expected the token to be reused, got %q and %q", data, again)
+		}
+		if _, args, err := r.decode(data); err != nil || args.String("note") != note {
+			t.Errorf("decode(%q) = %v, %v; want the note %q", data, args, err, note)
+		}
+	}
+
+	if _, _, err := r.decode("~forgotten"); !errors.Is(err, errCallbackExpired) {
+		t.Errorf("Expected an unknown token to have expired, got %v", err)
+	}
+}
+
+// TestCallbackTokensForgetOldest tests that the oldest tokens are forgotten once too many are kept
+func TestCallbackTokensForgetOldest(t *testing.T) {
+	var tokens callbackTokens
+	first := tokens.put("first")
+	for i := 1; i < maxCallbackTokens; i++ {
+		tokens.put(fmt.Sprint(i))
+	}
+	if _, ok := tokens.get(first); !ok {
+		t.Fatal("Expected the first token to be kept until the limit is reached")
+	}
+	tokens.put("one too many")
+	if _, ok := tokens.get(first); ok {
+		t.Error("Expected the first token to be forgotten")
+	}
+}
+
+// TestHandleCallbackQueryRejects tests that button presses with unusable data are answered with why
+func TestHandleCallbackQueryRejects(t *testing.T) {
+	testCases := []struct {
+		data     string
+		expected string
+	}{
+		{"save:abc", callbackMalformedText},
+		{"property_type", callbackMalformedText},
+		{"furnished:Partly", callbackMalformedText},
+		{"~forgotten", callbackExpiredText},
+		{callbackData("noop"), ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.data, func(t *testing.T) {
+			mockAPI := &MockBotAPI2{}
+			bot := &Bot{api: mockAPI, state: make(map[int64]*UserState)}
+			bot.handleCallbackQuery(context.Background(), &tgbotapi.CallbackQuery{
+				ID: "1", Data: tc.data, From: &tgbotapi.User{ID: 123}, Message: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 123}},
+			})
+
+			if len(mockAPI.answerCallbacks) != 1 || mockAPI.answerCallbacks[0].Text != tc.expected {
+				t.Errorf("Expected one answer %q, got %+v", tc.expected, mockAPI.answerCallbacks)
+			}
+			if len(mockAPI.messages)+len(mockAPI.editedMessages)+len(mockAPI.editedTexts) != 0 {
+				t.Error("Expected nothing else to be sent")
+			}
+		})
+	}
+}
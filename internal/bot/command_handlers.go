@@ -2,6 +2,8 @@
 package bot
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"imitation_project/internal/database"
@@ -17,11 +19,30 @@ const (
 	stageAwaitingPropertyType = "awaiting_property_type"
 	stageAwaitingBedrooms     = "awaiting_bedrooms"
 	stageAwaitingFurnished    = "awaiting_furnished"
+	stageAwaitingListingKind  = "awaiting_listing_kind"
+	stageAwaitingMoveInDate   = "awaiting_move_in_date"
+	stageAwaitingTenancy      = "awaiting_tenancy"
+
+	stageAwaitingDigestTime     = "awaiting_digest_time"
+	stageAwaitingDigestTimezone = "awaiting_digest_timezone"
+
+	stageAwaitingQuietHours           = "awaiting_quiet_hours"
+	stageAwaitingNotificationTimezone = "awaiting_notification_timezone"
+	stageAwaitingMuteDays             = "awaiting_mute_days"
 )
 
+// listingKindAny is the callback value for searching both whole properties and rooms.
+const listingKindAny = "any"
+
 // handleStartCommand processes the /start command.
 // It sends a welcome message to the user with an overview of the bot's functionality.
-func (b *Bot) handleStartCommand(message *tgbotapi.Message) {
+// If the bot was opened from a link to a listing, such as in a digest, it shows the listing instead.
+func (b *Bot) handleStartCommand(ctx context.Context, message *tgbotapi.Message) {
+	if propertyID, ok := parseListingPayload(message.CommandArguments()); ok {
+		b.showListing(ctx, message.Chat.ID, propertyID)
+		return
+	}
+
 	welcomeText := fmt.Sprintf(
 		`🌟 Welcome, %s!
 
@@ -34,174 +55,293 @@ Let’s get started on finding your ideal home in Bath!`,
 	)
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Okay, let's go!", "start_preferences"),
+			tgbotapi.NewInlineKeyboardButtonData("Okay, let's go!", callbackData("start_preferences")),
 		),
 	)
 	b.sendMessage(message.Chat.ID, welcomeText, keyboard)
 }
 
-// handleCallbackQuery processes callback queries from inline keyboards.
-// It handles various user interactions based on the callback data received.
-func (b *Bot) handleCallbackQuery(query *tgbotapi.CallbackQuery) {
-	// Retrieve the current state for the user
-	state := b.getUserState(int64(query.From.ID))
-	// Split the callback data into parts
-	data := strings.Split(query.Data, ":")
-
-	// Handle different callback actions based on the first part of the data
-	switch data[0] {
-	case "use_saved_prefs":
-		// Use saved preferences to perform a search
-		prefs, err := database.GetUserPreferences(b.db, query.From.ID)
-		if err != nil {
-			b.sendMessage(query.Message.Chat.ID, "Error retrieving saved preferences. Starting new search.", nil)
-			b.startNewSearch(query.Message.Chat.ID)
-		} else {
-			// Directly perform the search without sending a message
-			b.performSearch(query.Message.Chat.ID, prefs)
-		}
-	case "start_new_search":
+// handleUseSavedPrefsCallback searches with the user's saved preferences, optionally leaving out listings
+// they have seen.
+func (b *Bot) handleUseSavedPrefsCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	if state.Preferences != nil {
+		state.Preferences.NewOnly = args.String("filter") == "new"
+	}
+	prefs, err := database.GetUserPreferences(ctx, b.db, query.From.ID)
+	if err != nil {
+		b.sendMessage(query.Message.Chat.ID, "Error retrieving saved preferences. Starting new search.", nil)
 		b.startNewSearch(query.Message.Chat.ID)
-	case "start_preferences":
-		b.askPropertyType(query.Message.Chat.ID)
-	case "property_type":
-		if data[1] == "done" {
-			b.askBedrooms(query.Message.Chat.ID)
-		} else {
-			updateMultiSelectOption(state.Preferences.PropertyTypes, data[1])
-			keyboard := createMultiSelectKeyboard(state.Preferences.PropertyTypes, "property_type")
-			b.editMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, keyboard)
-		}
-	case "bedrooms":
-		if data[1] == "done" {
-			b.askPriceRange(query.Message.Chat.ID)
-		} else {
-			// Toggle the selected state
-			state.Preferences.BedroomOptions[data[1]] = !state.Preferences.BedroomOptions[data[1]]
-
-			// Update the keyboard
-			keyboard := tgbotapi.NewInlineKeyboardMarkup(
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(getButtonText("Studio", state.Preferences.BedroomOptions["Studio"]), "bedrooms:Studio"),
-					tgbotapi.NewInlineKeyboardButtonData(getButtonText("1", state.Preferences.BedroomOptions["1"]), "bedrooms:1"),
-					tgbotapi.NewInlineKeyboardButtonData(getButtonText("2", state.Preferences.BedroomOptions["2"]), "bedrooms:2"),
-				),
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(getButtonText("3", state.Preferences.BedroomOptions["3"]), "bedrooms:3"),
-					tgbotapi.NewInlineKeyboardButtonData(getButtonText("4", state.Preferences.BedroomOptions["4"]), "bedrooms:4"),
-					tgbotapi.NewInlineKeyboardButtonData(getButtonText("5+", state.Preferences.BedroomOptions["5+"]), "bedrooms:5+"),
-				),
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData("Done", "bedrooms:done"),
-				),
-			)
-
-			editMsg := tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, keyboard)
-			b.api.Send(editMsg)
-		}
-	case "furnished":
-		if data[1] == "done" {
-			b.askLocation(query.Message.Chat.ID)
-		} else {
-			// Toggle the selected state
-			state.Preferences.FurnishedOptions[data[1]] = !state.Preferences.FurnishedOptions[data[1]]
-
-			// Update the keyboard
-			keyboard := tgbotapi.NewInlineKeyboardMarkup(
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData(getButtonText("Furnished", state.Preferences.FurnishedOptions["Furnished"]), "furnished:Furnished"),
-					tgbotapi.NewInlineKeyboardButtonData(getButtonText("Unfurnished", state.Preferences.FurnishedOptions["Unfurnished"]), "furnished:Unfurnished"),
-				),
-				tgbotapi.NewInlineKeyboardRow(
-					tgbotapi.NewInlineKeyboardButtonData("Done", "furnished:done"),
-				),
-			)
-
-			editMsg := tgbotapi.NewEditMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, keyboard)
-			b.api.Send(editMsg)
-		}
-	case "location":
-		if data[1] == "Bath" {
-			state.Preferences.Location = "Bath"
-			state.Stage = "showing_summary" // Update the state to showing_summary
-			b.updateUserState(query.From.ID, state)
-			b.showSummary(query.Message.Chat.ID)
-		}
-	case "save":
-		if len(data) != 2 {
-			b.answerCallbackQuery(query.ID, "Invalid save request")
-			return
-		}
-		propertyID, err := strconv.Atoi(data[1])
-		if err != nil {
-			b.answerCallbackQuery(query.ID, "Invalid property ID")
-			return
-		}
-		err = database.SaveListing(b.db, int64(query.From.ID), propertyID)
-		if err != nil {
-			b.answerCallbackQuery(query.ID, "Error saving listing")
-			return
-		}
+	} else {
+		// Directly perform the search without sending a message
+		b.performSearch(ctx, query.Message.Chat.ID, prefs)
+	}
+	return ""
+}
 
-		// Update the message to reflect that the listing has been saved
-		editMsg := tgbotapi.NewEditMessageText(
-			query.Message.Chat.ID,
-			query.Message.MessageID,
-			query.Message.Text+"\n\n✅ Saved",
-		)
-		editMsg.ParseMode = "HTML"
+// handleStartNewSearchCallback starts a search without the saved preferences.
+func (b *Bot) handleStartNewSearchCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	b.startNewSearch(query.Message.Chat.ID)
+	return ""
+}
 
-		// Create the keyboard markup and assign its address to ReplyMarkup
-		keyboard := tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Saved ✅", "noop"),
-			),
-		)
-		editMsg.ReplyMarkup = &keyboard
+// handleStartPreferencesCallback starts asking for the user's preferences.
+func (b *Bot) handleStartPreferencesCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	b.askPropertyType(query.Message.Chat.ID)
+	return ""
+}
 
-		_, err = b.api.Send(editMsg)
-		if err != nil {
-			log.Printf("Error updating message: %v", err)
-		}
+// handlePropertyTypeCallback handles the property type keyboard, moving on once the user is done.
+func (b *Bot) handlePropertyTypeCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	return b.handleSelectCallback(query, b.propertyTypeSelect(), setSelection(state.Preferences.PropertyTypes), args, func() {
+		b.askListingKind(query.Message.Chat.ID)
+	})
+}
 
-		b.answerCallbackQuery(query.ID, "Listing saved successfully!")
-	case "noop":
-		// Do nothing for the "Saved ✅" button
-		b.answerCallbackQuery(query.ID, "")
-	case "delete":
-		if len(data) != 2 {
-			b.answerCallbackQuery(query.ID, "Invalid delete request")
-			return
-		}
-		propertyID, err := strconv.Atoi(data[1])
-		if err != nil {
-			b.answerCallbackQuery(query.ID, "Invalid property ID")
-			return
-		}
-		err = database.DeleteSavedListing(b.db, int64(query.From.ID), propertyID)
-		if err != nil {
-			b.answerCallbackQuery(query.ID, "Error deleting listing")
-			return
+// handlePropertyGroupCallback toggles a group of property types, showing the page with the group's first type.
+func (b *Bot) handlePropertyGroupCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	group := args.String("group")
+	if !b.togglePropertyTypeGroup(state.Preferences.PropertyTypes, group) {
+		return "Unknown property type group"
+	}
+	m := b.propertyTypeSelect()
+	page := 0
+	for _, t := range b.getPropertyTypes() {
+		if t.Group == group {
+			page = m.pageOf(t.Code)
+			break
 		}
+	}
+	b.editMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, m.keyboard(setSelection(state.Preferences.PropertyTypes), page))
+	return ""
+}
 
-		// Update the message to reflect that the listing has been deleted
-		editMsg := tgbotapi.NewEditMessageText(
-			query.Message.Chat.ID,
-			query.Message.MessageID,
-			query.Message.Text+"\n\n❌ Deleted",
-		)
-		editMsg.ParseMode = "HTML"
+// handleListingKindCallback records whether the user wants a whole property, a room or either.
+func (b *Bot) handleListingKindCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	switch args.String("kind") {
+	case database.ListingWhole:
+		state.Preferences.ListingKind = database.ListingWhole
+		b.askBedrooms(query.Message.Chat.ID)
+	case database.ListingRoom:
+		state.Preferences.ListingKind = database.ListingRoom
+		b.askPriceRange(query.Message.Chat.ID)
+	case listingKindAny:
+		state.Preferences.ListingKind = ""
+		b.askBedrooms(query.Message.Chat.ID)
+	}
+	return ""
+}
 
-		_, err = b.api.Send(editMsg)
-		if err != nil {
-			log.Printf("Error updating message: %v", err)
+// handleBedroomsCallback handles the bedroom keyboard, moving on once the user is done.
+func (b *Bot) handleBedroomsCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	return b.handleSelectCallback(query, bedroomSelect(), bedroomSelection{state.Preferences}, args, func() {
+		b.askPriceRange(query.Message.Chat.ID)
+	})
+}
+
+// handlePriceCallback records the chosen price range.
+func (b *Bot) handlePriceCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	price, err := parsePrice(args.String("range"))
+	if err != nil {
+		return "Invalid price range"
+	}
+	state.Preferences.PriceRange = price.String()
+	b.askFurnished(query.Message.Chat.ID)
+	return ""
+}
+
+// handleFurnishedCallback handles the furnishing keyboard, moving on once the user is done.
+func (b *Bot) handleFurnishedCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	return b.handleSelectCallback(query, furnishedSelect(), setSelection(state.Preferences.FurnishedOptions), args, func() {
+		b.askMoveInDate(query.Message.Chat.ID)
+	})
+}
+
+// handleMoveInCallback records the chosen move-in date.
+func (b *Bot) handleMoveInCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	moveIn, err := parseMoveInDate(args.String("date"), time.Now())
+	if err != nil {
+		return "Invalid move-in date"
+	}
+	state.Preferences.MoveInDate = moveIn
+	b.askTenancyLength(query.Message.Chat.ID)
+	return ""
+}
+
+// handleTenancyCallback records the chosen tenancy length.
+func (b *Bot) handleTenancyCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	months := 0
+	if option := args.String("months"); option != tenancyAny {
+		var err error
+		months, err = strconv.Atoi(option)
+		if err != nil || months <= 0 {
+			return "Invalid tenancy length"
 		}
+	}
+	state.Preferences.TenancyMonths = months
+	b.askLocation(query.Message.Chat.ID)
+	return ""
+}
+
+// handleLocationCallback records the chosen location and shows the summary of the preferences.
+func (b *Bot) handleLocationCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	state.Preferences.Location = args.String("area")
+	state.Stage = "showing_summary" // Update the state to showing_summary
+	b.updateUserState(query.From.ID, state)
+	b.showSummary(ctx, query.Message.Chat.ID)
+	return ""
+}
+
+// handleRelaxedCallback shows or declines the results found by relaxing the user's filters.
+func (b *Bot) handleRelaxedCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	results := state.RelaxedResults
+	state.RelaxedResults = nil
+	switch {
+	case args.String("choice") == "decline":
+		b.sendMessage(query.Message.Chat.ID, "No problem. Use /search to adjust your preferences and try again.", nil)
+	case results != nil:
+		b.updateUserState(query.From.ID, state)
+		b.presentSearchResults(ctx, query.Message.Chat.ID, *results)
+	default:
+		b.sendMessage(query.Message.Chat.ID, "These results are no longer available. Use /search to search again.", nil)
+	}
+	return ""
+}
+
+// handleSaveCallback saves a listing for the user.
+func (b *Bot) handleSaveCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	propertyID := args.Int("property")
+	if err := database.SaveListing(ctx, b.db, query.From.ID, propertyID); err != nil {
+		return "Error saving listing"
+	}
 
-		b.answerCallbackQuery(query.ID, "Listing deleted successfully!")
+	// Keep the results navigable if the listing was saved while browsing them
+	if browser := state.browserFor(query.Message.MessageID); browser != nil {
+		browser.Saved[propertyID] = true
+		b.showBrowserPage(ctx, query.Message.Chat.ID, browser)
+		return "Listing saved successfully!"
 	}
 
-	b.updateUserState(int64(query.From.ID), state)
-	b.answerCallbackQuery(query.ID, "")
+	// Update the message to reflect that the listing has been saved
+	editMsg := tgbotapi.NewEditMessageText(
+		query.Message.Chat.ID,
+		query.Message.MessageID,
+		query.Message.Text+"\n\n✅ Saved",
+	)
+	editMsg.ParseMode = "HTML"
+
+	// Create the keyboard markup and assign its address to ReplyMarkup
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Saved ✅", callbackData("noop")),
+		),
+	)
+	editMsg.ReplyMarkup = &keyboard
+
+	if _, err := b.api.Send(editMsg); err != nil {
+		log.Printf("Error updating message: %v", err)
+	}
+	return "Listing saved successfully!"
+}
+
+// handleHideCallback hides a listing from the user's results.
+func (b *Bot) handleHideCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	propertyID := args.Int("property")
+	if err := database.HideListing(ctx, b.db, query.From.ID, propertyID); err != nil {
+		return "Error hiding listing"
+	}
+
+	// Move on to the next result if the listing was hidden while browsing results
+	if browser := state.browserFor(query.Message.MessageID); browser != nil {
+		browser.remove(propertyID)
+		b.showBrowserPage(ctx, query.Message.Chat.ID, browser)
+		return "Listing hidden. Use /hidden to undo."
+	}
+
+	// Replace the listing with a note so it's out of the way, but can still be undone
+	editMsg := tgbotapi.NewEditMessageText(
+		query.Message.Chat.ID,
+		query.Message.MessageID,
+		"🙈 Hidden. You won't see this listing again.",
+	)
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Undo", callbackData("unhide", propertyID)),
+		),
+	)
+	editMsg.ReplyMarkup = &keyboard
+
+	if _, err := b.api.Send(editMsg); err != nil {
+		log.Printf("Error updating message: %v", err)
+	}
+	return "Listing hidden"
+}
+
+// handleUnhideCallback shows a hidden listing again.
+func (b *Bot) handleUnhideCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	propertyID := args.Int("property")
+	if err := database.UnhideListing(ctx, b.db, query.From.ID, propertyID); err != nil {
+		return "Error unhiding listing"
+	}
+
+	// Show the listing again in place of the note
+	property, err := database.GetProperty(ctx, b.db, propertyID)
+	if err != nil {
+		log.Printf("Error getting property %d: %v", propertyID, err)
+		return "Listing unhidden"
+	}
+	message, keyboard := b.presentProperty(property, false)
+	editMsg := tgbotapi.NewEditMessageText(query.Message.Chat.ID, query.Message.MessageID, message)
+	editMsg.ParseMode = "HTML"
+	editMsg.ReplyMarkup = &keyboard
+
+	if _, err := b.api.Send(editMsg); err != nil {
+		log.Printf("Error updating message: %v", err)
+	}
+	return "Listing unhidden"
+}
+
+// handleDeleteCallback removes a listing from the user's saved listings.
+func (b *Bot) handleDeleteCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	if err := database.DeleteSavedListing(ctx, b.db, query.From.ID, args.Int("property")); err != nil {
+		return "Error deleting listing"
+	}
+
+	// Update the message to reflect that the listing has been deleted
+	editMsg := tgbotapi.NewEditMessageText(
+		query.Message.Chat.ID,
+		query.Message.MessageID,
+		query.Message.Text+"\n\n❌ Deleted",
+	)
+	editMsg.ParseMode = "HTML"
+
+	if _, err := b.api.Send(editMsg); err != nil {
+		log.Printf("Error updating message: %v", err)
+	}
+	return "Listing deleted successfully!"
+}
+
+// handleSimilarCallback shows homes similar to a listing.
+func (b *Bot) handleSimilarCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	b.showSimilarProperties(ctx, query.Message.Chat.ID, args.Int("property"))
+	return ""
+}
+
+// handlePhotosCallback sends a listing's photos.
+func (b *Bot) handlePhotosCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	b.sendPropertyPhotos(ctx, query.Message.Chat.ID, args.Int("property"))
+	return ""
+}
+
+// handleRoomsCallback shows the other rooms in a shared house.
+func (b *Bot) handleRoomsCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	b.showRoomsInProperty(ctx, query.Message.Chat.ID, args.Int("property"), args.Int("room"))
+	return ""
+}
+
+// handleAlertsCallback turns the user's alerts on or off.
+func (b *Bot) handleAlertsCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	b.setAlertsEnabled(ctx, query.Message.Chat.ID, query.From.ID, args.String("state") == "on")
+	return ""
 }
 
 // editMessageReplyMarkup updates the reply markup (inline keyboard) of an existing message.
@@ -225,52 +365,70 @@ func (b *Bot) answerCallbackQuery(callbackQueryID string, text string) {
 }
 
 // handleHelpCommand processes the /help command.
-// It sends a message to the user with a list of available commands and their descriptions.
-func (b *Bot) handleHelpCommand(message *tgbotapi.Message) {
-	helpText := `Welcome to RentSeekerBot!
-
-Below are the commands you can use to interact with the bot:
-
-	1.	/start - Initiates the bot and displays a welcome message.
-
-	2.	/search- Starts a property search using your saved preferences if they are available. You will be prompted to provide details if no preferences are saved.
-	
-	3.	/save_preferences - Saves your current search preferences for future use. This includes details such as property type, price range, number of bedrooms, furnishing status, and location.
-	
-	4.	/view_preferences - Displays your currently saved search preferences.
-	
-	5.	/clear_preferences - Clears all your saved search preferences.
-	
-	6.	/help - Provides information about all available commands and their usage.
-
- 	7.  /saved - View all your saved property listings. To save a listing, use the "Save Listing" button that appears below each property listing.
-	
-
-If you need further assistance or have any questions, please do not hesitate to contact our support team. Thank you for using RentSeekerBot!
-`
-
-	b.sendMessage(message.Chat.ID, helpText, nil)
+// It sends a message to the user with a list of the commands they can use in the chat and their descriptions.
+func (b *Bot) handleHelpCommand(ctx context.Context, message *tgbotapi.Message) {
+	b.sendMessage(message.Chat.ID, botCommands.helpText(isGroupChat(message.Chat), requestFrom(ctx).Admin), nil)
 }
 
 // handleRegularMessage processes non-command messages.
-func (b *Bot) handleRegularMessage(message *tgbotapi.Message) {
+func (b *Bot) handleRegularMessage(ctx context.Context, message *tgbotapi.Message) {
 	state := b.getUserState(int64(message.From.ID))
 
 	switch state.Stage {
 	case stageAwaitingPriceRange:
-		if b.validatePriceRange(message.Text) {
-			state.Preferences.PriceRange = message.Text
-			b.askFurnished(message.Chat.ID)
-		} else {
-			b.sendMessage(message.Chat.ID, "Invalid price range format. Please use the format: min - max (e.g., 1200 - 1800)", nil)
+		price, err := parsePrice(message.Text)
+		if err != nil {
+			b.sendMessage(message.Chat.ID, "Sorry, I couldn't understand that price range. Try something like 1200 - 1800, under 1500, 1.2k to 1.6k or 350pw.", nil)
+			return
+		}
+		state.Preferences.PriceRange = price.String()
+		b.askFurnished(message.Chat.ID)
+	case stageAwaitingMoveInDate:
+		moveIn, err := parseMoveInDate(message.Text, time.Now())
+		if errors.Is(err, errMoveInDateInPast) {
+			b.sendMessage(message.Chat.ID, "That date is in the past. Please enter a date from today onwards.", nil)
+			return
+		}
+		if err != nil {
+			b.sendMessage(message.Chat.ID, "Sorry, I couldn't understand that date. Try something like 01/09/2025, 1st September, September or ASAP.", nil)
+			return
+		}
+		state.Preferences.MoveInDate = moveIn
+		b.askTenancyLength(message.Chat.ID)
+	case stageAwaitingTenancy:
+		months, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(strings.ToLower(message.Text)), " months"))
+		if err != nil || months <= 0 {
+			b.sendMessage(message.Chat.ID, "Please enter the tenancy length as a number of months, e.g. 12.", nil)
 			return
 		}
+		state.Preferences.TenancyMonths = months
+		b.askLocation(message.Chat.ID)
+	case stageAwaitingDigestTime:
+		if state.DigestDraft == nil {
+			b.sendMessage(message.Chat.ID, "Use /digest to choose when you get your digest.", nil)
+			return
+		}
+		sendTime, err := parseTimeOfDay(message.Text)
+		if err != nil {
+			b.sendMessage(message.Chat.ID, "Sorry, I couldn't understand that time. Try something like 07:30 or 6pm.", nil)
+			return
+		}
+		state.DigestDraft.SendTime = sendTime
+		b.askDigestTimezone(message.Chat.ID, state)
+	case stageAwaitingDigestTimezone:
+		if state.DigestDraft == nil {
+			b.sendMessage(message.Chat.ID, "Use /digest to choose when you get your digest.", nil)
+			return
+		}
+		b.saveDigestDraft(ctx, message.Chat.ID, state, strings.TrimSpace(message.Text))
+	case stageAwaitingQuietHours, stageAwaitingNotificationTimezone, stageAwaitingMuteDays:
+		b.handleNotificationSettingText(ctx, message, state)
 	case stageAwaitingLocation:
 		log.Printf("Handling awaiting_location state")
 		state.Preferences.Location = message.Text
 		log.Printf("Updated location to: %s", state.Preferences.Location)
 		b.updateUserState(message.From.ID, state)
-		b.showSummary(message.Chat.ID)
+		b.showSummary(ctx, message.Chat.ID)
 	default:
 		log.Printf("Unhandled state: %s", state.Stage)
 		b.sendMessage(message.Chat.ID, "I'm sorry, I didn't understand that. Please use the provided buttons or follow the instructions.", nil)
@@ -285,111 +443,132 @@ func (b *Bot) askPropertyType(chatID int64) {
 	if state.Preferences == nil {
 		state.Preferences = NewFlexibleSearchPreferences()
 	}
-	if len(state.Preferences.PropertyTypes) == 0 {
-		state.Preferences.PropertyTypes["Flat"] = false
-		state.Preferences.PropertyTypes["House"] = false
+	if state.Preferences.PropertyTypes == nil {
+		state.Preferences.PropertyTypes = make(map[string]bool)
 	}
 
-	keyboard := createMultiSelectKeyboard(state.Preferences.PropertyTypes, "property_type")
+	keyboard := b.createPropertyTypeKeyboard(state.Preferences.PropertyTypes)
 	b.sendMessage(chatID, "🏠 Select property type(s):", keyboard)
 	state.Stage = stageAwaitingPropertyType
 	b.updateUserState(chatID, state)
 }
 
+// askListingKind asks whether the user wants a whole property or a room in a shared house.
+func (b *Bot) askListingKind(chatID int64) {
+	state := b.getUserState(chatID)
+	state.Stage = stageAwaitingListingKind
+	b.updateUserState(chatID, state)
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🏠 Whole property", callbackData("listing_kind", database.ListingWhole)),
+			tgbotapi.NewInlineKeyboardButtonData("🚪 Room in a shared house", callbackData("listing_kind", database.ListingRoom)),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Either", callbackData("listing_kind", listingKindAny)),
+		),
+	)
+
+	b.sendMessage(chatID, "🔑 Are you looking for a whole property or a single room in a shared house?", keyboard)
+}
+
 // askPriceRange asks the user to input a price range.
+// It offers preset price buckets as buttons and also accepts a typed range.
 func (b *Bot) askPriceRange(chatID int64) {
 	state := b.getUserState(chatID)
 	state.Stage = stageAwaitingPriceRange
 	b.updateUserState(chatID, state)
-	b.sendMessage(chatID, `💰 Let me know the price range for the monthly rent in GBP. 
-								Format: min - max (e.g., 1200 - 1800)`, nil)
-}
 
-// validatePriceRange checks if the price range is in the correct format.
-func (b *Bot) validatePriceRange(input string) bool {
-	parts := strings.Split(input, "-")
-	if len(parts) != 2 {
-		return false
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, bucket := range priceBuckets {
+		button := tgbotapi.NewInlineKeyboardButtonData(bucket.Label, callbackData("price", bucket.Value.String()))
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(button))
 	}
-	// Trim spaces from each part
-	min := strings.TrimSpace(parts[0])
-	max := strings.TrimSpace(parts[1])
 
-	// Check if both parts are valid numbers
-	_, err1 := strconv.Atoi(min)
-	_, err2 := strconv.Atoi(max)
-
-	return err1 == nil && err2 == nil
+	rentFor := "the monthly rent"
+	if state.Preferences != nil && state.Preferences.ListingKind == database.ListingRoom {
+		rentFor = "the monthly rent per room"
+	}
+	b.sendMessage(chatID, fmt.Sprintf(`💰 Let me know the price range for %s in GBP.
+Pick one of the options below or type your own, e.g. 1200 - 1800, under 1500, 1.2k to 1.6k or 350pw.`, rentFor), tgbotapi.NewInlineKeyboardMarkup(rows...))
 }
 
 // askBedrooms asks the user to select the number of bedrooms.
 func (b *Bot) askBedrooms(chatID int64) {
 	state := b.getUserState(chatID)
-	if len(state.Preferences.BedroomOptions) == 0 {
-		state.Preferences.BedroomOptions = map[string]bool{
-			"Studio": false,
-			"1":      false,
-			"2":      false,
-			"3":      false,
-			"4":      false,
-			"5+":     false,
-		}
-	}
+	keyboard := createBedroomKeyboard(state.Preferences)
 
-	keyboard := tgbotapi.NewInlineKeyboardMarkup(
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(getButtonText("Studio", state.Preferences.BedroomOptions["Studio"]), "bedrooms:Studio"),
-			tgbotapi.NewInlineKeyboardButtonData(getButtonText("1", state.Preferences.BedroomOptions["1"]), "bedrooms:1"),
-			tgbotapi.NewInlineKeyboardButtonData(getButtonText("2", state.Preferences.BedroomOptions["2"]), "bedrooms:2"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(getButtonText("3", state.Preferences.BedroomOptions["3"]), "bedrooms:3"),
-			tgbotapi.NewInlineKeyboardButtonData(getButtonText("4", state.Preferences.BedroomOptions["4"]), "bedrooms:4"),
-			tgbotapi.NewInlineKeyboardButtonData(getButtonText("5+", state.Preferences.BedroomOptions["5+"]), "bedrooms:5+"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Done", "bedrooms:done"),
-		),
-	)
-
-	b.sendMessage(chatID, "🛏 Select the number of bedrooms (you can select multiple options):", keyboard)
+	b.sendMessage(chatID, "🛏 Select the number of bedrooms. Tap one option, or tap two to choose a range (e.g. 2 and 4, or 3 and 5+):", keyboard)
 	state.Stage = stageAwaitingBedrooms
 	b.updateUserState(chatID, state)
 }
 
-// getButtonText generates the display text for a button in the inline keyboard.
-// It adds a checkmark emoji (✅) in front of the option text if it is selected.
-func getButtonText(option string, isSelected bool) string {
-	if isSelected {
-		return "✅ " + option
+// createBedroomKeyboard builds the bedroom keyboard, marking every option within the selected range.
+func createBedroomKeyboard(preferences *SearchPreferences) tgbotapi.InlineKeyboardMarkup {
+	return bedroomSelect().keyboard(bedroomSelection{preferences}, 0)
+}
+
+// furnishedOptions are the furnishing options, in display order.
+var furnishedOptions = []string{"Furnished", "Unfurnished"}
+
+// furnishedSelect is the furnishing keyboard.
+func furnishedSelect() multiSelect {
+	m := multiSelect{Action: "furnished", Columns: 2}
+	for _, option := range furnishedOptions {
+		m.Options = append(m.Options, selectOption{Value: option, Label: option})
 	}
-	return option
+	return m
 }
 
 // askFurnished asks if the user wants furnished or unfurnished accommodation.
 func (b *Bot) askFurnished(chatID int64) {
 	state := b.getUserState(chatID)
-	if len(state.Preferences.FurnishedOptions) == 0 {
-		state.Preferences.FurnishedOptions = map[string]bool{
-			"Furnished":   false,
-			"Unfurnished": false,
-		}
+	if state.Preferences.FurnishedOptions == nil {
+		state.Preferences.FurnishedOptions = make(map[string]bool)
 	}
 
+	keyboard := furnishedSelect().keyboard(setSelection(state.Preferences.FurnishedOptions), 0)
+	b.sendMessage(chatID, "🪑 Do you want to search for furnished or unfurnished accommodation? (You can select both)", keyboard)
+	state.Stage = stageAwaitingFurnished
+	b.updateUserState(chatID, state)
+}
+
+// askMoveInDate asks the user when they want to move in.
+// It offers ASAP, the start of the next few months and "Flexible", and also accepts a typed date.
+func (b *Bot) askMoveInDate(chatID int64) {
+	state := b.getUserState(chatID)
+	state.Stage = stageAwaitingMoveInDate
+	b.updateUserState(chatID, state)
+
+	var monthRow []tgbotapi.InlineKeyboardButton
+	for _, pick := range moveInQuickPicks(time.Now()) {
+		monthRow = append(monthRow, tgbotapi.NewInlineKeyboardButtonData(pick.Format("January"), callbackData("move_in", pick.Format(database.DateLayout))))
+	}
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData(getButtonText("Furnished", state.Preferences.FurnishedOptions["Furnished"]), "furnished:Furnished"),
-			tgbotapi.NewInlineKeyboardButtonData(getButtonText("Unfurnished", state.Preferences.FurnishedOptions["Unfurnished"]), "furnished:Unfurnished"),
-		),
-		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Done", "furnished:done"),
+			tgbotapi.NewInlineKeyboardButtonData("ASAP", callbackData("move_in", "asap")),
+			tgbotapi.NewInlineKeyboardButtonData("Flexible", callbackData("move_in", moveInFlexible)),
 		),
+		monthRow,
 	)
 
-	b.sendMessage(chatID, "🪑 Do you want to search for furnished or unfurnished accommodation? (You can select both)", keyboard)
-	state.Stage = stageAwaitingFurnished
+	b.sendMessage(chatID, `📅 When would you like to move in?
+Pick one of the options below or type a date, e.g. 01/09/2025 or 1st September.`, keyboard)
+}
+
+// askTenancyLength asks the user how long they want to rent for.
+func (b *Bot) askTenancyLength(chatID int64) {
+	state := b.getUserState(chatID)
+	state.Stage = stageAwaitingTenancy
 	b.updateUserState(chatID, state)
 
+	var row []tgbotapi.InlineKeyboardButton
+	for _, months := range tenancyOptions {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(formatTenancyMonths(months), callbackData("tenancy", months)))
+	}
+	row = append(row, tgbotapi.NewInlineKeyboardButtonData("Any", callbackData("tenancy", tenancyAny)))
+
+	b.sendMessage(chatID, "📆 How long would you like to rent for? Pick an option or type the number of months:", tgbotapi.NewInlineKeyboardMarkup(row))
 }
 
 // askLocation asks the user to input their preferred location.
@@ -400,7 +579,7 @@ func (b *Bot) askLocation(chatID int64) {
 
 	keyboard := tgbotapi.NewInlineKeyboardMarkup(
 		tgbotapi.NewInlineKeyboardRow(
-			tgbotapi.NewInlineKeyboardButtonData("Bath", "location:Bath"),
+			tgbotapi.NewInlineKeyboardButtonData("Bath", callbackData("location", "Bath")),
 		),
 	)
 
@@ -408,26 +587,10 @@ func (b *Bot) askLocation(chatID int64) {
 }
 
 // showSummary displays a summary of the user's preferences.
-func (b *Bot) showSummary(chatID int64) {
+func (b *Bot) showSummary(ctx context.Context, chatID int64) {
 	state := b.getUserState(chatID)
 	prefs := state.Preferences
 
-	// Compile property types
-	var propertyTypes []string
-	for pType, selected := range prefs.PropertyTypes {
-		if selected {
-			propertyTypes = append(propertyTypes, pType)
-		}
-	}
-
-	// Compile bedroom options
-	var bedrooms []string
-	for bedroom, selected := range prefs.BedroomOptions {
-		if selected {
-			bedrooms = append(bedrooms, bedroom)
-		}
-	}
-
 	furnishedOptions := getSelectedOptions(prefs.FurnishedOptions)
 	furnishedStatus := "Any"
 	if len(furnishedOptions) == 1 {
@@ -435,49 +598,58 @@ func (b *Bot) showSummary(chatID int64) {
 	}
 
 	summary := fmt.Sprintf("Great! Here's a summary of your preferences:\n\n"+
+		"🔑 Looking for: %s\n"+
 		"🏠 Property Type: %s\n"+
 		"💰 Price Range: %s\n"+
 		"🛏 Bedrooms: %s\n"+
 		"🪑 Furnished: %s\n"+
+		"📅 Move in: %s\n"+
+		"📆 Tenancy: %s\n"+
 		"📍 Location: %s\n\n"+
 		"I'll now search for properties matching these criteria. Please wait a moment.",
-		strings.Join(propertyTypes, ", "),
+		formatListingKind(prefs.ListingKind),
+		b.formatPropertyTypes(prefs.PropertyTypes),
 		prefs.PriceRange,
-		strings.Join(bedrooms, ", "),
+		formatBedroomRange(prefs.MinBedrooms, prefs.MaxBedrooms),
 		furnishedStatus,
+		formatMoveInDate(prefs.MoveInDate),
+		formatTenancyMonths(prefs.TenancyMonths),
 		prefs.Location)
 
 	log.Printf("Sending summary message: %s", summary)
 	b.sendMessage(chatID, summary, nil)
 
 	// Perform the search
-	properties, err := b.searchProperties(prefs)
+	results, err := b.searchProperties(ctx, chatID, prefs)
 	if err != nil {
 		b.sendMessage(chatID, "Sorry, there was an error while searching for properties. Please try again later.", nil)
 		return
 	}
-	if len(properties) == 0 {
+	if len(results.Properties) == 0 {
 		b.sendMessage(chatID, "Sorry, no properties match your criteria. Try adjusting your preferences and searching again.", nil)
 		return
 	}
 
-	time.Sleep(5 * time.Second)
-	b.presentSearchResults(chatID, properties)
+	b.presentOrOfferResults(ctx, chatID, results)
 }
 
 // handleSavePreferences processes the user's request to save their current search preferences.
 // It converts the current user state preferences to a format suitable for database storage
 // and saves them using the database package.
-func (b *Bot) handleSavePreferences(message *tgbotapi.Message) {
+func (b *Bot) handleSavePreferences(ctx context.Context, message *tgbotapi.Message) {
 	state := b.getUserState(message.From.ID)
 	prefs := state.Preferences
 
 	dbPrefs := database.UserPreferences{
-		UserID:         message.From.ID,
-		PropertyTypes:  make(map[string]bool),
-		BedroomOptions: make(map[string]bool),
-		Location:       prefs.Location,
-		Furnished:      make(map[string]bool),
+		UserID:        message.From.ID,
+		PropertyTypes: make(map[string]bool),
+		MinBedrooms:   prefs.MinBedrooms,
+		MaxBedrooms:   prefs.MaxBedrooms,
+		Location:      prefs.Location,
+		Furnished:     make(map[string]bool),
+		ListingKind:   prefs.ListingKind,
+		MoveInDate:    prefs.MoveInDate,
+		TenancyMonths: prefs.TenancyMonths,
 	}
 
 	// Copy PropertyTypes
@@ -485,26 +657,12 @@ func (b *Bot) handleSavePreferences(message *tgbotapi.Message) {
 		dbPrefs.PropertyTypes[pType] = selected
 	}
 
-	// Copy BedroomOptions
-	for bedroom, selected := range prefs.BedroomOptions {
-		dbPrefs.BedroomOptions[bedroom] = selected
-	}
-
 	// Parse price range
-	if prefs.PriceRange != "" {
-		parts := strings.Split(prefs.PriceRange, "-")
-		if len(parts) == 2 {
-			minPrice, err := strconv.Atoi(strings.TrimSpace(parts[0]))
-			if err == nil {
-				dbPrefs.MinPrice = minPrice
-			}
-			maxPrice, err := strconv.Atoi(strings.TrimSpace(parts[1]))
-			if err == nil {
-				dbPrefs.MaxPrice = maxPrice
-			}
-		}
+	if price, err := parsePrice(prefs.PriceRange); err == nil {
+		dbPrefs.MinPrice = price.Min
+		dbPrefs.MaxPrice = price.Max
 	}
-	err := database.SaveUserPreferences(b.db, dbPrefs)
+	err := database.SaveUserPreferences(ctx, b.db, dbPrefs)
 	if err != nil {
 		b.sendMessage(message.Chat.ID, "Sorry, there was an error saving your preferences.", nil)
 	} else {
@@ -514,43 +672,31 @@ func (b *Bot) handleSavePreferences(message *tgbotapi.Message) {
 
 // handleViewPreferences retrieves and displays the user's saved preferences.
 // If no preferences are saved, it informs the user.
-func (b *Bot) handleViewPreferences(message *tgbotapi.Message) {
-	prefs, err := database.GetUserPreferences(b.db, message.From.ID)
+func (b *Bot) handleViewPreferences(ctx context.Context, message *tgbotapi.Message) {
+	prefs, err := database.GetUserPreferences(ctx, b.db, message.From.ID)
 	if err != nil {
 		b.sendMessage(message.Chat.ID, "You haven't saved any preferences yet.", nil)
 		return
 	}
 
-	// Format PropertyTypes
-	var propertyTypes []string
-	for pType, selected := range prefs.PropertyTypes {
-		if selected {
-			propertyTypes = append(propertyTypes, pType)
-		}
-	}
-
-	// Format BedroomOptions
-	var bedrooms []string
-	for bedroom, selected := range prefs.BedroomOptions {
-		if selected {
-			bedrooms = append(bedrooms, bedroom)
-		}
-	}
-
 	prefsMsg := fmt.Sprintf("Your saved preferences:\n"+
+		"Looking for: %s\n"+
 		"Property Type: %s\n"+
-		"Price Range: £%d - £%d\n"+
+		"Price Range: %s\n"+
 		"Bedrooms: %s\n"+
 		"Furnished: %v\n"+
-		"Location: %s",
-		strings.Join(propertyTypes, ", "), prefs.MinPrice, prefs.MaxPrice, strings.Join(bedrooms, ", "), prefs.Furnished, prefs.Location)
+		"Move in: %s\n"+
+		"Tenancy: %s\n"+
+		"Location: %s\n"+
+		"Alerts: %s (change with /alerts)",
+		formatListingKind(prefs.ListingKind), b.formatPropertyTypes(prefs.PropertyTypes), priceRange{Min: prefs.MinPrice, Max: prefs.MaxPrice}.Label(), formatBedroomRange(prefs.MinBedrooms, prefs.MaxBedrooms), prefs.Furnished, formatMoveInDate(prefs.MoveInDate), formatTenancyMonths(prefs.TenancyMonths), prefs.Location, formatAlertsEnabled(prefs.AlertsEnabled))
 
 	b.sendMessage(message.Chat.ID, prefsMsg, nil)
 }
 
 // handleClearPreferences removes all saved preferences for the user from the database.
-func (b *Bot) handleClearPreferences(message *tgbotapi.Message) {
-	err := database.DeleteUserPreferences(b.db, message.From.ID)
+func (b *Bot) handleClearPreferences(ctx context.Context, message *tgbotapi.Message) {
+	err := database.DeleteUserPreferences(ctx, b.db, message.From.ID)
 	if err != nil {
 		b.sendMessage(message.Chat.ID, "Sorry, there was an error clearing your preferences.", nil)
 	} else {
@@ -561,32 +707,36 @@ func (b *Bot) handleClearPreferences(message *tgbotapi.Message) {
 // performSearch executes a property search based on the given user preferences.
 // It converts the database UserPreferences to the internal SearchPreferences format,
 // performs the search, and presents the results to the user.
-func (b *Bot) performSearch(chatID int64, prefs database.UserPreferences) {
-	// Convert UserPreferences to the format expected by searchProperties
-	searchPrefs := &SearchPreferences{
-		PropertyTypes:    prefs.PropertyTypes,
-		BedroomOptions:   prefs.BedroomOptions,
-		FurnishedOptions: prefs.Furnished,
-		PriceRange:       fmt.Sprintf("%d-%d", prefs.MinPrice, prefs.MaxPrice),
-		Location:         prefs.Location,
+func (b *Bot) performSearch(ctx context.Context, chatID int64, prefs database.UserPreferences) {
+	searchPrefs := searchPreferencesFromSaved(prefs)
+
+	// Keywords come from the /search command rather than the saved preferences
+	if current := b.getUserState(chatID).Preferences; current != nil {
+		searchPrefs.Keywords = current.Keywords
+		searchPrefs.Near = current.Near
+		searchPrefs.NewOnly = current.NewOnly
 	}
 
-	properties, err := b.searchProperties(searchPrefs)
+	results, err := b.searchProperties(ctx, chatID, searchPrefs)
 	if err != nil {
 		b.sendMessage(chatID, "Sorry, there was an error while searching for properties. Please try again later.", nil)
 		return
 	}
-	if len(properties) == 0 {
+	if len(results.Properties) == 0 && searchPrefs.NewOnly {
+		b.sendMessage(chatID, "There are no new properties matching your preferences since your last search. Check back later!", nil)
+		return
+	}
+	if len(results.Properties) == 0 {
 		b.sendMessage(chatID, "Sorry, no properties match your criteria. Try adjusting your preferences and searching again.", nil)
 		return
 	}
 
-	b.presentSearchResults(chatID, properties)
+	b.presentOrOfferResults(ctx, chatID, results)
 }
 
 // handleViewSavedListings shows all saved property listings
-func (b *Bot) handleViewSavedListings(message *tgbotapi.Message) {
-	properties, err := database.GetSavedListings(b.db, message.From.ID)
+func (b *Bot) handleViewSavedListings(ctx context.Context, message *tgbotapi.Message) {
+	properties, err := database.GetSavedListings(ctx, b.db, message.From.ID)
 	if err != nil {
 		b.sendMessage(message.Chat.ID, "Sorry, there was an error retrieving your saved listings. Please try again.", nil)
 		return
@@ -598,5 +748,70 @@ func (b *Bot) handleViewSavedListings(message *tgbotapi.Message) {
 	}
 
 	b.sendMessage(message.Chat.ID, "Here are your saved listings:", nil)
-	b.presentMultipleProperties(message.Chat.ID, properties, true)
+	b.presentMultipleProperties(ctx, message.Chat.ID, properties, true, nil)
+}
+
+// handleViewHiddenListings shows the listings the user has hidden, so they can unhide them
+func (b *Bot) handleViewHiddenListings(ctx context.Context, message *tgbotapi.Message) {
+	properties, err := database.GetHiddenListings(ctx, b.db, message.From.ID)
+	if err != nil {
+		b.sendMessage(message.Chat.ID, "Sorry, there was an error retrieving your hidden listings. Please try again.", nil)
+		return
+	}
+
+	if len(properties) == 0 {
+		b.sendMessage(message.Chat.ID, "You haven't hidden any listings.", nil)
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, "Here are the listings you've hidden:", nil)
+	for _, prop := range properties {
+		text := fmt.Sprintf("🙈 %s in %s, £%d/month", b.propertyTypeLabel(prop.Type), prop.Location, prop.PricePerMonth)
+		keyboard := tgbotapi.NewInlineKeyboardMarkup(
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Unhide", callbackData("unhide", prop.ID)),
+			),
+		)
+		b.sendMessage(message.Chat.ID, text, keyboard)
+	}
+}
+
+// handleRecommendCommand suggests properties similar to the user's saved listings.
+func (b *Bot) handleRecommendCommand(ctx context.Context, message *tgbotapi.Message) {
+	properties, err := b.recommendForUser(ctx, message.From.ID)
+	if errors.Is(err, errNoSavedListings) {
+		b.sendMessage(message.Chat.ID, "Save a few listings you like first, and I'll recommend similar homes.", nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Error recommending properties: %v", err)
+		b.sendMessage(message.Chat.ID, "Sorry, there was an error finding recommendations. Please try again later.", nil)
+		return
+	}
+
+	if len(properties) == 0 {
+		b.sendMessage(message.Chat.ID, "I couldn't find any available homes like the ones you saved. Check back later!", nil)
+		return
+	}
+
+	b.sendMessage(message.Chat.ID, "Based on the listings you saved, you might like these homes:", nil)
+	b.presentMultipleProperties(ctx, message.Chat.ID, properties, false, nil)
+}
+
+// showSimilarProperties shows available properties similar to the given property.
+func (b *Bot) showSimilarProperties(ctx context.Context, chatID int64, propertyID int) {
+	properties, err := b.recommendSimilar(ctx, chatID, propertyID)
+	if err != nil {
+		log.Printf("Error finding properties similar to %d: %v", propertyID, err)
+		b.sendMessage(chatID, "Sorry, there was an error finding similar homes. Please try again later.", nil)
+		return
+	}
+
+	if len(properties) == 0 {
+		b.sendMessage(chatID, "I couldn't find any similar homes right now.", nil)
+		return
+	}
+
+	b.sendMessage(chatID, "Here are some similar homes:", nil)
+	b.presentMultipleProperties(ctx, chatID, properties, false, nil)
 }
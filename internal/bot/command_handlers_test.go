@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -30,6 +31,9 @@ func (m *MockBotAPI2) GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.Upda
 	return make(tgbotapi.UpdatesChannel)
 }
 
+// StopReceivingUpdates mocks the method to stop getting updates
+func (m *MockBotAPI2) StopReceivingUpdates() {}
+
 // Send mocks the method to send messages
 func (m *MockBotAPI2) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
 	switch v := c.(type) {
@@ -96,6 +100,7 @@ func (m *MockBotAPI2) CallbackAnswered(callbackID string) bool {
 
 // TestHandleStartCommand tests the handleStartCommand function
 func TestHandleStartCommand(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -120,7 +125,7 @@ func TestHandleStartCommand(t *testing.T) {
 
 	mock.ExpectQuery("SELECT").WillReturnRows(sqlmock.NewRows([]string{"id"}))
 
-	bot.handleStartCommand(message)
+	bot.handleStartCommand(ctx, message)
 
 	// Check if a message was sent
 	if len(mockAPI.messages) == 0 {
@@ -144,6 +149,7 @@ func TestHandleStartCommand(t *testing.T) {
 
 // TestHandleHelpCommand tests the handleHelpCommand function
 func TestHandleHelpCommand(t *testing.T) {
+	ctx := context.Background()
 	mockAPI := &MockBotAPI2{}
 	bot := &Bot{
 		api:   mockAPI,
@@ -156,7 +162,7 @@ func TestHandleHelpCommand(t *testing.T) {
 		},
 	}
 
-	bot.handleHelpCommand(message)
+	bot.handleHelpCommand(ctx, message)
 
 	// Check if a message was sent
 	if len(mockAPI.messages) == 0 {
@@ -180,6 +186,7 @@ func TestHandleHelpCommand(t *testing.T) {
 
 // TestHandleSearchCommand tests the handleSearchCommand function
 func TestHandleSearchCommand(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -203,9 +210,9 @@ func TestHandleSearchCommand(t *testing.T) {
 	}
 
 	// Mock the database query for user preferences
-	mock.ExpectQuery("SELECT (.+) FROM user_preferences").WillReturnRows(sqlmock.NewRows([]string{"user_id", "property_type", "min_price", "max_price", "bedrooms", "furnished", "location", "last_search"}))
+	mock.ExpectQuery("SELECT (.+) FROM user_preferences").WillReturnRows(sqlmock.NewRows(userPreferencesColumns))
 
-	bot.handleSearchCommand(message)
+	bot.handleSearchCommand(ctx, message)
 
 	// Check if a message was sent
 	if len(mockAPI.messages) == 0 {
@@ -223,6 +230,7 @@ func TestHandleSearchCommand(t *testing.T) {
 
 // TestHandleSavePreferences tests the handleSavePreferences function
 func TestHandleSavePreferences(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -252,7 +260,8 @@ func TestHandleSavePreferences(t *testing.T) {
 	bot.state[userID] = &UserState{
 		Preferences: &SearchPreferences{
 			PropertyTypes:    map[string]bool{"Apartment": true},
-			BedroomOptions:   map[string]bool{"2": true},
+			MinBedrooms:      intPtr(2),
+			MaxBedrooms:      intPtr(2),
 			PriceRange:       "1000-2000",
 			Location:         "Bath",
 			FurnishedOptions: map[string]bool{"Furnished": true},
@@ -265,13 +274,18 @@ func TestHandleSavePreferences(t *testing.T) {
 		sqlmock.AnyArg(), // property_type JSON
 		1000,
 		2000,
-		sqlmock.AnyArg(), // bedrooms JSON
+		2,                // min_bedrooms
+		2,                // max_bedrooms
 		sqlmock.AnyArg(), // furnished JSON
 		"Bath",
+		"",               // listing_kind
+		sqlmock.AnyArg(), // move_in_date
+		0,                // tenancy_months
 		sqlmock.AnyArg(), // last_search timestamp
+		userID,           // keeps the existing alert setting
 	).WillReturnResult(sqlmock.NewResult(1, 1))
 
-	bot.handleSavePreferences(message)
+	bot.handleSavePreferences(ctx, message)
 
 	if len(mockAPI.messages) == 0 {
 		t.Error("Expected a message to be sent, but none was")
@@ -288,6 +302,7 @@ func TestHandleSavePreferences(t *testing.T) {
 
 // TestHandleViewPreferences tests the handleViewPreferences function
 func TestHandleViewPreferences(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -314,16 +329,15 @@ func TestHandleViewPreferences(t *testing.T) {
 	}
 
 	propertyTypesJSON, _ := json.Marshal(map[string]bool{"Apartment": true})
-	bedroomsJSON, _ := json.Marshal(map[string]bool{"2": true})
 	furnishedJSON, _ := json.Marshal(map[string]bool{"Furnished": true})
 	lastSearch := time.Now()
 
-	rows := sqlmock.NewRows([]string{"user_id", "property_type", "min_price", "max_price", "bedrooms", "furnished", "location", "last_search"}).
-		AddRow(userID, propertyTypesJSON, 1000, 2000, bedroomsJSON, furnishedJSON, "Bath", lastSearch)
+	rows := sqlmock.NewRows(userPreferencesColumns).
+		AddRow(userID, propertyTypesJSON, 1000, 2000, 2, 2, furnishedJSON, "Bath", "", nil, 0, lastSearch, true)
 
 	mock.ExpectQuery("SELECT (.+) FROM user_preferences").WithArgs(userID).WillReturnRows(rows)
 
-	bot.handleViewPreferences(message)
+	bot.handleViewPreferences(ctx, message)
 
 	if len(mockAPI.messages) == 0 {
 		t.Error("Expected a message to be sent, but none was")
@@ -350,6 +364,7 @@ func TestHandleViewPreferences(t *testing.T) {
 
 // TestHandleClearPreferences tests the handleClearPreferences function
 func TestHandleClearPreferences(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -377,7 +392,7 @@ func TestHandleClearPreferences(t *testing.T) {
 
 	mock.ExpectExec("DELETE FROM user_preferences").WithArgs(userID).WillReturnResult(sqlmock.NewResult(0, 1))
 
-	bot.handleClearPreferences(message)
+	bot.handleClearPreferences(ctx, message)
 
 	if len(mockAPI.messages) == 0 {
 		t.Error("Expected a message to be sent, but none was")
@@ -394,6 +409,7 @@ func TestHandleClearPreferences(t *testing.T) {
 
 // TestHandleViewSavedListings tests the handleViewSavedListings function
 func TestHandleViewSavedListings(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -419,12 +435,12 @@ func TestHandleViewSavedListings(t *testing.T) {
 		},
 	}
 
-	rows := sqlmock.NewRows([]string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link"}).
-		AddRow(1, "Apartment", 1500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com/property1")
+	rows := sqlmock.NewRows(propertyRowColumns).
+		AddRow(1, "Apartment", 1500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com/property1", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
 
 	mock.ExpectQuery("SELECT (.+) FROM properties (.+) JOIN saved_listings").WithArgs(userID).WillReturnRows(rows)
 
-	bot.handleViewSavedListings(message)
+	bot.handleViewSavedListings(ctx, message)
 
 	if len(mockAPI.messages) < 2 {
 		t.Error("Expected at least two messages to be sent")
@@ -447,8 +463,59 @@ func TestHandleViewSavedListings(t *testing.T) {
 	}
 }
 
+// TestHandleViewHiddenListings tests that hidden listings are shown with an Unhide button
+func TestHandleViewHiddenListings(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{
+		api:   mockAPI,
+		db:    db,
+		state: make(map[int64]*UserState),
+	}
+
+	userID := int64(123)
+	chatID := int64(456)
+
+	message := &tgbotapi.Message{
+		Chat: &tgbotapi.Chat{
+			ID: chatID,
+		},
+		From: &tgbotapi.User{
+			ID: userID,
+		},
+	}
+
+	rows := sqlmock.NewRows(propertyRowColumns).
+		AddRow(7, "Apartment", 1500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com/property7", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
+
+	mock.ExpectQuery("SELECT (.+) FROM properties (.+) JOIN hidden_listings").WithArgs(userID).WillReturnRows(rows)
+
+	bot.handleViewHiddenListings(ctx, message)
+
+	if len(mockAPI.messages) != 2 {
+		t.Fatalf("Expected 2 messages to be sent, got %d", len(mockAPI.messages))
+	}
+	if !strings.Contains(mockAPI.messages[1].Text, "Bath") || !strings.Contains(mockAPI.messages[1].Text, "1500") {
+		t.Errorf("Unexpected hidden listing message: %s", mockAPI.messages[1].Text)
+	}
+	keyboard, ok := mockAPI.messages[1].ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok || *keyboard.InlineKeyboard[0][0].CallbackData != callbackData("unhide", 7) {
+		t.Errorf("Expected an Unhide button for the hidden listing, got %+v", mockAPI.messages[1].ReplyMarkup)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
 // TestHandleViewPreferencesNoPreferences tests the handleViewPreferences function when no preferences are saved
 func TestHandleViewPreferencesNoPreferences(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -476,7 +543,7 @@ func TestHandleViewPreferencesNoPreferences(t *testing.T) {
 
 	mock.ExpectQuery("SELECT (.+) FROM user_preferences").WithArgs(userID).WillReturnError(sql.ErrNoRows)
 
-	bot.handleViewPreferences(message)
+	bot.handleViewPreferences(ctx, message)
 
 	if len(mockAPI.messages) == 0 {
 		t.Error("Expected a message to be sent, but none was")
@@ -493,6 +560,7 @@ func TestHandleViewPreferencesNoPreferences(t *testing.T) {
 
 // TestHandleSavePreferencesError tests the handleSavePreferences function when an error occurs
 func TestHandleSavePreferencesError(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -521,7 +589,8 @@ func TestHandleSavePreferencesError(t *testing.T) {
 	bot.state[userID] = &UserState{
 		Preferences: &SearchPreferences{
 			PropertyTypes:    map[string]bool{"Apartment": true},
-			BedroomOptions:   map[string]bool{"2": true},
+			MinBedrooms:      intPtr(2),
+			MaxBedrooms:      intPtr(2),
 			PriceRange:       "1000-2000",
 			Location:         "Bath",
 			FurnishedOptions: map[string]bool{"Furnished": true},
@@ -537,9 +606,14 @@ func TestHandleSavePreferencesError(t *testing.T) {
 		sqlmock.AnyArg(),
 		sqlmock.AnyArg(),
 		sqlmock.AnyArg(),
+		sqlmock.AnyArg(),
+		sqlmock.AnyArg(),
+		sqlmock.AnyArg(),
+		sqlmock.AnyArg(),
+		sqlmock.AnyArg(),
 	).WillReturnError(errors.New("database error"))
 
-	bot.handleSavePreferences(message)
+	bot.handleSavePreferences(ctx, message)
 
 	if len(mockAPI.messages) == 0 {
 		t.Error("Expected a message to be sent, but none was")
@@ -556,6 +630,7 @@ func TestHandleSavePreferencesError(t *testing.T) {
 
 // TestHandleSearchCommandWithExistingPreferences tests the handleSearchCommand function with existing preferences
 func TestHandleSearchCommandWithExistingPreferences(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -582,16 +657,15 @@ func TestHandleSearchCommandWithExistingPreferences(t *testing.T) {
 	}
 
 	propertyTypesJSON, _ := json.Marshal(map[string]bool{"Apartment": true})
-	bedroomsJSON, _ := json.Marshal(map[string]bool{"2": true})
 	furnishedJSON, _ := json.Marshal(map[string]bool{"Furnished": true})
 	lastSearch := time.Now()
 
-	rows := sqlmock.NewRows([]string{"user_id", "property_type", "min_price", "max_price", "bedrooms", "furnished", "location", "last_search"}).
-		AddRow(userID, propertyTypesJSON, 1000, 2000, bedroomsJSON, furnishedJSON, "Bath", lastSearch)
+	rows := sqlmock.NewRows(userPreferencesColumns).
+		AddRow(userID, propertyTypesJSON, 1000, 2000, 2, 2, furnishedJSON, "Bath", "", nil, 0, lastSearch, true)
 
 	mock.ExpectQuery("SELECT (.+) FROM user_preferences").WithArgs(userID).WillReturnRows(rows)
 
-	bot.handleSearchCommand(message)
+	bot.handleSearchCommand(ctx, message)
 
 	if len(mockAPI.messages) == 0 {
 		t.Error("Expected a message to be sent, but none was")
@@ -611,6 +685,7 @@ func TestHandleSearchCommandWithExistingPreferences(t *testing.T) {
 
 // TestHandleInvalidCommand tests the handling of an invalid command
 func TestHandleInvalidCommand(t *testing.T) {
+	ctx := context.Background()
 	mockAPI := &MockBotAPI2{}
 	bot := &Bot{
 		api:   mockAPI,
@@ -624,7 +699,7 @@ func TestHandleInvalidCommand(t *testing.T) {
 		Text: "/invalidcommand",
 	}
 
-	bot.handleCommand(message)
+	bot.handleCommand(ctx, message)
 
 	if len(mockAPI.messages) == 0 {
 		t.Error("Expected a message to be sent, but none was")
@@ -639,9 +714,8 @@ func TestHandleInvalidCommand(t *testing.T) {
 	}
 }
 
-// TestValidatePriceRangeEdgeCases tests the validatePriceRange function with various edge cases
+// TestValidatePriceRangeEdgeCases tests parsePrice validation with various edge cases
 func TestValidatePriceRangeEdgeCases(t *testing.T) {
-	bot := &Bot{}
 	testCases := []struct {
 		name     string
 		input    string
@@ -650,7 +724,7 @@ func TestValidatePriceRangeEdgeCases(t *testing.T) {
 		{"Valid range", "1000-2000", true},
 		{"Valid range with spaces", " 1000 - 2000 ", true},
 		{"Invalid format", "1000 2000", false},
-		{"Single number", "1000", false},
+		{"Single number", "1000", true},
 		{"Empty string", "", false},
 		{"Non-numeric", "abc-def", false},
 		{"Negative numbers", "-1000--500", false}, // Depending on your implementation
@@ -661,9 +735,9 @@ func TestValidatePriceRangeEdgeCases(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result := bot.validatePriceRange(tc.input)
-			if result != tc.expected {
-				t.Errorf("validatePriceRange(%s) = %v, want %v", tc.input, result, tc.expected)
+			_, err := parsePrice(tc.input)
+			if result := err == nil; result != tc.expected {
+				t.Errorf("parsePrice(%s) valid = %v, want %v", tc.input, result, tc.expected)
 			}
 		})
 	}
@@ -671,6 +745,7 @@ func TestValidatePriceRangeEdgeCases(t *testing.T) {
 
 // TestShowSummaryWithDifferentPreferences tests the showSummary function with different preference configurations
 func TestShowSummaryWithDifferentPreferences(t *testing.T) {
+	ctx := context.Background()
 	testCases := []struct {
 		name        string
 		preferences *SearchPreferences
@@ -680,7 +755,8 @@ func TestShowSummaryWithDifferentPreferences(t *testing.T) {
 			name: "All preferences set",
 			preferences: &SearchPreferences{
 				PropertyTypes:    map[string]bool{"Apartment": true, "House": true},
-				BedroomOptions:   map[string]bool{"2": true, "3": true},
+				MinBedrooms:      intPtr(2),
+				MaxBedrooms:      intPtr(3),
 				PriceRange:       "1000-2000",
 				Location:         "Bath",
 				FurnishedOptions: map[string]bool{"Furnished": true},
@@ -699,10 +775,11 @@ func TestShowSummaryWithDifferentPreferences(t *testing.T) {
 		{
 			name: "No furnished preference",
 			preferences: &SearchPreferences{
-				PropertyTypes:  map[string]bool{"House": true},
-				BedroomOptions: map[string]bool{"4": true},
-				PriceRange:     "2000-3000",
-				Location:       "Bath",
+				PropertyTypes: map[string]bool{"House": true},
+				MinBedrooms:   intPtr(4),
+				MaxBedrooms:   intPtr(4),
+				PriceRange:    "2000-3000",
+				Location:      "Bath",
 			},
 			expected: []string{"House", "2000-3000", "4", "Bath"},
 		},
@@ -732,10 +809,10 @@ func TestShowSummaryWithDifferentPreferences(t *testing.T) {
 			}
 
 			// Mock the database query for property search
-			rows := sqlmock.NewRows([]string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link"})
+			rows := sqlmock.NewRows(propertyRowColumns)
 			mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(rows)
 
-			bot.showSummary(chatID)
+			bot.showSummary(ctx, chatID)
 
 			if len(mockAPI.messages) < 1 {
 				t.Errorf("Expected at least 1 message to be sent, but got %d", len(mockAPI.messages))
@@ -753,6 +830,7 @@ func TestShowSummaryWithDifferentPreferences(t *testing.T) {
 
 // TestHandleRegularMessageWithDifferentStates tests the handleRegularMessage function with different user states
 func TestHandleRegularMessageWithDifferentStates(t *testing.T) {
+	ctx := context.Background()
 	testCases := []struct {
 		name          string
 		initialState  string
@@ -777,8 +855,8 @@ func TestHandleRegularMessageWithDifferentStates(t *testing.T) {
 			expectedReply: "Great! Here's a summary of your preferences:",
 			setupMock: func(mock sqlmock.Sqlmock) {
 				// Mock the search query
-				rows := sqlmock.NewRows([]string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link"}).
-					AddRow(1, "Apartment", 1500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com/property1")
+				rows := sqlmock.NewRows(propertyRowColumns).
+					AddRow(1, "Apartment", 1500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com/property1", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
 				mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(rows)
 			},
 		},
@@ -828,7 +906,7 @@ func TestHandleRegularMessageWithDifferentStates(t *testing.T) {
 				Text: tc.messageText,
 			}
 
-			bot.handleRegularMessage(message)
+			bot.handleRegularMessage(ctx, message)
 
 			if bot.state[userID].Stage != tc.expectedState {
 				t.Errorf("Expected state to be '%s', but got '%s'", tc.expectedState, bot.state[userID].Stage)
@@ -844,6 +922,7 @@ func TestHandleRegularMessageWithDifferentStates(t *testing.T) {
 
 // TestHandleCallbackQueryWithVariousData tests the handleCallbackQuery function with various callback data
 func TestHandleCallbackQueryWithVariousData(t *testing.T) {
+	ctx := context.Background()
 	testCases := []struct {
 		name           string
 		callbackData   string
@@ -859,10 +938,23 @@ func TestHandleCallbackQueryWithVariousData(t *testing.T) {
 			expectedState:  "initial",
 			expectedAction: "performSearch",
 			setupMock: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"user_id", "property_type", "min_price", "max_price", "bedrooms", "furnished", "location", "last_search"}).
-					AddRow(123, "{}", 1000, 2000, "{}", "{}", "Bath", time.Now())
+				rows := sqlmock.NewRows(userPreferencesColumns).
+					AddRow(123, "{}", 1000, 2000, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true)
+				mock.ExpectQuery("SELECT (.+) FROM user_preferences").WillReturnRows(rows)
+				mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows(propertyRowColumns))
+			},
+		},
+		{
+			name:           "Use saved preferences for new listings only",
+			callbackData:   "use_saved_prefs:new",
+			initialState:   "initial",
+			expectedState:  "initial",
+			expectedAction: "performSearch",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				rows := sqlmock.NewRows(userPreferencesColumns).
+					AddRow(123, "{}", 1000, 2000, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true)
 				mock.ExpectQuery("SELECT (.+) FROM user_preferences").WillReturnRows(rows)
-				mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link"}))
+				mock.ExpectQuery("SELECT (.+) FROM properties (.+) listing_impressions").WillReturnRows(sqlmock.NewRows(propertyRowColumns))
 			},
 		},
 		{
@@ -874,11 +966,39 @@ func TestHandleCallbackQueryWithVariousData(t *testing.T) {
 		},
 		{
 			name:           "Property type selection",
-			callbackData:   "property_type:Apartment",
+			callbackData:   "property_type:flat",
 			initialState:   "awaiting_property_type",
 			expectedState:  "awaiting_property_type",
 			expectedAction: "editMessageReplyMarkup",
 		},
+		{
+			name:           "Property type group selection",
+			callbackData:   "property_group:house",
+			initialState:   "awaiting_property_type",
+			expectedState:  "awaiting_property_type",
+			expectedAction: "editMessageReplyMarkup",
+		},
+		{
+			name:           "Property type done",
+			callbackData:   "property_type:done",
+			initialState:   "awaiting_property_type",
+			expectedState:  "awaiting_listing_kind",
+			expectedAction: "sendMessage",
+		},
+		{
+			name:           "Whole property listing kind",
+			callbackData:   "listing_kind:whole",
+			initialState:   "awaiting_listing_kind",
+			expectedState:  "awaiting_bedrooms",
+			expectedAction: "sendMessage",
+		},
+		{
+			name:           "Room listing kind skips bedrooms",
+			callbackData:   "listing_kind:room",
+			initialState:   "awaiting_listing_kind",
+			expectedState:  "awaiting_price_range",
+			expectedAction: "sendMessage",
+		},
 		{
 			name:           "Bedroom selection",
 			callbackData:   "bedrooms:2",
@@ -893,6 +1013,41 @@ func TestHandleCallbackQueryWithVariousData(t *testing.T) {
 			expectedState:  "awaiting_furnished",
 			expectedAction: "editMessageReplyMarkup",
 		},
+		{
+			name:           "Furnished done",
+			callbackData:   "furnished:done",
+			initialState:   "awaiting_furnished",
+			expectedState:  "awaiting_move_in_date",
+			expectedAction: "sendMessage",
+		},
+		{
+			name:           "Move-in quick pick",
+			callbackData:   "move_in:asap",
+			initialState:   "awaiting_move_in_date",
+			expectedState:  "awaiting_tenancy",
+			expectedAction: "sendMessage",
+		},
+		{
+			name:           "Tenancy length selection",
+			callbackData:   "tenancy:12",
+			initialState:   "awaiting_tenancy",
+			expectedState:  "awaiting_location",
+			expectedAction: "sendMessage",
+		},
+		{
+			name:           "Decline relaxed results",
+			callbackData:   "relaxed:decline",
+			initialState:   "showing_summary",
+			expectedState:  "showing_summary",
+			expectedAction: "sendMessage",
+		},
+		{
+			name:           "Price bucket selection",
+			callbackData:   "price:800-1200",
+			initialState:   "awaiting_price_range",
+			expectedState:  "awaiting_furnished",
+			expectedAction: "sendMessage",
+		},
 		{
 			name:           "Location selection",
 			callbackData:   "location:Bath",
@@ -900,7 +1055,7 @@ func TestHandleCallbackQueryWithVariousData(t *testing.T) {
 			expectedState:  "showing_summary",
 			expectedAction: "sendMessage",
 			setupMock: func(mock sqlmock.Sqlmock) {
-				mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link"}))
+				mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows(propertyRowColumns))
 			},
 		},
 		{
@@ -923,6 +1078,29 @@ func TestHandleCallbackQueryWithVariousData(t *testing.T) {
 				mock.ExpectExec("DELETE FROM saved_listings").WillReturnResult(sqlmock.NewResult(1, 1))
 			},
 		},
+		{
+			name:           "Hide listing",
+			callbackData:   "hide:123",
+			initialState:   "showing_results",
+			expectedState:  "showing_results",
+			expectedAction: "editMessageText",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("INSERT OR IGNORE INTO hidden_listings").WithArgs(int64(123), 123).WillReturnResult(sqlmock.NewResult(1, 1))
+			},
+		},
+		{
+			name:           "Unhide listing",
+			callbackData:   "unhide:123",
+			initialState:   "showing_results",
+			expectedState:  "showing_results",
+			expectedAction: "editMessageText",
+			setupMock: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec("DELETE FROM hidden_listings").WithArgs(int64(123), 123).WillReturnResult(sqlmock.NewResult(1, 1))
+				rows := sqlmock.NewRows(propertyRowColumns).
+					AddRow(123, "Apartment", 1500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
+				mock.ExpectQuery("SELECT (.+) FROM properties WHERE id = ?").WithArgs(123).WillReturnRows(rows)
+			},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -951,7 +1129,6 @@ func TestHandleCallbackQueryWithVariousData(t *testing.T) {
 				Stage: tc.initialState,
 				Preferences: &SearchPreferences{
 					PropertyTypes:    make(map[string]bool),
-					BedroomOptions:   make(map[string]bool),
 					FurnishedOptions: make(map[string]bool),
 				},
 			}
@@ -970,7 +1147,7 @@ func TestHandleCallbackQueryWithVariousData(t *testing.T) {
 				ID: "query_id",
 			}
 
-			bot.handleCallbackQuery(callbackQuery)
+			bot.handleCallbackQuery(ctx, callbackQuery)
 
 			if bot.state[userID].Stage != tc.expectedState {
 				t.Errorf("Expected state to be '%s', but got '%s'", tc.expectedState, bot.state[userID].Stage)
@@ -0,0 +1,397 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// commandScope is where a command may be used. Scopes are combined with |.
+type commandScope int
+
+const (
+	scopePrivate commandScope = 1 << iota // private chats with the bot
+	scopeGroup                            // group chats
+	scopeAdmin                            // only admins, who also see it in their command menu
+)
+
+// Limits Telegram puts on commands.
+var commandNamePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+const (
+	minCommandDescription = 3
+	maxCommandDescription = 256
+	maxCommandSuggestions = 3
+)
+
+// command is a command the bot understands. The registry uses it to route the command, write /help
+// and tell Telegram which commands to show in its command menu.
+type command struct {
+	Name         string
+	Args         string            // arguments it takes, shown in /help, such as "[keywords]"
+	Description  string            // one line for Telegram's command menu
+	Help         string            // more detail for /help; the description is used if it's empty
+	Translations map[string]string // descriptions in other languages, by IETF language code
+	Scopes       commandScope
+	Handler      func(b *Bot, ctx context.Context, message *tgbotapi.Message)
+}
+
+// usableIn reports whether the command may be used in a group or private chat, by an admin or not.
+func (c command) usableIn(group, admin bool) bool {
+	if c.Scopes&scopeAdmin != 0 && !admin {
+		return false
+	}
+	if group {
+		return c.Scopes&scopeGroup != 0
+	}
+	return c.Scopes&scopePrivate != 0
+}
+
+// botCommands are the commands the bot understands, in the order /help and the command menu list them.
+// It's set in init, as the help command's handler refers to it.
+var botCommands *commandRegistry
+
+func init() {
+	botCommands = newCommandRegistry(
+		command{
+			Name:         "start",
+			Description:  "Start the bot",
+			Help:         "Initiates the bot and displays a welcome message.",
+			Translations: map[string]string{"cy": "Dechrau'r bot"},
+			Scopes:       scopePrivate | scopeGroup,
+			Handler:      (*Bot).handleStartCommand,
+		},
+		command{
+			Name:         "search",
+			Args:         "[keywords]",
+			Description:  "Search for a property",
+			Help:         "Starts a property search using your saved preferences if they are available. You will be prompted to provide details if no preferences are saved. Add words to rank the results by them, e.g. /search near uni balcony parking.",
+			Translations: map[string]string{"cy": "Chwilio am eiddo"},
+			Scopes:       scopePrivate,
+			Handler:      (*Bot).handleSearchCommand,
+		},
+		command{
+			Name:         "save_preferences",
+			Description:  "Save your search preferences",
+			Help:         "Saves your current search preferences for future use. This includes details such as property type, price range, number of bedrooms, furnishing status, and location.",
+			Translations: map[string]string{"cy": "Cadw eich dewisiadau chwilio"},
+			Scopes:       scopePrivate,
+			Handler:      (*Bot).handleSavePreferences,
+		},
+		command{
+			Name:         "view_preferences",
+			Description:  "Show your saved search preferences",
+			Help:         "Displays your currently saved search preferences.",
+			Translations: map[string]string{"cy": "Dangos eich dewisiadau chwilio"},
+			Scopes:       scopePrivate,
+			Handler:      (*Bot).handleViewPreferences,
+		},
+		command{
+			Name:         "clear_preferences",
+			Description:  "Clear your saved search preferences",
+			Help:         "Clears all your saved search preferences.",
+			Translations: map[string]string{"cy": "Clirio eich dewisiadau chwilio"},
+			Scopes:       scopePrivate,
+			Handler:      (*Bot).handleClearPreferences,
+		},
+		command{
+			Name:         "saved",
+			Description:  "Show the listings you saved",
+			Help:         `View all your saved property listings. To save a listing, use the "Save Listing" button that appears below each property listing. You'll be told if the rent of a saved listing drops or rises, or it goes under offer or is let.`,
+			Translations: map[string]string{"cy": "Dangos yr eiddo a gadwoch"},
+			Scopes:       scopePrivate,
+			Handler:      (*Bot).handleViewSavedListings,
+		},
+		command{
+			Name:         "recommend",
+			Description:  "Suggest homes like the ones you saved",
+			Help:         `Suggests homes similar to the listings you saved. You can also tap "Similar homes" on any listing.`,
+			Translations: map[string]string{"cy": "Awgrymu cartrefi tebyg i'r rhai a gadwoch"},
+			Scopes:       scopePrivate,
+			Handler:      (*Bot).handleRecommendCommand,
+		},
+		command{
+			Name:         "hidden",
+			Description:  "Show the listings you hid",
+			Help:         `View the listings you hid with the "Hide" button, and unhide them if you change your mind.`,
+			Translations: map[string]string{"cy": "Dangos yr eiddo a guddioch"},
+			Scopes:       scopePrivate,
+			Handler:      (*Bot).handleViewHiddenListings,
+		},
+		command{
+			Name:         "alerts",
+			Description:  "Turn new property alerts on or off",
+			Help:         "Turn alerts for new properties matching your saved preferences on or off.",
+			Translations: map[string]string{"cy": "Troi rhybuddion eiddo newydd ymlaen neu i ffwrdd"},
+			Scopes:       scopePrivate,
+			Handler:      (*Bot).handleAlertsCommand,
+		},
+		command{
+			Name:         "digest",
+			Description:  "Get your alerts in a daily or weekly digest",
+			Help:         "Get new matches, price drops and status changes in one daily or weekly message at a time of your choosing, instead of instant alerts.",
+			Translations: map[string]string{"cy": "Cael eich rhybuddion mewn crynodeb dyddiol neu wythnosol"},
+			Scopes:       scopePrivate,
+			Handler:      (*Bot).handleDigestCommand,
+		},
+		command{
+			Name:         "notifications",
+			Description:  "Set quiet hours and alert limits",
+			Help:         "Set quiet hours, a maximum number of alerts per day and which kinds of alerts you get, or mute notifications for a few days. Alerts held back are sent afterwards.",
+			Translations: map[string]string{"cy": "Gosod oriau tawel a therfynau rhybuddion"},
+			Scopes:       scopePrivate,
+			Handler:      (*Bot).handleNotificationsCommand,
+		},
+		command{
+			Name:         "help",
+			Description:  "List the commands",
+			Help:         "Provides information about all available commands and their usage.",
+			Translations: map[string]string{"cy": "Rhestru'r gorchmynion"},
+			Scopes:       scopePrivate | scopeGroup,
+			Handler:      (*Bot).handleHelpCommand,
+		},
+		command{
+			Name:         "stats",
+			Description:  "Show how busy the bot is",
+			Help:         "Shows how many updates were handled and how long each kind took.",
+			Translations: map[string]string{"cy": "Dangos pa mor brysur yw'r bot"},
+			Scopes:       scopePrivate | scopeAdmin,
+			Handler:      (*Bot).handleStatsCommand,
+		},
+	)
+}
+
+// commandRegistry holds the bot's commands in the order they're listed.
+type commandRegistry struct {
+	commands []command
+	byName   map[string]int
+}
+
+// newCommandRegistry creates a registry of the commands. It panics if a command is invalid or registered
+// twice, as Telegram would refuse the list.
+func newCommandRegistry(commands ...command) *commandRegistry {
+	r := &commandRegistry{byName: make(map[string]int)}
+	for _, c := range commands {
+		if !commandNamePattern.MatchString(c.Name) {
+			panic(fmt.Sprintf("invalid command name %q", c.Name))
+		}
+		if _, ok := r.byName[c.Name]; ok {
+			panic(fmt.Sprintf("command /%s registered twice", c.Name))
+		}
+		descriptions := []string{c.Description}
+		for _, d := range c.Translations {
+			descriptions = append(descriptions, d)
+		}
+		for _, d := range descriptions {
+			if n := len([]rune(d)); n < minCommandDescription || n > maxCommandDescription {
+				panic(fmt.Sprintf("description of /%s must be %d-%d characters", c.Name, minCommandDescription, maxCommandDescription))
+			}
+		}
+		if c.Scopes&(scopePrivate|scopeGroup) == 0 || c.Handler == nil {
+			panic(fmt.Sprintf("command /%s needs a chat scope and a handler", c.Name))
+		}
+		r.byName[c.Name] = len(r.commands)
+		r.commands = append(r.commands, c)
+	}
+	return r
+}
+
+// lookup returns the command with the name.
+func (r *commandRegistry) lookup(name string) (command, bool) {
+	i, ok := r.byName[name]
+	if !ok {
+		return command{}, false
+	}
+	return r.commands[i], true
+}
+
+// usable returns the commands that may be used in a group or private chat, by an admin or not, in order.
+func (r *commandRegistry) usable(group, admin bool) []command {
+	var usable []command
+	for _, c := range r.commands {
+		if c.usableIn(group, admin) {
+			usable = append(usable, c)
+		}
+	}
+	return usable
+}
+
+// suggest returns the names of the usable commands closest to a mistyped one, closest first:
+// those it's a prefix of, or that are a couple of edits away.
+func (r *commandRegistry) suggest(name string, group, admin bool) []string {
+	type match struct {
+		name     string
+		distance int
+	}
+	var matches []match
+	for _, c := range r.usable(group, admin) {
+		distance := editDistance(name, c.Name)
+		if len(name) >= 3 && strings.HasPrefix(c.Name, name) {
+			distance = 0
+		}
+		if distance <= 2 {
+			matches = append(matches, match{c.Name, distance})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+
+	var names []string
+	for i := 0; i < len(matches) && i < maxCommandSuggestions; i++ {
+		names = append(names, matches[i].name)
+	}
+	return names
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// helpText lists the commands that may be used in a group or private chat, by an admin or not.
+func (r *commandRegistry) helpText(group, admin bool) string {
+	var sb strings.Builder
+	sb.WriteString("Welcome to RentSeekerBot!\n\nBelow are the commands you can use to interact with the bot:\n")
+	for i, c := range r.usable(group, admin) {
+		usage := "/" + c.Name
+		if c.Args != "" {
+			usage += " " + c.Args
+		}
+		help := c.Help
+		if help == "" {
+			help = c.Description
+		}
+		fmt.Fprintf(&sb, "\n%d. %s - %s\n", i+1, usage, help)
+	}
+	if group {
+		sb.WriteString("\nMore commands are available in a private chat with me.\n")
+	}
+	sb.WriteString("\nIf you need further assistance or have any questions, please do not hesitate to contact our support team. Thank you for using RentSeekerBot!")
+	return sb.String()
+}
+
+// languages returns the language codes the commands are translated into, in order.
+func (r *commandRegistry) languages() []string {
+	seen := make(map[string]bool)
+	var languages []string
+	for _, c := range r.commands {
+		for language := range c.Translations {
+			if !seen[language] {
+				seen[language] = true
+				languages = append(languages, language)
+			}
+		}
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// menu returns the commands for Telegram's command menu, described in the language, or in English if
+// the language is empty or a command isn't translated into it.
+func menu(commands []command, language string) []tgbotapi.BotCommand {
+	entries := make([]tgbotapi.BotCommand, 0, len(commands))
+	for _, c := range commands {
+		description := c.Description
+		if translated, ok := c.Translations[language]; ok {
+			description = translated
+		}
+		entries = append(entries, tgbotapi.BotCommand{Command: c.Name, Description: description})
+	}
+	return entries
+}
+
+// menuConfigs returns the setMyCommands requests for each scope and language: the commands for private
+// chats, for groups, and for each admin's private chat, which includes the admin commands.
+func (r *commandRegistry) menuConfigs(adminIDs []int64) []tgbotapi.SetMyCommandsConfig {
+	var configs []tgbotapi.SetMyCommandsConfig
+	for _, language := range append([]string{""}, r.languages()...) {
+		configs = append(configs,
+			tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeAllPrivateChats(), language, menu(r.usable(false, false), language)...),
+			tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeAllGroupChats(), language, menu(r.usable(true, false), language)...),
+		)
+		for _, adminID := range adminIDs {
+			configs = append(configs,
+				tgbotapi.NewSetMyCommandsWithScopeAndLanguage(tgbotapi.NewBotCommandScopeChat(adminID), language, menu(r.usable(false, true), language)...))
+		}
+	}
+	return configs
+}
+
+// RegisterCommands tells Telegram which commands to show in its command menu, for each kind of chat
+// and language, and for the admins. An admin who hasn't started a chat with the bot is skipped.
+func (b *Bot) RegisterCommands() error {
+	adminIDs := make([]int64, 0, len(b.admins))
+	for id := range b.admins {
+		adminIDs = append(adminIDs, id)
+	}
+	sort.Slice(adminIDs, func(i, j int) bool { return adminIDs[i] < adminIDs[j] })
+
+	for _, config := range botCommands.menuConfigs(adminIDs) {
+		if _, err := b.api.Request(config); err != nil {
+			if config.Scope != nil && config.Scope.Type == "chat" {
+				log.Printf("Error setting the command menu for admin %d: %v", config.Scope.ChatID, err)
+				continue
+			}
+			return fmt.Errorf("error setting the command menu: %w", err)
+		}
+	}
+	return nil
+}
+
+// isGroupChat reports whether a chat is a group rather than a private chat.
+func isGroupChat(chat *tgbotapi.Chat) bool {
+	return chat != nil && (chat.IsGroup() || chat.IsSuperGroup())
+}
+
+// handleCommand routes a command to its handler, if it may be used in the chat. Commands addressed to
+// another bot in a group are ignored, and mistyped ones get suggestions.
+func (b *Bot) handleCommand(ctx context.Context, message *tgbotapi.Message) {
+	if _, to, ok := strings.Cut(message.CommandWithAt(), "@"); ok && !strings.EqualFold(to, b.botUserName) {
+		return
+	}
+
+	name := strings.ToLower(message.Command())
+	group := isGroupChat(message.Chat)
+	admin := requestFrom(ctx).Admin
+	c, ok := botCommands.lookup(name)
+	switch {
+	case ok && c.usableIn(group, admin):
+		c.Handler(b, ctx, message)
+	case ok && group && c.usableIn(false, admin):
+		b.sendMessage(message.Chat.ID, fmt.Sprintf("/%s only works in a private chat with me.", name), nil)
+	default:
+		b.sendMessage(message.Chat.ID, unknownCommandText(botCommands.suggest(name, group, admin)), nil)
+	}
+}
+
+// unknownCommandText tells the user a command wasn't recognised, suggesting the ones they might have meant.
+func unknownCommandText(suggestions []string) string {
+	if len(suggestions) == 0 {
+		return "Unknown command. Type /help for available commands."
+	}
+	for i, s := range suggestions {
+		suggestions[i] = "/" + s
+	}
+	return fmt.Sprintf("Unknown command. Did you mean %s? Type /help for available commands.", strings.Join(suggestions, " or "))
+}
@@ -0,0 +1,256 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"strings"
+	"testing"
+)
+
+// menuAPI records the command menus it's sent, failing those for the given chat
+type menuAPI struct {
+	MockBotAPI2
+	menus    []tgbotapi.SetMyCommandsConfig
+	failChat int64
+}
+
+// Request records setMyCommands requests
+func (m *menuAPI) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	if config, ok := c.(tgbotapi.SetMyCommandsConfig); ok {
+		if config.Scope != nil && config.Scope.ChatID == m.failChat && m.failChat != 0 {
+			return nil, errors.New("Bad Request: chat not found")
+		}
+		m.menus = append(m.menus, config)
+	}
+	return m.MockBotAPI2.Request(c)
+}
+
+// testCommands returns a registry with a command for each scope, which records the commands handled
+func testCommands(handled *[]string) *commandRegistry {
+	handler := func(name string) func(*Bot, context.Context, *tgbotapi.Message) {
+		return func(b *Bot, ctx context.Context, message *tgbotapi.Message) {
+			*handled = append(*handled, name)
+		}
+	}
+	return newCommandRegistry(
+		command{Name: "help", Description: "List the commands", Translations: map[string]string{"de": "Befehle anzeigen"}, Scopes: scopePrivate | scopeGroup, Handler: handler("help")},
+		command{Name: "search", Args: "[keywords]", Description: "Search for a property", Help: "Starts a search.", Scopes: scopePrivate, Handler: handler("search")},
+		command{Name: "saved", Description: "Show saved listings", Scopes: scopePrivate, Handler: handler("saved")},
+		command{Name: "stats", Description: "Show how busy the bot is", Scopes: scopePrivate | scopeAdmin, Handler: handler("stats")},
+	)
+}
+
+// useCommands replaces the bot's commands for the rest of the test
+func useCommands(t *testing.T, r *commandRegistry) {
+	saved := botCommands
+	botCommands = r
+	t.Cleanup(func() { botCommands = saved })
+}
+
+// commandIn returns a message with a command sent to a chat, a group if the chat ID is negative
+func commandIn(chatID, userID int64, text string) *tgbotapi.Message {
+	message := commandUpdate(userID, text).Message
+	message.Chat = &tgbotapi.Chat{ID: chatID, Type: "private"}
+	if chatID < 0 {
+		message.Chat.Type = "supergroup"
+	}
+	command, _, _ := strings.Cut(text, " ")
+	message.Entities[0].Length = len(command)
+	return message
+}
+
+// TestNewCommandRegistry tests that commands Telegram would refuse are rejected when the registry is created
+func TestNewCommandRegistry(t *testing.T) {
+	handler := (*Bot).handleHelpCommand
+	testCases := []struct {
+		name     string
+		commands []command
+	}{
+		{"Upper case name", []command{{Name: "Help", Description: "List the commands", Scopes: scopePrivate, Handler: handler}}},
+		{"Long name", []command{{Name: strings.Repeat("a", 33), Description: "List the commands", Scopes: scopePrivate, Handler: handler}}},
+		{"Short description", []command{{Name: "help", Description: "Hi", Scopes: scopePrivate, Handler: handler}}},
+		{"Short translation", []command{{Name: "help", Description: "List the commands", Translations: map[string]string{"de": ""}, Scopes: scopePrivate, Handler: handler}}},
+		{"No chat scope", []command{{Name: "help", Description: "List the commands", Scopes: scopeAdmin, Handler: handler}}},
+		{"No handler", []command{{Name: "help", Description: "List the commands", Scopes: scopePrivate}}},
+		{"Duplicate", []command{
+			{Name: "help", Description: "List the commands", Scopes: scopePrivate, Handler: handler},
+			{Name: "help", Description: "List the commands", Scopes: scopeGroup, Handler: handler},
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected newCommandRegistry to panic")
+				}
+			}()
+			newCommandRegistry(tc.commands...)
+		})
+	}
+}
+
+// TestHandleCommandRouting tests that commands are routed by scope, and mistyped ones get suggestions
+func TestHandleCommandRouting(t *testing.T) {
+	var handled []string
+	useCommands(t, testCommands(&handled))
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, botUserName: "RentSeekerBot", state: make(map[int64]*UserState)}
+	admin := withRequest(context.Background(), &request{Admin: true})
+
+	testCases := []struct {
+		name     string
+		ctx      context.Context
+		message  *tgbotapi.Message
+		handled  string
+		expected string
+	}{
+		{"Private command", context.Background(), commandIn(123, 123, "/search flat"), "search", ""},
+		{"Addressed to the bot", context.Background(), commandIn(-100, 123, "/help@RentSeekerBot"), "help", ""},
+		{"Addressed to another bot", context.Background(), commandIn(-100, 123, "/help@OtherBot"), "", ""},
+		{"Private command in a group", context.Background(), commandIn(-100, 123, "/search"), "", "only works in a private chat"},
+		{"Admin command", admin, commandIn(123, 123, "/stats"), "stats", ""},
+		{"Admin command from a user", context.Background(), commandIn(123, 123, "/stats"), "", "Unknown command. Type /help"},
+		{"Typo", context.Background(), commandIn(123, 123, "/serch"), "", "Did you mean /search?"},
+		{"Prefix", context.Background(), commandIn(123, 123, "/sav"), "", "Did you mean /saved?"},
+		{"Unknown", context.Background(), commandIn(123, 123, "/weather"), "", "Unknown command. Type /help"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handled = nil
+			mockAPI.messages = nil
+			bot.handleCommand(tc.ctx, tc.message)
+
+			if tc.handled != "" && (len(handled) != 1 || handled[0] != tc.handled) {
+				t.Errorf("Expected /%s to be handled, got %v", tc.handled, handled)
+			}
+			if tc.handled == "" && len(handled) != 0 {
+				t.Errorf("Expected nothing to be handled, got %v", handled)
+			}
+			if tc.expected == "" && len(mockAPI.messages) != 0 {
+				t.Errorf("Expected no reply, got %q", mockAPI.messages[0].Text)
+			}
+			if tc.expected != "" && !mockAPI.MessageSent(tc.message.Chat.ID, tc.expected) {
+				t.Errorf("Expected a reply containing %q, got %+v", tc.expected, mockAPI.messages)
+			}
+		})
+	}
+}
+
+// TestHelpText tests that /help lists the commands usable in the chat, in order, with their arguments
+func TestHelpText(t *testing.T) {
+	var handled []string
+	r := testCommands(&handled)
+
+	private := r.helpText(false, false)
+	if !strings.Contains(private, "1. /help - List the commands") || !strings.Contains(private, "2. /search [keywords] - Starts a search.") {
+		t.Errorf("Unexpected help text %q", private)
+	}
+	if strings.Contains(private, "/stats") {
+		t.Error("Expected admin commands to be left out for users")
+	}
+	if admin := r.helpText(false, true); !strings.Contains(admin, "4. /stats") {
+		t.Errorf("Expected admins to see /stats, got %q", admin)
+	}
+	if group := r.helpText(true, false); strings.Contains(group, "/search") || !strings.Contains(group, "private chat") {
+		t.Errorf("Expected the group help to list only group commands, got %q", group)
+	}
+}
+
+// TestRegisterCommands tests that a menu is set for each scope and language, and a failing admin chat is skipped
+func TestRegisterCommands(t *testing.T) {
+	var handled []string
+	useCommands(t, testCommands(&handled))
+	mockAPI := &menuAPI{failChat: 888}
+	bot := &Bot{api: mockAPI}
+	bot.SetAdmins([]int64{999, 888})
+
+	if err := bot.RegisterCommands(); err != nil {
+		t.Fatalf("RegisterCommands returned an error: %v", err)
+	}
+
+	// Private, group and the working admin's chat, in English and German
+	if len(mockAPI.menus) != 6 {
+		t.Fatalf("Expected 6 menus, got %d", len(mockAPI.menus))
+	}
+	commands := func(menu tgbotapi.SetMyCommandsConfig) string {
+		var names []string
+		for _, c := range menu.Commands {
+			names = append(names, c.Command)
+		}
+		return strings.Join(names, ",")
+	}
+	expected := []struct {
+		scope    string
+		language string
+		commands string
+	}{
+		{"all_private_chats", "", "help,search,saved"},
+		{"all_group_chats", "", "help"},
+		{"chat", "", "help,search,saved,stats"},
+		{"all_private_chats", "de", "help,search,saved"},
+		{"all_group_chats", "de", "help"},
+		{"chat", "de", "help,search,saved,stats"},
+	}
+	for i, e := range expected {
+		menu := mockAPI.menus[i]
+		if menu.Scope.Type != e.scope || menu.LanguageCode != e.language || commands(menu) != e.commands {
+			t.Errorf("Menu %d: expected %s %q %s, got %s %q %s", i, e.scope, e.language, e.commands, menu.Scope.Type, menu.LanguageCode, commands(menu))
+		}
+	}
+	if mockAPI.menus[3].Commands[0].Description != "Befehle anzeigen" || mockAPI.menus[3].Commands[1].Description != "Search for a property" {
+		t.Errorf("Expected German descriptions, falling back to English, got %+v", mockAPI.menus[3].Commands)
+	}
+}
+
+// TestRegisterBotCommandsWelsh tests that the bot's own commands are registered with a Welsh menu
+// for each scope, with every command described in Welsh
+func TestRegisterBotCommandsWelsh(t *testing.T) {
+	mockAPI := &menuAPI{}
+	bot := &Bot{api: mockAPI}
+	bot.SetAdmins([]int64{999})
+
+	if err := bot.RegisterCommands(); err != nil {
+		t.Fatalf("RegisterCommands returned an error: %v", err)
+	}
+
+	var welsh []tgbotapi.SetMyCommandsConfig
+	for _, menu := range mockAPI.menus {
+		if menu.LanguageCode == "cy" {
+			welsh = append(welsh, menu)
+		}
+	}
+	if len(welsh) != 3 {
+		t.Fatalf("Expected Welsh menus for private chats, groups and the admin, got %d", len(welsh))
+	}
+	for _, menu := range welsh {
+		for _, entry := range menu.Commands {
+			c, _ := botCommands.lookup(entry.Command)
+			if entry.Description == c.Description {
+				t.Errorf("Expected /%s to be described in Welsh in the %s menu, got %q", entry.Command, menu.Scope.Type, entry.Description)
+			}
+		}
+	}
+	if got := welsh[0].Commands[0]; got.Command != "start" || got.Description != "Dechrau'r bot" {
+		t.Errorf("Expected /start first in the Welsh menu, got %+v", got)
+	}
+}
+
+// TestEditDistance tests the distance used to suggest commands
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"search", "search", 0},
+		{"serch", "search", 1},
+		{"saerch", "search", 2},
+		{"", "help", 4},
+		{"help", "helper", 2},
+	}
+	for _, tc := range testCases {
+		if d := editDistance(tc.a, tc.b); d != tc.expected {
+			t.Errorf("editDistance(%q, %q): expected %d, got %d", tc.a, tc.b, tc.expected, d)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	// Embed the timezone database, so digest times work on hosts without one
+	_ "time/tzdata"
+)
+
+// Digest settings.
+const (
+	defaultDigestTimezone = "Europe/London"
+	digestMaxItems        = 20 // changes listed in one digest, so it fits in a message
+)
+
+// digestTimeOptions are the times offered as buttons. Users can type any other time.
+var digestTimeOptions = []string{"07:00", "08:00", "12:00", "18:00", "20:00"}
+
+// digestItem is a change to one property that is reported in a digest.
+type digestItem struct {
+	Property database.Property
+	Change   string
+}
+
+// digestMatch is a property in a digest's events that matches the user's saved search,
+// with the first update to it in the events, if there was one.
+type digestMatch struct {
+	Property database.Property
+	Before   database.PropertyEvent
+	Updated  bool
+}
+
+// nextDigestTime returns the first time after the given time that a digest is due,
+// at the subscription's time of day in its timezone.
+func nextDigestTime(sub database.DigestSubscription, after time.Time) (time.Time, error) {
+	if sub.Frequency != database.DigestDaily && sub.Frequency != database.DigestWeekly {
+		return time.Time{}, fmt.Errorf("unknown digest frequency %q", sub.Frequency)
+	}
+	loc, err := time.LoadLocation(sub.Timezone)
+	if err != nil {
+		return time.Time{}, err
+	}
+	sendAt, err := time.Parse("15:04", sub.SendTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	local := after.In(loc)
+	for days := 0; days <= 7; days++ {
+		next := time.Date(local.Year(), local.Month(), local.Day()+days, sendAt.Hour(), sendAt.Minute(), 0, 0, loc)
+		if !next.After(after) {
+			continue
+		}
+		if sub.Frequency == database.DigestWeekly && next.Weekday() != sub.Weekday {
+			continue
+		}
+		return next, nil
+	}
+	return time.Time{}, fmt.Errorf("no digest time found after %v", after)
+}
+
+// processDigests sends every digest that is due. Subscriptions with an invalid time or timezone, and
+// digests that fail to send, are logged and skipped, so they don't hold up everyone else's digest.
+// A digest that failed is still due, so it's tried again at the next check.
+func (b *Bot) processDigests(ctx context.Context, now time.Time) error {
+	subs, err := database.GetDigestSubscriptions(ctx, b.db)
+	if err != nil {
+		return fmt.Errorf("error getting digest subscriptions: %w", err)
+	}
+
+	for _, sub := range subs {
+		next, err := nextDigestTime(sub, sub.LastSentAt)
+		if err != nil {
+			log.Printf("Invalid digest subscription for user %d: %v", sub.UserID, err)
+			continue
+		}
+		if next.After(now) {
+			continue
+		}
+		if err := b.sendDigest(ctx, sub, now); err != nil {
+			log.Printf("Error sending digest to user %d: %v", sub.UserID, err)
+		}
+	}
+	return nil
+}
+
+// sendDigest sends a user one message summarising the changes matching their saved search since
+// their last digest. Nothing is sent if there are no changes, but the digest still counts as sent.
+// The digest is written to the outbox in the transaction that records it as sent.
+func (b *Bot) sendDigest(ctx context.Context, sub database.DigestSubscription, now time.Time) error {
+	prefs, err := database.GetUserPreferences(ctx, b.db, sub.UserID)
+	if err != nil {
+		return err
+	}
+	events, err := database.GetPropertyEventsAfter(ctx, b.db, sub.LastEventID)
+	if err != nil {
+		return err
+	}
+
+	matches, err := b.matchDigestProperties(ctx, prefs, events)
+	if err != nil {
+		return err
+	}
+
+	return b.inTransaction(ctx, func(tx *sql.Tx) error {
+		items, err := b.collectDigestItems(ctx, tx, prefs.UserID, matches)
+		if err != nil {
+			return err
+		}
+		if len(items) > 0 {
+			text, keyboard := b.renderDigest(sub, items)
+			msg := tgbotapi.NewMessage(sub.UserID, text)
+			msg.ParseMode = "HTML"
+			msg.ReplyMarkup = keyboard
+			if _, err := b.notify(ctx, tx, msg, database.NotifyDigest); err != nil {
+				return err
+			}
+		}
+
+		lastEventID := sub.LastEventID
+		if len(events) > 0 {
+			lastEventID = events[len(events)-1].ID
+		}
+		return database.MarkDigestSent(ctx, tx, sub.UserID, now, lastEventID)
+	})
+}
+
+// matchDigestProperties returns the properties in the events that match the user's saved search,
+// in the order they first appear.
+func (b *Bot) matchDigestProperties(ctx context.Context, prefs database.UserPreferences, events []database.PropertyEvent) ([]digestMatch, error) {
+	var order []int
+	included := make(map[int]bool)
+	before := make(map[int]database.PropertyEvent)
+	for _, event := range events {
+		if !included[event.PropertyID] {
+			included[event.PropertyID] = true
+			order = append(order, event.PropertyID)
+		}
+		if _, ok := before[event.PropertyID]; !ok && event.Kind == database.PropertyUpdated {
+			before[event.PropertyID] = event
+		}
+	}
+
+	var matches []digestMatch
+	for _, propertyID := range order {
+		property, err := database.GetProperty(ctx, b.db, propertyID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		ok, err := b.matchesSavedSearch(ctx, prefs, propertyID)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		previous, updated := before[propertyID]
+		matches = append(matches, digestMatch{Property: property, Before: previous, Updated: updated})
+	}
+	return matches, nil
+}
+
+// collectDigestItems works out what changed for each matching property and records that the user
+// was told about it. Properties the user hasn't been told about are new. Otherwise a property is
+// included if its price dropped or its status changed since the first update in the events.
+func (b *Bot) collectDigestItems(ctx context.Context, tx database.Execer, userID int64, matches []digestMatch) ([]digestItem, error) {
+	var items []digestItem
+	for _, match := range matches {
+		property, previous := match.Property, match.Before
+		isNew, err := database.RecordAlertDelivery(ctx, tx, userID, property.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case isNew:
+			items = append(items, digestItem{Property: property, Change: "🆕 New"})
+		case match.Updated && property.PricePerMonth < previous.OldPrice:
+			items = append(items, digestItem{Property: property, Change: fmt.Sprintf("💷 Price drop £%d → £%d", previous.OldPrice, property.PricePerMonth)})
+		case match.Updated && property.Status != previous.OldStatus:
+			items = append(items, digestItem{Property: property, Change: "📌 Now " + strings.ToLower(formatListingStatus(property.Status))})
+		}
+	}
+	return items, nil
+}
+
+// renderDigest formats the digest message, with a button to open each listing.
+func (b *Bot) renderDigest(sub database.DigestSubscription, items []digestItem) (string, tgbotapi.InlineKeyboardMarkup) {
+	var text strings.Builder
+	fmt.Fprintf(&text, "📬 Your %s digest: %d changes matching your saved search\n\n", sub.Frequency, len(items))
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for i, item := range items {
+		if i == digestMaxItems {
+			fmt.Fprintf(&text, "\n…and %d more. Use /search to see them all.", len(items)-digestMaxItems)
+			break
+		}
+		fmt.Fprintf(&text, "%d. %s: %s in %s, £%d per month\n",
+			i+1, item.Change, b.propertyTypeLabel(item.Property.Type), item.Property.Location, item.Property.PricePerMonth)
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL(
+				fmt.Sprintf("%d. Open %s in %s", i+1, b.propertyTypeLabel(item.Property.Type), item.Property.Location),
+				listingDeepLink(b.botUserName, item.Property.ID)),
+		))
+	}
+
+	return text.String(), tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// listingDeepLink returns a link that opens the bot and shows a listing.
+func listingDeepLink(botUserName string, propertyID int) string {
+	return fmt.Sprintf("https://t.me/%s?start=listing_%d", botUserName, propertyID)
+}
+
+// parseListingPayload returns the property ID in a /start payload from listingDeepLink.
+func parseListingPayload(payload string) (int, bool) {
+	var propertyID int
+	if _, err := fmt.Sscanf(payload, "listing_%d", &propertyID); err != nil {
+		return 0, false
+	}
+	return propertyID, true
+}
+
+// showListing shows a single listing, such as one opened from a digest.
+func (b *Bot) showListing(ctx context.Context, chatID int64, propertyID int) {
+	property, err := database.GetProperty(ctx, b.db, propertyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		b.sendMessage(chatID, "Sorry, that listing is no longer available.", nil)
+		return
+	}
+	if err != nil {
+		log.Printf("Error getting property %d: %v", propertyID, err)
+		b.sendMessage(chatID, "Sorry, there was an error retrieving the listing. Please try again later.", nil)
+		return
+	}
+	b.presentMultipleProperties(ctx, chatID, []database.Property{property}, false, nil)
+}
+
+// handleDigestCommand shows how the user is told about properties matching their saved search,
+// with buttons to switch between instant alerts and a daily or weekly digest.
+func (b *Bot) handleDigestCommand(ctx context.Context, message *tgbotapi.Message) {
+	if _, err := database.GetUserPreferences(ctx, b.db, message.From.ID); err != nil {
+		b.sendMessage(message.Chat.ID, "Digests are sent for your saved search. Use /search and then /save_preferences to save one first.", nil)
+		return
+	}
+
+	current := "You get an alert as soon as a property matches your saved search."
+	sub, err := database.GetDigestSubscription(ctx, b.db, message.From.ID)
+	if err == nil {
+		current = "You get " + formatDigestSubscription(sub) + "."
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error getting digest subscription for user %d: %v", message.From.ID, err)
+	}
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Instant", callbackData("digest", "instant")),
+			tgbotapi.NewInlineKeyboardButtonData("Daily", callbackData("digest", database.DigestDaily)),
+			tgbotapi.NewInlineKeyboardButtonData("Weekly", callbackData("digest", database.DigestWeekly)),
+		),
+	)
+	b.sendMessage(message.Chat.ID, current+"\n\nHow would you like to hear about new matches, price drops and status changes?", keyboard)
+}
+
+// handleDigestCallback handles the buttons for choosing a digest: its frequency, then the day
+// for weekly digests, the time and the timezone. The choices are kept in the user's state until
+// the digest is saved.
+func (b *Bot) handleDigestCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	chatID := query.Message.Chat.ID
+	value := args.String("value")
+
+	switch choice := args.String("choice"); choice {
+	case "instant":
+		if err := database.DeleteDigestSubscription(ctx, b.db, query.From.ID); err != nil {
+			log.Printf("Error deleting digest subscription for user %d: %v", query.From.ID, err)
+			b.sendMessage(chatID, "Sorry, there was an error updating your digest. Please try again.", nil)
+			return ""
+		}
+		state.DigestDraft = nil
+		b.sendMessage(chatID, "🔔 You'll get an alert as soon as a property matches your saved search.", nil)
+	case database.DigestDaily:
+		state.DigestDraft = &database.DigestSubscription{UserID: query.From.ID, Frequency: database.DigestDaily}
+		b.askDigestTime(chatID, state)
+	case database.DigestWeekly:
+		state.DigestDraft = &database.DigestSubscription{UserID: query.From.ID, Frequency: database.DigestWeekly}
+		b.askDigestWeekday(chatID)
+	default:
+		// The day, time and timezone are chosen after the frequency
+		if state.DigestDraft == nil || value == "" {
+			return "This choice is out of date. Use /digest to start again."
+		}
+		switch choice {
+		case "day":
+			weekday, err := strconv.Atoi(value)
+			if err != nil || weekday < 0 || weekday > 6 {
+				return "Invalid day"
+			}
+			state.DigestDraft.Weekday = time.Weekday(weekday)
+			b.askDigestTime(chatID, state)
+		case "time":
+			// The time is sent as HHMM, so it fits in a button without a token
+			sendTime, err := parseTimeOfDay(value)
+			if err != nil {
+				return "Invalid time"
+			}
+			state.DigestDraft.SendTime = sendTime
+			b.askDigestTimezone(chatID, state)
+		case "tz":
+			b.saveDigestDraft(ctx, chatID, state, value)
+		}
+	}
+	return ""
+}
+
+// askDigestWeekday asks which day weekly digests should be sent.
+func (b *Bot) askDigestWeekday(chatID int64) {
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	// Start the week on Monday
+	for i := 1; i <= 7; i++ {
+		weekday := time.Weekday(i % 7)
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(weekday.String()[:3], callbackData("digest", "day", int(weekday))))
+		if len(row) == 4 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	rows = append(rows, row)
+	b.sendMessage(chatID, "Which day would you like your weekly digest?", tgbotapi.NewInlineKeyboardMarkup(rows...))
+}
+
+// askDigestTime asks what time of day the digest should be sent. The user can pick a time or type one.
+func (b *Bot) askDigestTime(chatID int64, state *UserState) {
+	state.Stage = stageAwaitingDigestTime
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, option := range digestTimeOptions {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(option, callbackData("digest", "time", strings.Replace(option, ":", "", 1))))
+	}
+	b.sendMessage(chatID, "What time would you like your digest? Pick a time or type one, e.g. 07:30.", tgbotapi.NewInlineKeyboardMarkup(row))
+}
+
+// askDigestTimezone asks which timezone the digest time is in. The user can pick one or type any IANA timezone name.
+func (b *Bot) askDigestTimezone(chatID int64, state *UserState) {
+	state.Stage = stageAwaitingDigestTimezone
+
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("UK time", callbackData("digest", "tz", defaultDigestTimezone)),
+			tgbotapi.NewInlineKeyboardButtonData("UTC", callbackData("digest", "tz", "UTC")),
+		),
+	)
+	b.sendMessage(chatID, "Which timezone is that in? Pick one or type a timezone name, e.g. Europe/Paris.", keyboard)
+}
+
+// saveDigestDraft saves the digest the user has chosen, in the given timezone.
+func (b *Bot) saveDigestDraft(ctx context.Context, chatID int64, state *UserState, timezone string) {
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "" || timezone == "Local" {
+		b.sendMessage(chatID, "Sorry, I don't know that timezone. Try a name like Europe/London or America/New_York.", nil)
+		return
+	}
+
+	sub := *state.DigestDraft
+	sub.Timezone = timezone
+	if err := database.SaveDigestSubscription(ctx, b.db, sub); err != nil {
+		log.Printf("Error saving digest subscription for user %d: %v", sub.UserID, err)
+		b.sendMessage(chatID, "Sorry, there was an error saving your digest. Please try again.", nil)
+		return
+	}
+
+	state.DigestDraft = nil
+	state.Stage = "initial"
+	b.sendMessage(chatID, fmt.Sprintf("📬 Done! You'll get %s instead of instant alerts. Use /digest to change it.", formatDigestSubscription(sub)), nil)
+}
+
+// parseTimeOfDay parses a time of day such as 7:30, 07:30, 0730 or 7pm, and returns it as HH:MM.
+func parseTimeOfDay(text string) (string, error) {
+	text = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(text), " ", ""))
+	for _, layout := range []string{"15:04", "1504", "3pm", "3:04pm", "15.04"} {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t.Format("15:04"), nil
+		}
+	}
+	return "", fmt.Errorf("invalid time %q", text)
+}
+
+// formatDigestSubscription describes when a digest is sent, e.g. "a weekly digest on Monday at 08:00 (Europe/London)".
+func formatDigestSubscription(sub database.DigestSubscription) string {
+	if sub.Frequency == database.DigestWeekly {
+		return fmt.Sprintf("a weekly digest on %s at %s (%s)", sub.Weekday, sub.SendTime, sub.Timezone)
+	}
+	return fmt.Sprintf("a daily digest at %s (%s)", sub.SendTime, sub.Timezone)
+}
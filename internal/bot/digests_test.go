@@ -0,0 +1,265 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"strings"
+	"testing"
+	"time"
+)
+
+// digestSubscriptionColumns are the columns selected for digest subscriptions
+var digestSubscriptionColumns = []string{"user_id", "frequency", "weekday", "send_time", "timezone", "last_sent_at", "last_event_id"}
+
+// TestNextDigestTime tests when the next digest is due, in the subscription's timezone
+func TestNextDigestTime(t *testing.T) {
+	daily := database.DigestSubscription{Frequency: database.DigestDaily, SendTime: "08:00", Timezone: "Europe/London"}
+	weekly := database.DigestSubscription{Frequency: database.DigestWeekly, Weekday: time.Monday, SendTime: "18:30", Timezone: "UTC"}
+	newYork := database.DigestSubscription{Frequency: database.DigestDaily, SendTime: "08:00", Timezone: "America/New_York"}
+
+	testCases := []struct {
+		name  string
+		sub   database.DigestSubscription
+		after time.Time
+		want  time.Time
+	}{
+		{"Later today", daily, time.Date(2026, 1, 10, 6, 0, 0, 0, time.UTC), time.Date(2026, 1, 10, 8, 0, 0, 0, time.UTC)},
+		{"Tomorrow", daily, time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC), time.Date(2026, 1, 11, 8, 0, 0, 0, time.UTC)},
+		{"Exactly at the send time", daily, time.Date(2026, 1, 10, 8, 0, 0, 0, time.UTC), time.Date(2026, 1, 11, 8, 0, 0, 0, time.UTC)},
+		{"After the clocks go forward", daily, time.Date(2026, 3, 28, 9, 0, 0, 0, time.UTC), time.Date(2026, 3, 29, 7, 0, 0, 0, time.UTC)},
+		{"Weekly on the next Monday", weekly, time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC), time.Date(2026, 1, 12, 18, 30, 0, 0, time.UTC)},
+		{"Weekly a week after sending", weekly, time.Date(2026, 1, 12, 18, 30, 0, 0, time.UTC), time.Date(2026, 1, 19, 18, 30, 0, 0, time.UTC)},
+		{"Another timezone", newYork, time.Date(2026, 1, 10, 12, 0, 0, 0, time.UTC), time.Date(2026, 1, 10, 13, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := nextDigestTime(tc.sub, tc.after)
+			if err != nil {
+				t.Fatalf("nextDigestTime() returned an error: %v", err)
+			}
+			if !got.Equal(tc.want) {
+				t.Errorf("nextDigestTime() = %v, want %v", got.UTC(), tc.want)
+			}
+		})
+	}
+
+	invalid := []database.DigestSubscription{
+		{Frequency: database.DigestDaily, SendTime: "08:00", Timezone: "Nowhere/Special"},
+		{Frequency: database.DigestDaily, SendTime: "8 o'clock", Timezone: "UTC"},
+		{Frequency: "monthly", SendTime: "08:00", Timezone: "UTC"},
+	}
+	for _, sub := range invalid {
+		if _, err := nextDigestTime(sub, time.Now()); err == nil {
+			t.Errorf("nextDigestTime(%+v) expected an error", sub)
+		}
+	}
+}
+
+// TestParseTimeOfDay tests parsing the times of day users type
+func TestParseTimeOfDay(t *testing.T) {
+	testCases := map[string]string{
+		"07:30":   "07:30",
+		"7:30":    "07:30",
+		"0730":    "07:30",
+		"6pm":     "18:00",
+		"6:15 PM": "18:15",
+		"18.45":   "18:45",
+	}
+	for input, want := range testCases {
+		got, err := parseTimeOfDay(input)
+		if err != nil || got != want {
+			t.Errorf("parseTimeOfDay(%q) = %q, %v, want %q", input, got, err, want)
+		}
+	}
+	for _, input := range []string{"", "soon", "25:00"} {
+		if _, err := parseTimeOfDay(input); err == nil {
+			t.Errorf("parseTimeOfDay(%q) expected an error", input)
+		}
+	}
+}
+
+// TestProcessDigests tests that a due digest lists new matches, price drops and status changes in one message,
+// and that digests that aren't due are left alone
+func TestProcessDigests(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db, botUserName: "TestBot"}
+	now := time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT (.+) FROM digest_subscriptions").
+		WillReturnRows(sqlmock.NewRows(digestSubscriptionColumns).
+			AddRow(201, "daily", 0, "08:00", "Europe/London", time.Date(2026, 1, 9, 8, 0, 0, 0, time.UTC), 5).
+			AddRow(202, "weekly", 1, "08:00", "Europe/London", time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC), 5))
+
+	mock.ExpectQuery("SELECT (.+) FROM user_preferences WHERE user_id = ?").WithArgs(int64(201)).
+		WillReturnRows(sqlmock.NewRows(userPreferencesColumns).
+			AddRow(201, "{}", 0, 1500, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true))
+	mock.ExpectQuery("SELECT (.+) FROM property_events WHERE id > ?").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(propertyEventColumns).
+			AddRow(6, 7, "added", nil, nil).
+			AddRow(7, 8, "updated", 1300, "available").
+			AddRow(8, 9, "updated", 1000, "available").
+			AddRow(9, 8, "updated", 1250, "available").
+			AddRow(10, 10, "updated", 900, "available"))
+
+	property := func(id, price int, status string) *sqlmock.Rows {
+		return sqlmock.NewRows(propertyRowColumns).
+			AddRow(id, "Apartment", price, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, status)
+	}
+	properties := []struct {
+		id, price int
+		status    string
+		isNew     bool
+	}{
+		{7, 1200, "available", true},
+		{8, 1200, "available", false},
+		{9, 1000, "let_agreed", false},
+		{10, 900, "available", false},
+	}
+	for _, p := range properties {
+		mock.ExpectQuery("SELECT (.+) FROM properties WHERE id = ?").WithArgs(p.id).WillReturnRows(property(p.id, p.price, p.status))
+		mock.ExpectQuery("SELECT (.+) FROM properties WHERE 1=1 AND id = ?").WithArgs(p.id, 1500, "Bath", int64(201), int64(201)).
+			WillReturnRows(property(p.id, p.price, p.status))
+	}
+	// The transaction only records the deliveries and writes the digest
+	mock.ExpectBegin()
+	for _, p := range properties {
+		inserted := int64(0)
+		if p.isNew {
+			inserted = 1
+		}
+		mock.ExpectExec("INSERT OR IGNORE INTO alert_deliveries").WithArgs(int64(201), p.id).WillReturnResult(sqlmock.NewResult(0, inserted))
+	}
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(201)).WillReturnError(sql.ErrNoRows)
+	digest := expectOutboxMessage(mock, 201, "digest")
+	mock.ExpectExec("UPDATE digest_subscriptions SET last_sent_at").WithArgs(now, 10, int64(201)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := bot.processDigests(ctx, now); err != nil {
+		t.Fatalf("processDigests() returned an error: %v", err)
+	}
+
+	for _, want := range []string{"3 changes", "1. 🆕 New", "2. 💷 Price drop £1300 → £1200", "3. 📌 Now let agreed"} {
+		if !strings.Contains(digest.String(), want) {
+			t.Errorf("Expected digest to contain %q, got:\n%s", want, digest.String())
+		}
+	}
+	keyboard := digest.Keyboard(t)
+	if len(keyboard.InlineKeyboard) != 3 {
+		t.Fatalf("Expected a button for each of the 3 listings, got %d rows", len(keyboard.InlineKeyboard))
+	}
+	if link := keyboard.InlineKeyboard[0][0].URL; link == nil || *link != "https://t.me/TestBot?start=listing_7" {
+		t.Errorf("Unexpected link for the first listing: %v", link)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestProcessDigestsFailure tests that a digest that fails doesn't stop the digests after it
+func TestProcessDigestsFailure(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	bot := &Bot{api: &MockBotAPI2{}, db: db}
+	now := time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+	yesterday := time.Date(2026, 1, 9, 8, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT (.+) FROM digest_subscriptions").
+		WillReturnRows(sqlmock.NewRows(digestSubscriptionColumns).
+			AddRow(201, "daily", 0, "08:00", "Europe/London", yesterday, 5).
+			AddRow(202, "daily", 0, "08:00", "Europe/London", yesterday, 5))
+	mock.ExpectQuery("SELECT (.+) FROM user_preferences WHERE user_id = ?").WithArgs(int64(201)).
+		WillReturnRows(sqlmock.NewRows(userPreferencesColumns).
+			AddRow(201, "not json", 0, 1500, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true))
+	mock.ExpectQuery("SELECT (.+) FROM user_preferences WHERE user_id = ?").WithArgs(int64(202)).
+		WillReturnRows(sqlmock.NewRows(userPreferencesColumns).
+			AddRow(202, "{}", 0, 1500, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true))
+	mock.ExpectQuery("SELECT (.+) FROM property_events WHERE id > ?").WithArgs(5).
+		WillReturnRows(sqlmock.NewRows(propertyEventColumns))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE digest_subscriptions SET last_sent_at").WithArgs(now, 5, int64(202)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := bot.processDigests(ctx, now); err != nil {
+		t.Fatalf("processDigests() returned an error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestDigestSetup tests choosing a weekly digest with the buttons, a typed time and a typed timezone
+func TestDigestSetup(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db, state: make(map[int64]*UserState)}
+	user := &tgbotapi.User{ID: 1}
+	chat := &tgbotapi.Chat{ID: 1}
+	callback := func(data string) {
+		bot.handleCallbackQuery(ctx, &tgbotapi.CallbackQuery{ID: data, From: user, Message: &tgbotapi.Message{Chat: chat}, Data: data})
+	}
+	reply := func(text string) {
+		bot.handleRegularMessage(ctx, &tgbotapi.Message{From: user, Chat: chat, Text: text})
+	}
+
+	callback("digest:weekly")
+	callback("digest:day:1")
+	reply("7.30am")
+	if state := bot.getUserState(1); state.Stage != stageAwaitingDigestTime {
+		t.Fatalf("Expected an invalid time to be asked for again, got stage %q", state.Stage)
+	}
+	reply("07:30")
+	reply("Mars/Olympus_Mons")
+	if state := bot.getUserState(1); state.Stage != stageAwaitingDigestTimezone {
+		t.Fatalf("Expected an unknown timezone to be asked for again, got stage %q", state.Stage)
+	}
+
+	mock.ExpectExec("INSERT OR REPLACE INTO digest_subscriptions").
+		WithArgs(int64(1), "weekly", 1, "07:30", "Europe/Paris", int64(1), int64(1)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	reply("Europe/Paris")
+
+	last := mockAPI.messages[len(mockAPI.messages)-1]
+	if !strings.Contains(last.Text, "a weekly digest on Monday at 07:30 (Europe/Paris)") {
+		t.Errorf("Unexpected confirmation: %q", last.Text)
+	}
+	if state := bot.getUserState(1); state.DigestDraft != nil || state.Stage != "initial" {
+		t.Errorf("Expected the draft to be cleared once saved, got %+v", state)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestParseListingPayload tests reading the listing from a /start deep link
+func TestParseListingPayload(t *testing.T) {
+	if id, ok := parseListingPayload("listing_42"); !ok || id != 42 {
+		t.Errorf("parseListingPayload(listing_42) = %d, %v, want 42, true", id, ok)
+	}
+	for _, payload := range []string{"", "listing_", "hello"} {
+		if _, ok := parseListingPayload(payload); ok {
+			t.Errorf("parseListingPayload(%q) expected no listing", payload)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"fmt"
+	"imitation_project/internal/database"
+	"imitation_project/internal/scheduler"
+)
+
+// Background job names.
+const (
+	jobPropertyAlerts      = "property_alerts"
+	jobDigests             = "digests"
+	jobQueuedNotifications = "queued_notifications"
+	jobOutbox              = "outbox"
+	jobOutboxReport        = "outbox_report"
+	jobDispatchStats       = "dispatch_stats"
+)
+
+// backgroundJobs are the bot's recurring jobs and their schedules, in the order they're added.
+var backgroundJobs = []struct{ name, schedule string }{
+	{jobPropertyAlerts, "@every 1m"},
+	{jobDigests, "@every 1m"},
+	{jobQueuedNotifications, "@every 1m"},
+	{jobOutbox, "@every 10s"},
+	{jobOutboxReport, "@hourly"},
+	{jobDispatchStats, "@every 5m"},
+}
+
+// scheduleJobs registers the bot's background work with the scheduler: alerts about added and updated
+// properties, digests that are due, notifications that were held back by the users' settings,
+// delivering the outbox, and reporting on it and on how busy update handling is.
+func (b *Bot) scheduleJobs(ctx context.Context, jobs *scheduler.Scheduler) error {
+	jobs.Register(jobPropertyAlerts, func(ctx context.Context, job database.Job) error {
+		return b.processPropertyEvents(ctx)
+	})
+	jobs.Register(jobDigests, func(ctx context.Context, job database.Job) error {
+		return b.processDigests(ctx, jobs.Now())
+	})
+	jobs.Register(jobQueuedNotifications, func(ctx context.Context, job database.Job) error {
+		return b.deliverQueuedNotifications(ctx, jobs.Now())
+	})
+	jobs.Register(jobOutbox, func(ctx context.Context, job database.Job) error {
+		_, err := b.dispatchOutbox(ctx, jobs.Now())
+		return err
+	})
+	jobs.Register(jobOutboxReport, func(ctx context.Context, job database.Job) error {
+		return b.reportStuckOutboxMessages(ctx, jobs.Now())
+	})
+	jobs.Register(jobDispatchStats, func(ctx context.Context, job database.Job) error {
+		return b.logUpdateStats()
+	})
+
+	for _, job := range backgroundJobs {
+		if err := jobs.Every(ctx, job.name, job.schedule); err != nil {
+			return fmt.Errorf("error scheduling %s: %w", job.name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"imitation_project/internal/scheduler"
+	"testing"
+	"time"
+)
+
+type fixedClock struct {
+	now time.Time
+}
+
+func (c fixedClock) Now() time.Time {
+	return c.now
+}
+
+// TestScheduleJobs tests that the bot's background work is added as recurring jobs
+func TestScheduleJobs(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	bot := &Bot{api: &MockBotAPI2{}, db: db}
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	jobs := scheduler.New(db, fixedClock{now})
+
+	for _, job := range backgroundJobs {
+		schedule, err := scheduler.ParseSchedule(job.schedule)
+		if err != nil {
+			t.Fatalf("Invalid schedule %q for %s: %v", job.schedule, job.name, err)
+		}
+		mock.ExpectExec("INSERT INTO jobs").
+			WithArgs(job.name, job.name, job.schedule, schedule.Next(now)).
+			WillReturnResult(sqlmock.NewResult(1, 1))
+	}
+
+	if err := bot.scheduleJobs(ctx, jobs); err != nil {
+		t.Fatalf("scheduleJobs returned error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,344 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/dispatch"
+	"log"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Middleware settings.
+const (
+	floodLimit       = 20               // updates a user may send in each flood window
+	floodWindow      = 10 * time.Second // how long the flood limit applies over
+	maxFloodWindows  = 10000            // users tracked before expired windows are dropped
+	maxMetricKinds   = 100              // kinds of update timed separately, after which new kinds are counted as "other"
+	maxPanicReport   = 3000             // characters of the stack trace sent to admins
+	slowUpdateWarn   = 5 * time.Second  // how long an update may take before it's logged as slow
+	panicUserMessage = "Sorry, something went wrong. Please try again."
+)
+
+// Middleware wraps an update handler with behaviour shared by every update.
+type Middleware func(next dispatch.Handler) dispatch.Handler
+
+// chain wraps a handler in middleware. The first middleware is the outermost, so it runs first.
+func chain(handler dispatch.Handler, middleware ...Middleware) dispatch.Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+}
+
+// pipeline returns the handler the dispatcher runs for each update: handleUpdate wrapped in the middleware
+// every update goes through, so new handlers get it without doing anything.
+func (b *Bot) pipeline() dispatch.Handler {
+	return chain(b.handleUpdate,
+		b.recoverPanics,
+		b.logRequests,
+		b.loadUser,
+		b.checkAccess,
+		b.guardFlood,
+		b.timeHandlers,
+	)
+}
+
+// request describes the update being handled. The middleware puts it in the handler's context.
+type request struct {
+	UpdateID int
+	Kind     string // what the update is, such as "/search" or "callback:page"
+	User     *tgbotapi.User
+	ChatID   int64
+	Admin    bool
+	Log      *log.Logger // logs with the update and user as a prefix
+}
+
+type requestKey struct{}
+
+// withRequest returns a context carrying the request.
+func withRequest(ctx context.Context, r *request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+// requestFrom returns the request being handled, or an empty one if the context has none, as in tests.
+func requestFrom(ctx context.Context) *request {
+	if r, ok := ctx.Value(requestKey{}).(*request); ok {
+		return r
+	}
+	return &request{Log: log.Default()}
+}
+
+// updateKind describes an update for logs and metrics.
+func updateKind(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil && update.Message.IsCommand():
+		return "/" + update.Message.Command()
+	case update.Message != nil:
+		return "message"
+	case update.CallbackQuery != nil:
+		if strings.HasPrefix(update.CallbackQuery.Data, callbackTokenMark) {
+			return "callback:token"
+		}
+		head, _, _ := strings.Cut(update.CallbackQuery.Data, callbackSeparator)
+		action, _, _ := strings.Cut(head, callbackVersionSep)
+		return "callback:" + action
+	default:
+		return "other"
+	}
+}
+
+// recoverPanics stops a panic in a handler from crashing the bot. The panic is logged with its stack,
+// reported to the admins, and the user is told something went wrong.
+func (b *Bot) recoverPanics(next dispatch.Handler) dispatch.Handler {
+	return func(ctx context.Context, update tgbotapi.Update) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			stack := string(debug.Stack())
+			kind := updateKind(update)
+			var userID int64
+			if user := update.SentFrom(); user != nil {
+				userID = user.ID
+			}
+			log.Printf("Panic handling update %d (%s) from user %d: %v\n%s", update.UpdateID, kind, userID, r, stack)
+
+			if len(stack) > maxPanicReport {
+				stack = stack[:maxPanicReport] + "…"
+			}
+			b.reportToAdmins(fmt.Sprintf("⚠️ Panic handling update %d (%s) from user %d: %v\n\n%s", update.UpdateID, kind, userID, r, stack))
+
+			if update.CallbackQuery != nil {
+				b.answerCallbackQuery(update.CallbackQuery.ID, panicUserMessage)
+			} else if chat := updateChat(update); chat != nil {
+				b.sendMessage(chat.ID, panicUserMessage, nil)
+			}
+		}()
+		next(ctx, update)
+	}
+}
+
+// reportToAdmins sends a plain text message to each admin.
+func (b *Bot) reportToAdmins(text string) {
+	for adminID := range b.admins {
+		if _, err := b.api.Send(tgbotapi.NewMessage(adminID, text)); err != nil {
+			log.Printf("Error reporting to admin %d: %v", adminID, err)
+		}
+	}
+}
+
+// logRequests gives the handler a logger that prefixes each line with the update and user,
+// and logs each update once it's handled, with how long it took.
+func (b *Bot) logRequests(next dispatch.Handler) dispatch.Handler {
+	return func(ctx context.Context, update tgbotapi.Update) {
+		r := &request{UpdateID: update.UpdateID, Kind: updateKind(update)}
+		prefix := fmt.Sprintf("update %d %s: ", update.UpdateID, r.Kind)
+		if user := update.SentFrom(); user != nil {
+			prefix = fmt.Sprintf("update %d %s from user %d: ", update.UpdateID, r.Kind, user.ID)
+		}
+		r.Log = log.New(log.Writer(), prefix, log.Flags()|log.Lmsgprefix)
+
+		started := time.Now()
+		next(withRequest(ctx, r), update)
+		if elapsed := time.Since(started); elapsed >= slowUpdateWarn {
+			r.Log.Printf("Handled slowly, in %v", elapsed)
+		} else {
+			r.Log.Printf("Handled in %v", elapsed)
+		}
+	}
+}
+
+// loadUser adds the user and chat the update is from to the request, and whether the user is an admin.
+func (b *Bot) loadUser(next dispatch.Handler) dispatch.Handler {
+	return func(ctx context.Context, update tgbotapi.Update) {
+		r := requestFrom(ctx)
+		r.User = update.SentFrom()
+		if chat := updateChat(update); chat != nil {
+			r.ChatID = chat.ID
+		}
+		r.Admin = r.User != nil && b.admins[r.User.ID]
+		next(withRequest(ctx, r), update)
+	}
+}
+
+// checkAccess drops updates the bot doesn't serve: those without a user, such as channel posts,
+// those from other bots, and those from blocked users.
+func (b *Bot) checkAccess(next dispatch.Handler) dispatch.Handler {
+	return func(ctx context.Context, update tgbotapi.Update) {
+		r := requestFrom(ctx)
+		switch {
+		case r.User == nil:
+			return
+		case r.User.IsBot:
+			r.Log.Printf("Ignoring update from a bot")
+			return
+		case b.blockedUsers[r.User.ID]:
+			r.Log.Printf("Ignoring update from a blocked user")
+			return
+		}
+		next(ctx, update)
+	}
+}
+
+// guardFlood drops updates from users who send more than floodLimit in a flood window, warning them once.
+// Admins aren't limited.
+func (b *Bot) guardFlood(next dispatch.Handler) dispatch.Handler {
+	return func(ctx context.Context, update tgbotapi.Update) {
+		r := requestFrom(ctx)
+		if r.User == nil || r.Admin {
+			next(ctx, update)
+			return
+		}
+		allowed, warn := b.flood.allow(r.User.ID, time.Now())
+		if allowed {
+			next(ctx, update)
+			return
+		}
+
+		const slowDown = "You're going a bit fast. Please wait a few seconds and try again."
+		if update.CallbackQuery != nil {
+			b.answerCallbackQuery(update.CallbackQuery.ID, slowDown)
+		} else if warn && r.ChatID != 0 {
+			b.sendMessage(r.ChatID, slowDown, nil)
+		}
+		if warn {
+			r.Log.Printf("Flood limit reached, dropping updates for %v", floodWindow)
+		}
+	}
+}
+
+// floodGuard counts each user's updates in fixed windows. Its zero value is ready to use.
+type floodGuard struct {
+	mu    sync.Mutex
+	users map[int64]*floodCount
+}
+
+// floodCount is a user's updates in their current window.
+type floodCount struct {
+	start  time.Time
+	count  int
+	warned bool
+}
+
+// allow counts an update from the user and reports whether it's within the limit, and whether it's the
+// first one over the limit in this window, so the user should be warned.
+func (g *floodGuard) allow(userID int64, now time.Time) (allowed, warn bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.users == nil {
+		g.users = make(map[int64]*floodCount)
+	}
+	c, ok := g.users[userID]
+	if !ok || now.Sub(c.start) >= floodWindow {
+		if !ok && len(g.users) >= maxFloodWindows {
+			g.forgetExpired(now)
+		}
+		c = &floodCount{start: now}
+		g.users[userID] = c
+	}
+
+	c.count++
+	if c.count <= floodLimit {
+		return true, false
+	}
+	warn = !c.warned
+	c.warned = true
+	return false, warn
+}
+
+// forgetExpired drops the windows that have ended. The caller holds g.mu.
+func (g *floodGuard) forgetExpired(now time.Time) {
+	for userID, c := range g.users {
+		if now.Sub(c.start) >= floodWindow {
+			delete(g.users, userID)
+		}
+	}
+}
+
+// timeHandlers records how long each kind of update takes to handle, and how many panicked.
+func (b *Bot) timeHandlers(next dispatch.Handler) dispatch.Handler {
+	return func(ctx context.Context, update tgbotapi.Update) {
+		kind := requestFrom(ctx).Kind
+		if kind == "" {
+			kind = updateKind(update)
+		}
+		started := time.Now()
+		completed := false
+		defer func() {
+			b.handlerMetrics.record(kind, time.Since(started), !completed)
+		}()
+		next(ctx, update)
+		completed = true
+	}
+}
+
+// handlerMetrics are timings for each kind of update. Its zero value is ready to use.
+type handlerMetrics struct {
+	mu    sync.Mutex
+	kinds map[string]*handlerTiming
+}
+
+// handlerTiming is how often a kind of update was handled and how long it took.
+type handlerTiming struct {
+	Kind   string
+	Count  int64
+	Panics int64
+	Total  time.Duration
+	Max    time.Duration
+}
+
+// record adds a handled update to the metrics.
+func (m *handlerMetrics) record(kind string, elapsed time.Duration, panicked bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.kinds == nil {
+		m.kinds = make(map[string]*handlerTiming)
+	}
+	t, ok := m.kinds[kind]
+	if !ok {
+		// Users can send any command, so the number of kinds is capped
+		if len(m.kinds) >= maxMetricKinds {
+			kind = "other"
+			t = m.kinds[kind]
+		}
+		if t == nil {
+			t = &handlerTiming{Kind: kind}
+			m.kinds[kind] = t
+		}
+	}
+	t.Count++
+	t.Total += elapsed
+	if elapsed > t.Max {
+		t.Max = elapsed
+	}
+	if panicked {
+		t.Panics++
+	}
+}
+
+// snapshot returns the timings, the most frequent kind first.
+func (m *handlerMetrics) snapshot() []handlerTiming {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	timings := make([]handlerTiming, 0, len(m.kinds))
+	for _, t := range m.kinds {
+		timings = append(timings, *t)
+	}
+	sort.Slice(timings, func(i, j int) bool {
+		if timings[i].Count != timings[j].Count {
+			return timings[i].Count > timings[j].Count
+		}
+		return timings[i].Kind < timings[j].Kind
+	})
+	return timings
+}
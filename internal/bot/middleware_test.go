@@ -0,0 +1,216 @@
+package bot
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/dispatch"
+	"strings"
+	"testing"
+	"time"
+)
+
+// commandUpdate returns an update with a command from a user in their private chat
+func commandUpdate(userID int64, command string) tgbotapi.Update {
+	return tgbotapi.Update{UpdateID: 1, Message: &tgbotapi.Message{
+		Text:     command,
+		Chat:     &tgbotapi.Chat{ID: userID},
+		From:     &tgbotapi.User{ID: userID},
+		Entities: []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len(command)}},
+	}}
+}
+
+// TestChain tests that middleware runs in order, the first outermost
+func TestChain(t *testing.T) {
+	var calls []string
+	mark := func(name string) Middleware {
+		return func(next dispatch.Handler) dispatch.Handler {
+			return func(ctx context.Context, update tgbotapi.Update) {
+				calls = append(calls, name)
+				next(ctx, update)
+			}
+		}
+	}
+	handler := chain(func(ctx context.Context, update tgbotapi.Update) {
+		calls = append(calls, "handler")
+	}, mark("first"), mark("second"))
+
+	handler(context.Background(), tgbotapi.Update{})
+	if strings.Join(calls, ",") != "first,second,handler" {
+		t.Errorf("Unexpected order %v", calls)
+	}
+}
+
+// TestRecoverPanics tests that a panicking handler is reported to the admins and the user, without crashing the bot
+func TestRecoverPanics(t *testing.T) {
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, state: make(map[int64]*UserState)}
+	bot.SetAdmins([]int64{999})
+
+	handler := bot.recoverPanics(func(ctx context.Context, update tgbotapi.Update) {
+		var data []string
+		_ = data[1]
+	})
+	handler(context.Background(), commandUpdate(123, "/search"))
+
+	if !mockAPI.MessageSent(999, "Panic handling update 1 (/search) from user 123") {
+		t.Errorf("Expected the panic to be reported to the admin, got %+v", mockAPI.messages)
+	}
+	if !mockAPI.MessageSent(123, "something went wrong") {
+		t.Errorf("Expected the user to be told something went wrong, got %+v", mockAPI.messages)
+	}
+
+	// A button press whose message is too old to be included is answered rather than crashing
+	mockAPI = &MockBotAPI2{}
+	bot.api = mockAPI
+	bot.pipeline()(context.Background(), tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{
+		ID: "1", From: &tgbotapi.User{ID: 123}, Data: "property_type:done",
+	}})
+	if len(mockAPI.answerCallbacks) != 1 || !strings.Contains(mockAPI.answerCallbacks[0].Text, "expired") {
+		t.Errorf("Expected the button press to be answered, got %+v", mockAPI.answerCallbacks)
+	}
+}
+
+// TestRequestContext tests that handlers are given the request, with the user and whether they're an admin
+func TestRequestContext(t *testing.T) {
+	bot := &Bot{api: &MockBotAPI2{}}
+	bot.SetAdmins([]int64{123})
+
+	var got *request
+	handler := chain(func(ctx context.Context, update tgbotapi.Update) {
+		got = requestFrom(ctx)
+	}, bot.logRequests, bot.loadUser)
+	handler(context.Background(), commandUpdate(123, "/help"))
+
+	if got == nil || got.Kind != "/help" || got.User.ID != 123 || got.ChatID != 123 || !got.Admin {
+		t.Fatalf("Unexpected request %+v", got)
+	}
+	if !strings.Contains(got.Log.Prefix(), "update 1 /help from user 123") {
+		t.Errorf("Expected the logger to be prefixed with the update, got %q", got.Log.Prefix())
+	}
+}
+
+// TestCheckAccess tests that updates from bots, blocked users and no user at all aren't handled
+func TestCheckAccess(t *testing.T) {
+	bot := &Bot{api: &MockBotAPI2{}}
+	bot.SetBlockedUsers([]int64{666})
+
+	blocked := commandUpdate(666, "/search")
+	fromBot := commandUpdate(777, "/search")
+	fromBot.Message.From.IsBot = true
+	channelPost := tgbotapi.Update{ChannelPost: &tgbotapi.Message{Chat: &tgbotapi.Chat{ID: -100}}}
+
+	testCases := []struct {
+		name     string
+		update   tgbotapi.Update
+		expected bool
+	}{
+		{"User", commandUpdate(123, "/search"), true},
+		{"Blocked user", blocked, false},
+		{"Bot", fromBot, false},
+		{"Channel post", channelPost, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handled := false
+			handler := chain(func(ctx context.Context, update tgbotapi.Update) {
+				handled = true
+			}, bot.loadUser, bot.checkAccess)
+			handler(context.Background(), tc.update)
+			if handled != tc.expected {
+				t.Errorf("Expected handled to be %v", tc.expected)
+			}
+		})
+	}
+}
+
+// TestFloodGuard tests that a user's updates over the limit are dropped with one warning, until the window ends
+func TestFloodGuard(t *testing.T) {
+	var guard floodGuard
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	for i := 0; i < floodLimit; i++ {
+		if allowed, _ := guard.allow(1, now); !allowed {
+			t.Fatalf("Expected update %d to be allowed", i+1)
+		}
+	}
+	if allowed, warn := guard.allow(1, now); allowed || !warn {
+		t.Errorf("Expected the first update over the limit to be dropped with a warning, got %v, %v", allowed, warn)
+	}
+	if allowed, warn := guard.allow(1, now); allowed || warn {
+		t.Errorf("Expected later updates to be dropped without a warning, got %v, %v", allowed, warn)
+	}
+	if allowed, _ := guard.allow(2, now); !allowed {
+		t.Error("Expected other users not to be limited")
+	}
+	if allowed, _ := guard.allow(1, now.Add(floodWindow)); !allowed {
+		t.Error("Expected the limit to reset after the window")
+	}
+}
+
+// TestGuardFlood tests that a flooding user is warned once, and that admins aren't limited
+func TestGuardFlood(t *testing.T) {
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI}
+	bot.SetAdmins([]int64{999})
+
+	handled := map[int64]int{}
+	handler := chain(func(ctx context.Context, update tgbotapi.Update) {
+		handled[update.Message.From.ID]++
+	}, bot.loadUser, bot.guardFlood)
+	for i := 0; i < floodLimit+5; i++ {
+		handler(context.Background(), commandUpdate(123, "/search"))
+		handler(context.Background(), commandUpdate(999, "/search"))
+	}
+
+	if handled[123] != floodLimit || handled[999] != floodLimit+5 {
+		t.Errorf("Expected the user to be limited to %d updates and the admin not at all, got %v", floodLimit, handled)
+	}
+	if len(mockAPI.messages) != 1 || !strings.Contains(mockAPI.messages[0].Text, "going a bit fast") {
+		t.Errorf("Expected one warning, got %+v", mockAPI.messages)
+	}
+}
+
+// TestTimeHandlers tests that handled updates, and panics, are counted for each kind of update
+func TestTimeHandlers(t *testing.T) {
+	bot := &Bot{api: &MockBotAPI2{}}
+	handler := chain(func(ctx context.Context, update tgbotapi.Update) {
+		if update.Message.Text == "/crash" {
+			panic("crash")
+		}
+	}, bot.recoverPanics, bot.timeHandlers)
+
+	handler(context.Background(), commandUpdate(1, "/help"))
+	handler(context.Background(), commandUpdate(1, "/help"))
+	handler(context.Background(), commandUpdate(1, "/crash"))
+
+	timings := bot.handlerMetrics.snapshot()
+	if len(timings) != 2 {
+		t.Fatalf("Expected 2 kinds of update, got %+v", timings)
+	}
+	if timings[0].Kind != "/help" || timings[0].Count != 2 || timings[0].Panics != 0 {
+		t.Errorf("Unexpected /help timing %+v", timings[0])
+	}
+	if timings[1].Kind != "/crash" || timings[1].Count != 1 || timings[1].Panics != 1 {
+		t.Errorf("Unexpected /crash timing %+v", timings[1])
+	}
+}
+
+// TestUpdateKind tests how updates are described in logs and metrics
+func TestUpdateKind(t *testing.T) {
+	testCases := []struct {
+		update   tgbotapi.Update
+		expected string
+	}{
+		{commandUpdate(1, "/search"), "/search"},
+		{tgbotapi.Update{Message: &tgbotapi.Message{Text: "Bath"}}, "message"},
+		{tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{Data: "page:12:3"}}, "callback:page"},
+		{tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{Data: "page.1:12:3"}}, "callback:page"},
+		{tgbotapi.Update{CallbackQuery: &tgbotapi.CallbackQuery{Data: "~abc"}}, "callback:token"},
+		{tgbotapi.Update{}, "other"},
+	}
+	for _, tc := range testCases {
+		if kind := updateKind(tc.update); kind != tc.expected {
+			t.Errorf("Expected %q, got %q", tc.expected, kind)
+		}
+	}
+}
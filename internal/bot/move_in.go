@@ -0,0 +1,207 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMoveInToleranceDays is how many days after the requested move-in date
+// a property may become available and still match the search.
+const defaultMoveInToleranceDays = 14
+
+// moveInFlexible and tenancyAny are the callback values for "no preference".
+const (
+	moveInFlexible = "flexible"
+	tenancyAny     = "any"
+)
+
+// tenancyOptions are the tenancy lengths in months offered as quick picks.
+var tenancyOptions = []int{6, 12}
+
+var (
+	errInvalidMoveInDate = errors.New("invalid move-in date")
+	errMoveInDateInPast  = errors.New("move-in date is in the past")
+)
+
+var (
+	ordinalPattern  = regexp.MustCompile(`\b(\d{1,2})(st|nd|rd|th)\b`)
+	dayMonthPattern = regexp.MustCompile(`^(\d{1,2}) ([a-z]+)(?: (\d{4}))?$`)
+	monthPattern    = regexp.MustCompile(`^([a-z]+)(?: (\d{4}))?$`)
+)
+
+// asapWords are the inputs meaning "as soon as possible".
+var asapWords = map[string]bool{"asap": true, "now": true, "immediately": true, "today": true}
+
+// numericDateLayouts are the UK date formats accepted with a year, most specific first.
+var numericDateLayouts = []string{"2006-01-02", "2/1/2006", "2/1/06", "2-1-2006", "2-1-06", "2.1.2006", "2.1.06"}
+
+// numericShortLayouts are the UK date formats accepted without a year.
+var numericShortLayouts = []string{"2/1", "2-1", "2.1"}
+
+// parseMoveInDate parses a move-in date typed by the user, relative to now.
+// It accepts quick picks such as "ASAP" or "September", UK dates such as
+// 01/09/2025, 1.9.25 or 1st September 2025, and ISO dates.
+// A month on its own means the first of that month. Dates without a year
+// refer to their next occurrence. "Flexible" returns the zero time.
+func parseMoveInDate(input string, now time.Time) (time.Time, error) {
+	s := strings.ToLower(strings.TrimSpace(input))
+	s = strings.Join(strings.Fields(strings.ReplaceAll(s, ",", " ")), " ")
+	s = ordinalPattern.ReplaceAllString(s, "$1")
+	s = strings.TrimPrefix(s, "the ")
+	s = strings.ReplaceAll(s, " of ", " ")
+
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if s == "" {
+		return time.Time{}, errInvalidMoveInDate
+	}
+	if asapWords[s] {
+		return today, nil
+	}
+	if s == moveInFlexible || s == "any" {
+		return time.Time{}, nil
+	}
+
+	for _, layout := range numericDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return checkNotPast(t, today)
+		}
+	}
+	for _, layout := range numericShortLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return nextOccurrence(t.Day(), t.Month(), today)
+		}
+	}
+
+	if m := dayMonthPattern.FindStringSubmatch(s); m != nil {
+		month, ok := lookupMonth(m[2])
+		if !ok {
+			return time.Time{}, errInvalidMoveInDate
+		}
+		day, _ := strconv.Atoi(m[1])
+		if m[3] == "" {
+			return nextOccurrence(day, month, today)
+		}
+		year, _ := strconv.Atoi(m[3])
+		t, err := calendarDate(year, month, day)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return checkNotPast(t, today)
+	}
+
+	if m := monthPattern.FindStringSubmatch(s); m != nil {
+		month, ok := lookupMonth(m[1])
+		if !ok {
+			return time.Time{}, errInvalidMoveInDate
+		}
+		year := today.Year()
+		if m[2] != "" {
+			year, _ = strconv.Atoi(m[2])
+		} else if month < today.Month() {
+			year++
+		}
+		if year == today.Year() && month == today.Month() {
+			return today, nil
+		}
+		return checkNotPast(time.Date(year, month, 1, 0, 0, 0, 0, time.UTC), today)
+	}
+
+	return time.Time{}, errInvalidMoveInDate
+}
+
+// lookupMonth matches full or abbreviated month names, such as "sep", "sept" or "september".
+func lookupMonth(name string) (time.Month, bool) {
+	if len(name) < 3 {
+		return 0, false
+	}
+	for m := time.January; m <= time.December; m++ {
+		if strings.HasPrefix(strings.ToLower(m.String()), name) {
+			return m, true
+		}
+	}
+	return 0, false
+}
+
+// calendarDate builds a date, rejecting days that don't exist such as 31 February.
+func calendarDate(year int, month time.Month, day int) (time.Time, error) {
+	t := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	if t.Day() != day || t.Month() != month {
+		return time.Time{}, errInvalidMoveInDate
+	}
+	return t, nil
+}
+
+// nextOccurrence returns the next date on or after today with the given day and month.
+func nextOccurrence(day int, month time.Month, today time.Time) (time.Time, error) {
+	t, err := calendarDate(today.Year(), month, day)
+	if err != nil {
+		// 29 February only exists in leap years, so try next year before giving up
+		if t, err = calendarDate(today.Year()+1, month, day); err != nil {
+			return time.Time{}, err
+		}
+	}
+	if t.Before(today) {
+		return calendarDate(today.Year()+1, month, day)
+	}
+	return t, nil
+}
+
+// checkNotPast rejects dates before today.
+func checkNotPast(t, today time.Time) (time.Time, error) {
+	if t.Before(today) {
+		return time.Time{}, errMoveInDateInPast
+	}
+	return t, nil
+}
+
+// moveInQuickPicks returns the first day of each of the next three months.
+func moveInQuickPicks(now time.Time) []time.Time {
+	first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	picks := make([]time.Time, 3)
+	for i := range picks {
+		picks[i] = first.AddDate(0, i+1, 0)
+	}
+	return picks
+}
+
+// formatMoveInDate describes a move-in date, or "Flexible" if none was given.
+func formatMoveInDate(t time.Time) string {
+	if t.IsZero() {
+		return "Flexible"
+	}
+	return t.Format("2 Jan 2006")
+}
+
+// formatTenancyMonths describes the requested tenancy length.
+func formatTenancyMonths(months int) string {
+	if months <= 0 {
+		return "Any"
+	}
+	return fmt.Sprintf("%d months", months)
+}
+
+// formatAvailability describes when a property is available and for how long it can be let.
+func formatAvailability(availableFrom time.Time, minMonths, maxMonths int, now time.Time) string {
+	availability := "Available now"
+	if availableFrom.After(now) {
+		availability = "Available from " + availableFrom.Format("2 Jan 2006")
+	}
+
+	switch {
+	case minMonths > 0 && maxMonths > 0 && minMonths == maxMonths:
+		availability += fmt.Sprintf(", %d month tenancy", minMonths)
+	case minMonths > 0 && maxMonths > 0:
+		availability += fmt.Sprintf(", %d-%d month tenancy", minMonths, maxMonths)
+	case minMonths > 0:
+		availability += fmt.Sprintf(", minimum %d month tenancy", minMonths)
+	case maxMonths > 0:
+		availability += fmt.Sprintf(", up to %d month tenancy", maxMonths)
+	}
+	return availability
+}
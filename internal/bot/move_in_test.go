@@ -0,0 +1,91 @@
+package bot
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestParseMoveInDate tests the accepted move-in date formats and quick picks
+func TestParseMoveInDate(t *testing.T) {
+	now := time.Date(2025, time.June, 15, 10, 30, 0, 0, time.UTC)
+	date := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	}
+
+	testCases := []struct {
+		input    string
+		expected time.Time
+	}{
+		{"ASAP", date(2025, time.June, 15)},
+		{"now", date(2025, time.June, 15)},
+		{"Flexible", time.Time{}},
+		{"01/09/2025", date(2025, time.September, 1)},
+		{"1/9/25", date(2025, time.September, 1)},
+		{"1.9.2025", date(2025, time.September, 1)},
+		{"2025-09-01", date(2025, time.September, 1)},
+		{"1/9", date(2025, time.September, 1)},
+		{"1/3", date(2026, time.March, 1)},
+		{"1st September", date(2025, time.September, 1)},
+		{"the 21st of Sept", date(2025, time.September, 21)},
+		{"3 Jan 2026", date(2026, time.January, 3)},
+		{"September", date(2025, time.September, 1)},
+		{"sep 2026", date(2026, time.September, 1)},
+		{"January", date(2026, time.January, 1)},
+		{"June", date(2025, time.June, 15)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.input, func(t *testing.T) {
+			result, err := parseMoveInDate(tc.input, now)
+			if err != nil {
+				t.Fatalf("parseMoveInDate(%q) returned an error: %v", tc.input, err)
+			}
+			if !result.Equal(tc.expected) {
+				t.Errorf("parseMoveInDate(%q) = %v, want %v", tc.input, result, tc.expected)
+			}
+		})
+	}
+}
+
+// TestParseMoveInDateInvalid tests that invalid and past dates are rejected
+func TestParseMoveInDateInvalid(t *testing.T) {
+	now := time.Date(2025, time.June, 15, 10, 30, 0, 0, time.UTC)
+
+	for _, input := range []string{"", "soon", "31/02/2026", "32 March", "ju"} {
+		if _, err := parseMoveInDate(input, now); !errors.Is(err, errInvalidMoveInDate) {
+			t.Errorf("parseMoveInDate(%q) error = %v, want %v", input, err, errInvalidMoveInDate)
+		}
+	}
+
+	for _, input := range []string{"01/01/2025", "1 May 2025", "March 2025"} {
+		if _, err := parseMoveInDate(input, now); !errors.Is(err, errMoveInDateInPast) {
+			t.Errorf("parseMoveInDate(%q) error = %v, want %v", input, err, errMoveInDateInPast)
+		}
+	}
+}
+
+// TestFormatAvailability tests the availability line shown on listings
+func TestFormatAvailability(t *testing.T) {
+	now := time.Date(2025, time.June, 15, 0, 0, 0, 0, time.UTC)
+	september := time.Date(2025, time.September, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		availableFrom time.Time
+		min, max      int
+		expected      string
+	}{
+		{time.Time{}, 0, 0, "Available now"},
+		{now.AddDate(0, -1, 0), 12, 12, "Available now, 12 month tenancy"},
+		{september, 6, 12, "Available from 1 Sep 2025, 6-12 month tenancy"},
+		{september, 6, 0, "Available from 1 Sep 2025, minimum 6 month tenancy"},
+		{september, 0, 11, "Available from 1 Sep 2025, up to 11 month tenancy"},
+	}
+
+	for _, tc := range testCases {
+		result := formatAvailability(tc.availableFrom, tc.min, tc.max, now)
+		if result != tc.expected {
+			t.Errorf("formatAvailability(%v, %d, %d) = %q, want %q", tc.availableFrom, tc.min, tc.max, result, tc.expected)
+		}
+	}
+}
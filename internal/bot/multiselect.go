@@ -0,0 +1,210 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"slices"
+)
+
+// Values of a multi-select keyboard's buttons other than its options.
+const (
+	selectDone = "done"
+	selectAll  = "all"
+	selectNone = "none"
+	selectPage = "page"
+)
+
+// selectOption is an option in a multi-select keyboard.
+type selectOption struct {
+	Value string // sent in the button's callback data
+	Label string
+}
+
+// selection is what a multi-select keyboard shows and changes, such as a set of property types.
+type selection interface {
+	Selected(value string) bool
+	Toggle(value string) error
+	Set(values []string, selected bool)
+}
+
+// setSelection is a selection of independent options, such as the furnishing options.
+type setSelection map[string]bool
+
+// Selected reports whether the option is selected.
+func (s setSelection) Selected(value string) bool { return s[value] }
+
+// Toggle selects the option, or clears it if it's selected.
+func (s setSelection) Toggle(value string) error {
+	s[value] = !s[value]
+	return nil
+}
+
+// Set selects or clears the options.
+func (s setSelection) Set(values []string, selected bool) {
+	for _, v := range values {
+		s[v] = selected
+	}
+}
+
+// multiSelect is an inline keyboard for choosing several options. It always lists the options in the
+// same order, so the buttons don't move when it's redrawn after each tap.
+type multiSelect struct {
+	Action    string // callback action of its buttons
+	Options   []selectOption
+	Columns   int  // options in each row, one if 0
+	PageSize  int  // options on each page, all of them if 0
+	Min       int  // options that must be selected before Done
+	Max       int  // options that may be selected, any number if 0
+	SelectAll bool // offer buttons to select all the options and to clear them
+	// Extra rows are shown above Done, such as buttons that select a group of options
+	Extra [][]tgbotapi.InlineKeyboardButton
+}
+
+// selectFields are the callback fields of a multi-select keyboard's action. The option is one of its
+// options or a control such as selectDone, and the page is where the keyboard was when it was tapped.
+func selectFields(values ...string) []callbackField {
+	return []callbackField{{Name: "option", Values: values}, {Name: "page", Kind: fieldInt, Optional: true}}
+}
+
+// pages returns how many pages the options take up.
+func (m multiSelect) pages() int {
+	if m.PageSize <= 0 || len(m.Options) == 0 {
+		return 1
+	}
+	return (len(m.Options) + m.PageSize - 1) / m.PageSize
+}
+
+// pageOf returns the page an option is on.
+func (m multiSelect) pageOf(value string) int {
+	i := slices.IndexFunc(m.Options, func(o selectOption) bool { return o.Value == value })
+	if i < 0 || m.PageSize <= 0 {
+		return 0
+	}
+	return i / m.PageSize
+}
+
+// values returns the values of all the options, in order.
+func (m multiSelect) values() []string {
+	values := make([]string, len(m.Options))
+	for i, o := range m.Options {
+		values[i] = o.Value
+	}
+	return values
+}
+
+// count returns how many options are selected.
+func (m multiSelect) count(s selection) int {
+	n := 0
+	for _, o := range m.Options {
+		if s.Selected(o.Value) {
+			n++
+		}
+	}
+	return n
+}
+
+// keyboard draws a page of the options, marking the selected ones, with the page buttons, the select all
+// and clear buttons, the extra rows and Done.
+func (m multiSelect) keyboard(s selection, page int) tgbotapi.InlineKeyboardMarkup {
+	pages := m.pages()
+	page = max(0, min(page, pages-1))
+	options := m.Options
+	if m.PageSize > 0 && len(options) > 0 {
+		options = options[page*m.PageSize : min((page+1)*m.PageSize, len(options))]
+	}
+	columns := max(m.Columns, 1)
+
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for _, o := range options {
+		mark := "☐ "
+		if s.Selected(o.Value) {
+			mark = "✅ "
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(mark+o.Label, callbackData(m.Action, o.Value)))
+		if len(row) == columns {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+
+	if pages > 1 {
+		var navigation []tgbotapi.InlineKeyboardButton
+		if page > 0 {
+			navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("◀", callbackData(m.Action, selectPage, page-1)))
+		}
+		navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("%d of %d", page+1, pages), callbackData("noop")))
+		if page < pages-1 {
+			navigation = append(navigation, tgbotapi.NewInlineKeyboardButtonData("▶", callbackData(m.Action, selectPage, page+1)))
+		}
+		rows = append(rows, navigation)
+	}
+	if m.SelectAll {
+		var bulk []tgbotapi.InlineKeyboardButton
+		if m.Max <= 0 || len(m.Options) <= m.Max {
+			bulk = append(bulk, tgbotapi.NewInlineKeyboardButtonData("Select all", callbackData(m.Action, selectAll, page)))
+		}
+		bulk = append(bulk, tgbotapi.NewInlineKeyboardButtonData("Clear", callbackData(m.Action, selectNone, page)))
+		rows = append(rows, bulk)
+	}
+	rows = append(rows, m.Extra...)
+	rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Done", callbackData(m.Action, selectDone))))
+	return tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// selectPress is the outcome of tapping a multi-select keyboard's button.
+type selectPress struct {
+	Done   bool   // the user has finished choosing
+	Redraw bool   // the keyboard should be redrawn on Page
+	Page   int    // page to show
+	Answer string // text to answer the tap with, such as why it was refused
+}
+
+// press applies a tap on one of the keyboard's buttons to the selection, keeping to the minimum and
+// maximum number of options.
+func (m multiSelect) press(s selection, args callbackArgs) selectPress {
+	page := args.Int("page")
+	switch option := args.String("option"); option {
+	case selectDone:
+		if n := m.count(s); n < m.Min {
+			return selectPress{Answer: fmt.Sprintf("Please choose at least %d.", m.Min)}
+		}
+		return selectPress{Done: true}
+	case selectPage:
+		return selectPress{Redraw: true, Page: page}
+	case selectAll, selectNone:
+		if !m.SelectAll || (option == selectAll && m.Max > 0 && len(m.Options) > m.Max) {
+			return selectPress{Answer: "That option isn't available."}
+		}
+		s.Set(m.values(), option == selectAll)
+		return selectPress{Redraw: true, Page: page}
+	default:
+		if !slices.ContainsFunc(m.Options, func(o selectOption) bool { return o.Value == option }) {
+			return selectPress{Answer: "That option isn't available any more."}
+		}
+		if !s.Selected(option) && m.Max > 0 && m.count(s) >= m.Max {
+			return selectPress{Answer: fmt.Sprintf("You can choose up to %d. Untick one first.", m.Max)}
+		}
+		if err := s.Toggle(option); err != nil {
+			return selectPress{Answer: "That option isn't available."}
+		}
+		return selectPress{Redraw: true, Page: m.pageOf(option)}
+	}
+}
+
+// handleSelectCallback applies a tap on a multi-select keyboard, redrawing the keyboard or calling done once
+// the user has finished choosing, and returns the text to answer the tap with.
+func (b *Bot) handleSelectCallback(query *tgbotapi.CallbackQuery, m multiSelect, s selection, args callbackArgs, done func()) string {
+	press := m.press(s, args)
+	switch {
+	case press.Done:
+		done()
+	case press.Redraw:
+		b.editMessageReplyMarkup(query.Message.Chat.ID, query.Message.MessageID, m.keyboard(s, press.Page))
+	}
+	return press.Answer
+}
@@ -0,0 +1,160 @@
+package bot
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"strings"
+	"testing"
+)
+
+// testSelect returns a keyboard of five options, two to a row and four to a page
+func testSelect() multiSelect {
+	m := multiSelect{Action: "property_type", Columns: 2, PageSize: 4, SelectAll: true}
+	for _, option := range []string{"a", "b", "c", "d", "e"} {
+		m.Options = append(m.Options, selectOption{Value: option, Label: strings.ToUpper(option)})
+	}
+	return m
+}
+
+// labels returns the text of each row of buttons
+func labels(keyboard tgbotapi.InlineKeyboardMarkup) []string {
+	var rows []string
+	for _, row := range keyboard.InlineKeyboard {
+		var texts []string
+		for _, button := range row {
+			texts = append(texts, button.Text)
+		}
+		rows = append(rows, strings.Join(texts, " "))
+	}
+	return rows
+}
+
+// press returns the decoded arguments of a keyboard button's callback data
+func press(t *testing.T, data string) callbackArgs {
+	t.Helper()
+	_, args, err := callbacks.decode(data)
+	if err != nil {
+		t.Fatalf("decode(%q) returned an error: %v", data, err)
+	}
+	return args
+}
+
+// TestMultiSelectKeyboard tests that options are laid out in order, in columns and pages, the same on every redraw
+func TestMultiSelectKeyboard(t *testing.T) {
+	m := testSelect()
+	selected := setSelection{"b": true, "e": true}
+
+	first := strings.Join(labels(m.keyboard(selected, 0)), " | ")
+	expected := "☐ A ✅ B | ☐ C ☐ D | 1 of 2 ▶ | Select all Clear | Done"
+	if first != expected {
+		t.Errorf("Expected %q, got %q", expected, first)
+	}
+	for i := 0; i < 10; i++ {
+		if again := strings.Join(labels(m.keyboard(selected, 0)), " | "); again != first {
+			t.Fatalf("Expected the same keyboard on every redraw, got %q", again)
+		}
+	}
+
+	second := strings.Join(labels(m.keyboard(selected, 1)), " | ")
+	if second != "✅ E | ◀ 2 of 2 | Select all Clear | Done" {
+		t.Errorf("Unexpected second page %q", second)
+	}
+	if last := strings.Join(labels(m.keyboard(selected, 9)), " | "); last != second {
+		t.Errorf("Expected a page past the end to show the last page, got %q", last)
+	}
+}
+
+// TestMultiSelectPress tests toggling options, paging, selecting all and clearing
+func TestMultiSelectPress(t *testing.T) {
+	m := testSelect()
+	selected := setSelection{}
+
+	if p := m.press(selected, press(t, callbackData("property_type", "e"))); !p.Redraw || p.Page != 1 || !selected["e"] {
+		t.Errorf("Expected e to be selected and its page redrawn, got %+v and %v", p, selected)
+	}
+	if p := m.press(selected, press(t, callbackData("property_type", selectPage, 1))); !p.Redraw || p.Page != 1 {
+		t.Errorf("Expected the second page, got %+v", p)
+	}
+	if p := m.press(selected, press(t, callbackData("property_type", selectAll, 1))); !p.Redraw || p.Page != 1 || m.count(selected) != 5 {
+		t.Errorf("Expected every option to be selected, got %+v and %v", p, selected)
+	}
+	if p := m.press(selected, press(t, callbackData("property_type", selectNone, 0))); !p.Redraw || m.count(selected) != 0 {
+		t.Errorf("Expected every option to be cleared, got %+v and %v", p, selected)
+	}
+	if p := m.press(selected, press(t, callbackData("property_type", "z"))); p.Redraw || p.Answer == "" {
+		t.Errorf("Expected an unknown option to be refused, got %+v", p)
+	}
+	if p := m.press(selected, press(t, callbackData("property_type", selectDone))); !p.Done {
+		t.Errorf("Expected Done to finish, got %+v", p)
+	}
+}
+
+// TestMultiSelectRules tests the minimum and maximum number of options
+func TestMultiSelectRules(t *testing.T) {
+	m := testSelect()
+	m.Min, m.Max = 1, 2
+	selected := setSelection{}
+
+	if p := m.press(selected, press(t, callbackData("property_type", selectDone))); p.Done || !strings.Contains(p.Answer, "at least 1") {
+		t.Errorf("Expected Done to be refused with nothing selected, got %+v", p)
+	}
+	m.press(selected, press(t, callbackData("property_type", "a")))
+	m.press(selected, press(t, callbackData("property_type", "b")))
+	if p := m.press(selected, press(t, callbackData("property_type", "c"))); p.Redraw || selected["c"] || !strings.Contains(p.Answer, "up to 2") {
+		t.Errorf("Expected a third option to be refused, got %+v and %v", p, selected)
+	}
+	if p := m.press(selected, press(t, callbackData("property_type", "a"))); !p.Redraw || selected["a"] {
+		t.Errorf("Expected an option to be cleared at the maximum, got %+v and %v", p, selected)
+	}
+	if p := m.press(selected, press(t, callbackData("property_type", selectAll, 0))); p.Redraw || m.count(selected) != 1 {
+		t.Errorf("Expected select all to be refused over the maximum, got %+v and %v", p, selected)
+	}
+	if rows := labels(m.keyboard(selected, 0)); rows[3] != "Clear" {
+		t.Errorf("Expected only Clear to be offered over the maximum, got %q", rows[3])
+	}
+	if p := m.press(selected, press(t, callbackData("property_type", selectDone))); !p.Done {
+		t.Errorf("Expected Done to finish with one selected, got %+v", p)
+	}
+}
+
+// TestBedroomSelection tests the bedroom keyboard, whose options select a range
+func TestBedroomSelection(t *testing.T) {
+	preferences := NewFlexibleSearchPreferences()
+	s := bedroomSelection{preferences}
+	m := bedroomSelect()
+
+	m.press(s, press(t, callbackData("bedrooms", "2")))
+	m.press(s, press(t, callbackData("bedrooms", "4")))
+	expected := "☐ Studio ☐ 1 ✅ 2 | ✅ 3 ✅ 4 ☐ 5+ | Done"
+	if got := strings.Join(labels(m.keyboard(s, 0)), " | "); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+
+	s.Set(m.values(), true)
+	if m.count(s) != len(bedroomOptions) {
+		t.Errorf("Expected every bedroom option to be selected, got %s", formatBedroomRange(preferences.MinBedrooms, preferences.MaxBedrooms))
+	}
+	s.Set(m.values(), false)
+	if preferences.MinBedrooms != nil || preferences.MaxBedrooms != nil {
+		t.Error("Expected the bedroom range to be cleared")
+	}
+}
+
+// TestPropertyTypeSelectAll tests selecting every property type from the keyboard, which is redrawn in place
+func TestPropertyTypeSelectAll(t *testing.T) {
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, propertyTypes: testPropertyTypes, state: make(map[int64]*UserState)}
+	bot.state[123] = &UserState{Preferences: NewFlexibleSearchPreferences()}
+
+	bot.handleCallbackQuery(context.Background(), &tgbotapi.CallbackQuery{
+		ID: "1", Data: callbackData("property_type", selectAll, 0), From: &tgbotapi.User{ID: 123},
+		Message: &tgbotapi.Message{MessageID: 456, Chat: &tgbotapi.Chat{ID: 123}},
+	})
+
+	if got := bot.formatPropertyTypes(bot.state[123].Preferences.PropertyTypes); got != "Flat, House, Bungalow" {
+		t.Errorf("Expected every property type to be selected, got %q", got)
+	}
+	if !mockAPI.MessageEdited(123, 456) {
+		t.Error("Expected the keyboard to be redrawn")
+	}
+}
@@ -0,0 +1,510 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// notificationBatchSize is how many queued notifications are looked at per check.
+const notificationBatchSize = 100
+
+// quietHoursOptions are the quiet hours offered as buttons, as start and end times.
+var quietHoursOptions = [][2]string{{"22:00", "07:00"}, {"23:00", "08:00"}, {"21:00", "09:00"}}
+
+// dailyLimitOptions are the daily alert limits offered as buttons. Zero means no limit.
+var dailyLimitOptions = []int{3, 5, 10, 0}
+
+// muteOptions are the mute lengths offered as buttons, in days.
+var muteOptions = []int{1, 3, 7}
+
+// notify adds a message the user didn't ask for, such as an alert or a digest, to the outbox if their
+// notification settings allow it. It writes with tx, so the message is only delivered if the change
+// it's about is saved. Messages held back by quiet hours, the daily limit or a mute are queued and moved
+// to the outbox by deliverQueuedNotifications once they're allowed. Messages in a category the user
+// turned off are dropped. It reports whether the message went to the outbox straight away.
+func (b *Bot) notify(ctx context.Context, tx database.Execer, msg tgbotapi.MessageConfig, category string) (bool, error) {
+	settings, err := database.GetNotificationSettings(ctx, b.db, msg.ChatID)
+	if err != nil {
+		// Don't lose notifications because the settings couldn't be read
+		log.Printf("Error getting notification settings for user %d: %v", msg.ChatID, err)
+		settings = database.DefaultNotificationSettings(msg.ChatID)
+	}
+	if !settings.CategoryEnabled(category) {
+		return false, nil
+	}
+	markup, err := encodeKeyboard(msg)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	if reason := b.notificationHeld(ctx, settings, now); reason != "" {
+		queued := database.QueuedNotification{UserID: msg.ChatID, Category: category, Text: msg.Text, ReplyMarkup: markup}
+		return false, database.QueueNotification(ctx, tx, queued)
+	}
+
+	message := database.OutboxMessage{UserID: msg.ChatID, Category: category, Text: msg.Text, ReplyMarkup: markup}
+	return true, enqueueNotification(ctx, tx, message, now)
+}
+
+// notificationHeld returns why a notification can't be sent to the user now, or an empty string if it can.
+func (b *Bot) notificationHeld(ctx context.Context, settings database.NotificationSettings, now time.Time) string {
+	if now.Before(settings.MutedUntil) {
+		return "muted"
+	}
+
+	loc, err := time.LoadLocation(settings.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+	if inQuietHours(settings.QuietStart, settings.QuietEnd, now.In(loc)) {
+		return "quiet hours"
+	}
+
+	if settings.MaxPerDay > 0 {
+		local := now.In(loc)
+		startOfDay := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+		sent, err := database.CountNotificationsSince(ctx, b.db, settings.UserID, startOfDay)
+		if err != nil {
+			log.Printf("Error counting notifications for user %d: %v", settings.UserID, err)
+		} else if sent >= settings.MaxPerDay {
+			return "daily limit"
+		}
+	}
+	return ""
+}
+
+// inQuietHours reports whether a local time is within quiet hours, which may run past midnight.
+// Quiet hours that start and end at the same time, or aren't set, never apply.
+func inQuietHours(start, end string, local time.Time) bool {
+	startAt, err := time.Parse("15:04", start)
+	if err != nil {
+		return false
+	}
+	endAt, err := time.Parse("15:04", end)
+	if err != nil {
+		return false
+	}
+
+	minute := local.Hour()*60 + local.Minute()
+	from := startAt.Hour()*60 + startAt.Minute()
+	to := endAt.Hour()*60 + endAt.Minute()
+	if from < to {
+		return minute >= from && minute < to
+	}
+	if from > to {
+		return minute >= from || minute < to
+	}
+	return false
+}
+
+// deliverQueuedNotifications moves the queued notifications that the users' settings now allow to the outbox,
+// oldest first. Once a user's notification is held back again, the rest of theirs stay queued so they arrive in order.
+func (b *Bot) deliverQueuedNotifications(ctx context.Context, now time.Time) error {
+	queued, err := database.GetQueuedNotifications(ctx, b.db, notificationBatchSize)
+	if err != nil {
+		return fmt.Errorf("error getting queued notifications: %w", err)
+	}
+
+	held := make(map[int64]bool)
+	for _, n := range queued {
+		if held[n.UserID] {
+			continue
+		}
+		settings, err := database.GetNotificationSettings(ctx, b.db, n.UserID)
+		if err != nil {
+			return fmt.Errorf("error getting notification settings for user %d: %w", n.UserID, err)
+		}
+
+		if !settings.CategoryEnabled(n.Category) {
+			// The user turned the category off after the notification was queued
+			if err := database.DeleteQueuedNotification(ctx, b.db, n.ID); err != nil {
+				return fmt.Errorf("error removing queued notification %d: %w", n.ID, err)
+			}
+			continue
+		}
+		if b.notificationHeld(ctx, settings, now) != "" {
+			held[n.UserID] = true
+			continue
+		}
+
+		err = b.inTransaction(ctx, func(tx *sql.Tx) error {
+			message := database.OutboxMessage{UserID: n.UserID, Category: n.Category, Text: n.Text, ReplyMarkup: n.ReplyMarkup}
+			if err := enqueueNotification(ctx, tx, message, now); err != nil {
+				return err
+			}
+			return database.DeleteQueuedNotification(ctx, tx, n.ID)
+		})
+		if err != nil {
+			return fmt.Errorf("error moving queued notification %d to the outbox: %w", n.ID, err)
+		}
+	}
+	return nil
+}
+
+// handleNotificationsCommand shows the user's notification settings, with buttons to change them.
+func (b *Bot) handleNotificationsCommand(ctx context.Context, message *tgbotapi.Message) {
+	settings, err := database.GetNotificationSettings(ctx, b.db, message.From.ID)
+	if err != nil {
+		log.Printf("Error getting notification settings for user %d: %v", message.From.ID, err)
+		b.sendMessage(message.Chat.ID, "Sorry, there was an error retrieving your notification settings. Please try again later.", nil)
+		return
+	}
+
+	text, keyboard := renderNotificationSettings(settings, time.Now())
+	b.sendMessage(message.Chat.ID, text, keyboard)
+}
+
+// handleNotificationsCallback handles the buttons in the notification settings message. Each change is
+// saved straight away, and the message is edited to show the new settings.
+func (b *Bot) handleNotificationsCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	chatID := query.Message.Chat.ID
+
+	settings, err := database.GetNotificationSettings(ctx, b.db, query.From.ID)
+	if err != nil {
+		log.Printf("Error getting notification settings for user %d: %v", query.From.ID, err)
+		return "Sorry, there was an error. Please try again."
+	}
+
+	option := args.String("option")
+	now := time.Now()
+
+	switch args.String("setting") {
+	case "back":
+		text, keyboard := renderNotificationSettings(settings, now)
+		b.editMessageTextAndMarkup(chatID, query.Message.MessageID, text, keyboard)
+		return ""
+	case "quiet":
+		switch option {
+		case "":
+			b.editMessageReplyMarkup(chatID, query.Message.MessageID, quietHoursKeyboard())
+			return ""
+		case "other":
+			state.Stage = stageAwaitingQuietHours
+			b.updateUserState(query.From.ID, state)
+			b.sendMessage(chatID, "Type your quiet hours, e.g. 22:30-07:00.", nil)
+			return ""
+		case "timezone":
+			state.Stage = stageAwaitingNotificationTimezone
+			b.updateUserState(query.From.ID, state)
+			b.sendMessage(chatID, fmt.Sprintf("Quiet hours and daily limits use %s time. Type a timezone name to change it, e.g. Europe/Paris.", settings.Timezone), nil)
+			return ""
+		case "off":
+			settings.QuietStart, settings.QuietEnd = "", ""
+		default:
+			// Quiet hours are sent as HHMM-HHMM, so they fit in a button without a token
+			start, end, err := parseQuietHours(option)
+			if err != nil {
+				return "Invalid quiet hours"
+			}
+			settings.QuietStart, settings.QuietEnd = start, end
+		}
+	case "limit":
+		if option == "" {
+			b.editMessageReplyMarkup(chatID, query.Message.MessageID, dailyLimitKeyboard())
+			return ""
+		}
+		limit, err := strconv.Atoi(option)
+		if err != nil || limit < 0 {
+			return "Invalid limit"
+		}
+		settings.MaxPerDay = limit
+	case "cat":
+		if option == "" {
+			b.editMessageReplyMarkup(chatID, query.Message.MessageID, alertCategorySelect().keyboard(categorySelection{&settings}, 0))
+			return ""
+		}
+		// Settings messages sent before the alerts had their own keyboard toggle a category directly
+		if !isAlertCategory(option) {
+			return "Unknown category"
+		}
+		settings.DisabledCategories[option] = settings.CategoryEnabled(option)
+	case "mute":
+		if option == "other" {
+			state.Stage = stageAwaitingMuteDays
+			b.updateUserState(query.From.ID, state)
+			b.sendMessage(chatID, "How many days would you like to mute notifications for?", nil)
+			return ""
+		}
+		days, err := strconv.Atoi(option)
+		if err != nil || days < 0 {
+			return "Invalid mute"
+		}
+		settings.MutedUntil = time.Time{}
+		if days > 0 {
+			settings.MutedUntil = now.AddDate(0, 0, days)
+		}
+	}
+
+	if err := database.SaveNotificationSettings(ctx, b.db, settings); err != nil {
+		log.Printf("Error saving notification settings for user %d: %v", query.From.ID, err)
+		return "Sorry, there was an error saving your settings. Please try again."
+	}
+	text, keyboard := renderNotificationSettings(settings, now)
+	b.editMessageTextAndMarkup(chatID, query.Message.MessageID, text, keyboard)
+	return "Settings saved"
+}
+
+// handleAlertCategoriesCallback handles the alert category keyboard, saving each change, and goes back to the
+// notification settings once the user is done.
+func (b *Bot) handleAlertCategoriesCallback(ctx context.Context, query *tgbotapi.CallbackQuery, state *UserState, args callbackArgs) string {
+	chatID := query.Message.Chat.ID
+	settings, err := database.GetNotificationSettings(ctx, b.db, query.From.ID)
+	if err != nil {
+		log.Printf("Error getting notification settings for user %d: %v", query.From.ID, err)
+		return "Sorry, there was an error. Please try again."
+	}
+
+	m := alertCategorySelect()
+	selection := categorySelection{&settings}
+	press := m.press(selection, args)
+	switch {
+	case press.Done:
+		text, keyboard := renderNotificationSettings(settings, time.Now())
+		b.editMessageTextAndMarkup(chatID, query.Message.MessageID, text, keyboard)
+	case press.Redraw:
+		if err := database.SaveNotificationSettings(ctx, b.db, settings); err != nil {
+			log.Printf("Error saving notification settings for user %d: %v", query.From.ID, err)
+			return "Sorry, there was an error saving your settings. Please try again."
+		}
+		b.editMessageReplyMarkup(chatID, query.Message.MessageID, m.keyboard(selection, press.Page))
+	}
+	return press.Answer
+}
+
+// handleNotificationSettingText handles typed quiet hours, timezones and mute lengths from the notification settings.
+func (b *Bot) handleNotificationSettingText(ctx context.Context, message *tgbotapi.Message, state *UserState) {
+	settings, err := database.GetNotificationSettings(ctx, b.db, message.From.ID)
+	if err != nil {
+		log.Printf("Error getting notification settings for user %d: %v", message.From.ID, err)
+		b.sendMessage(message.Chat.ID, "Sorry, there was an error retrieving your notification settings. Please try again later.", nil)
+		return
+	}
+	text := strings.TrimSpace(message.Text)
+	now := time.Now()
+
+	switch state.Stage {
+	case stageAwaitingQuietHours:
+		start, end, err := parseQuietHours(text)
+		if err != nil {
+			b.sendMessage(message.Chat.ID, "Sorry, I couldn't understand those hours. Try something like 22:30-07:00.", nil)
+			return
+		}
+		settings.QuietStart, settings.QuietEnd = start, end
+	case stageAwaitingNotificationTimezone:
+		if _, err := time.LoadLocation(text); err != nil || text == "" || text == "Local" {
+			b.sendMessage(message.Chat.ID, "Sorry, I don't know that timezone. Try a name like Europe/London or America/New_York.", nil)
+			return
+		}
+		settings.Timezone = text
+	case stageAwaitingMuteDays:
+		days, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(text), " days"))
+		if err != nil || days <= 0 {
+			b.sendMessage(message.Chat.ID, "Please enter the number of days as a whole number, e.g. 5.", nil)
+			return
+		}
+		settings.MutedUntil = now.AddDate(0, 0, days)
+	}
+
+	if err := database.SaveNotificationSettings(ctx, b.db, settings); err != nil {
+		log.Printf("Error saving notification settings for user %d: %v", message.From.ID, err)
+		b.sendMessage(message.Chat.ID, "Sorry, there was an error saving your settings. Please try again.", nil)
+		return
+	}
+	state.Stage = "initial"
+	reply, keyboard := renderNotificationSettings(settings, now)
+	b.sendMessage(message.Chat.ID, reply, keyboard)
+}
+
+// renderNotificationSettings formats the notification settings message and its buttons.
+func renderNotificationSettings(s database.NotificationSettings, now time.Time) (string, tgbotapi.InlineKeyboardMarkup) {
+	quiet := "Off"
+	if s.QuietStart != "" {
+		quiet = fmt.Sprintf("%s–%s", s.QuietStart, s.QuietEnd)
+	}
+	limit := "No limit"
+	if s.MaxPerDay > 0 {
+		limit = strconv.Itoa(s.MaxPerDay)
+	}
+	muted := "No"
+	if now.Before(s.MutedUntil) {
+		muted = "Until " + s.MutedUntil.Format("2 Jan 2006 15:04")
+	}
+
+	var enabled []string
+	for _, category := range database.AlertCategories {
+		if s.CategoryEnabled(category) {
+			enabled = append(enabled, formatAlertCategory(category))
+		}
+	}
+	alerts := "None"
+	if len(enabled) > 0 {
+		alerts = strings.Join(enabled, ", ")
+	}
+
+	text := fmt.Sprintf("🔔 Notification settings\n\n"+
+		"Quiet hours: %s (%s)\n"+
+		"Maximum alerts per day: %s\n"+
+		"Alerts: %s\n"+
+		"Muted: %s\n\n"+
+		"Alerts held back by quiet hours, the daily limit or a mute are sent afterwards.",
+		quiet, s.Timezone, limit, alerts, muted)
+
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🌙 Quiet hours", callbackData("notify", "quiet")),
+			tgbotapi.NewInlineKeyboardButtonData("🔢 Daily limit", callbackData("notify", "limit")),
+		),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("🏷 Alerts", callbackData("notify", "cat"))),
+	}
+
+	if now.Before(s.MutedUntil) {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("🔔 Unmute", callbackData("notify", "mute", 0))))
+	} else {
+		var muteRow []tgbotapi.InlineKeyboardButton
+		for _, days := range muteOptions {
+			muteRow = append(muteRow, tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("🔕 %dd", days), callbackData("notify", "mute", days)))
+		}
+		muteRow = append(muteRow, tgbotapi.NewInlineKeyboardButtonData("🔕 Other", callbackData("notify", "mute", "other")))
+		rows = append(rows, muteRow)
+	}
+
+	return text, tgbotapi.NewInlineKeyboardMarkup(rows...)
+}
+
+// quietHoursKeyboard offers quiet hours to choose from.
+func quietHoursKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	for _, option := range quietHoursOptions {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("%s–%s", option[0], option[1]),
+			callbackData("notify", "quiet", strings.Replace(option[0], ":", "", 1)+"-"+strings.Replace(option[1], ":", "", 1))))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		row,
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("Other hours", callbackData("notify", "quiet", "other")),
+			tgbotapi.NewInlineKeyboardButtonData("Timezone", callbackData("notify", "quiet", "timezone")),
+			tgbotapi.NewInlineKeyboardButtonData("Off", callbackData("notify", "quiet", "off")),
+		),
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("« Back", callbackData("notify", "back"))),
+	)
+}
+
+// dailyLimitKeyboard offers daily alert limits to choose from.
+func dailyLimitKeyboard() tgbotapi.InlineKeyboardMarkup {
+	var row []tgbotapi.InlineKeyboardButton
+	for _, limit := range dailyLimitOptions {
+		label := strconv.Itoa(limit)
+		if limit == 0 {
+			label = "No limit"
+		}
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(label, callbackData("notify", "limit", limit)))
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(
+		row,
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("« Back", callbackData("notify", "back"))),
+	)
+}
+
+// parseQuietHours parses quiet hours such as 22:00-07:00 or 11pm to 7am, and returns the start and end as HH:MM.
+func parseQuietHours(text string) (string, string, error) {
+	text = strings.NewReplacer("–", "-", " to ", "-").Replace(strings.ToLower(text))
+	parts := strings.Split(text, "-")
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("invalid quiet hours %q", text)
+	}
+	start, err := parseTimeOfDay(parts[0])
+	if err != nil {
+		return "", "", err
+	}
+	end, err := parseTimeOfDay(parts[1])
+	if err != nil {
+		return "", "", err
+	}
+	if start == end {
+		return "", "", fmt.Errorf("quiet hours %q start and end at the same time", text)
+	}
+	return start, end, nil
+}
+
+// isAlertCategory reports whether a category is one users can turn on or off.
+func isAlertCategory(category string) bool {
+	for _, c := range database.AlertCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// alertCategorySelect is the keyboard for turning alert categories on or off.
+func alertCategorySelect() multiSelect {
+	m := multiSelect{Action: "alert_categories", Columns: 2, SelectAll: true}
+	for _, category := range database.AlertCategories {
+		m.Options = append(m.Options, selectOption{Value: category, Label: formatAlertCategory(category)})
+	}
+	return m
+}
+
+// categorySelection is the alert categories a user has turned on.
+type categorySelection struct {
+	settings *database.NotificationSettings
+}
+
+// Selected reports whether the category is turned on.
+func (s categorySelection) Selected(category string) bool {
+	return s.settings.CategoryEnabled(category)
+}
+
+// Toggle turns the category on or off.
+func (s categorySelection) Toggle(category string) error {
+	s.settings.DisabledCategories[category] = s.settings.CategoryEnabled(category)
+	return nil
+}
+
+// Set turns the categories on or off.
+func (s categorySelection) Set(categories []string, selected bool) {
+	for _, category := range categories {
+		s.settings.DisabledCategories[category] = !selected
+	}
+}
+
+// formatAlertCategory describes an alert category.
+func formatAlertCategory(category string) string {
+	switch category {
+	case database.NotifyNewMatch:
+		return "New matches"
+	case database.NotifyPriceDrop:
+		return "Price drops"
+	case database.NotifyPriceRise:
+		return "Price rises"
+	case database.NotifyUnderOffer:
+		return "Under offer"
+	case database.NotifyLetAgreed:
+		return "Let agreed"
+	case database.NotifyWithdrawn:
+		return "Withdrawn"
+	case database.NotifyViewingReminder:
+		return "Viewing reminders"
+	default:
+		return category
+	}
+}
+
+// editMessageTextAndMarkup replaces the text and inline keyboard of a message.
+func (b *Bot) editMessageTextAndMarkup(chatID int64, messageID int, text string, keyboard tgbotapi.InlineKeyboardMarkup) {
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, messageID, text, keyboard)
+	edit.ParseMode = "HTML"
+	if _, err := b.api.Send(edit); err != nil {
+		log.Printf("Error editing message: %v", err)
+	}
+}
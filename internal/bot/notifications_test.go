@@ -0,0 +1,264 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"strings"
+	"testing"
+	"time"
+)
+
+// notificationSettingsColumns are the columns selected for notification settings
+var notificationSettingsColumns = []string{"user_id", "quiet_start", "quiet_end", "timezone", "max_per_day", "disabled_categories", "muted_until"}
+
+// TestInQuietHours tests quiet hours within a day and past midnight
+func TestInQuietHours(t *testing.T) {
+	at := func(hour, minute int) time.Time {
+		return time.Date(2026, 1, 10, hour, minute, 0, 0, time.UTC)
+	}
+	testCases := []struct {
+		name       string
+		start, end string
+		local      time.Time
+		want       bool
+	}{
+		{"Not set", "", "", at(23, 0), false},
+		{"Before overnight quiet hours", "22:00", "07:00", at(21, 59), false},
+		{"Start of overnight quiet hours", "22:00", "07:00", at(22, 0), true},
+		{"After midnight", "22:00", "07:00", at(3, 30), true},
+		{"End of overnight quiet hours", "22:00", "07:00", at(7, 0), false},
+		{"Within daytime quiet hours", "09:00", "17:30", at(12, 0), true},
+		{"After daytime quiet hours", "09:00", "17:30", at(17, 30), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := inQuietHours(tc.start, tc.end, tc.local); got != tc.want {
+				t.Errorf("inQuietHours(%q, %q, %v) = %v, want %v", tc.start, tc.end, tc.local, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestParseQuietHours tests parsing typed quiet hours
+func TestParseQuietHours(t *testing.T) {
+	testCases := map[string][2]string{
+		"22:00-07:00":   {"22:00", "07:00"},
+		"2230-0700":     {"22:30", "07:00"},
+		"11pm to 7am":   {"23:00", "07:00"},
+		"21:00 – 09:00": {"21:00", "09:00"},
+	}
+	for input, want := range testCases {
+		start, end, err := parseQuietHours(input)
+		if err != nil || start != want[0] || end != want[1] {
+			t.Errorf("parseQuietHours(%q) = %q, %q, %v, want %q, %q", input, start, end, err, want[0], want[1])
+		}
+	}
+	for _, input := range []string{"", "22:00", "late-early", "08:00-08:00"} {
+		if _, _, err := parseQuietHours(input); err == nil {
+			t.Errorf("parseQuietHours(%q) expected an error", input)
+		}
+	}
+}
+
+// TestNotify tests that notifications are added to the outbox, queued while muted, and dropped when their category is off
+func TestNotify(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db}
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Save Listing", "save:7")))
+	message := func(userID int64) tgbotapi.MessageConfig {
+		msg := tgbotapi.NewMessage(userID, "🔔 New property")
+		msg.ReplyMarkup = keyboard
+		return msg
+	}
+
+	// User 1 has the default settings
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(1)).WillReturnError(sql.ErrNoRows)
+	sent := expectOutboxMessage(mock, 1, "new_match")
+	if ok, err := bot.notify(ctx, db, message(1), "new_match"); !ok || err != nil {
+		t.Errorf("Expected the notification to be added to the outbox with the default settings, got %v, %v", ok, err)
+	}
+	if label := sent.Keyboard(t).InlineKeyboard[0][0].Text; label != "Save Listing" {
+		t.Errorf("Expected the keyboard to be stored with the notification, got %q", label)
+	}
+
+	// User 2 is muted
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows(notificationSettingsColumns).AddRow(2, "", "", "Europe/London", 0, "{}", time.Now().Add(time.Hour)))
+	mock.ExpectExec("INSERT INTO notification_queue").
+		WithArgs(int64(2), "new_match", "🔔 New property", `{"inline_keyboard":[[{"text":"Save Listing","callback_data":"save:7"}]]}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	if ok, err := bot.notify(ctx, db, message(2), "new_match"); ok || err != nil {
+		t.Errorf("Expected the notification to be queued while muted, got %v, %v", ok, err)
+	}
+
+	// User 3 turned price drops off
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows(notificationSettingsColumns).AddRow(3, "", "", "Europe/London", 0, `{"price_drop":true}`, nil))
+	if ok, err := bot.notify(ctx, db, message(3), "price_drop"); ok || err != nil {
+		t.Errorf("Expected the notification to be dropped for a category that is off, got %v, %v", ok, err)
+	}
+
+	if len(mockAPI.messages) != 0 {
+		t.Errorf("Expected notifications to be left for the outbox to deliver, got %+v", mockAPI.messages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestDeliverQueuedNotifications tests that queued notifications are moved to the outbox once allowed,
+// and stay queued while the daily limit is reached
+func TestDeliverQueuedNotifications(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db}
+	now := time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT (.+) FROM notification_queue").WithArgs(notificationBatchSize).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "category", "text", "reply_markup"}).
+			AddRow(1, 1, "new_match", "First", `{"inline_keyboard":[[{"text":"Save Listing","callback_data":"save:7"}]]}`).
+			AddRow(2, 2, "new_match", "Over the limit", "").
+			AddRow(3, 2, "price_drop", "Also over the limit", ""))
+
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(1)).WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO outbox").
+		WithArgs(int64(1), "new_match", "First", `{"inline_keyboard":[[{"text":"Save Listing","callback_data":"save:7"}]]}`, now, now).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO notification_log").WithArgs(int64(1), "new_match", now).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("DELETE FROM notification_queue").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows(notificationSettingsColumns).AddRow(2, "", "", "UTC", 3, "{}", nil))
+	mock.ExpectQuery("SELECT COUNT(.+) FROM notification_log").WithArgs(int64(2), time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	if err := bot.deliverQueuedNotifications(ctx, now); err != nil {
+		t.Fatalf("deliverQueuedNotifications() returned an error: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestNotificationsCallback tests changing notification settings with the buttons
+func TestNotificationsCallback(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db, state: make(map[int64]*UserState)}
+	callback := func(data string) {
+		bot.handleCallbackQuery(ctx, &tgbotapi.CallbackQuery{
+			ID:      data,
+			From:    &tgbotapi.User{ID: 1},
+			Message: &tgbotapi.Message{MessageID: 5, Chat: &tgbotapi.Chat{ID: 1}},
+			Data:    data,
+		})
+	}
+
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(1)).WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT OR REPLACE INTO notification_settings").
+		WithArgs(int64(1), "", "", "Europe/London", 0, `{"let_agreed":true}`, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	callback("notify:cat:let_agreed")
+
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows(notificationSettingsColumns).AddRow(1, "", "", "Europe/London", 0, `{"let_agreed":true}`, nil))
+	mock.ExpectExec("INSERT OR REPLACE INTO notification_settings").
+		WithArgs(int64(1), "22:00", "07:00", "Europe/London", 0, `{"let_agreed":true}`, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	callback("notify:quiet:2200-0700")
+
+	if len(mockAPI.editedTexts) != 2 {
+		t.Fatalf("Expected the settings message to be edited twice, got %d", len(mockAPI.editedTexts))
+	}
+	settings := mockAPI.editedTexts[1]
+	if !strings.Contains(settings.Text, "Quiet hours: 22:00–07:00 (Europe/London)") {
+		t.Errorf("Expected the new quiet hours to be shown, got:\n%s", settings.Text)
+	}
+	if !strings.Contains(settings.Text, "Alerts: New matches") || strings.Contains(settings.Text, "Let agreed") {
+		t.Errorf("Expected new matches to stay on and let agreed alerts to be off, got:\n%s", settings.Text)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestAlertCategoriesCallback tests turning alert categories on and off with their keyboard
+func TestAlertCategoriesCallback(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db, state: make(map[int64]*UserState)}
+	callback := func(data string) {
+		bot.handleCallbackQuery(ctx, &tgbotapi.CallbackQuery{
+			ID:      data,
+			From:    &tgbotapi.User{ID: 1},
+			Message: &tgbotapi.Message{MessageID: 5, Chat: &tgbotapi.Chat{ID: 1}},
+			Data:    data,
+		})
+	}
+	disabled := func(json string) *sqlmock.Rows {
+		return sqlmock.NewRows(notificationSettingsColumns).AddRow(1, "", "", "Europe/London", 0, json, nil)
+	}
+
+	// The settings open the keyboard
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(1)).WillReturnRows(disabled(`{"let_agreed":true}`))
+	callback(callbackData("notify", "cat"))
+	if len(mockAPI.editedMessages) != 1 {
+		t.Fatalf("Expected the alert keyboard to be shown, got %d edits", len(mockAPI.editedMessages))
+	}
+	rows := labels(*mockAPI.editedMessages[0].ReplyMarkup)
+	if rows[0] != "✅ New matches ✅ Price drops" || !strings.Contains(strings.Join(rows, " | "), "☐ Let agreed") {
+		t.Errorf("Expected let agreed alerts to be off, got %q", rows)
+	}
+
+	// Tapping a category saves it straight away
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(1)).WillReturnRows(disabled(`{"let_agreed":true}`))
+	mock.ExpectExec("INSERT OR REPLACE INTO notification_settings").
+		WithArgs(int64(1), "", "", "Europe/London", 0, `{"let_agreed":false}`, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	callback(callbackData("alert_categories", database.NotifyLetAgreed))
+	if rows := labels(*mockAPI.editedMessages[1].ReplyMarkup); !strings.Contains(strings.Join(rows, " | "), "✅ Let agreed") {
+		t.Errorf("Expected let agreed alerts to be on, got %q", rows)
+	}
+
+	// Done goes back to the settings
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(1)).WillReturnRows(disabled(`{"let_agreed":false}`))
+	callback(callbackData("alert_categories", selectDone))
+	if len(mockAPI.editedTexts) != 1 || !strings.Contains(mockAPI.editedTexts[0].Text, "Let agreed") {
+		t.Errorf("Expected the settings to be shown with let agreed alerts on, got %+v", mockAPI.editedTexts)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
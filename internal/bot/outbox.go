@@ -0,0 +1,184 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"imitation_project/internal/scheduler"
+	"imitation_project/internal/sendqueue"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Outbox settings.
+const (
+	outboxBatchSize   = 50        // messages delivered per run
+	outboxMaxAttempts = 8         // failed deliveries before a message is given up on
+	outboxStuckAfter  = time.Hour // how long a message may wait before it's reported as stuck
+	outboxReportLimit = 20        // stuck messages logged per report
+)
+
+// inTransaction runs fn in a database transaction, which is committed if fn succeeds and rolled back if not.
+func (b *Bot) inTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// enqueueNotification adds a notification to the outbox and records it against the user's daily limit.
+func enqueueNotification(ctx context.Context, tx database.Execer, message database.OutboxMessage, now time.Time) error {
+	if err := database.AddOutboxMessage(ctx, tx, message, now); err != nil {
+		return err
+	}
+	return database.RecordNotificationSent(ctx, tx, message.UserID, message.Category, now)
+}
+
+// encodeKeyboard returns a message's inline keyboard as JSON, so it can be stored with the message,
+// or an empty string if it has none.
+func encodeKeyboard(msg tgbotapi.MessageConfig) (string, error) {
+	keyboard, ok := msg.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	if !ok {
+		return "", nil
+	}
+	markup, err := json.Marshal(keyboard)
+	if err != nil {
+		return "", fmt.Errorf("error encoding notification keyboard: %w", err)
+	}
+	return string(markup), nil
+}
+
+// outboxMessageConfig rebuilds the Telegram message for an outbox message.
+func outboxMessageConfig(m database.OutboxMessage) tgbotapi.MessageConfig {
+	msg := tgbotapi.NewMessage(m.UserID, m.Text)
+	msg.ParseMode = "HTML"
+	if m.ReplyMarkup != "" {
+		var keyboard tgbotapi.InlineKeyboardMarkup
+		if err := json.Unmarshal([]byte(m.ReplyMarkup), &keyboard); err != nil {
+			log.Printf("Error decoding keyboard of outbox message %d: %v", m.ID, err)
+		} else {
+			msg.ReplyMarkup = keyboard
+		}
+	}
+	return msg
+}
+
+// broadcaster returns the API to send notifications with, which sends them behind interactive replies.
+func (b *Bot) broadcaster() sendqueue.API {
+	if b.broadcastAPI != nil {
+		return b.broadcastAPI
+	}
+	return b.api
+}
+
+// dispatchOutbox delivers the outbox messages that are due, oldest first. Messages that fail are retried
+// with backoff, unless Telegram says they can never be delivered or they've failed too often. When Telegram
+// rate limits the bot, nothing more is delivered until it says to retry. It returns how many messages it tried.
+func (b *Bot) dispatchOutbox(ctx context.Context, now time.Time) (int, error) {
+	if now.Before(b.outboxPausedUntil) {
+		return 0, nil
+	}
+	messages, err := database.GetDueOutboxMessages(ctx, b.db, now, outboxBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("error getting outbox messages: %w", err)
+	}
+
+	for _, m := range messages {
+		_, sendErr := b.broadcaster().Send(outboxMessageConfig(m))
+		if sendErr == nil {
+			if err := database.MarkOutboxDelivered(ctx, b.db, m.ID, now); err != nil {
+				return 0, fmt.Errorf("error marking outbox message %d as delivered: %w", m.ID, err)
+			}
+			continue
+		}
+
+		if retryAfter := telegramRetryAfter(sendErr); retryAfter > 0 {
+			// Being rate limited isn't the message's fault, so it doesn't count as an attempt
+			b.outboxPausedUntil = now.Add(retryAfter)
+			log.Printf("Telegram rate limit reached, pausing the outbox for %v", retryAfter)
+			return len(messages), database.RetryOutboxMessage(ctx, b.db, m.ID, m.Attempts, b.outboxPausedUntil, sendErr.Error())
+		}
+
+		attempts := m.Attempts + 1
+		if undeliverable(sendErr) || attempts >= outboxMaxAttempts {
+			log.Printf("Giving up on outbox message %d to user %d after %d attempts: %v", m.ID, m.UserID, attempts, sendErr)
+			err = database.FailOutboxMessage(ctx, b.db, m.ID, attempts, sendErr.Error())
+		} else {
+			err = database.RetryOutboxMessage(ctx, b.db, m.ID, attempts, now.Add(scheduler.RetryDelay(attempts)), sendErr.Error())
+		}
+		if err != nil {
+			return 0, fmt.Errorf("error recording failed delivery of outbox message %d: %w", m.ID, err)
+		}
+	}
+	return len(messages), nil
+}
+
+// flushOutbox delivers the outbox messages that are due, batch by batch, until none are left or ctx is done.
+// Messages that fail wait for their retry, so they don't keep it going.
+func (b *Bot) flushOutbox(ctx context.Context) error {
+	for ctx.Err() == nil {
+		n, err := b.dispatchOutbox(ctx, time.Now())
+		if err != nil || n == 0 {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// telegramRetryAfter returns how long Telegram asked the bot to wait before sending again,
+// or zero if the error isn't a rate limit.
+func telegramRetryAfter(err error) time.Duration {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second
+	}
+	return 0
+}
+
+// undeliverable reports whether Telegram refused a message in a way retrying won't fix,
+// such as the user blocking the bot or deleting their account.
+func undeliverable(err error) bool {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	return apiErr.Code == http.StatusForbidden ||
+		(apiErr.Code == http.StatusBadRequest && strings.Contains(strings.ToLower(apiErr.Message), "chat not found"))
+}
+
+// reportStuckOutboxMessages logs how many outbox messages were given up on, or have waited too long to be
+// delivered, and the newest of them, so operators can look into them.
+func (b *Bot) reportStuckOutboxMessages(ctx context.Context, now time.Time) error {
+	pendingSince := now.Add(-outboxStuckAfter)
+	total, err := database.CountStuckOutboxMessages(ctx, b.db, pendingSince)
+	if err != nil {
+		return fmt.Errorf("error counting stuck outbox messages: %w", err)
+	}
+	if total == 0 {
+		return nil
+	}
+	stuck, err := database.GetStuckOutboxMessages(ctx, b.db, pendingSince, outboxReportLimit)
+	if err != nil {
+		return fmt.Errorf("error getting stuck outbox messages: %w", err)
+	}
+
+	log.Printf("%d outbox messages are stuck, showing the newest %d", total, len(stuck))
+	for _, m := range stuck {
+		log.Printf("Stuck outbox message %d to user %d: %s after %d attempts, added %s, last error: %s",
+			m.ID, m.UserID, m.Status, m.Attempts, m.CreatedAt.Format(time.RFC3339), m.LastError)
+	}
+	return nil
+}
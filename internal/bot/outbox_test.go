@@ -0,0 +1,204 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"imitation_project/internal/scheduler"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// outboxColumns are the columns selected for outbox messages
+var outboxColumns = []string{"id", "user_id", "category", "text", "reply_markup", "status", "attempts", "next_attempt_at", "last_error", "created_at"}
+
+// capturedString matches any string argument and remembers it
+type capturedString struct {
+	value *string
+}
+
+func (c capturedString) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	*c.value = s
+	return ok
+}
+
+// outboxCapture holds the text and keyboard of a message written to the outbox, so tests can check them
+type outboxCapture struct {
+	text, markup string
+}
+
+func (c *outboxCapture) String() string {
+	return c.text
+}
+
+func (c *outboxCapture) Keyboard(t *testing.T) tgbotapi.InlineKeyboardMarkup {
+	t.Helper()
+	var keyboard tgbotapi.InlineKeyboardMarkup
+	if err := json.Unmarshal([]byte(c.markup), &keyboard); err != nil {
+		t.Fatalf("Expected a keyboard in the outbox message, got %q: %v", c.markup, err)
+	}
+	return keyboard
+}
+
+// expectOutboxMessage expects a notification to be added to the outbox and counted against the user's daily limit
+func expectOutboxMessage(mock sqlmock.Sqlmock, userID int64, category string) *outboxCapture {
+	c := &outboxCapture{}
+	mock.ExpectExec("INSERT INTO outbox").
+		WithArgs(userID, category, capturedString{&c.text}, capturedString{&c.markup}, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO notification_log").WithArgs(userID, category, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(1, 1))
+	return c
+}
+
+// failingBotAPI fails to send messages to some chats
+type failingBotAPI struct {
+	MockBotAPI2
+	errs map[int64]error
+}
+
+func (m *failingBotAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	if msg, ok := c.(tgbotapi.MessageConfig); ok && m.errs[msg.ChatID] != nil {
+		return tgbotapi.Message{}, m.errs[msg.ChatID]
+	}
+	return m.MockBotAPI2.Send(c)
+}
+
+// TestDispatchOutbox tests that outbox messages are delivered, retried with backoff, or given up on
+func TestDispatchOutbox(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &failingBotAPI{errs: map[int64]error{
+		2: errors.New("connection reset"),
+		3: &tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"},
+		4: errors.New("connection reset"),
+	}}
+	bot := &Bot{api: mockAPI, db: db}
+	now := time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT (.+) FROM outbox WHERE status = 'pending'").WithArgs(now, outboxBatchSize).
+		WillReturnRows(sqlmock.NewRows(outboxColumns).
+			AddRow(1, 1, "new_match", "Delivered", `{"inline_keyboard":[[{"text":"Save Listing","callback_data":"save:7"}]]}`, "pending", 0, now, "", now).
+			AddRow(2, 2, "new_match", "Retried", "", "pending", 1, now, "", now).
+			AddRow(3, 3, "new_match", "Blocked", "", "pending", 0, now, "", now).
+			AddRow(4, 4, "new_match", "Too many attempts", "", "pending", outboxMaxAttempts-1, now, "", now))
+	mock.ExpectExec("UPDATE outbox SET status = 'delivered'").WithArgs(now, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox SET attempts").WithArgs(2, now.Add(scheduler.RetryDelay(2)), "connection reset", 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox SET status = 'failed'").WithArgs(1, "Forbidden: bot was blocked by the user", 3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox SET status = 'failed'").WithArgs(outboxMaxAttempts, "connection reset", 4).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if _, err := bot.dispatchOutbox(ctx, now); err != nil {
+		t.Fatalf("dispatchOutbox() returned an error: %v", err)
+	}
+
+	if len(mockAPI.messages) != 1 || mockAPI.messages[0].Text != "Delivered" {
+		t.Fatalf("Expected only the first message to be delivered, got %+v", mockAPI.messages)
+	}
+	if _, ok := mockAPI.messages[0].ReplyMarkup.(tgbotapi.InlineKeyboardMarkup); !ok {
+		t.Errorf("Expected the stored keyboard to be restored, got %T", mockAPI.messages[0].ReplyMarkup)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestDispatchOutboxRateLimited tests that delivery pauses for as long as Telegram asks
+func TestDispatchOutboxRateLimited(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	rateLimited := &tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 30", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 30}}
+	mockAPI := &failingBotAPI{errs: map[int64]error{1: rateLimited}}
+	bot := &Bot{api: mockAPI, db: db}
+	now := time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery("SELECT (.+) FROM outbox WHERE status = 'pending'").WithArgs(now, outboxBatchSize).
+		WillReturnRows(sqlmock.NewRows(outboxColumns).
+			AddRow(1, 1, "new_match", "Rate limited", "", "pending", 2, now, "", now).
+			AddRow(2, 2, "new_match", "Not tried", "", "pending", 0, now, "", now))
+	// The attempt isn't counted, and the message is retried once the rate limit ends
+	mock.ExpectExec("UPDATE outbox SET attempts").WithArgs(2, now.Add(30*time.Second), rateLimited.Message, 1).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if _, err := bot.dispatchOutbox(ctx, now); err != nil {
+		t.Fatalf("dispatchOutbox() returned an error: %v", err)
+	}
+	// Nothing is looked at until the rate limit ends
+	if _, err := bot.dispatchOutbox(ctx, now.Add(10*time.Second)); err != nil {
+		t.Fatalf("dispatchOutbox() returned an error: %v", err)
+	}
+
+	if len(mockAPI.messages) != 0 {
+		t.Errorf("Expected nothing to be delivered while rate limited, got %+v", mockAPI.messages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestReportStuckOutboxMessages tests that the report counts every stuck message and shows the newest,
+// even when more have failed than fit in one report
+func TestReportStuckOutboxMessages(t *testing.T) {
+	ctx := context.Background()
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "bot.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialise database: %v", err)
+	}
+	defer db.Close()
+
+	now := time.Now()
+	failed := outboxReportLimit + 5
+	for i := 1; i <= failed; i++ {
+		message := database.OutboxMessage{UserID: int64(i), Category: "new_match", Text: "🔔 New property"}
+		if err := database.AddOutboxMessage(ctx, db, message, now); err != nil {
+			t.Fatalf("Failed to add outbox message: %v", err)
+		}
+	}
+	due, err := database.GetDueOutboxMessages(ctx, db, now, failed)
+	if err != nil {
+		t.Fatalf("Failed to get outbox messages: %v", err)
+	}
+	for _, m := range due {
+		if err := database.FailOutboxMessage(ctx, db, m.ID, 1, "bot was blocked"); err != nil {
+			t.Fatalf("Failed to fail outbox message: %v", err)
+		}
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	bot := &Bot{db: db}
+	if err := bot.reportStuckOutboxMessages(ctx, now); err != nil {
+		t.Fatalf("reportStuckOutboxMessages() returned an error: %v", err)
+	}
+
+	report := logs.String()
+	if !strings.Contains(report, fmt.Sprintf("%d outbox messages are stuck, showing the newest %d", failed, outboxReportLimit)) {
+		t.Errorf("Expected the report to count every stuck message, got:\n%s", report)
+	}
+	if !strings.Contains(report, fmt.Sprintf("to user %d:", failed)) || strings.Contains(report, "to user 1:") {
+		t.Errorf("Expected the report to show the newest messages, got:\n%s", report)
+	}
+	if lines := strings.Count(report, "Stuck outbox message"); lines != outboxReportLimit {
+		t.Errorf("Expected %d stuck messages to be logged, got %d", outboxReportLimit, lines)
+	}
+}
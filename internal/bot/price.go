@@ -0,0 +1,186 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// errInvalidPrice is returned when a price range cannot be understood.
+var errInvalidPrice = errors.New("invalid price range")
+
+// priceRange is a monthly rent range in GBP.
+// A zero bound means that side of the range is open.
+type priceRange struct {
+	Min int
+	Max int
+}
+
+// priceBucket is a preset price range offered as a quick-pick button.
+type priceBucket struct {
+	Label string
+	Value priceRange
+}
+
+// priceBuckets are the quick-pick price ranges shown alongside the price question.
+var priceBuckets = []priceBucket{
+	{Label: "Up to £800", Value: priceRange{Max: 800}},
+	{Label: "£800 - £1,200", Value: priceRange{Min: 800, Max: 1200}},
+	{Label: "£1,200 - £1,600", Value: priceRange{Min: 1200, Max: 1600}},
+	{Label: "£1,600 - £2,000", Value: priceRange{Min: 1600, Max: 2000}},
+	{Label: "£2,000+", Value: priceRange{Min: 2000}},
+}
+
+var (
+	weeklyPattern  = regexp.MustCompile(`\s*(p\s*/\s*w|pw|per\s+week|a\s+week|/\s*week|/\s*wk|weekly)\b`)
+	monthlyPattern = regexp.MustCompile(`\s*(p\s*/\s*m|pcm|pm|per\s+month|a\s+month|/\s*month|/\s*mo|monthly)\b`)
+	rangePattern   = regexp.MustCompile(`^(?:between\s+)?(.+?)\s*(?:-|–|—|\bto\b|\band\b)\s*(.+)$`)
+	amountPattern  = regexp.MustCompile(`^(\d{1,3}(?:,\d{3})+|\d+)(\.\d+)?\s*(k)?$`)
+)
+
+// maxPrefixes mark an input as an upper bound only, e.g. "under 1500".
+var maxPrefixes = []string{"up to", "upto", "under", "below", "less than", "no more than", "maximum", "max", "<=", "<"}
+
+// minPrefixes mark an input as a lower bound only, e.g. "from 1000".
+var minPrefixes = []string{"at least", "more than", "over", "above", "from", "minimum", "min", ">=", ">"}
+
+// minSuffixes mark an input as a lower bound only, e.g. "1000+".
+var minSuffixes = []string{"or more", "and above", "and up", "plus", "+"}
+
+// parsePrice parses a free-text price range into a monthly priceRange.
+// It understands currency symbols, thousands separators, "k" suffixes,
+// open-ended bounds ("under 1500", "1000+") and weekly prices ("900pw"),
+// which are converted to monthly rent. A single amount is treated as a maximum budget.
+func parsePrice(input string) (priceRange, error) {
+	s := strings.ToLower(strings.TrimSpace(input))
+	s = strings.ReplaceAll(s, "£", "")
+	s = strings.ReplaceAll(s, "gbp", "")
+
+	weekly := weeklyPattern.MatchString(s)
+	s = weeklyPattern.ReplaceAllString(s, "")
+	s = strings.TrimSpace(monthlyPattern.ReplaceAllString(s, ""))
+	if s == "" {
+		return priceRange{}, errInvalidPrice
+	}
+
+	var r priceRange
+	if rest, ok := trimAnyPrefix(s, maxPrefixes); ok {
+		amount, err := parseAmount(rest)
+		if err != nil {
+			return priceRange{}, err
+		}
+		r.Max = amount
+	} else if rest, ok := trimAnyPrefix(s, minPrefixes); ok {
+		amount, err := parseAmount(rest)
+		if err != nil {
+			return priceRange{}, err
+		}
+		r.Min = amount
+	} else if rest, ok := trimAnySuffix(s, minSuffixes); ok {
+		amount, err := parseAmount(rest)
+		if err != nil {
+			return priceRange{}, err
+		}
+		r.Min = amount
+	} else if m := rangePattern.FindStringSubmatch(s); m != nil {
+		low, err := parseAmount(m[1])
+		if err != nil {
+			return priceRange{}, err
+		}
+		high, err := parseAmount(m[2])
+		if err != nil {
+			return priceRange{}, err
+		}
+		if low > high {
+			low, high = high, low
+		}
+		r.Min, r.Max = low, high
+	} else {
+		amount, err := parseAmount(s)
+		if err != nil {
+			return priceRange{}, err
+		}
+		r.Max = amount
+	}
+
+	if weekly {
+		r.Min = weeklyToMonthly(r.Min)
+		r.Max = weeklyToMonthly(r.Max)
+	}
+	if r.Min == 0 && r.Max == 0 {
+		return priceRange{}, errInvalidPrice
+	}
+	return r, nil
+}
+
+// parseAmount parses a single amount such as "1,200", "1.2k" or "900".
+func parseAmount(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	m := amountPattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, errInvalidPrice
+	}
+	value, err := strconv.ParseFloat(strings.ReplaceAll(m[1], ",", "")+m[2], 64)
+	if err != nil {
+		return 0, errInvalidPrice
+	}
+	if m[3] == "k" {
+		value *= 1000
+	}
+	return int(math.Round(value)), nil
+}
+
+// weeklyToMonthly converts a weekly rent to the equivalent monthly rent.
+func weeklyToMonthly(weekly int) int {
+	return int(math.Round(float64(weekly) * 52 / 12))
+}
+
+// trimAnyPrefix removes the first matching prefix from s.
+func trimAnyPrefix(s string, prefixes []string) (string, bool) {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return strings.TrimSpace(strings.TrimPrefix(s, p)), true
+		}
+	}
+	return s, false
+}
+
+// trimAnySuffix removes the first matching suffix from s.
+func trimAnySuffix(s string, suffixes []string) (string, bool) {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return strings.TrimSpace(strings.TrimSuffix(s, suffix)), true
+		}
+	}
+	return s, false
+}
+
+// String returns the canonical form of the range, which parsePrice accepts again.
+func (r priceRange) String() string {
+	switch {
+	case r.Min > 0 && r.Max > 0:
+		return fmt.Sprintf("%d-%d", r.Min, r.Max)
+	case r.Max > 0:
+		return fmt.Sprintf("up to %d", r.Max)
+	case r.Min > 0:
+		return fmt.Sprintf("%d+", r.Min)
+	}
+	return ""
+}
+
+// Label returns the range formatted for display, e.g. "£1200 - £1800" or "up to £1500".
+func (r priceRange) Label() string {
+	switch {
+	case r.Min > 0 && r.Max > 0:
+		return fmt.Sprintf("£%d - £%d", r.Min, r.Max)
+	case r.Max > 0:
+		return fmt.Sprintf("up to £%d", r.Max)
+	case r.Min > 0:
+		return fmt.Sprintf("£%d+", r.Min)
+	}
+	return "Any"
+}
@@ -0,0 +1,63 @@
+package bot
+
+import (
+	"testing"
+)
+
+// TestParsePrice tests the parsePrice function with various price formats
+func TestParsePrice(t *testing.T) {
+	testCases := []struct {
+		input   string
+		minWant int
+		maxWant int
+	}{
+		{"1000-2000", 1000, 2000},           // Normal case
+		{"2000 - 1000", 1000, 2000},         // Reversed range
+		{"£1,200-£1,800", 1200, 1800},       // Currency symbols and separators
+		{"1.2k to 1.6k", 1200, 1600},        // k-suffixes
+		{"between 900 and 1100", 900, 1100}, // Words
+		{"1500", 0, 1500},                   // Single amount is a maximum budget
+		{"under 1500", 0, 1500},             // Open lower bound
+		{"up to £1,500 pcm", 0, 1500},       // Monthly marker
+		{"from 1000", 1000, 0},              // Open upper bound
+		{"£2,000+", 2000, 0},                // Plus suffix
+		{"900pw", 0, 3900},                  // Weekly rent
+		{"£200 - £300 per week", 867, 1300}, // Weekly range
+		{"1000 GBP a month", 0, 1000},       // Currency code
+		{"0-1000", 0, 1000},                 // Zero minimum
+	}
+
+	for _, tc := range testCases {
+		got, err := parsePrice(tc.input)
+		if err != nil {
+			t.Errorf("parsePrice(%s) returned an error: %v", tc.input, err)
+			continue
+		}
+		if got.Min != tc.minWant || got.Max != tc.maxWant {
+			t.Errorf("parsePrice(%s) = (%d, %d), want (%d, %d)", tc.input, got.Min, got.Max, tc.minWant, tc.maxWant)
+		}
+	}
+}
+
+// TestParsePriceInvalid tests that parsePrice rejects input it cannot understand
+func TestParsePriceInvalid(t *testing.T) {
+	inputs := []string{"", "invalid", "1000-", "-2000", "abc - def", "-1000--500", "1000 2000", "0"}
+
+	for _, input := range inputs {
+		if got, err := parsePrice(input); err == nil {
+			t.Errorf("parsePrice(%s) = %+v, want an error", input, got)
+		}
+	}
+}
+
+// TestPriceRangeString tests that the canonical form of a range can be parsed again
+func TestPriceRangeString(t *testing.T) {
+	ranges := []priceRange{{Min: 800, Max: 1200}, {Max: 800}, {Min: 2000}}
+
+	for _, r := range ranges {
+		got, err := parsePrice(r.String())
+		if err != nil || got != r {
+			t.Errorf("parsePrice(%q) = %+v, %v, want %+v", r.String(), got, err, r)
+		}
+	}
+}
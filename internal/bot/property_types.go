@@ -0,0 +1,135 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"sort"
+	"strings"
+)
+
+// getPropertyTypes returns the property type taxonomy used by the wizard.
+// It falls back to the default taxonomy if none was loaded from the database.
+func (b *Bot) getPropertyTypes() []database.PropertyType {
+	if len(b.propertyTypes) > 0 {
+		return b.propertyTypes
+	}
+	return database.DefaultPropertyTypes
+}
+
+// findPropertyType looks up a property type by its code.
+func (b *Bot) findPropertyType(code string) (database.PropertyType, bool) {
+	for _, t := range b.getPropertyTypes() {
+		if t.Code == code {
+			return t, true
+		}
+	}
+	return database.PropertyType{}, false
+}
+
+// propertyTypeLabel returns the display label for a property type code,
+// or the code itself if it is not part of the taxonomy.
+func (b *Bot) propertyTypeLabel(code string) string {
+	if t, ok := b.findPropertyType(code); ok {
+		return t.Label
+	}
+	return code
+}
+
+// propertyTypeGroups returns the groups that contain more than one property type, in display order.
+func (b *Bot) propertyTypeGroups() []string {
+	counts := make(map[string]int)
+	var groups []string
+	for _, t := range b.getPropertyTypes() {
+		if counts[t.Group] == 0 {
+			groups = append(groups, t.Group)
+		}
+		counts[t.Group]++
+	}
+
+	var result []string
+	for _, group := range groups {
+		if counts[group] > 1 {
+			result = append(result, group)
+		}
+	}
+	return result
+}
+
+// togglePropertyTypeGroup selects every property type in a group,
+// or clears them all if the whole group is already selected.
+func (b *Bot) togglePropertyTypeGroup(selected map[string]bool, group string) bool {
+	var codes []string
+	allSelected := true
+	for _, t := range b.getPropertyTypes() {
+		if t.Group == group {
+			codes = append(codes, t.Code)
+			allSelected = allSelected && selected[t.Code]
+		}
+	}
+	if len(codes) == 0 {
+		return false
+	}
+	for _, code := range codes {
+		selected[code] = !allSelected
+	}
+	return true
+}
+
+// propertyTypePageSize is how many property types the keyboard shows at once.
+const propertyTypePageSize = 8
+
+// propertyTypeSelect is the property type keyboard, in taxonomy order,
+// with a button per group (e.g. "Any house").
+func (b *Bot) propertyTypeSelect() multiSelect {
+	var options []selectOption
+	for _, t := range b.getPropertyTypes() {
+		options = append(options, selectOption{Value: t.Code, Label: t.Label})
+	}
+
+	var groupRow []tgbotapi.InlineKeyboardButton
+	for _, group := range b.propertyTypeGroups() {
+		groupRow = append(groupRow, tgbotapi.NewInlineKeyboardButtonData("Any "+group, callbackData("property_group", group)))
+	}
+	var extra [][]tgbotapi.InlineKeyboardButton
+	if len(groupRow) > 0 {
+		extra = append(extra, groupRow)
+	}
+
+	return multiSelect{
+		Action:    "property_type",
+		Options:   options,
+		Columns:   2,
+		PageSize:  propertyTypePageSize,
+		SelectAll: true,
+		Extra:     extra,
+	}
+}
+
+// createPropertyTypeKeyboard builds the first page of the property type keyboard.
+func (b *Bot) createPropertyTypeKeyboard(selected map[string]bool) tgbotapi.InlineKeyboardMarkup {
+	return b.propertyTypeSelect().keyboard(setSelection(selected), 0)
+}
+
+// formatPropertyTypes returns the labels of the selected property types in taxonomy order.
+// Codes that are not part of the taxonomy are listed as they are.
+func (b *Bot) formatPropertyTypes(selected map[string]bool) string {
+	var labels []string
+	known := make(map[string]bool)
+	for _, t := range b.getPropertyTypes() {
+		known[t.Code] = true
+		if selected[t.Code] {
+			labels = append(labels, t.Label)
+		}
+	}
+
+	var unknown []string
+	for code, isSelected := range selected {
+		if isSelected && !known[code] {
+			unknown = append(unknown, code)
+		}
+	}
+	sort.Strings(unknown)
+
+	return strings.Join(append(labels, unknown...), ", ")
+}
@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+	"testing"
+)
+
+// testPropertyTypes is a small taxonomy used by the property type tests
+var testPropertyTypes = []database.PropertyType{
+	{Code: "flat", Label: "Flat", Group: "flat", SortOrder: 1},
+	{Code: "house", Label: "House", Group: "house", SortOrder: 2},
+	{Code: "bungalow", Label: "Bungalow", Group: "house", SortOrder: 3},
+}
+
+// TestCreatePropertyTypeKeyboard tests that the keyboard follows the taxonomy order
+func TestCreatePropertyTypeKeyboard(t *testing.T) {
+	bot := &Bot{propertyTypes: testPropertyTypes}
+
+	keyboard := bot.createPropertyTypeKeyboard(map[string]bool{"house": true})
+
+	expected := []string{"☐ Flat", "✅ House", "☐ Bungalow", "Select all", "Clear", "Any house", "Done"}
+	var got []string
+	for _, row := range keyboard.InlineKeyboard {
+		for _, button := range row {
+			got = append(got, button.Text)
+		}
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("Expected buttons %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Button %d = %s, want %s", i, got[i], expected[i])
+		}
+	}
+}
+
+// TestTogglePropertyTypeGroup tests selecting and clearing a whole group
+func TestTogglePropertyTypeGroup(t *testing.T) {
+	bot := &Bot{propertyTypes: testPropertyTypes}
+	selected := map[string]bool{"house": true}
+
+	if !bot.togglePropertyTypeGroup(selected, "house") {
+		t.Fatal("togglePropertyTypeGroup() did not recognise the house group")
+	}
+	if !selected["house"] || !selected["bungalow"] || selected["flat"] {
+		t.Errorf("Expected every house type to be selected, got %v", selected)
+	}
+
+	bot.togglePropertyTypeGroup(selected, "house")
+	if selected["house"] || selected["bungalow"] {
+		t.Errorf("Expected the house group to be cleared, got %v", selected)
+	}
+
+	if bot.togglePropertyTypeGroup(selected, "castle") {
+		t.Error("togglePropertyTypeGroup() accepted an unknown group")
+	}
+}
+
+// TestFormatPropertyTypes tests that labels are shown in taxonomy order
+func TestFormatPropertyTypes(t *testing.T) {
+	bot := &Bot{propertyTypes: testPropertyTypes}
+
+	got := bot.formatPropertyTypes(map[string]bool{"bungalow": true, "flat": true, "house": false, "Apartment": true})
+	want := "Flat, Bungalow, Apartment"
+	if got != want {
+		t.Errorf("formatPropertyTypes() = %s, want %s", got, want)
+	}
+}
+
+// TestPropertyGroupCallbackPage tests that selecting a group redraws the page with the group's types
+func TestPropertyGroupCallbackPage(t *testing.T) {
+	var types []database.PropertyType
+	for i := 1; i <= propertyTypePageSize; i++ {
+		code := fmt.Sprintf("flat%d", i)
+		types = append(types, database.PropertyType{Code: code, Label: code, Group: "flat", SortOrder: i})
+	}
+	types = append(types,
+		database.PropertyType{Code: "room", Label: "Room", Group: "shared", SortOrder: 20},
+		database.PropertyType{Code: "hmo", Label: "HMO", Group: "shared", SortOrder: 21})
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, propertyTypes: types, state: make(map[int64]*UserState)}
+	bot.state[123] = &UserState{Preferences: NewFlexibleSearchPreferences()}
+
+	bot.handleCallbackQuery(context.Background(), &tgbotapi.CallbackQuery{
+		ID: "1", Data: callbackData("property_group", "shared"), From: &tgbotapi.User{ID: 123},
+		Message: &tgbotapi.Message{MessageID: 456, Chat: &tgbotapi.Chat{ID: 123}},
+	})
+
+	if len(mockAPI.editedMessages) != 1 {
+		t.Fatalf("Expected the keyboard to be redrawn, got %d edits", len(mockAPI.editedMessages))
+	}
+	rows := labels(*mockAPI.editedMessages[0].ReplyMarkup)
+	if rows[0] != "✅ Room ✅ HMO" || rows[1] != "◀ 2 of 2" {
+		t.Errorf("Expected the second page with the shared types selected, got %q", rows)
+	}
+}
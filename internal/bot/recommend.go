@@ -0,0 +1,277 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"imitation_project/internal/database"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Recommendation settings.
+const (
+	recommendationLimit    = 5
+	minRecommendationScore = 0.4
+	profileKeywordCount    = 10
+	priceBandTolerance     = 0.15 // prices within 15% of the liked band still fit
+)
+
+// errNoSavedListings is returned when there is nothing to base recommendations on.
+var errNoSavedListings = errors.New("no saved listings")
+
+// recommendationWeights sets how much each attribute contributes to similarity.
+// Distance only counts when both the property and the liked listings have coordinates.
+var recommendationWeights = struct {
+	Price, Distance, Type, Bedrooms, Furnished, Keywords float64
+}{
+	Price:     3,
+	Distance:  2,
+	Type:      2,
+	Bedrooms:  2,
+	Furnished: 1,
+	Keywords:  2,
+}
+
+// descriptionStopWords are common words that say nothing about a property.
+var descriptionStopWords = map[string]bool{
+	"this": true, "that": true, "with": true, "from": true, "have": true, "will": true, "your": true,
+	"property": true, "available": true, "bath": true, "there": true, "which": true, "into": true,
+	"also": true, "very": true, "would": true, "offers": true, "located": true,
+}
+
+// tasteProfile summarises the listings a user liked, and the ones they didn't.
+type tasteProfile struct {
+	MinPrice, MaxPrice int
+	// Latitude and Longitude are the middle of the liked listings with coordinates, and zero if none have them
+	Latitude, Longitude float64
+	Types               map[string]int
+	Bedrooms            map[int]int
+	Furnished           map[bool]int
+	Keywords            map[string]int
+	DislikedKeywords    map[string]int
+	liked               int
+	excluded            map[int]bool
+}
+
+// buildTasteProfile builds a profile from liked listings. Keywords that also appear
+// in disliked listings count against a property. Liked and disliked listings are
+// never recommended again.
+func buildTasteProfile(liked, disliked []database.Property) tasteProfile {
+	profile := tasteProfile{
+		Types:            make(map[string]int),
+		Bedrooms:         make(map[int]int),
+		Furnished:        make(map[bool]int),
+		Keywords:         make(map[string]int),
+		DislikedKeywords: make(map[string]int),
+		liked:            len(liked),
+		excluded:         make(map[int]bool),
+	}
+
+	var located int
+	for i, p := range liked {
+		if i == 0 || p.PricePerMonth < profile.MinPrice {
+			profile.MinPrice = p.PricePerMonth
+		}
+		if p.PricePerMonth > profile.MaxPrice {
+			profile.MaxPrice = p.PricePerMonth
+		}
+		if p.Latitude != 0 || p.Longitude != 0 {
+			profile.Latitude += p.Latitude
+			profile.Longitude += p.Longitude
+			located++
+		}
+		profile.Types[p.Type]++
+		profile.Bedrooms[p.Bedrooms]++
+		profile.Furnished[p.Furnished]++
+		for word := range descriptionKeywords(p.Description) {
+			profile.Keywords[word]++
+		}
+		profile.excluded[p.ID] = true
+	}
+	if located > 0 {
+		profile.Latitude /= float64(located)
+		profile.Longitude /= float64(located)
+	}
+	profile.Keywords = topKeywords(profile.Keywords, profileKeywordCount)
+
+	for _, p := range disliked {
+		for word := range descriptionKeywords(p.Description) {
+			if profile.Keywords[word] == 0 {
+				profile.DislikedKeywords[word]++
+			}
+		}
+		profile.excluded[p.ID] = true
+	}
+	profile.DislikedKeywords = topKeywords(profile.DislikedKeywords, profileKeywordCount)
+
+	return profile
+}
+
+// similarity rates how closely a property matches the profile, from 0 to 1.
+func (t tasteProfile) similarity(p database.Property) float64 {
+	if t.liked == 0 {
+		return 0
+	}
+	w := recommendationWeights
+	share := func(count int) float64 { return float64(count) / float64(t.liked) }
+
+	score := w.Price * t.priceFit(p.PricePerMonth)
+	total := w.Price + w.Type + w.Bedrooms + w.Furnished + w.Keywords
+	if (t.Latitude != 0 || t.Longitude != 0) && (p.Latitude != 0 || p.Longitude != 0) {
+		score += w.Distance * t.distanceFit(p)
+		total += w.Distance
+	}
+	score += w.Type * share(t.Types[p.Type])
+	score += w.Bedrooms * share(t.Bedrooms[p.Bedrooms])
+	score += w.Furnished * share(t.Furnished[p.Furnished])
+
+	if len(t.Keywords) > 0 {
+		var matched, disliked int
+		for word := range descriptionKeywords(p.Description) {
+			if t.Keywords[word] > 0 {
+				matched++
+			}
+			if t.DislikedKeywords[word] > 0 {
+				disliked++
+			}
+		}
+		keywordFit := float64(matched-disliked) / float64(len(t.Keywords))
+		score += w.Keywords * math.Max(0, math.Min(1, keywordFit))
+	}
+
+	return score / total
+}
+
+// distanceFit gives full marks within nearbyKm of the middle of the liked listings,
+// falling to nothing at farKm, as a search does for a landmark.
+func (t tasteProfile) distanceFit(p database.Property) float64 {
+	km := distanceKm(p.Latitude, p.Longitude, t.Latitude, t.Longitude)
+	return math.Max(0, math.Min(1, (farKm-km)/(farKm-nearbyKm)))
+}
+
+// priceFit gives full marks within the liked price band, falling to nothing
+// at priceBandTolerance beyond it.
+func (t tasteProfile) priceFit(price int) float64 {
+	low := float64(t.MinPrice) * (1 - priceBandTolerance)
+	high := float64(t.MaxPrice) * (1 + priceBandTolerance)
+	switch {
+	case price >= t.MinPrice && price <= t.MaxPrice:
+		return 1
+	case float64(price) < low || float64(price) > high:
+		return 0
+	case price < t.MinPrice:
+		return (float64(price) - low) / (float64(t.MinPrice) - low)
+	default:
+		return (high - float64(price)) / (high - float64(t.MaxPrice))
+	}
+}
+
+// recommend ranks the candidates against the profile and returns the best matches.
+func (t tasteProfile) recommend(candidates []database.Property, limit int) []database.Property {
+	type scored struct {
+		property database.Property
+		score    float64
+	}
+	var matches []scored
+	for _, p := range candidates {
+		if t.excluded[p.ID] {
+			continue
+		}
+		if score := t.similarity(p); score >= minRecommendationScore {
+			matches = append(matches, scored{p, score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+
+	var result []database.Property
+	for i := 0; i < len(matches) && i < limit; i++ {
+		result = append(result, matches[i].property)
+	}
+	return result
+}
+
+// descriptionKeywords returns the distinctive words in a description.
+func descriptionKeywords(description string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(description), func(r rune) bool {
+		return (r < 'a' || r > 'z') && r != '-'
+	}) {
+		word = strings.Trim(word, "-")
+		if len(word) >= 4 && !descriptionStopWords[word] {
+			words[word] = true
+		}
+	}
+	return words
+}
+
+// topKeywords keeps the n most frequent keywords, breaking ties alphabetically.
+func topKeywords(counts map[string]int, n int) map[string]int {
+	words := make([]string, 0, len(counts))
+	for word := range counts {
+		words = append(words, word)
+	}
+	sort.Slice(words, func(i, j int) bool {
+		if counts[words[i]] != counts[words[j]] {
+			return counts[words[i]] > counts[words[j]]
+		}
+		return words[i] < words[j]
+	})
+
+	result := make(map[string]int)
+	for i := 0; i < len(words) && i < n; i++ {
+		result[words[i]] = counts[words[i]]
+	}
+	return result
+}
+
+// availableProperties returns the properties on the market that are available now or soon,
+// leaving out the ones the user has hidden.
+func (b *Bot) availableProperties(ctx context.Context, userID int64) ([]database.Property, error) {
+	return database.GetProperties(ctx, b.db, map[string]interface{}{
+		"move_in":                time.Now(),
+		"move_in_tolerance_days": b.moveInToleranceDays,
+		"listed_only":            true,
+		"exclude_hidden_for":     userID,
+	})
+}
+
+// recommendForUser suggests properties similar to the ones the user saved.
+// Listings the user hid count against similar properties.
+func (b *Bot) recommendForUser(ctx context.Context, userID int64) ([]database.Property, error) {
+	saved, err := database.GetSavedListings(ctx, b.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting saved listings: %w", err)
+	}
+	if len(saved) == 0 {
+		return nil, errNoSavedListings
+	}
+
+	hidden, err := database.GetHiddenListings(ctx, b.db, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting hidden listings: %w", err)
+	}
+
+	candidates, err := b.availableProperties(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting available properties: %w", err)
+	}
+	return buildTasteProfile(saved, hidden).recommend(candidates, recommendationLimit), nil
+}
+
+// recommendSimilar suggests properties similar to a single property.
+func (b *Bot) recommendSimilar(ctx context.Context, userID int64, propertyID int) ([]database.Property, error) {
+	property, err := database.GetProperty(ctx, b.db, propertyID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting property %d: %w", propertyID, err)
+	}
+
+	candidates, err := b.availableProperties(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting available properties: %w", err)
+	}
+	return buildTasteProfile([]database.Property{property}, nil).recommend(candidates, recommendationLimit), nil
+}
@@ -0,0 +1,98 @@
+package bot
+
+import (
+	"imitation_project/internal/database"
+	"reflect"
+	"testing"
+)
+
+// TestTasteProfileRecommend tests that properties like the liked ones are recommended first
+// and that liked and disliked properties are never recommended
+func TestTasteProfileRecommend(t *testing.T) {
+	liked := []database.Property{
+		{ID: 1, Type: "flat", PricePerMonth: 1200, Bedrooms: 2, Furnished: true, Location: "Widcombe", Description: "Bright flat with balcony and parking"},
+		{ID: 2, Type: "flat", PricePerMonth: 1300, Bedrooms: 2, Furnished: true, Location: "Widcombe", Description: "Modern flat, balcony overlooking the river"},
+	}
+	disliked := []database.Property{
+		{ID: 3, Type: "house", PricePerMonth: 2500, Bedrooms: 4, Location: "Combe Down", Description: "Basement house needing renovation"},
+	}
+	candidates := []database.Property{
+		{ID: 1, Type: "flat", PricePerMonth: 1200, Bedrooms: 2, Furnished: true, Location: "Widcombe"},
+		{ID: 3, Type: "house", PricePerMonth: 2500, Bedrooms: 4, Location: "Combe Down"},
+		{ID: 4, Type: "house", PricePerMonth: 2400, Bedrooms: 4, Location: "Combe Down", Description: "Large basement"},
+		{ID: 5, Type: "flat", PricePerMonth: 1250, Bedrooms: 2, Furnished: true, Location: "Widcombe", Description: "Flat with balcony"},
+		{ID: 6, Type: "flat", PricePerMonth: 1350, Bedrooms: 1, Furnished: true, Location: "Widcombe", Description: "Compact flat"},
+	}
+
+	profile := buildTasteProfile(liked, disliked)
+	recommended := profile.recommend(candidates, recommendationLimit)
+
+	var ids []int
+	for _, p := range recommended {
+		ids = append(ids, p.ID)
+	}
+	if !reflect.DeepEqual(ids, []int{5, 6}) {
+		t.Errorf("recommend() = %v, want [5 6]", ids)
+	}
+}
+
+// TestTasteProfileDistance tests that homes near the liked listings rank above otherwise identical
+// homes further away, and that homes without coordinates are scored without distance
+func TestTasteProfileDistance(t *testing.T) {
+	liked := []database.Property{
+		{ID: 1, Type: "flat", PricePerMonth: 1200, Bedrooms: 2, Latitude: 51.3755, Longitude: -2.3530},
+		{ID: 2, Type: "flat", PricePerMonth: 1200, Bedrooms: 2, Latitude: 51.3765, Longitude: -2.3550},
+	}
+	near := database.Property{ID: 3, Type: "flat", PricePerMonth: 1200, Bedrooms: 2, Latitude: 51.3770, Longitude: -2.3520}
+	far := database.Property{ID: 4, Type: "flat", PricePerMonth: 1200, Bedrooms: 2, Latitude: 51.3400, Longitude: -2.2500}
+	unknown := database.Property{ID: 5, Type: "flat", PricePerMonth: 1200, Bedrooms: 2}
+
+	profile := buildTasteProfile(liked, nil)
+	if profile.Latitude < 51.3755 || profile.Latitude > 51.3765 {
+		t.Errorf("Expected the profile to be centred between the liked listings, got %.4f, %.4f", profile.Latitude, profile.Longitude)
+	}
+	if near, far := profile.similarity(near), profile.similarity(far); near <= far {
+		t.Errorf("Expected the nearby home to score higher, got %.2f and %.2f", near, far)
+	}
+	if got := profile.similarity(unknown); got <= profile.similarity(far) || got >= profile.similarity(near) {
+		t.Errorf("Expected a home without coordinates to score between the nearby and distant homes, got %.2f", got)
+	}
+
+	recommended := profile.recommend([]database.Property{far, near}, 1)
+	if len(recommended) != 1 || recommended[0].ID != 3 {
+		t.Errorf("recommend() = %+v, want the nearby home", recommended)
+	}
+}
+
+// TestTasteProfilePriceFit tests the price band tolerance
+func TestTasteProfilePriceFit(t *testing.T) {
+	profile := tasteProfile{MinPrice: 1000, MaxPrice: 1200}
+
+	testCases := []struct {
+		price    int
+		expected float64
+	}{
+		{1100, 1},
+		{1200, 1},
+		{1380, 0},
+		{1290, 0.5},
+		{850, 0},
+		{925, 0.5},
+	}
+
+	for _, tc := range testCases {
+		if fit := profile.priceFit(tc.price); fit < tc.expected-0.01 || fit > tc.expected+0.01 {
+			t.Errorf("priceFit(%d) = %.2f, want %.2f", tc.price, fit, tc.expected)
+		}
+	}
+}
+
+// TestDescriptionKeywords tests that short and common words are ignored
+func TestDescriptionKeywords(t *testing.T) {
+	keywords := descriptionKeywords("A bright, south-facing flat with a garden. This property is in Bath!")
+
+	expected := map[string]bool{"bright": true, "south-facing": true, "flat": true, "garden": true}
+	if !reflect.DeepEqual(keywords, expected) {
+		t.Errorf("descriptionKeywords() = %v, want %v", keywords, expected)
+	}
+}
@@ -0,0 +1,264 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// defaultRelaxationStrategy is the order in which filters are relaxed when nothing matches.
+var defaultRelaxationStrategy = []string{"bedrooms", "types", "price", "furnished", "availability"}
+
+// priceWidening are the fractions by which the price range is widened before it is dropped.
+var priceWidening = []float64{0.1, 0.2}
+
+// extraMoveInToleranceDays is how much later a property may become available
+// before the move-in date filter is dropped altogether.
+const extraMoveInToleranceDays = 30
+
+// relaxation describes how one filter was relaxed to find results.
+type relaxation struct {
+	Filter      string
+	Description string
+}
+
+// relaxationStage is one gradual change to a filter, such as widening the price by 10%.
+// Later stages for the same filter supersede earlier ones.
+type relaxationStage struct {
+	filter      string
+	apply       func(filters map[string]interface{})
+	description string
+}
+
+// relaxationSteps maps each relaxation step name to a function that builds
+// its stages from the original filters. Steps without a matching filter have no stages.
+var relaxationSteps = map[string]func(b *Bot, original map[string]interface{}) []relaxationStage{
+	"bedrooms":     (*Bot).bedroomRelaxationStages,
+	"types":        (*Bot).typeRelaxationStages,
+	"price":        (*Bot).priceRelaxationStages,
+	"furnished":    (*Bot).furnishedRelaxationStages,
+	"availability": (*Bot).availabilityRelaxationStages,
+}
+
+// SetRelaxationStrategy sets the order in which filters are relaxed when a search has no results.
+// Valid steps are "bedrooms", "types", "price", "furnished" and "availability".
+// A single "none" step disables relaxation.
+func (b *Bot) SetRelaxationStrategy(steps []string) error {
+	strategy := []string{}
+	for _, step := range steps {
+		step = strings.ToLower(strings.TrimSpace(step))
+		if step == "none" && len(steps) == 1 {
+			break
+		}
+		if _, ok := relaxationSteps[step]; !ok {
+			return fmt.Errorf("unknown relaxation step %q", step)
+		}
+		strategy = append(strategy, step)
+	}
+	b.relaxationStrategy = strategy
+	return nil
+}
+
+// getRelaxationStrategy returns the configured relaxation strategy, or the default one.
+func (b *Bot) getRelaxationStrategy() []string {
+	if b.relaxationStrategy == nil {
+		return defaultRelaxationStrategy
+	}
+	return b.relaxationStrategy
+}
+
+// copyFilters returns a shallow copy of the filters.
+func copyFilters(filters map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(filters))
+	for key, value := range filters {
+		result[key] = value
+	}
+	return result
+}
+
+// bedroomRelaxationStages widens the bedroom range by one on each side, then drops it.
+func (b *Bot) bedroomRelaxationStages(original map[string]interface{}) []relaxationStage {
+	minBedrooms, hasMin := original["min_bedrooms"].(int)
+	maxBedrooms, hasMax := original["max_bedrooms"].(int)
+	if !hasMin && !hasMax {
+		return nil
+	}
+	originalText := describeBedrooms(optionalInt(minBedrooms, hasMin), optionalInt(maxBedrooms, hasMax))
+
+	var stages []relaxationStage
+	if (hasMin && minBedrooms > 0) || hasMax {
+		widenedMin, widenedMax := minBedrooms-1, maxBedrooms+1
+		if widenedMin < 0 {
+			widenedMin = 0
+		}
+		stages = append(stages, relaxationStage{
+			filter: "bedrooms",
+			apply: func(filters map[string]interface{}) {
+				if hasMin {
+					filters["min_bedrooms"] = widenedMin
+				}
+				if hasMax {
+					filters["max_bedrooms"] = widenedMax
+				}
+			},
+			description: fmt.Sprintf("%s instead of %s", describeBedrooms(optionalInt(widenedMin, hasMin), optionalInt(widenedMax, hasMax)), originalText),
+		})
+	}
+
+	return append(stages, relaxationStage{
+		filter: "bedrooms",
+		apply: func(filters map[string]interface{}) {
+			delete(filters, "min_bedrooms")
+			delete(filters, "max_bedrooms")
+		},
+		description: "any number of bedrooms instead of " + originalText,
+	})
+}
+
+// typeRelaxationStages drops the property type filter.
+func (b *Bot) typeRelaxationStages(original map[string]interface{}) []relaxationStage {
+	types, ok := original["types"].([]string)
+	if !ok || len(types) == 0 {
+		return nil
+	}
+	selected := make(map[string]bool)
+	for _, t := range types {
+		selected[t] = true
+	}
+
+	return []relaxationStage{{
+		filter: "types",
+		apply: func(filters map[string]interface{}) {
+			delete(filters, "types")
+		},
+		description: "any property type instead of " + b.formatPropertyTypes(selected),
+	}}
+}
+
+// priceRelaxationStages widens the price range by each of priceWidening, then drops it.
+func (b *Bot) priceRelaxationStages(original map[string]interface{}) []relaxationStage {
+	minPrice, hasMin := original["min_price"].(int)
+	maxPrice, hasMax := original["max_price"].(int)
+	if !hasMin && !hasMax {
+		return nil
+	}
+	originalText := priceRange{Min: minPrice, Max: maxPrice}.Label()
+
+	var stages []relaxationStage
+	for _, fraction := range priceWidening {
+		widened := priceRange{}
+		if hasMin {
+			widened.Min = int(math.Round(float64(minPrice) * (1 - fraction)))
+		}
+		if hasMax {
+			widened.Max = int(math.Round(float64(maxPrice) * (1 + fraction)))
+		}
+		stages = append(stages, relaxationStage{
+			filter: "price",
+			apply: func(filters map[string]interface{}) {
+				if hasMin {
+					filters["min_price"] = widened.Min
+				}
+				if hasMax {
+					filters["max_price"] = widened.Max
+				}
+			},
+			description: fmt.Sprintf("%s instead of %s", widened.Label(), originalText),
+		})
+	}
+
+	return append(stages, relaxationStage{
+		filter: "price",
+		apply: func(filters map[string]interface{}) {
+			delete(filters, "min_price")
+			delete(filters, "max_price")
+		},
+		description: "any price instead of " + originalText,
+	})
+}
+
+// furnishedRelaxationStages drops the furnished filter.
+func (b *Bot) furnishedRelaxationStages(original map[string]interface{}) []relaxationStage {
+	furnished, ok := original["furnished"].(bool)
+	if !ok {
+		return nil
+	}
+
+	return []relaxationStage{{
+		filter: "furnished",
+		apply: func(filters map[string]interface{}) {
+			delete(filters, "furnished")
+		},
+		description: fmt.Sprintf("furnished or unfurnished instead of %s only", strings.ToLower(propertyFurnished(furnished))),
+	}}
+}
+
+// availabilityRelaxationStages allows properties available later than the move-in date,
+// then drops the move-in date and the tenancy length.
+func (b *Bot) availabilityRelaxationStages(original map[string]interface{}) []relaxationStage {
+	var stages []relaxationStage
+
+	if moveIn, ok := original["move_in"].(time.Time); ok && !moveIn.IsZero() {
+		tolerance, _ := original["move_in_tolerance_days"].(int)
+		availableBy := moveIn.AddDate(0, 0, tolerance)
+		extended := tolerance + extraMoveInToleranceDays
+
+		stages = append(stages,
+			relaxationStage{
+				filter: "move_in",
+				apply: func(filters map[string]interface{}) {
+					filters["move_in_tolerance_days"] = extended
+				},
+				description: fmt.Sprintf("available by %s instead of %s", moveIn.AddDate(0, 0, extended).Format("2 Jan"), availableBy.Format("2 Jan")),
+			},
+			relaxationStage{
+				filter: "move_in",
+				apply: func(filters map[string]interface{}) {
+					delete(filters, "move_in")
+					delete(filters, "move_in_tolerance_days")
+				},
+				description: "any availability date instead of " + availableBy.Format("2 Jan"),
+			},
+		)
+	}
+
+	if months, ok := original["tenancy_months"].(int); ok && months > 0 {
+		stages = append(stages, relaxationStage{
+			filter: "tenancy",
+			apply: func(filters map[string]interface{}) {
+				delete(filters, "tenancy_months")
+			},
+			description: "any tenancy length instead of " + formatTenancyMonths(months),
+		})
+	}
+
+	return stages
+}
+
+// describeBedrooms describes a bedroom range for relaxation messages, e.g. "2-3 bed" or "studio".
+func describeBedrooms(min, max *int) string {
+	text := formatBedroomRange(min, max)
+	if text == "Studio" {
+		return "studio"
+	}
+	return text + " bed"
+}
+
+// optionalInt returns a pointer to v if present, or nil.
+func optionalInt(v int, present bool) *int {
+	if !present {
+		return nil
+	}
+	return intPtr(v)
+}
+
+// formatRelaxations tells the user which filters were relaxed to find results.
+func formatRelaxations(relaxations []relaxation) string {
+	descriptions := make([]string, len(relaxations))
+	for i, r := range relaxations {
+		descriptions[i] = r.Description
+	}
+	return "No exact matches; showing " + strings.Join(descriptions, ", ") + "."
+}
@@ -0,0 +1,311 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"fmt"
+	"imitation_project/internal/database"
+	"math"
+	"sort"
+	"strings"
+)
+
+// scoreWeights sets how much each criterion contributes to a property's match score.
+type scoreWeights struct {
+	Price     float64
+	Bedrooms  float64
+	Type      float64
+	Furnished float64
+	Distance  float64
+	Keywords  float64
+}
+
+// defaultScoreWeights favours price and size over the softer criteria.
+var defaultScoreWeights = scoreWeights{
+	Price:     3,
+	Bedrooms:  2,
+	Type:      1.5,
+	Furnished: 1,
+	Distance:  1.5,
+	Keywords:  1,
+}
+
+// Scoring tolerances: how far a property can miss a criterion before it scores nothing.
+const (
+	priceToleranceFraction = 0.2 // 20% over budget
+	bedroomPenalty         = 0.5 // per bedroom too many or too few
+	nearbyKm               = 1.0 // full marks within this distance
+	farKm                  = 5.0 // no marks beyond this distance
+)
+
+// landmark is a place users can ask to live near.
+type landmark struct {
+	Name      string
+	Latitude  float64
+	Longitude float64
+}
+
+// landmarks are the places in Bath that distances are measured from, by search keyword.
+var landmarks = map[string]landmark{
+	"centre":     {Name: "the city centre", Latitude: 51.3811, Longitude: -2.3590},
+	"university": {Name: "the University of Bath", Latitude: 51.3782, Longitude: -2.3264},
+	"spa":        {Name: "Bath Spa University", Latitude: 51.3733, Longitude: -2.4399},
+	"station":    {Name: "Bath Spa station", Latitude: 51.3777, Longitude: -2.3571},
+}
+
+// landmarkAliases maps other words users type to a landmark key.
+var landmarkAliases = map[string]string{
+	"center":    "centre",
+	"town":      "centre",
+	"city":      "centre",
+	"uni":       "university",
+	"campus":    "university",
+	"claverton": "university",
+	"bathspa":   "spa",
+	"newton":    "spa",
+	"train":     "station",
+}
+
+// defaultLandmark is where distances are measured from if the user didn't name a landmark.
+const defaultLandmark = "centre"
+
+// keywordStopWords are ignored when reading keywords from a search.
+var keywordStopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "the": true, "with": true, "to": true, "in": true, "of": true, "or": true,
+}
+
+// scoreReason explains how a property did against one criterion.
+type scoreReason struct {
+	Matched bool
+	Text    string
+}
+
+// propertyScore is how well a property matches the user's criteria, from 0 to 100.
+type propertyScore struct {
+	Score   int
+	Reasons []scoreReason
+}
+
+// String formats the score as shown on a property card, e.g. "85% match: ✓ within budget, ✗ 1 bedroom fewer".
+func (s propertyScore) String() string {
+	reasons := make([]string, len(s.Reasons))
+	for i, r := range s.Reasons {
+		mark := "✗"
+		if r.Matched {
+			mark = "✓"
+		}
+		reasons[i] = mark + " " + r.Text
+	}
+	if len(reasons) == 0 {
+		return fmt.Sprintf("%d%% match", s.Score)
+	}
+	return fmt.Sprintf("%d%% match: %s", s.Score, strings.Join(reasons, ", "))
+}
+
+// parseSearchArguments reads keywords and a landmark from the text after /search,
+// e.g. "near uni balcony parking". The landmark is empty if none was named.
+func parseSearchArguments(args string) (keywords []string, near string) {
+	words := strings.Fields(strings.ToLower(args))
+	for i := 0; i < len(words); i++ {
+		word := strings.Trim(words[i], ",.;!?")
+		if word == "near" {
+			// Allow "near the station" as well as "near station"
+			next := i + 1
+			for next < len(words) && keywordStopWords[words[next]] {
+				next++
+			}
+			if next < len(words) {
+				if key, ok := lookupLandmark(strings.Trim(words[next], ",.;!?")); ok {
+					near = key
+					i = next
+					continue
+				}
+			}
+		}
+		if word == "" || word == "near" || keywordStopWords[word] {
+			continue
+		}
+		keywords = append(keywords, word)
+	}
+	return keywords, near
+}
+
+// lookupLandmark finds a landmark key by name or alias.
+func lookupLandmark(word string) (string, bool) {
+	if _, ok := landmarks[word]; ok {
+		return word, true
+	}
+	key, ok := landmarkAliases[word]
+	return key, ok
+}
+
+// rankProperties scores every property against the preferences and sorts them best first.
+// The scores are keyed by property ID.
+func (b *Bot) rankProperties(properties []database.Property, preferences *SearchPreferences) map[int]propertyScore {
+	scores := make(map[int]propertyScore, len(properties))
+	for _, p := range properties {
+		scores[p.ID] = b.scoreProperty(p, preferences)
+	}
+	sort.SliceStable(properties, func(i, j int) bool {
+		return scores[properties[i].ID].Score > scores[properties[j].ID].Score
+	})
+	return scores
+}
+
+// scoreProperty rates a property against each criterion the user set.
+// Criteria the user didn't set, or that the property has no data for, don't count towards the score.
+func (b *Bot) scoreProperty(p database.Property, preferences *SearchPreferences) propertyScore {
+	weights := defaultScoreWeights
+	var total, achieved float64
+	var reasons []scoreReason
+
+	add := func(weight, fit float64, reason scoreReason) {
+		total += weight
+		achieved += weight * fit
+		reasons = append(reasons, reason)
+	}
+
+	if price, err := parsePrice(preferences.PriceRange); err == nil {
+		fit, reason := scorePrice(p.PricePerMonth, price)
+		add(weights.Price, fit, reason)
+	}
+
+	if p.ListingKind != database.ListingRoom && (preferences.MinBedrooms != nil || preferences.MaxBedrooms != nil) {
+		fit, reason := scoreBedrooms(p.Bedrooms, preferences.MinBedrooms, preferences.MaxBedrooms)
+		add(weights.Bedrooms, fit, reason)
+	}
+
+	if types := getSelectedOptions(preferences.PropertyTypes); len(types) > 0 {
+		fit, reason := b.scoreType(p.Type, preferences.PropertyTypes)
+		add(weights.Type, fit, reason)
+	}
+
+	if furnished := getSelectedOptions(preferences.FurnishedOptions); len(furnished) == 1 {
+		wantFurnished := furnished[0] == "Furnished"
+		reason := scoreReason{Matched: p.Furnished == wantFurnished, Text: strings.ToLower(propertyFurnished(p.Furnished))}
+		add(weights.Furnished, boolFit(reason.Matched), reason)
+	}
+
+	if p.Latitude != 0 || p.Longitude != 0 {
+		fit, reason := scoreDistance(p, preferences.Near)
+		add(weights.Distance, fit, reason)
+	}
+
+	if len(preferences.Keywords) > 0 {
+		fit, keywordReasons := scoreKeywords(p.Description, preferences.Keywords)
+		total += weights.Keywords
+		achieved += weights.Keywords * fit
+		reasons = append(reasons, keywordReasons...)
+	}
+
+	score := 100
+	if total > 0 {
+		score = int(math.Round(100 * achieved / total))
+	}
+	return propertyScore{Score: score, Reasons: reasons}
+}
+
+// scorePrice gives full marks within budget, falling to nothing at priceToleranceFraction over it.
+func scorePrice(price int, budget priceRange) (float64, scoreReason) {
+	if budget.Max > 0 && price > budget.Max {
+		over := price - budget.Max
+		fit := math.Max(0, 1-float64(over)/(float64(budget.Max)*priceToleranceFraction))
+		return fit, scoreReason{Matched: false, Text: fmt.Sprintf("£%d over budget", over)}
+	}
+	if budget.Min > 0 && price < budget.Min {
+		return 1, scoreReason{Matched: true, Text: "below budget"}
+	}
+	return 1, scoreReason{Matched: true, Text: "within budget"}
+}
+
+// scoreBedrooms gives full marks within the range, losing bedroomPenalty per bedroom outside it.
+func scoreBedrooms(bedrooms int, minBedrooms, maxBedrooms *int) (float64, scoreReason) {
+	switch {
+	case minBedrooms != nil && bedrooms < *minBedrooms:
+		diff := *minBedrooms - bedrooms
+		return math.Max(0, 1-bedroomPenalty*float64(diff)), scoreReason{Matched: false, Text: pluralise(diff, "bedroom") + " fewer"}
+	case maxBedrooms != nil && bedrooms > *maxBedrooms:
+		diff := bedrooms - *maxBedrooms
+		return math.Max(0, 1-bedroomPenalty*float64(diff)), scoreReason{Matched: false, Text: pluralise(diff, "bedroom") + " more"}
+	case bedrooms == 0:
+		return 1, scoreReason{Matched: true, Text: "studio"}
+	default:
+		return 1, scoreReason{Matched: true, Text: pluralise(bedrooms, "bedroom")}
+	}
+}
+
+// scoreType gives full marks for a selected type and half marks for a type in the same group.
+func (b *Bot) scoreType(code string, selected map[string]bool) (float64, scoreReason) {
+	label := strings.ToLower(b.propertyTypeLabel(code))
+	if selected[code] {
+		return 1, scoreReason{Matched: true, Text: label}
+	}
+	if t, ok := b.findPropertyType(code); ok {
+		for _, other := range b.getPropertyTypes() {
+			if other.Group == t.Group && selected[other.Code] {
+				return 0.5, scoreReason{Matched: false, Text: label + " rather than " + strings.ToLower(other.Label)}
+			}
+		}
+	}
+	return 0, scoreReason{Matched: false, Text: label}
+}
+
+// scoreDistance gives full marks within nearbyKm of the landmark, falling to nothing at farKm.
+func scoreDistance(p database.Property, near string) (float64, scoreReason) {
+	place, ok := landmarks[near]
+	if !ok {
+		place = landmarks[defaultLandmark]
+	}
+	km := distanceKm(p.Latitude, p.Longitude, place.Latitude, place.Longitude)
+	fit := math.Max(0, math.Min(1, (farKm-km)/(farKm-nearbyKm)))
+	return fit, scoreReason{Matched: fit >= 0.5, Text: fmt.Sprintf("%.1f km from %s", km, place.Name)}
+}
+
+// scoreKeywords scores the share of keywords mentioned in the description.
+func scoreKeywords(description string, keywords []string) (float64, []scoreReason) {
+	text := strings.ToLower(description)
+	var found, missing []string
+	for _, keyword := range keywords {
+		if strings.Contains(text, keyword) {
+			found = append(found, keyword)
+		} else {
+			missing = append(missing, keyword)
+		}
+	}
+
+	var reasons []scoreReason
+	if len(found) > 0 {
+		reasons = append(reasons, scoreReason{Matched: true, Text: strings.Join(found, ", ")})
+	}
+	if len(missing) > 0 {
+		reasons = append(reasons, scoreReason{Matched: false, Text: "no mention of " + strings.Join(missing, ", ")})
+	}
+	return float64(len(found)) / float64(len(keywords)), reasons
+}
+
+// distanceKm returns the great-circle distance between two points in kilometres.
+func distanceKm(lat1, lng1, lat2, lng2 float64) float64 {
+	const earthRadiusKm = 6371
+	toRadians := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadiusKm * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// boolFit converts a match into full or no marks.
+func boolFit(matched bool) float64 {
+	if matched {
+		return 1
+	}
+	return 0
+}
+
+// pluralise formats a count with a noun, e.g. "1 bedroom" or "2 bedrooms".
+func pluralise(n int, noun string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, noun)
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,130 @@
+package bot
+
+import (
+	"imitation_project/internal/database"
+	"math"
+	"reflect"
+	"testing"
+)
+
+// TestScoreProperty tests the score and explanation for a property that misses one criterion
+func TestScoreProperty(t *testing.T) {
+	bot := &Bot{}
+
+	preferences := &SearchPreferences{
+		PropertyTypes:    map[string]bool{"flat": true},
+		MinBedrooms:      intPtr(2),
+		MaxBedrooms:      intPtr(2),
+		FurnishedOptions: map[string]bool{"Furnished": true},
+		PriceRange:       "up to 1500",
+	}
+	prop := database.Property{ID: 1, Type: "flat", PricePerMonth: 1400, Bedrooms: 1, Furnished: true}
+
+	score := bot.scoreProperty(prop, preferences)
+
+	// Price 3, bedrooms 2 at half marks, type 1.5 and furnished 1: 6.5 of 7.5
+	if score.Score != 87 {
+		t.Errorf("scoreProperty() score = %d, want 87", score.Score)
+	}
+
+	expected := "87% match: ✓ within budget, ✗ 1 bedroom fewer, ✓ flat, ✓ furnished"
+	if score.String() != expected {
+		t.Errorf("propertyScore.String() = %q, want %q", score.String(), expected)
+	}
+}
+
+// TestScorePropertyNoCriteria tests that a property scores full marks when the user set no criteria
+func TestScorePropertyNoCriteria(t *testing.T) {
+	bot := &Bot{}
+
+	score := bot.scoreProperty(database.Property{ID: 1}, NewFlexibleSearchPreferences())
+	if score.Score != 100 || len(score.Reasons) != 0 {
+		t.Errorf("scoreProperty() = %+v, want 100 with no reasons", score)
+	}
+}
+
+// TestScorePropertyDistanceAndKeywords tests the distance and keyword criteria
+func TestScorePropertyDistanceAndKeywords(t *testing.T) {
+	bot := &Bot{}
+
+	preferences := &SearchPreferences{
+		Keywords: []string{"balcony", "parking"},
+		Near:     "university",
+	}
+	campus := landmarks["university"]
+	prop := database.Property{
+		ID:          1,
+		Description: "Bright flat with a balcony",
+		Latitude:    campus.Latitude,
+		Longitude:   campus.Longitude,
+	}
+
+	score := bot.scoreProperty(prop, preferences)
+
+	// Distance 1.5 at full marks and keywords 1 at half marks: 2 of 2.5
+	if score.Score != 80 {
+		t.Errorf("scoreProperty() score = %d, want 80", score.Score)
+	}
+
+	expected := "80% match: ✓ 0.0 km from the University of Bath, ✓ balcony, ✗ no mention of parking"
+	if score.String() != expected {
+		t.Errorf("propertyScore.String() = %q, want %q", score.String(), expected)
+	}
+}
+
+// TestRankProperties tests that properties are sorted by score, best first
+func TestRankProperties(t *testing.T) {
+	bot := &Bot{}
+
+	preferences := &SearchPreferences{PriceRange: "up to 1000"}
+	properties := []database.Property{
+		{ID: 1, PricePerMonth: 1150},
+		{ID: 2, PricePerMonth: 900},
+		{ID: 3, PricePerMonth: 1050},
+	}
+
+	scores := bot.rankProperties(properties, preferences)
+
+	var order []int
+	for _, p := range properties {
+		order = append(order, p.ID)
+	}
+	if !reflect.DeepEqual(order, []int{2, 3, 1}) {
+		t.Errorf("rankProperties() order = %v, want [2 3 1]", order)
+	}
+	if scores[2].Score != 100 || scores[3].Score != 75 || scores[1].Score != 25 {
+		t.Errorf("Unexpected scores: %+v", scores)
+	}
+}
+
+// TestParseSearchArguments tests reading keywords and a landmark from /search arguments
+func TestParseSearchArguments(t *testing.T) {
+	testCases := []struct {
+		args     string
+		keywords []string
+		near     string
+	}{
+		{"", nil, ""},
+		{"near uni balcony and parking", []string{"balcony", "parking"}, "university"},
+		{"Garden, near the station", []string{"garden"}, "station"},
+		{"near my parents", []string{"my", "parents"}, ""},
+		{"near centre", nil, "centre"},
+	}
+
+	for _, tc := range testCases {
+		keywords, near := parseSearchArguments(tc.args)
+		if !reflect.DeepEqual(keywords, tc.keywords) || near != tc.near {
+			t.Errorf("parseSearchArguments(%q) = %v, %q; want %v, %q", tc.args, keywords, near, tc.keywords, tc.near)
+		}
+	}
+}
+
+// TestDistanceKm tests the distance between the city centre and the University of Bath
+func TestDistanceKm(t *testing.T) {
+	centre, campus := landmarks["centre"], landmarks["university"]
+
+	km := distanceKm(centre.Latitude, centre.Longitude, campus.Latitude, campus.Longitude)
+	if math.Abs(km-2.3) > 0.1 {
+		t.Errorf("distanceKm() = %.2f, want about 2.3", km)
+	}
+}
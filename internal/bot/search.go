@@ -2,45 +2,109 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"imitation_project/internal/database"
 	"log"
-	"strconv"
-	"strings"
+	"time"
 )
 
-// searchProperties performs a property search based on the given preferences.
-// It builds filters, executes the search, and applies filter relaxation if no results are found.
-func (b *Bot) searchProperties(preferences *SearchPreferences) ([]database.Property, error) {
+// searchResults are the properties found by a search, best match first.
+type searchResults struct {
+	Properties []database.Property
+	// Relaxations lists the filters that were relaxed to find the properties, if any
+	Relaxations []relaxation
+	// Scores are keyed by property ID
+	Scores map[int]propertyScore
+	// Seen holds the IDs of the properties the user was shown before this search.
+	// It is nil if they couldn't be looked up.
+	Seen map[int]bool
+}
+
+// searchProperties performs a property search for a user based on the given preferences
+// and ranks the results by how well they match. Listings the user has hidden are left out.
+// It records the search as the user's last search, so the next search can tell which results are new.
+func (b *Bot) searchProperties(ctx context.Context, userID int64, preferences *SearchPreferences) (searchResults, error) {
+	properties, relaxations, err := b.findProperties(ctx, userID, preferences)
+	if err != nil {
+		return searchResults{}, err
+	}
+
+	scores := b.rankProperties(properties, preferences)
+	results := searchResults{Properties: properties, Relaxations: relaxations, Scores: scores}
+
+	// Tracking what the user has seen is a nicety, so errors don't fail the search
+	results.Seen, err = database.GetSeenListingIDs(ctx, b.db, userID)
+	if err != nil {
+		log.Printf("Error getting seen listings for user %d: %v", userID, err)
+	}
+	if err := database.UpdateLastSearch(ctx, b.db, userID, time.Now()); err != nil {
+		log.Printf("Error updating last search for user %d: %v", userID, err)
+	}
+
+	return results, nil
+}
+
+// isNew reports whether a property is new to the user since their last search.
+// Nothing is new on a user's first search, as there is nothing to compare with.
+func (r searchResults) isNew(propertyID int) bool {
+	return len(r.Seen) > 0 && !r.Seen[propertyID]
+}
+
+// findProperties runs the search. If nothing matches exactly, it relaxes the filters gradually
+// following the relaxation strategy and returns the relaxations that were needed to find the results.
+func (b *Bot) findProperties(ctx context.Context, userID int64, preferences *SearchPreferences) ([]database.Property, []relaxation, error) {
 	filters := b.buildFilters(preferences)
+	filters["exclude_hidden_for"] = userID
+	if preferences.NewOnly {
+		filters["exclude_seen_by"] = userID
+	}
 	log.Printf("Initial search with filters: %+v", filters)
 
-	properties, err := database.GetProperties(b.db, filters)
+	properties, err := database.GetProperties(ctx, b.db, filters)
 	if err != nil {
-		return nil, fmt.Errorf("error searching properties: %w", err)
+		return nil, nil, fmt.Errorf("error searching properties: %w", err)
+	}
+	if len(properties) > 0 {
+		log.Printf("Found %d properties", len(properties))
+		return properties, nil, nil
 	}
 
-	if len(properties) == 0 {
-		log.Println("No properties found, relaxing filters")
-		relaxationSteps := []string{"bedrooms", "types", "price", "furnished"}
+	log.Println("No properties found, relaxing filters")
+	original := copyFilters(filters)
+	var relaxations []relaxation
 
-		for _, step := range relaxationSteps {
-			delete(filters, step)
-			log.Printf("Relaxing %s filter. New filters: %+v", step, filters)
+	for _, step := range b.getRelaxationStrategy() {
+		for _, stage := range relaxationSteps[step](b, original) {
+			stage.apply(filters)
+			relaxations = recordRelaxation(relaxations, relaxation{Filter: stage.filter, Description: stage.description})
+			log.Printf("Relaxing %s filter. New filters: %+v", stage.filter, filters)
 
-			properties, err = database.GetProperties(b.db, filters)
+			properties, err = database.GetProperties(ctx, b.db, filters)
 			if err != nil {
-				return nil, fmt.Errorf("error searching properties with relaxed filters: %w", err)
+				return nil, nil, fmt.Errorf("error searching properties with relaxed filters: %w", err)
 			}
 
 			if len(properties) > 0 {
-				break
+				log.Printf("Found %d properties with relaxed filters", len(properties))
+				return properties, relaxations, nil
 			}
 		}
 	}
 
-	log.Printf("Found %d properties", len(properties))
-	return properties, nil
+	log.Println("Found no properties")
+	return nil, nil, nil
+}
+
+// recordRelaxation adds a relaxation, replacing any earlier relaxation of the same filter.
+func recordRelaxation(relaxations []relaxation, r relaxation) []relaxation {
+	for i := range relaxations {
+		if relaxations[i].Filter == r.Filter {
+			relaxations[i] = r
+			return relaxations
+		}
+	}
+	return append(relaxations, r)
 }
 
 // buildFilters constructs a map of filters based on the given search preferences.
@@ -51,17 +115,26 @@ func (b *Bot) buildFilters(preferences *SearchPreferences) map[string]interface{
 		filters["types"] = types
 	}
 
-	if bedrooms := getSelectedBedroomOptions(preferences.BedroomOptions); len(bedrooms) > 0 {
-		filters["bedrooms"] = bedrooms
+	if preferences.ListingKind != "" {
+		filters["listing_kind"] = preferences.ListingKind
+	}
+
+	// Bedroom counts describe whole properties, so they don't apply to room searches
+	if preferences.ListingKind != database.ListingRoom {
+		if preferences.MinBedrooms != nil {
+			filters["min_bedrooms"] = *preferences.MinBedrooms
+		}
+		if preferences.MaxBedrooms != nil {
+			filters["max_bedrooms"] = *preferences.MaxBedrooms
+		}
 	}
 
-	if preferences.PriceRange != "" {
-		min, max := parsePriceRange(preferences.PriceRange)
-		if min > 0 {
-			filters["min_price"] = min
+	if price, err := parsePrice(preferences.PriceRange); err == nil {
+		if price.Min > 0 {
+			filters["min_price"] = price.Min
 		}
-		if max > 0 {
-			filters["max_price"] = max
+		if price.Max > 0 {
+			filters["max_price"] = price.Max
 		}
 	}
 
@@ -71,7 +144,17 @@ func (b *Bot) buildFilters(preferences *SearchPreferences) map[string]interface{
 		}
 	}
 
+	if !preferences.MoveInDate.IsZero() {
+		filters["move_in"] = preferences.MoveInDate
+		filters["move_in_tolerance_days"] = b.moveInToleranceDays
+	}
+
+	if preferences.TenancyMonths > 0 {
+		filters["tenancy_months"] = preferences.TenancyMonths
+	}
+
 	filters["location"] = "Bath"
+	filters["listed_only"] = true
 
 	return filters
 }
@@ -86,49 +169,3 @@ func getSelectedOptions(options map[string]bool) []string {
 	}
 	return selected
 }
-
-// getSelectedBedroomOptions converts selected bedroom options to a slice of integers.
-// It handles the "Studio" option as 0 and parses other options as integers.
-func getSelectedBedroomOptions(options map[string]bool) []int {
-	var selected []int
-	for option, isSelected := range options {
-		if isSelected {
-			switch option {
-			case "Studio":
-				selected = append(selected, 0)
-			case "3+":
-				selected = append(selected, 3)
-			default:
-				if num, err := strconv.Atoi(option); err == nil {
-					selected = append(selected, num)
-				}
-			}
-		}
-	}
-	return selected
-}
-
-// parsePriceRange converts a price range string (e.g., "500-1000") to minimum and maximum integer values.
-// If parsing fails, it returns default values of 0 for min and 1000000 for max.
-func parsePriceRange(priceRange string) (int, int) {
-	parts := strings.Split(priceRange, "-")
-	if len(parts) != 2 {
-		return 0, 1000000
-	}
-
-	// Trim spaces and parse to integers
-	min, err := strconv.Atoi(strings.TrimSpace(parts[0]))
-	if err != nil {
-		min = 0
-	}
-	max, err := strconv.Atoi(strings.TrimSpace(parts[1]))
-	if err != nil {
-		max = 1000000
-	}
-
-	if min > max {
-		min, max = max, min
-	}
-
-	return min, max
-}
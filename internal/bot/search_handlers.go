@@ -2,47 +2,51 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"imitation_project/internal/database"
 	"log"
+	"slices"
 	"strings"
 	"time"
 )
 
 // handleSearchCommand processes the /search command.
-func (b *Bot) handleSearchCommand(message *tgbotapi.Message) {
-	prefs, err := database.GetUserPreferences(b.db, message.From.ID)
+// Any text after the command, such as "near uni balcony", is used to rank the results.
+func (b *Bot) handleSearchCommand(ctx context.Context, message *tgbotapi.Message) {
+	state := b.getUserState(message.Chat.ID)
+	if state.Preferences == nil {
+		state.Preferences = NewFlexibleSearchPreferences()
+	}
+	state.Preferences.Keywords, state.Preferences.Near = parseSearchArguments(message.CommandArguments())
+	state.Preferences.NewOnly = false
+	b.updateUserState(message.Chat.ID, state)
+
+	prefs, err := database.GetUserPreferences(ctx, b.db, message.From.ID)
 	if err == nil && !prefs.LastSearch.IsZero() {
-		// Format PropertyTypes
-		var propertyTypes []string
-		for pType, selected := range prefs.PropertyTypes {
-			if selected {
-				propertyTypes = append(propertyTypes, pType)
-			}
-		}
 
-		// Format BedroomOptions
-		var bedrooms []string
-		for bedroom, selected := range prefs.BedroomOptions {
-			if selected {
-				bedrooms = append(bedrooms, bedroom)
-			}
-		}
 		// User has saved preferences
 		prefsMsg := fmt.Sprintf("You have saved preferences:\n"+
+			"Looking for: %s\n"+
 			"Property Type: %s\n"+
-			"Price Range: £%d - £%d\n"+
-			"Bedrooms: %v\n"+
+			"Price Range: %s\n"+
+			"Bedrooms: %s\n"+
 			"Furnished: %v\n"+
-			"Location: %s\n\n"+
+			"Move in: %s\n"+
+			"Tenancy: %s\n"+
+			"Location: %s\n"+
+			"Last search: %s\n\n"+
 			"Would you like to use these preferences or start a new search?",
-			strings.Join(propertyTypes, ", "), prefs.MinPrice, prefs.MaxPrice, strings.Join(bedrooms, ", "), prefs.Furnished, prefs.Location)
+			formatListingKind(prefs.ListingKind), b.formatPropertyTypes(prefs.PropertyTypes), priceRange{Min: prefs.MinPrice, Max: prefs.MaxPrice}.Label(), formatBedroomRange(prefs.MinBedrooms, prefs.MaxBedrooms), prefs.Furnished, formatMoveInDate(prefs.MoveInDate), formatTenancyMonths(prefs.TenancyMonths), prefs.Location, prefs.LastSearch.Format("2 Jan 2006"))
 
 		keyboard := tgbotapi.NewInlineKeyboardMarkup(
 			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Use Saved Preferences", "use_saved_prefs"),
-				tgbotapi.NewInlineKeyboardButtonData("Start New Search", "start_new_search"),
+				tgbotapi.NewInlineKeyboardButtonData("Use Saved Preferences", callbackData("use_saved_prefs")),
+				tgbotapi.NewInlineKeyboardButtonData("Start New Search", callbackData("start_new_search")),
+			),
+			tgbotapi.NewInlineKeyboardRow(
+				tgbotapi.NewInlineKeyboardButtonData("Only New Since Last Search", callbackData("use_saved_prefs", "new")),
 			),
 		)
 
@@ -64,76 +68,202 @@ func (b *Bot) startNewSearch(chatID int64) {
 	b.askPropertyType(chatID)
 }
 
-// presentSearchResults displays the search results to the user in a staged manner.
-// It simulates a real-world scenario where properties are found over time.
-func (b *Bot) presentSearchResults(chatID int64, properties []database.Property) {
-	if len(properties) == 0 {
-		b.sendMessage(chatID, "Sorry, no properties match your criteria. Try adjusting your preferences and searching again.", nil)
+// presentOrOfferResults presents exact matches straight away. Results found with relaxed filters
+// are held back until the user has seen what was relaxed and chosen to view them.
+func (b *Bot) presentOrOfferResults(ctx context.Context, chatID int64, results searchResults) {
+	if len(results.Relaxations) == 0 {
+		b.presentSearchResults(ctx, chatID, results)
 		return
 	}
 
-	// Present first 3 properties (or less if there are fewer than 3)
-	numInitialProperties := min(3, len(properties))
-	b.presentMultipleProperties(chatID, properties[:numInitialProperties], false) // Note the 'false' here
-
-	if len(properties) > 3 {
-		b.sendMessage(chatID, "As the bot is in testing mode, please assume that several hours have passed. So, a few moments later…", nil)
-		time.Sleep(5 * time.Second)
+	state := b.getUserState(chatID)
+	state.RelaxedResults = &results
+	b.updateUserState(chatID, state)
 
-		// Present next 2 properties (or less if there are fewer than 5 total)
-		numNextProperties := min(2, len(properties)-3)
-		b.presentMultipleProperties(chatID, properties[3:3+numNextProperties], false) // Note the 'false' here
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("Show %d results", len(results.Properties)), callbackData("relaxed", "show")),
+			tgbotapi.NewInlineKeyboardButtonData("No thanks", callbackData("relaxed", "decline")),
+		),
+	)
+	b.sendMessage(chatID, formatRelaxations(results.Relaxations)+"\nWould you like to see these properties?", keyboard)
+}
 
-		if len(properties) > 5 {
-			b.sendMessage(chatID, "As the bot is in testing mode, please assume that one day has passed. So, a few moments later…", nil)
-			time.Sleep(5 * time.Second)
+// presentSearchResults shows the search results in a single message, one property at a time.
+// The user pages through them with the buttons below, and the message is edited in place.
+func (b *Bot) presentSearchResults(ctx context.Context, chatID int64, results searchResults) {
+	if len(results.Properties) == 0 {
+		b.sendMessage(chatID, "Sorry, no properties match your criteria. Try adjusting your preferences and searching again.", nil)
+		return
+	}
 
-			b.sendMessage(chatID, "🔔 Alert: New property found matching your criteria!", nil)
-			time.Sleep(time.Second)
+	browser := newResultBrowser(results)
+	message, keyboard := b.renderBrowserPage(browser)
 
-			// Present the last property
-			b.presentMultipleProperties(chatID, properties[len(properties)-1:], false) // Note the 'false' here
-		}
+	msg := tgbotapi.NewMessage(chatID, message)
+	msg.ParseMode = "HTML"
+	msg.ReplyMarkup = keyboard
+	sent, err := b.api.Send(msg)
+	if err != nil {
+		log.Printf("Error sending results message: %v", err)
+		return
 	}
+	b.recordImpression(ctx, chatID, browser.current().ID)
 
-	b.sendMessage(chatID, "That's all the properties I found matching your criteria. Would you like to start a new search?", nil)
+	// Keep the cursor so the navigation buttons keep working whatever else happens in the chat
+	browser.MessageID = sent.MessageID
+	state := b.getUserState(chatID)
+	state.Browser = browser
+	b.updateUserState(chatID, state)
 }
 
 // presentProperty displays a single property to the user.
 // It formats the property information and sends it along with photos if available.
 func (b *Bot) presentProperty(prop database.Property, isSaved bool) (string, tgbotapi.InlineKeyboardMarkup) {
-	message := fmt.Sprintf(
-		"🏠 %s\n"+
-			"💰 £%d per month\n"+
-			"🛏 %d bedrooms\n"+
+	var message string
+	if prop.ListingKind == database.ListingRoom {
+		message = b.presentRoom(prop)
+	} else {
+		message = fmt.Sprintf(
+			"🏠 %s\n"+
+				"💰 £%d per month\n"+
+				"🛏 %d bedrooms\n"+
+				"📍 %s\n"+
+				"🔑 %s\n"+
+				"📅 %s\n\n"+
+				"📝 Description: %s\n\n"+
+				"🔗 <a href=\"%s\">View on website</a>",
+			b.propertyTypeLabel(prop.Type),
+			prop.PricePerMonth,
+			prop.Bedrooms,
+			prop.Location,
+			propertyFurnished(prop.Furnished),
+			formatAvailability(prop.AvailableFrom, prop.MinTenancyMonths, prop.MaxTenancyMonths, time.Now()),
+			prop.Description,
+			prop.WebLink)
+	}
+	if prop.Status != "" && prop.Status != database.StatusAvailable {
+		message = "📌 " + formatListingStatus(prop.Status) + "\n" + message
+	}
+
+	var row []tgbotapi.InlineKeyboardButton
+	if isSaved {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Delete Listing", callbackData("delete", prop.ID)))
+	} else {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Save Listing", callbackData("save", prop.ID)))
+	}
+	if prop.ListingKind == database.ListingRoom && prop.ParentPropertyID > 0 {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData("Other rooms in this house", callbackData("rooms", prop.ParentPropertyID, prop.ID)))
+	}
+
+	similarRow := tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Similar homes", callbackData("similar", prop.ID)),
+	)
+	if !isSaved {
+		similarRow = append(similarRow, tgbotapi.NewInlineKeyboardButtonData("Hide", callbackData("hide", prop.ID)))
+	}
+
+	return message, tgbotapi.NewInlineKeyboardMarkup(row, similarRow)
+}
+
+// presentRoom formats a room in a shared house, including the details that matter to sharers.
+func (b *Bot) presentRoom(prop database.Property) string {
+	bills := "Bills not included"
+	if prop.BillsIncluded {
+		bills = "Bills included"
+	}
+	bathroom := "Shared bathroom"
+	if prop.Ensuite {
+		bathroom = "Ensuite"
+	}
+	facilities := "Not specified"
+	if len(prop.SharedFacilities) > 0 {
+		facilities = strings.Join(prop.SharedFacilities, ", ")
+	}
+
+	return fmt.Sprintf(
+		"🚪 Room to rent\n"+
+			"🏠 %s\n"+
+			"💰 £%d per month for the room\n"+
+			"🧾 %s\n"+
+			"👥 %s\n"+
+			"🛁 %s\n"+
+			"🍳 Shared: %s\n"+
 			"📍 %s\n"+
-			"🔑 %s\n\n"+
+			"🔑 %s\n"+
+			"📅 %s\n\n"+
 			"📝 Description: %s\n\n"+
 			"🔗 <a href=\"%s\">View on website</a>",
-		prop.Type,
+		b.propertyTypeLabel(prop.Type),
 		prop.PricePerMonth,
-		prop.Bedrooms,
+		bills,
+		formatHousemates(prop.Housemates),
+		bathroom,
+		facilities,
 		prop.Location,
 		propertyFurnished(prop.Furnished),
+		formatAvailability(prop.AvailableFrom, prop.MinTenancyMonths, prop.MaxTenancyMonths, time.Now()),
 		prop.Description,
 		prop.WebLink)
+}
 
-	var keyboard tgbotapi.InlineKeyboardMarkup
-	if isSaved {
-		keyboard = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Delete Listing", fmt.Sprintf("delete:%d", prop.ID)),
-			),
-		)
-	} else {
-		keyboard = tgbotapi.NewInlineKeyboardMarkup(
-			tgbotapi.NewInlineKeyboardRow(
-				tgbotapi.NewInlineKeyboardButtonData("Save Listing", fmt.Sprintf("save:%d", prop.ID)),
-			),
-		)
+// formatListingKind describes the listing kind the user is searching for.
+func formatListingKind(kind string) string {
+	switch kind {
+	case database.ListingWhole:
+		return "Whole property"
+	case database.ListingRoom:
+		return "Room in a shared house"
+	default:
+		return "Whole property or room"
+	}
+}
+
+// formatListingStatus describes where a listing is in the letting process.
+func formatListingStatus(status string) string {
+	switch status {
+	case database.StatusUnderOffer:
+		return "Under offer"
+	case database.StatusLetAgreed:
+		return "Let agreed"
+	case database.StatusWithdrawn:
+		return "Withdrawn"
+	default:
+		return "Available"
+	}
+}
+
+// formatHousemates describes how many other people share the house.
+func formatHousemates(housemates int) string {
+	switch housemates {
+	case 0:
+		return "No other housemates"
+	case 1:
+		return "1 housemate"
+	default:
+		return fmt.Sprintf("%d housemates", housemates)
+	}
+}
+
+// showRoomsInProperty lists the rooms listed in the given shared house, other than the room the user is looking at.
+func (b *Bot) showRoomsInProperty(ctx context.Context, chatID int64, parentID, roomID int) {
+	rooms, err := database.GetProperties(ctx, b.db, map[string]interface{}{
+		"listing_kind":       database.ListingRoom,
+		"parent_property_id": parentID,
+	})
+	if err != nil {
+		log.Printf("Error getting rooms for property %d: %v", parentID, err)
+		b.sendMessage(chatID, "Sorry, there was an error retrieving the rooms in this house. Please try again later.", nil)
+		return
+	}
+	rooms = slices.DeleteFunc(rooms, func(room database.Property) bool { return room.ID == roomID })
+	if len(rooms) == 0 {
+		b.sendMessage(chatID, "There are no other rooms listed in this house.", nil)
+		return
 	}
 
-	return message, keyboard
+	b.sendMessage(chatID, fmt.Sprintf("Other rooms in this house (%d):", len(rooms)), nil)
+	b.presentMultipleProperties(ctx, chatID, rooms, false, nil)
 }
 
 // propertyFurnished converts boolean to "Furnished" or "Unfurnished"
@@ -146,9 +276,12 @@ func propertyFurnished(furnished bool) string {
 
 // presentMultipleProperties displays multiple properties to the user.
 // It formats the property information and sends it along with photos if available.
-func (b *Bot) presentMultipleProperties(chatID int64, properties []database.Property, isSaved bool) {
+// If search results are given, each card explains its match score and is marked if it's new.
+// Every card sent is recorded as seen by the user.
+func (b *Bot) presentMultipleProperties(ctx context.Context, chatID int64, properties []database.Property, isSaved bool, results *searchResults) {
 	for _, prop := range properties {
 		message, keyboard := b.presentProperty(prop, isSaved)
+		message = decorateCard(message, prop, results)
 
 		if len(prop.PhotoURLs) > 0 {
 			media := make([]interface{}, len(prop.PhotoURLs))
@@ -169,9 +302,30 @@ func (b *Bot) presentMultipleProperties(chatID int64, properties []database.Prop
 		_, err := b.api.Send(msg)
 		if err != nil {
 			log.Printf("Error sending message: %v", err)
+		} else {
+			b.recordImpression(ctx, chatID, prop.ID)
 		}
+	}
+}
+
+// decorateCard marks a property card as new and explains its match score, if search results are given.
+func decorateCard(message string, prop database.Property, results *searchResults) string {
+	if results == nil {
+		return message
+	}
+	if results.isNew(prop.ID) {
+		message = "🆕 New since your last search\n\n" + message
+	}
+	if score, ok := results.Scores[prop.ID]; ok {
+		message += "\n\n🎯 " + score.String()
+	}
+	return message
+}
 
-		// Add a delay between messages to avoid hitting rate limits
-		time.Sleep(time.Second)
+// recordImpression records that a property was shown to a user. Errors are only logged,
+// as tracking what the user has seen shouldn't get in the way of showing it.
+func (b *Bot) recordImpression(ctx context.Context, userID int64, propertyID int) {
+	if err := database.RecordImpression(ctx, b.db, userID, propertyID); err != nil {
+		log.Printf("Error recording impression of property %d: %v", propertyID, err)
 	}
 }
@@ -1,7 +1,9 @@
 package bot
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -23,6 +25,9 @@ func (m *MockBotAPI3) GetUpdatesChan(config tgbotapi.UpdateConfig) tgbotapi.Upda
 	return make(tgbotapi.UpdatesChannel)
 }
 
+// StopReceivingUpdates mocks the method to stop getting updates
+func (m *MockBotAPI3) StopReceivingUpdates() {}
+
 // Send mocks the method to send messages and stores them for later verification
 func (m *MockBotAPI3) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
 	msg, ok := c.(tgbotapi.MessageConfig)
@@ -39,6 +44,7 @@ func (m *MockBotAPI3) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, erro
 
 // TestHandleSearchCommand2 tests the handleSearchCommand function with existing preferences
 func TestHandleSearchCommand2(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -62,16 +68,15 @@ func TestHandleSearchCommand2(t *testing.T) {
 	}
 
 	propertyTypes, _ := json.Marshal(map[string]bool{"Apartment": true})
-	bedrooms, _ := json.Marshal(map[string]bool{"2": true})
 	furnished, _ := json.Marshal(map[string]bool{"Furnished": true})
 	lastSearch := time.Now()
 
-	rows := sqlmock.NewRows([]string{"user_id", "property_type", "min_price", "max_price", "bedrooms", "furnished", "location", "last_search"}).
-		AddRow(456, propertyTypes, 1000, 2000, bedrooms, furnished, "Bath", lastSearch)
+	rows := sqlmock.NewRows(userPreferencesColumns).
+		AddRow(456, propertyTypes, 1000, 2000, 2, 2, furnished, "Bath", "", nil, 0, lastSearch, true)
 
 	mock.ExpectQuery("SELECT (.+) FROM user_preferences").WithArgs(message.From.ID).WillReturnRows(rows)
 
-	bot.handleSearchCommand(message)
+	bot.handleSearchCommand(ctx, message)
 
 	if len(mockAPI.messages) == 0 {
 		t.Error("Expected a message to be sent, but none was")
@@ -88,9 +93,18 @@ func TestHandleSearchCommand2(t *testing.T) {
 
 // TestPresentSearchResults tests the presentSearchResults function with a single property
 func TestPresentSearchResults(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
 	mockAPI := &MockBotAPI3{}
 	bot := &Bot{
-		api: mockAPI,
+		api:   mockAPI,
+		db:    db,
+		state: make(map[int64]*UserState),
 	}
 
 	properties := []database.Property{
@@ -107,32 +121,141 @@ func TestPresentSearchResults(t *testing.T) {
 		},
 	}
 
-	bot.presentSearchResults(123, properties)
+	// The user has seen another property before, so this one is new
+	mock.ExpectExec("INSERT INTO listing_impressions").WithArgs(int64(123), 1).WillReturnResult(sqlmock.NewResult(1, 1))
 
-	expectedMessageCount := 2 // Property details + final message
-	if len(mockAPI.messages) != expectedMessageCount {
-		t.Errorf("Expected %d messages to be sent, but got %d", expectedMessageCount, len(mockAPI.messages))
+	bot.presentSearchResults(ctx, 123, searchResults{Properties: properties, Seen: map[int]bool{2: true}})
+
+	if len(mockAPI.messages) != 1 {
+		t.Fatalf("Expected 1 message to be sent, but got %d", len(mockAPI.messages))
 	}
 
 	// Check the content of the property message
 	propertyMessage := mockAPI.messages[0]
-	expectedContent := []string{"Apartment", "£1500", "2 bedrooms", "Bath", "Furnished", "Nice apartment", "http://example.com/property1"}
+	expectedContent := []string{"New since your last search", "Apartment", "£1500", "2 bedrooms", "Bath", "Furnished", "Nice apartment", "http://example.com/property1"}
 	for _, content := range expectedContent {
 		if !strings.Contains(propertyMessage.Text, content) {
 			t.Errorf("Expected property message to contain '%s', but it didn't. Message: %s", content, propertyMessage.Text)
 		}
 	}
 
-	// Check the content of the final message
-	finalMessage := mockAPI.messages[1]
-	expectedFinalContent := "That's all the properties I found matching your criteria. Would you like to start a new search?"
-	if !strings.Contains(finalMessage.Text, expectedFinalContent) {
-		t.Errorf("Expected final message to contain '%s', but it didn't. Message: %s", expectedFinalContent, finalMessage.Text)
+	// A single result has a counter but nowhere to navigate to
+	keyboard := propertyMessage.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	navigation := keyboard.InlineKeyboard[len(keyboard.InlineKeyboard)-1]
+	if len(navigation) != 1 || navigation[0].Text != "1 of 1" {
+		t.Errorf("Unexpected navigation buttons: %+v", navigation)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Impression was not recorded: %s", err)
+	}
+}
+
+// TestPresentPropertyRoom tests that room listings show room details and a link to the other rooms
+func TestPresentPropertyRoom(t *testing.T) {
+	bot := &Bot{}
+
+	room := database.Property{
+		ID:               7,
+		Type:             "hmo",
+		PricePerMonth:    550,
+		Location:         "Oldfield Park",
+		ListingKind:      database.ListingRoom,
+		ParentPropertyID: 3,
+		BillsIncluded:    true,
+		Housemates:       4,
+		Ensuite:          true,
+		SharedFacilities: []string{"Kitchen", "Garden"},
+	}
+
+	message, keyboard := bot.presentProperty(room, false)
+
+	expectedContent := []string{"Room to rent", "Shared house (HMO)", "£550 per month for the room", "Bills included", "4 housemates", "Ensuite", "Kitchen, Garden", "Oldfield Park"}
+	for _, content := range expectedContent {
+		if !strings.Contains(message, content) {
+			t.Errorf("Expected room message to contain '%s', but it didn't. Message: %s", content, message)
+		}
+	}
+	if strings.Contains(message, "bedrooms") {
+		t.Errorf("Room message should not mention bedrooms. Message: %s", message)
+	}
+
+	buttons := keyboard.InlineKeyboard[0]
+	if len(buttons) != 2 || *buttons[1].CallbackData != callbackData("rooms", 3, 7) {
+		t.Errorf("Expected an 'Other rooms in this house' button for property 3, got %+v", buttons)
+	}
+}
+
+// TestShowRoomsInProperty tests that the other rooms in a house are listed without the room the user tapped on
+func TestShowRoomsInProperty(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create mock database: %v", err)
+	}
+	defer db.Close()
+	mockAPI := &MockBotAPI3{}
+	bot := &Bot{api: mockAPI, db: db}
+
+	room := func(id int) []driver.Value {
+		return []driver.Value{id, "hmo", 550, 1, true, "Oldfield Park", "Room", "[]", "", "room", 3, true, 4, false, "[]", nil, 0, 0, nil, nil, "available"}
+	}
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE 1=1").WithArgs(database.ListingRoom, 3).
+		WillReturnRows(sqlmock.NewRows(propertyRowColumns).AddRow(room(7)...).AddRow(room(8)...))
+	mock.ExpectExec("INSERT INTO listing_impressions").WithArgs(int64(123), 8).WillReturnResult(sqlmock.NewResult(1, 1))
+
+	bot.showRoomsInProperty(context.Background(), 123, 3, 7)
+
+	if len(mockAPI.messages) != 2 || !strings.Contains(mockAPI.messages[0].Text, "Other rooms in this house (1)") {
+		t.Fatalf("Expected one other room to be listed, got %+v", mockAPI.messages)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Unfulfilled expectations: %v", err)
+	}
+
+	// The only room listed is the one the user is looking at
+	mockAPI.messages = nil
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE 1=1").WithArgs(database.ListingRoom, 3).
+		WillReturnRows(sqlmock.NewRows(propertyRowColumns).AddRow(room(7)...))
+
+	bot.showRoomsInProperty(context.Background(), 123, 3, 7)
+
+	if len(mockAPI.messages) != 1 || !strings.Contains(mockAPI.messages[0].Text, "no other rooms") {
+		t.Errorf("Expected to be told there are no other rooms, got %+v", mockAPI.messages)
+	}
+}
+
+// TestPresentOrOfferResultsRelaxed tests that relaxed results are held back until the user accepts them
+func TestPresentOrOfferResultsRelaxed(t *testing.T) {
+	ctx := context.Background()
+	mockAPI := &MockBotAPI3{}
+	bot := &Bot{
+		api:   mockAPI,
+		state: make(map[int64]*UserState),
+	}
+
+	results := searchResults{
+		Properties:  []database.Property{{ID: 1, Type: "Apartment", PricePerMonth: 1600, Bedrooms: 2}},
+		Relaxations: []relaxation{{Filter: "price", Description: "up to £1650 instead of up to £1500"}},
+	}
+
+	bot.presentOrOfferResults(ctx, 123, results)
+
+	if len(mockAPI.messages) != 1 {
+		t.Fatalf("Expected 1 message to be sent, but got %d", len(mockAPI.messages))
+	}
+	expected := "No exact matches; showing up to £1650 instead of up to £1500."
+	if !strings.Contains(mockAPI.messages[0].Text, expected) {
+		t.Errorf("Expected message to contain '%s', but it didn't. Message: %s", expected, mockAPI.messages[0].Text)
+	}
+
+	if state := bot.getUserState(123); state.RelaxedResults == nil || len(state.RelaxedResults.Properties) != 1 {
+		t.Errorf("Expected relaxed results to be held for the user, got %+v", state.RelaxedResults)
 	}
 }
 
 // TestHandleSearchCommandNoPreferences tests the handleSearchCommand function when no preferences are found
 func TestHandleSearchCommandNoPreferences(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -157,7 +280,7 @@ func TestHandleSearchCommandNoPreferences(t *testing.T) {
 
 	mock.ExpectQuery("SELECT (.+) FROM user_preferences").WithArgs(message.From.ID).WillReturnError(sql.ErrNoRows)
 
-	bot.handleSearchCommand(message)
+	bot.handleSearchCommand(ctx, message)
 
 	if len(mockAPI.messages) == 0 {
 		t.Error("Expected a message to be sent, but none was")
@@ -174,6 +297,7 @@ func TestHandleSearchCommandNoPreferences(t *testing.T) {
 
 // TestHandleSearchCommandDatabaseError tests the handleSearchCommand function when a database error occurs
 func TestHandleSearchCommandDatabaseError(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -198,7 +322,7 @@ func TestHandleSearchCommandDatabaseError(t *testing.T) {
 
 	mock.ExpectQuery("SELECT (.+) FROM user_preferences").WithArgs(message.From.ID).WillReturnError(errors.New("database error"))
 
-	bot.handleSearchCommand(message)
+	bot.handleSearchCommand(ctx, message)
 
 	if len(mockAPI.messages) == 0 {
 		t.Error("Expected a message to be sent, but none was")
@@ -216,9 +340,18 @@ func TestHandleSearchCommandDatabaseError(t *testing.T) {
 
 // TestPresentSearchResultsMultipleProperties tests the presentSearchResults function with multiple properties
 func TestPresentSearchResultsMultipleProperties(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
 	mockAPI := &MockBotAPI3{}
 	bot := &Bot{
-		api: mockAPI,
+		api:   mockAPI,
+		db:    db,
+		state: make(map[int64]*UserState),
 	}
 
 	properties := []database.Property{
@@ -246,42 +379,53 @@ func TestPresentSearchResultsMultipleProperties(t *testing.T) {
 		},
 	}
 
-	bot.presentSearchResults(123, properties)
+	mock.ExpectExec("INSERT INTO listing_impressions").WithArgs(int64(123), 1).WillReturnResult(sqlmock.NewResult(1, 1))
 
-	expectedMessageCount := 3 // 2 properties + 1 final message
-	if len(mockAPI.messages) != expectedMessageCount {
-		t.Errorf("Expected %d messages to be sent, but got %d", expectedMessageCount, len(mockAPI.messages))
-		return
+	bot.presentSearchResults(ctx, 123, searchResults{Properties: properties})
+
+	// Only the first property is sent, rather than one message per property
+	if len(mockAPI.messages) != 1 {
+		t.Fatalf("Expected 1 message to be sent, but got %d", len(mockAPI.messages))
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("Impression was not recorded: %s", err)
 	}
 
-	// Check content of property messages
-	for i, propertyMessage := range mockAPI.messages[:2] {
-		property := properties[i]
-		expectedContent := []string{
-			property.Type,
-			fmt.Sprintf("£%d", property.PricePerMonth),
-			fmt.Sprintf("%d bedrooms", property.Bedrooms),
-			property.Location,
-			property.Description,
-			property.WebLink,
-		}
-		for _, content := range expectedContent {
-			if !strings.Contains(propertyMessage.Text, content) {
-				t.Errorf("Expected property message to contain '%s', but it didn't. Message: %s", content, propertyMessage.Text)
-			}
+	propertyMessage := mockAPI.messages[0]
+	// Nothing is new on a first search
+	if strings.Contains(propertyMessage.Text, "New since your last search") {
+		t.Errorf("Property on a first search was marked as new: %s", propertyMessage.Text)
+	}
+	property := properties[0]
+	expectedContent := []string{
+		property.Type,
+		fmt.Sprintf("£%d", property.PricePerMonth),
+		fmt.Sprintf("%d bedrooms", property.Bedrooms),
+		property.Location,
+		property.Description,
+		property.WebLink,
+	}
+	for _, content := range expectedContent {
+		if !strings.Contains(propertyMessage.Text, content) {
+			t.Errorf("Expected property message to contain '%s', but it didn't. Message: %s", content, propertyMessage.Text)
 		}
 	}
 
-	// Check final message
-	finalMessage := mockAPI.messages[len(mockAPI.messages)-1]
-	expectedFinalContent := "That's all the properties I found matching your criteria"
-	if !strings.Contains(finalMessage.Text, expectedFinalContent) {
-		t.Errorf("Expected final message to contain '%s', but it didn't. Message: %s", expectedFinalContent, finalMessage.Text)
+	state := bot.getUserState(123)
+	if state.Browser == nil || state.Browser.Index != 0 || len(state.Browser.Results.Properties) != 2 {
+		t.Fatalf("Expected a result browser on the first of 2 properties, got %+v", state.Browser)
+	}
+
+	keyboard := propertyMessage.ReplyMarkup.(tgbotapi.InlineKeyboardMarkup)
+	navigation := keyboard.InlineKeyboard[len(keyboard.InlineKeyboard)-1]
+	if len(navigation) != 2 || navigation[0].Text != "1 of 2" || *navigation[1].CallbackData != state.Browser.pageData(1) {
+		t.Errorf("Unexpected navigation buttons: %+v", navigation)
 	}
 }
 
 // TestPresentSearchResultsNoProperties tests the presentSearchResults function when no properties are found
 func TestPresentSearchResultsNoProperties(t *testing.T) {
+	ctx := context.Background()
 	mockAPI := &MockBotAPI3{}
 	bot := &Bot{
 		api: mockAPI,
@@ -289,7 +433,7 @@ func TestPresentSearchResultsNoProperties(t *testing.T) {
 
 	properties := []database.Property{}
 
-	bot.presentSearchResults(123, properties)
+	bot.presentSearchResults(ctx, 123, searchResults{Properties: properties})
 
 	if len(mockAPI.messages) != 1 {
 		t.Errorf("Expected 1 message to be sent, but got %d", len(mockAPI.messages))
@@ -1,13 +1,16 @@
 package bot
 
 import (
+	"context"
 	"errors"
 	"github.com/DATA-DOG/go-sqlmock"
+	"imitation_project/internal/database"
 	"testing"
 )
 
 // TestSearchProperties tests the searchProperties function with a basic scenario
 func TestSearchProperties(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -24,12 +27,13 @@ func TestSearchProperties(t *testing.T) {
 		Location:      "Bath",
 	}
 
-	rows := sqlmock.NewRows([]string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link"}).
-		AddRow(1, "Apartment", 1500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com")
+	rows := sqlmock.NewRows(propertyRowColumns).
+		AddRow(1, "Apartment", 1500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
 
 	mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(rows)
 
-	properties, err := bot.searchProperties(preferences)
+	results, err := bot.searchProperties(ctx, 1, preferences)
+	properties, relaxations := results.Properties, results.Relaxations
 	if err != nil {
 		t.Errorf("searchProperties() returned an error: %v", err)
 	}
@@ -38,6 +42,70 @@ func TestSearchProperties(t *testing.T) {
 		t.Errorf("searchProperties() returned %d properties, want 1", len(properties))
 	}
 
+	if len(relaxations) != 0 {
+		t.Errorf("searchProperties() relaxed %v for an exact match", relaxations)
+	}
+}
+
+// TestSearchPropertiesNewOnly tests that a new-only search leaves out seen listings
+// and reports which results the user saw before
+func TestSearchPropertiesNewOnly(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	bot := &Bot{
+		db: db,
+	}
+
+	preferences := &SearchPreferences{NewOnly: true}
+
+	rows := sqlmock.NewRows(propertyRowColumns).
+		AddRow(2, "Apartment", 1500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
+	mock.ExpectQuery("SELECT (.+) FROM properties (.+) NOT IN \\(SELECT property_id FROM listing_impressions").
+		WithArgs("Bath", int64(1), int64(1)).WillReturnRows(rows)
+	mock.ExpectQuery("SELECT property_id FROM listing_impressions").
+		WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"property_id"}).AddRow(1))
+	mock.ExpectExec("UPDATE user_preferences SET last_search").
+		WithArgs(sqlmock.AnyArg(), int64(1)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	results, err := bot.searchProperties(ctx, 1, preferences)
+	if err != nil {
+		t.Fatalf("searchProperties() returned an error: %v", err)
+	}
+	if len(results.Properties) != 1 || !results.isNew(2) || results.isNew(1) {
+		t.Errorf("searchProperties() = %+v, want property 2 marked as new", results)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
+
+// TestSearchPropertiesListedOnly tests that searches leave out let and withdrawn listings
+func TestSearchPropertiesListedOnly(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	bot := &Bot{db: db}
+
+	rows := sqlmock.NewRows(propertyRowColumns).
+		AddRow(1, "Apartment", 1500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE (.+) AND status NOT IN \\('let_agreed', 'withdrawn'\\)").
+		WithArgs("Bath", int64(1)).WillReturnRows(rows)
+
+	if _, err := bot.searchProperties(ctx, 1, &SearchPreferences{}); err != nil {
+		t.Fatalf("searchProperties() returned an error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
 }
 
 // TestBuildFilters tests the buildFilters function
@@ -46,7 +114,8 @@ func TestBuildFilters(t *testing.T) {
 
 	preferences := &SearchPreferences{
 		PropertyTypes:    map[string]bool{"Apartment": true, "House": false},
-		BedroomOptions:   map[string]bool{"2": true, "3": true},
+		MinBedrooms:      intPtr(2),
+		MaxBedrooms:      intPtr(3),
 		PriceRange:       "1000-2000",
 		Location:         "Bath",
 		FurnishedOptions: map[string]bool{"Furnished": true},
@@ -54,18 +123,20 @@ func TestBuildFilters(t *testing.T) {
 
 	filters := bot.buildFilters(preferences)
 
-	if len(filters) != 6 {
-		t.Errorf("buildFilters() returned %d filters, want 6", len(filters))
+	if len(filters) != 8 {
+		t.Errorf("buildFilters() returned %d filters, want 8", len(filters))
 	}
 
 	// Check if all expected filters are present
 	expectedFilters := map[string]bool{
-		"types":     true,
-		"bedrooms":  true,
-		"min_price": true,
-		"max_price": true,
-		"location":  true,
-		"furnished": true,
+		"types":        true,
+		"min_bedrooms": true,
+		"max_bedrooms": true,
+		"min_price":    true,
+		"max_price":    true,
+		"location":     true,
+		"furnished":    true,
+		"listed_only":  true,
 	}
 
 	for key := range expectedFilters {
@@ -79,8 +150,12 @@ func TestBuildFilters(t *testing.T) {
 		t.Errorf("Unexpected value for 'types' filter: %v", filters["types"])
 	}
 
-	if bedrooms, ok := filters["bedrooms"].([]int); !ok || len(bedrooms) != 2 || (bedrooms[0] != 2 && bedrooms[1] != 3) {
-		t.Errorf("Unexpected value for 'bedrooms' filter: %v", filters["bedrooms"])
+	if minBedrooms, ok := filters["min_bedrooms"].(int); !ok || minBedrooms != 2 {
+		t.Errorf("Unexpected value for 'min_bedrooms' filter: %v", filters["min_bedrooms"])
+	}
+
+	if maxBedrooms, ok := filters["max_bedrooms"].(int); !ok || maxBedrooms != 3 {
+		t.Errorf("Unexpected value for 'max_bedrooms' filter: %v", filters["max_bedrooms"])
 	}
 
 	if minPrice, ok := filters["min_price"].(int); !ok || minPrice != 1000 {
@@ -100,8 +175,33 @@ func TestBuildFilters(t *testing.T) {
 	}
 }
 
+// TestBuildFiltersRoom tests that room searches filter on listing kind and ignore bedroom counts
+func TestBuildFiltersRoom(t *testing.T) {
+	bot := &Bot{}
+
+	preferences := &SearchPreferences{
+		MinBedrooms: intPtr(2),
+		MaxBedrooms: intPtr(3),
+		PriceRange:  "500-700",
+		ListingKind: database.ListingRoom,
+	}
+
+	filters := bot.buildFilters(preferences)
+
+	if kind, ok := filters["listing_kind"].(string); !ok || kind != database.ListingRoom {
+		t.Errorf("Unexpected value for 'listing_kind' filter: %v", filters["listing_kind"])
+	}
+	if _, exists := filters["min_bedrooms"]; exists {
+		t.Error("Room search should not filter on min_bedrooms")
+	}
+	if _, exists := filters["max_bedrooms"]; exists {
+		t.Error("Room search should not filter on max_bedrooms")
+	}
+}
+
 // TestSearchPropertiesNoResults tests the searchProperties function when no results are found
 func TestSearchPropertiesNoResults(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -119,15 +219,15 @@ func TestSearchPropertiesNoResults(t *testing.T) {
 	}
 
 	// Initial query
-	mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link"}))
+	mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows(propertyRowColumns))
 
-	// Queries for each relaxation step
-	relaxationSteps := []string{"bedrooms", "types", "price", "furnished"}
-	for range relaxationSteps {
-		mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link"}))
+	// Queries for each relaxation stage: any type, price widened by 10% and 20%, any price
+	for i := 0; i < 4; i++ {
+		mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows(propertyRowColumns))
 	}
 
-	properties, err := bot.searchProperties(preferences)
+	results, err := bot.searchProperties(ctx, 1, preferences)
+	properties := results.Properties
 	if err != nil {
 		t.Errorf("searchProperties() returned an error: %v", err)
 	}
@@ -143,6 +243,7 @@ func TestSearchPropertiesNoResults(t *testing.T) {
 
 // TestSearchPropertiesWithRelaxation tests the searchProperties function with filter relaxation
 func TestSearchPropertiesWithRelaxation(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -155,21 +256,23 @@ func TestSearchPropertiesWithRelaxation(t *testing.T) {
 
 	preferences := &SearchPreferences{
 		PropertyTypes:    map[string]bool{"Apartment": true},
-		BedroomOptions:   map[string]bool{"2": true},
+		MinBedrooms:      intPtr(2),
+		MaxBedrooms:      intPtr(2),
 		PriceRange:       "1000-2000",
 		Location:         "Bath",
 		FurnishedOptions: map[string]bool{"Furnished": true},
 	}
 
 	// First query returns no results
-	mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows([]string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link"}))
+	mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows(propertyRowColumns))
 
 	// Second query (with relaxed filters) returns a result
-	rows := sqlmock.NewRows([]string{"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link"}).
-		AddRow(1, "Apartment", 2500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com")
+	rows := sqlmock.NewRows(propertyRowColumns).
+		AddRow(1, "Apartment", 2500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
 	mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(rows)
 
-	properties, err := bot.searchProperties(preferences)
+	results, err := bot.searchProperties(ctx, 1, preferences)
+	properties, relaxations := results.Properties, results.Relaxations
 	if err != nil {
 		t.Errorf("searchProperties() returned an error: %v", err)
 	}
@@ -177,10 +280,87 @@ func TestSearchPropertiesWithRelaxation(t *testing.T) {
 	if len(properties) != 1 {
 		t.Errorf("searchProperties() returned %d properties, want 1", len(properties))
 	}
+
+	// Bedrooms are widened by one on each side before anything else is relaxed
+	if len(relaxations) != 1 || relaxations[0].Description != "1-3 bed instead of 2 bed" {
+		t.Errorf("searchProperties() relaxations = %v, want the widened bedroom range", relaxations)
+	}
+}
+
+// TestSearchPropertiesGradualRelaxation tests that the price is widened before it is dropped
+// and that the configured strategy is followed
+func TestSearchPropertiesGradualRelaxation(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	bot := &Bot{
+		db: db,
+	}
+	if err := bot.SetRelaxationStrategy([]string{"price", "bedrooms"}); err != nil {
+		t.Fatalf("SetRelaxationStrategy() returned an error: %v", err)
+	}
+
+	preferences := &SearchPreferences{
+		MinBedrooms: intPtr(3),
+		MaxBedrooms: intPtr(3),
+		PriceRange:  "up to 1500",
+	}
+
+	// Exact search, price up to £1650, price up to £1800, any price, then 2-4 bedrooms finds a result
+	for i := 0; i < 4; i++ {
+		mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnRows(sqlmock.NewRows(propertyRowColumns))
+	}
+	rows := sqlmock.NewRows(propertyRowColumns).
+		AddRow(1, "Apartment", 2500, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available")
+	mock.ExpectQuery("SELECT (.+) FROM properties").WithArgs(2, 4, "Bath", int64(1)).WillReturnRows(rows)
+
+	results, err := bot.searchProperties(ctx, 1, preferences)
+	properties, relaxations := results.Properties, results.Relaxations
+	if err != nil {
+		t.Fatalf("searchProperties() returned an error: %v", err)
+	}
+	if len(properties) != 1 {
+		t.Errorf("searchProperties() returned %d properties, want 1", len(properties))
+	}
+
+	expected := []string{"any price instead of up to £1500", "2-4 bed instead of 3 bed"}
+	if len(relaxations) != len(expected) {
+		t.Fatalf("searchProperties() relaxations = %v, want %v", relaxations, expected)
+	}
+	for i, description := range expected {
+		if relaxations[i].Description != description {
+			t.Errorf("relaxation %d = %q, want %q", i, relaxations[i].Description, description)
+		}
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+// TestSetRelaxationStrategy tests validation of the relaxation strategy
+func TestSetRelaxationStrategy(t *testing.T) {
+	bot := &Bot{}
+
+	if err := bot.SetRelaxationStrategy([]string{"price", "sea view"}); err == nil {
+		t.Error("SetRelaxationStrategy() accepted an unknown step")
+	}
+
+	if err := bot.SetRelaxationStrategy([]string{"none"}); err != nil {
+		t.Fatalf("SetRelaxationStrategy() returned an error: %v", err)
+	}
+	if len(bot.getRelaxationStrategy()) != 0 {
+		t.Errorf("Expected relaxation to be disabled, got %v", bot.getRelaxationStrategy())
+	}
 }
 
 // TestSearchPropertiesError tests the searchProperties function when a database error occurs
 func TestSearchPropertiesError(t *testing.T) {
+	ctx := context.Background()
 	db, mock, err := sqlmock.New()
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
@@ -199,7 +379,7 @@ func TestSearchPropertiesError(t *testing.T) {
 
 	mock.ExpectQuery("SELECT (.+) FROM properties").WillReturnError(errors.New("database error"))
 
-	_, err = bot.searchProperties(preferences)
+	_, err = bot.searchProperties(ctx, 1, preferences)
 	if err == nil {
 		t.Error("searchProperties() did not return an error when one was expected")
 	}
@@ -227,53 +407,6 @@ func TestGetSelectedOptions(t *testing.T) {
 	}
 }
 
-// TestGetSelectedBedroomOptions tests the getSelectedBedroomOptions function
-func TestGetSelectedBedroomOptions(t *testing.T) {
-	options := map[string]bool{
-		"Studio": true,
-		"1":      false,
-		"2":      true,
-		"3+":     true,
-	}
-
-	selected := getSelectedBedroomOptions(options)
-
-	if len(selected) != 3 {
-		t.Errorf("getSelectedBedroomOptions() returned %d options, want 3", len(selected))
-	}
-
-	expectedOptions := []int{0, 2, 3}
-	for _, option := range expectedOptions {
-		if !containsInt(selected, option) {
-			t.Errorf("getSelectedBedroomOptions() did not return expected option: %d", option)
-		}
-	}
-}
-
-// TestParsePriceRange tests the parsePriceRange function with various inputs
-func TestParsePriceRange(t *testing.T) {
-	testCases := []struct {
-		input   string
-		minWant int
-		maxWant int
-	}{
-		{"1000-2000", 1000, 2000}, // Normal case
-		{"500-1500", 500, 1500},   // Normal case
-		{"0-1000", 0, 1000},       // Zero minimum
-		{"invalid", 0, 1000000},   // Invalid input
-		{"1000", 0, 1000000},      // Missing maximum
-		{"1000-", 1000, 1000000},  // Missing maximum
-		{"-2000", 0, 2000},        // Missing minimum
-	}
-
-	for _, tc := range testCases {
-		minGot, maxGot := parsePriceRange(tc.input)
-		if minGot != tc.minWant || maxGot != tc.maxWant {
-			t.Errorf("parsePriceRange(%s) = (%d, %d), want (%d, %d)", tc.input, minGot, maxGot, tc.minWant, tc.maxWant)
-		}
-	}
-}
-
 // contains is a helper function to check if a slice contains a string
 func contains(slice []string, item string) bool {
 	for _, s := range slice {
@@ -283,13 +416,3 @@ func contains(slice []string, item string) bool {
 	}
 	return false
 }
-
-// containsInt is a helper function to check if a slice contains an int
-func containsInt(slice []int, item int) bool {
-	for _, s := range slice {
-		if s == item {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,82 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"imitation_project/internal/database"
+)
+
+// priceRiseAlertPercent is how much the rent of a saved listing must rise, as a percentage,
+// before the users who saved it are told. Smaller rises aren't worth a message.
+const priceRiseAlertPercent = 5
+
+// listingChange describes how an update changed a property, for the users who saved it.
+// A status change matters most, so it decides the category, and a change of rent made in the
+// same update is reported with it. It reports false if nothing changed that they need to hear about.
+func listingChange(event database.PropertyEvent, property database.Property) (category, change string, ok bool) {
+	if event.Kind != database.PropertyUpdated {
+		return "", "", false
+	}
+
+	var priceCategory, priceChange string
+	switch {
+	case property.PricePerMonth < event.OldPrice:
+		priceCategory, priceChange = database.NotifyPriceDrop, fmt.Sprintf("💷 The rent dropped from £%d to £%d", event.OldPrice, property.PricePerMonth)
+	case event.OldPrice > 0 && property.PricePerMonth*100 >= event.OldPrice*(100+priceRiseAlertPercent):
+		priceCategory, priceChange = database.NotifyPriceRise, fmt.Sprintf("📈 The rent rose from £%d to £%d", event.OldPrice, property.PricePerMonth)
+	}
+
+	if property.Status != event.OldStatus {
+		switch property.Status {
+		case database.StatusUnderOffer:
+			category, change = database.NotifyUnderOffer, "📌 It's now under offer"
+		case database.StatusLetAgreed:
+			category, change = database.NotifyLetAgreed, "📌 It's now let agreed"
+		case database.StatusWithdrawn:
+			category, change = database.NotifyWithdrawn, "🚫 It's been taken off the market"
+		}
+	}
+
+	switch {
+	case category != "" && priceChange != "":
+		return category, change + "\n" + priceChange, true
+	case category != "":
+		return category, change, true
+	case priceChange != "":
+		return priceCategory, priceChange, true
+	}
+	return "", "", false
+}
+
+// alertWatchers tells the users who saved the property in an event when its rent or status changes.
+func (b *Bot) alertWatchers(ctx context.Context, tx database.Execer, event database.PropertyEvent, property database.Property, userIDs []int64) error {
+	category, change, ok := listingChange(event, property)
+	if !ok {
+		return nil
+	}
+	for _, userID := range userIDs {
+		if err := b.sendWatcherAlert(ctx, tx, userID, property, category, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendWatcherAlert tells a user about a change to a listing they saved, with buttons to open the listing or unsave it.
+func (b *Bot) sendWatcherAlert(ctx context.Context, tx database.Execer, userID int64, property database.Property, category, change string) error {
+	text := fmt.Sprintf("👀 A listing you saved has changed\n%s\n\n🏠 %s in %s, £%d per month",
+		change, b.propertyTypeLabel(property.Type), property.Location, property.PricePerMonth)
+
+	msg := tgbotapi.NewMessage(userID, text)
+	msg.ParseMode = "HTML"
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonURL("🔗 View listing", listingDeepLink(b.botUserName, property.ID)),
+			tgbotapi.NewInlineKeyboardButtonData("Unsave", callbackData("delete", property.ID)),
+		),
+	)
+	_, err := b.notify(ctx, tx, msg, category)
+	return err
+}
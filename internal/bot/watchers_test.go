@@ -0,0 +1,101 @@
+package bot
+
+import (
+	"context"
+	"database/sql"
+	"github.com/DATA-DOG/go-sqlmock"
+	"imitation_project/internal/database"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestListingChange tests which changes to a saved listing are worth telling its watchers about
+func TestListingChange(t *testing.T) {
+	updated := func(oldPrice int, oldStatus string) database.PropertyEvent {
+		return database.PropertyEvent{Kind: database.PropertyUpdated, OldPrice: oldPrice, OldStatus: oldStatus}
+	}
+	property := func(price int, status string) database.Property {
+		return database.Property{PricePerMonth: price, Status: status}
+	}
+
+	testCases := []struct {
+		name         string
+		event        database.PropertyEvent
+		property     database.Property
+		wantCategory string
+		wantChange   string
+	}{
+		{"Added", database.PropertyEvent{Kind: database.PropertyAdded}, property(1000, "available"), "", ""},
+		{"Price drop", updated(1000, "available"), property(950, "available"), database.NotifyPriceDrop, ""},
+		{"Small price rise", updated(1000, "available"), property(1040, "available"), "", ""},
+		{"Price rise past the threshold", updated(1000, "available"), property(1050, "available"), database.NotifyPriceRise, ""},
+		{"Under offer", updated(1000, "available"), property(1000, "under_offer"), database.NotifyUnderOffer, ""},
+		{"Let agreed", updated(1000, "under_offer"), property(1000, "let_agreed"), database.NotifyLetAgreed, ""},
+		{"Withdrawn", updated(1000, "available"), property(1000, "withdrawn"), database.NotifyWithdrawn, "taken off the market"},
+		{"Back on the market", updated(1000, "under_offer"), property(1000, "available"), "", ""},
+		{"Nothing changed", updated(1000, "let_agreed"), property(1000, "let_agreed"), "", ""},
+		{"Let agreed with a price drop", updated(1000, "available"), property(950, "let_agreed"), database.NotifyLetAgreed, "let agreed\n💷 The rent dropped from £1000 to £950"},
+		{"Under offer with a price rise", updated(1000, "available"), property(1100, "under_offer"), database.NotifyUnderOffer, "under offer\n📈 The rent rose from £1000 to £1100"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			category, change, ok := listingChange(tc.event, tc.property)
+			if ok != (tc.wantCategory != "") || category != tc.wantCategory {
+				t.Errorf("listingChange() = %q, %v, want %q", category, ok, tc.wantCategory)
+			}
+			if !strings.Contains(change, tc.wantChange) {
+				t.Errorf("listingChange() change = %q, want it to contain %q", change, tc.wantChange)
+			}
+		})
+	}
+}
+
+// TestProcessPropertyEventsWatchers tests that users who saved a listing are told when its rent drops,
+// and aren't sent a saved search alert about it as well
+func TestProcessPropertyEventsWatchers(t *testing.T) {
+	ctx := context.Background()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, db: db, botUserName: "TestBot"}
+
+	mock.ExpectQuery("SELECT (.+) FROM property_events WHERE processed_at IS NULL").
+		WillReturnRows(sqlmock.NewRows(propertyEventColumns).AddRow(13, 7, "updated", 1300, "available"))
+	mock.ExpectQuery("SELECT (.+) FROM user_preferences WHERE alerts_enabled = 1").
+		WillReturnRows(sqlmock.NewRows(userPreferencesColumns).
+			AddRow(301, "{}", 0, 1500, nil, nil, "{}", "Bath", "", nil, 0, time.Now(), true))
+	mock.ExpectQuery("SELECT (.+) FROM properties WHERE id = ?").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows(propertyRowColumns).
+			AddRow(7, "Apartment", 1200, 2, true, "Bath", "Nice apartment", "[]", "http://example.com", "whole", nil, false, 0, false, "[]", nil, 0, 0, nil, nil, "available"))
+	mock.ExpectQuery("SELECT user_id FROM saved_listings").WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(301))
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT (.+) FROM notification_settings").WithArgs(int64(301)).WillReturnError(sql.ErrNoRows)
+	alert := expectOutboxMessage(mock, 301, "price_drop")
+	mock.ExpectExec("UPDATE property_events SET processed_at").WithArgs(13).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := bot.processPropertyEvents(ctx); err != nil {
+		t.Fatalf("processPropertyEvents() returned an error: %v", err)
+	}
+
+	if !strings.Contains(alert.String(), "The rent dropped from £1300 to £1200") {
+		t.Errorf("Unexpected watcher alert: %q", alert.String())
+	}
+	buttons := alert.Keyboard(t).InlineKeyboard[0]
+	if buttons[0].URL == nil || *buttons[0].URL != "https://t.me/TestBot?start=listing_7" {
+		t.Errorf("Expected a link back to the listing, got %+v", buttons[0])
+	}
+	if buttons[1].CallbackData == nil || *buttons[1].CallbackData != callbackData("delete", 7) {
+		t.Errorf("Expected an unsave button, got %+v", buttons[1])
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled database expectations: %s", err)
+	}
+}
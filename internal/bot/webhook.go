@@ -0,0 +1,169 @@
+// Package bot provides the core functionality for the Telegram bot.
+package bot
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// Webhook settings.
+const (
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+	webhookQueueSize    = 100     // updates received but not yet handled
+	maxWebhookBody      = 1 << 20 // bytes; updates are far smaller
+)
+
+// webhookSecretPattern is what Telegram accepts as a secret token.
+var webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// WebhookConfig configures webhook mode, where Telegram sends updates to the bot over HTTPS.
+type WebhookConfig struct {
+	URL        string // public URL Telegram sends updates to, usually behind a proxy that terminates TLS
+	ListenAddr string // address the HTTP server listens on, such as ":8080"
+	Secret     string // token Telegram sends in the X-Telegram-Bot-Api-Secret-Token header, so others can't send updates
+}
+
+// path returns the path updates are sent to.
+func (c WebhookConfig) path() (string, error) {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return "", fmt.Errorf("invalid webhook URL: %w", err)
+	}
+	if u.Path == "" {
+		return "/", nil
+	}
+	return u.Path, nil
+}
+
+// validate checks the settings before they're used.
+func (c WebhookConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("the webhook URL must be an https URL, got %q", c.URL)
+	}
+	if !webhookSecretPattern.MatchString(c.Secret) {
+		return errors.New("the webhook secret must be 1-256 letters, digits, underscores or hyphens")
+	}
+	return nil
+}
+
+// WebhookRegistrar is the part of the Telegram bot API used to register a webhook. *tgbotapi.BotAPI implements it.
+type WebhookRegistrar interface {
+	MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error)
+}
+
+// RegisterWebhook tells Telegram to send updates to the webhook URL, with the secret token.
+func RegisterWebhook(api WebhookRegistrar, cfg WebhookConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+
+	params := tgbotapi.Params{"url": cfg.URL, "secret_token": cfg.Secret}
+	if err := params.AddInterface("allowed_updates", []string{"message", "callback_query"}); err != nil {
+		return err
+	}
+	resp, err := api.MakeRequest("setWebhook", params)
+	if err != nil {
+		return fmt.Errorf("error registering webhook: %w", err)
+	}
+	if !resp.Ok {
+		return fmt.Errorf("error registering webhook: %s", resp.Description)
+	}
+	return nil
+}
+
+// StartWebhook begins the bot's operation in webhook mode. It runs an HTTP server that receives updates
+// from Telegram and handles them like Start does, until ctx is cancelled or the server fails. The webhook
+// must already be registered with RegisterWebhook.
+func (b *Bot) StartWebhook(ctx context.Context, cfg WebhookConfig) error {
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	path, err := cfg.path()
+	if err != nil {
+		return err
+	}
+	log.Printf("Authorised an account %s, listening for updates on %s%s", b.botUserName, cfg.ListenAddr, path)
+
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	updates := make(chan tgbotapi.Update, webhookQueueSize)
+	mux := http.NewServeMux()
+	mux.Handle(path, webhookHandler(ctx, cfg.Secret, updates))
+	server := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+			stop()
+		}
+	}()
+
+	b.run(ctx, updates, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Error stopping the webhook server: %v", err)
+		}
+	})
+
+	select {
+	case err := <-serverErr:
+		return err
+	default:
+		return nil
+	}
+}
+
+// webhookHandler returns an HTTP handler that receives updates from Telegram and sends them to updates,
+// until ctx is cancelled. Requests without the secret token are refused. Telegram resends an update until
+// it gets a 2xx response, so one is only sent once the update is queued.
+func webhookHandler(ctx context.Context, secret string, updates chan<- tgbotapi.Update) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token := r.Header.Get(webhookSecretHeader)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var update tgbotapi.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxWebhookBody)).Decode(&update); err != nil {
+			log.Printf("Error decoding webhook update: %v", err)
+			http.Error(w, "invalid update", http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case updates <- update:
+			w.WriteHeader(http.StatusOK)
+		case <-r.Context().Done():
+			// Telegram gave up waiting, and will send the update again
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		case <-ctx.Done():
+			// The bot is shutting down, so Telegram should send the update again once it's back
+			http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -0,0 +1,165 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// helpUpdate is an update as Telegram sends it when a user sends /help
+const helpUpdate = `{
+	"update_id": 1,
+	"message": {
+		"message_id": 5,
+		"from": {"id": 123, "is_bot": false, "first_name": "Test"},
+		"chat": {"id": 123, "type": "private"},
+		"date": 1700000000,
+		"text": "/help",
+		"entities": [{"type": "bot_command", "offset": 0, "length": 5}]
+	}
+}`
+
+// postUpdate sends a body to the webhook server as Telegram would, with the given secret token
+func postUpdate(t *testing.T, server *httptest.Server, method, secret, body string) int {
+	t.Helper()
+	req, err := http.NewRequest(method, server.URL+"/telegram", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error creating request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(webhookSecretHeader, secret)
+	}
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatalf("Error sending request: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+// TestWebhookHandler tests that the webhook refuses requests without the secret token or a valid update,
+// and that an update it accepts is handled like one received by polling
+func TestWebhookHandler(t *testing.T) {
+	ctx := context.Background()
+	updates := make(chan tgbotapi.Update, 1)
+	mux := http.NewServeMux()
+	mux.Handle("/telegram", webhookHandler(ctx, "s3cret", updates))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	testCases := []struct {
+		name     string
+		method   string
+		secret   string
+		body     string
+		expected int
+	}{
+		{"Missing secret", http.MethodPost, "", helpUpdate, http.StatusForbidden},
+		{"Wrong secret", http.MethodPost, "wrong", helpUpdate, http.StatusForbidden},
+		{"Wrong method", http.MethodGet, "s3cret", "", http.StatusMethodNotAllowed},
+		{"Invalid update", http.MethodPost, "s3cret", "{not json", http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if status := postUpdate(t, server, tc.method, tc.secret, tc.body); status != tc.expected {
+				t.Errorf("Expected status %d, got %d", tc.expected, status)
+			}
+			if len(updates) != 0 {
+				t.Errorf("Expected the update to be refused, but it was queued")
+			}
+		})
+	}
+
+	if status := postUpdate(t, server, http.MethodPost, "s3cret", helpUpdate); status != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", status)
+	}
+
+	var update tgbotapi.Update
+	select {
+	case update = <-updates:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the update to be queued")
+	}
+
+	mockAPI := &MockBotAPI2{}
+	bot := &Bot{api: mockAPI, state: make(map[int64]*UserState)}
+	bot.handleUpdate(ctx, update)
+
+	if len(mockAPI.messages) != 1 {
+		t.Fatalf("Expected 1 reply, got %d", len(mockAPI.messages))
+	}
+	if reply := mockAPI.messages[0]; reply.ChatID != 123 || !strings.Contains(reply.Text, "/search") {
+		t.Errorf("Expected the help message in chat 123, got %q in chat %d", reply.Text, reply.ChatID)
+	}
+}
+
+// TestWebhookHandlerShuttingDown tests that updates are refused once the bot is shutting down, so Telegram sends them again
+func TestWebhookHandlerShuttingDown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	updates := make(chan tgbotapi.Update)
+	mux := http.NewServeMux()
+	mux.Handle("/telegram", webhookHandler(ctx, "s3cret", updates))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	if status := postUpdate(t, server, http.MethodPost, "s3cret", helpUpdate); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503, got %d", status)
+	}
+}
+
+// fakeRegistrar records the webhook registration requests sent to Telegram
+type fakeRegistrar struct {
+	endpoint string
+	params   tgbotapi.Params
+	resp     *tgbotapi.APIResponse
+	err      error
+}
+
+func (f *fakeRegistrar) MakeRequest(endpoint string, params tgbotapi.Params) (*tgbotapi.APIResponse, error) {
+	f.endpoint, f.params = endpoint, params
+	return f.resp, f.err
+}
+
+// TestRegisterWebhook tests that the webhook is registered with its secret token, and that bad settings are refused
+func TestRegisterWebhook(t *testing.T) {
+	cfg := WebhookConfig{URL: "https://bot.example.com/telegram", ListenAddr: ":8080", Secret: "s3cret"}
+
+	api := &fakeRegistrar{resp: &tgbotapi.APIResponse{Ok: true}}
+	if err := RegisterWebhook(api, cfg); err != nil {
+		t.Fatalf("RegisterWebhook() returned an error: %v", err)
+	}
+	if api.endpoint != "setWebhook" || api.params["url"] != cfg.URL || api.params["secret_token"] != "s3cret" {
+		t.Errorf("Unexpected request %s %v", api.endpoint, api.params)
+	}
+	if api.params["allowed_updates"] != `["message","callback_query"]` {
+		t.Errorf("Unexpected allowed updates %s", api.params["allowed_updates"])
+	}
+
+	api = &fakeRegistrar{resp: &tgbotapi.APIResponse{Ok: false, Description: "bad webhook"}}
+	if err := RegisterWebhook(api, cfg); err == nil || !strings.Contains(err.Error(), "bad webhook") {
+		t.Errorf("Expected Telegram's error to be returned, got %v", err)
+	}
+	api = &fakeRegistrar{err: errors.New("network down")}
+	if err := RegisterWebhook(api, cfg); err == nil {
+		t.Error("Expected an error when the request fails")
+	}
+
+	invalid := []WebhookConfig{
+		{URL: "http://bot.example.com/telegram", Secret: "s3cret"},
+		{URL: "https://bot.example.com/telegram", Secret: ""},
+		{URL: "https://bot.example.com/telegram", Secret: "not allowed!"},
+	}
+	for _, c := range invalid {
+		api = &fakeRegistrar{resp: &tgbotapi.APIResponse{Ok: true}}
+		if err := RegisterWebhook(api, c); err == nil || api.endpoint != "" {
+			t.Errorf("Expected %+v to be refused without a request", c)
+		}
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"github.com/joho/godotenv"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 )
 
 func LoadConfig() {
@@ -16,3 +18,37 @@ func LoadConfig() {
 func GetEnv(key string) string {
 	return os.Getenv(key)
 }
+
+// GetEnvInt returns the integer value of an environment variable.
+// It reports false if the variable is unset or is not a valid integer.
+func GetEnvInt(key string) (int, bool) {
+	value := os.Getenv(key)
+	if value == "" {
+		return 0, false
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Ignoring invalid value for %s: %q", key, value)
+		return 0, false
+	}
+	return i, true
+}
+
+// GetEnvIDs returns the comma-separated IDs in an environment variable, such as Telegram user IDs.
+// Invalid IDs are logged and skipped.
+func GetEnvIDs(key string) []int64 {
+	var ids []int64
+	for _, field := range strings.Split(os.Getenv(key), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		id, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			log.Printf("Ignoring invalid ID in %s: %q", key, field)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -23,6 +23,41 @@ func TestGetEnv(t *testing.T) {
 	}
 }
 
+// TestGetEnvInt tests the GetEnvInt function.
+func TestGetEnvInt(t *testing.T) {
+	os.Setenv("TEST_ENV_INT", "14")
+	defer os.Unsetenv("TEST_ENV_INT")
+
+	value, ok := GetEnvInt("TEST_ENV_INT")
+	if !ok || value != 14 {
+		t.Errorf("GetEnvInt() = %v, %v, want 14, true", value, ok)
+	}
+
+	os.Setenv("TEST_ENV_INT", "two weeks")
+	if _, ok := GetEnvInt("TEST_ENV_INT"); ok {
+		t.Error("GetEnvInt() for invalid integer should report false")
+	}
+
+	if _, ok := GetEnvInt("NON_EXISTENT_VAR"); ok {
+		t.Error("GetEnvInt() for non-existent variable should report false")
+	}
+}
+
+// TestGetEnvIDs tests the GetEnvIDs function.
+func TestGetEnvIDs(t *testing.T) {
+	os.Setenv("TEST_ENV_IDS", "123, 456,,abc,-789")
+	defer os.Unsetenv("TEST_ENV_IDS")
+
+	ids := GetEnvIDs("TEST_ENV_IDS")
+	if len(ids) != 3 || ids[0] != 123 || ids[1] != 456 || ids[2] != -789 {
+		t.Errorf("GetEnvIDs() = %v, want [123 456 -789]", ids)
+	}
+
+	if ids := GetEnvIDs("NON_EXISTENT_VAR"); len(ids) != 0 {
+		t.Errorf("GetEnvIDs() for non-existent variable = %v, want none", ids)
+	}
+}
+
 // TestLoadConfig tests the LoadConfig function.
 func TestLoadConfig(t *testing.T) {
 	// Create a temporary .env file
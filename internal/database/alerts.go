@@ -0,0 +1,201 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Property event kinds.
+const (
+	PropertyAdded   = "added"
+	PropertyUpdated = "updated"
+)
+
+// PropertyEvent records that a property was added or updated. Events are processed
+// once, to alert users whose saved search the property matches.
+type PropertyEvent struct {
+	ID         int
+	PropertyID int
+	Kind       string
+	// OldPrice and OldStatus are the property's price and status before an update
+	OldPrice  int
+	OldStatus string
+}
+
+// createAlertTables creates the tables behind listing alerts: the queue of property
+// changes to process, and the alerts already sent to each user.
+func createAlertTables(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS property_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		property_id INTEGER NOT NULL,
+		kind TEXT NOT NULL,
+		old_price INTEGER,
+		old_status TEXT,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		processed_at TIMESTAMP,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		last_error TEXT NOT NULL DEFAULT '',
+		FOREIGN KEY (property_id) REFERENCES properties(id)
+	)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS alert_deliveries (
+		user_id INTEGER NOT NULL,
+		property_id INTEGER NOT NULL,
+		sent_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, property_id),
+		FOREIGN KEY (property_id) REFERENCES properties(id)
+	)
+	`)
+	return err
+}
+
+// recordPropertyEvent queues a property change for alert processing.
+func recordPropertyEvent(ctx context.Context, db Execer, e PropertyEvent) error {
+	var oldPrice sql.NullInt64
+	var oldStatus sql.NullString
+	if e.Kind == PropertyUpdated {
+		oldPrice = sql.NullInt64{Int64: int64(e.OldPrice), Valid: true}
+		oldStatus = sql.NullString{String: e.OldStatus, Valid: true}
+	}
+
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO property_events (property_id, kind, old_price, old_status)
+        VALUES (?, ?, ?, ?)
+    `, e.PropertyID, e.Kind, oldPrice, oldStatus)
+	return err
+}
+
+// GetPendingPropertyEvents returns up to limit unprocessed property events, oldest first.
+func GetPendingPropertyEvents(ctx context.Context, db *sql.DB, limit int) ([]PropertyEvent, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT id, property_id, kind, old_price, old_status FROM property_events
+        WHERE processed_at IS NULL
+        ORDER BY id
+        LIMIT ?
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanPropertyEvents(rows)
+}
+
+// GetPropertyEventsAfter returns the property events after the given event ID, oldest first.
+func GetPropertyEventsAfter(ctx context.Context, db *sql.DB, afterID int) ([]PropertyEvent, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT id, property_id, kind, old_price, old_status FROM property_events
+        WHERE id > ?
+        ORDER BY id
+    `, afterID)
+	if err != nil {
+		return nil, err
+	}
+	return scanPropertyEvents(rows)
+}
+
+// scanPropertyEvents reads and closes rows of property events.
+func scanPropertyEvents(rows *sql.Rows) ([]PropertyEvent, error) {
+	defer rows.Close()
+
+	var events []PropertyEvent
+	for rows.Next() {
+		var e PropertyEvent
+		var oldPrice sql.NullInt64
+		var oldStatus sql.NullString
+		if err := rows.Scan(&e.ID, &e.PropertyID, &e.Kind, &oldPrice, &oldStatus); err != nil {
+			return nil, err
+		}
+		e.OldPrice = int(oldPrice.Int64)
+		e.OldStatus = oldStatus.String
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// MarkPropertyEventProcessed records that a property event has been processed.
+func MarkPropertyEventProcessed(ctx context.Context, db Execer, eventID int) error {
+	_, err := db.ExecContext(ctx, `
+        UPDATE property_events SET processed_at = CURRENT_TIMESTAMP
+        WHERE id = ?
+    `, eventID)
+	return err
+}
+
+// RecordPropertyEventFailure records that processing a property event failed, so it's retried at the next check.
+// After maxAttempts failures the event is given up on: it's marked as processed, keeping its last error for operators.
+func RecordPropertyEventFailure(ctx context.Context, db *sql.DB, eventID int, lastError string, maxAttempts int) error {
+	_, err := db.ExecContext(ctx, `
+        UPDATE property_events SET attempts = attempts + 1, last_error = ?,
+            processed_at = CASE WHEN attempts + 1 >= ? THEN CURRENT_TIMESTAMP END
+        WHERE id = ?
+    `, lastError, maxAttempts, eventID)
+	return err
+}
+
+// GetAlertSubscribers returns the saved preferences of every user with instant alerts turned on.
+// Users who get a digest instead are left out.
+func GetAlertSubscribers(ctx context.Context, db *sql.DB) ([]UserPreferences, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+userPreferencesColumns+`
+		FROM user_preferences WHERE alerts_enabled = 1
+		AND user_id NOT IN (SELECT user_id FROM digest_subscriptions)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subscribers []UserPreferences
+	for rows.Next() {
+		prefs, err := scanUserPreferences(rows)
+		if err != nil {
+			return nil, err
+		}
+		subscribers = append(subscribers, prefs)
+	}
+
+	return subscribers, rows.Err()
+}
+
+// SetAlertsEnabled turns alerts for a user's saved search on or off.
+// It returns sql.ErrNoRows if the user has no saved search.
+func SetAlertsEnabled(ctx context.Context, db *sql.DB, userID int64, enabled bool) error {
+	result, err := db.ExecContext(ctx, `
+        UPDATE user_preferences SET alerts_enabled = ?
+        WHERE user_id = ?
+    `, enabled, userID)
+	if err != nil {
+		return err
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RecordAlertDelivery records that a user is being alerted about a property. It returns
+// false if the user was already alerted about it, so each listing is only sent once.
+func RecordAlertDelivery(ctx context.Context, db Execer, userID int64, propertyID int) (bool, error) {
+	result, err := db.ExecContext(ctx, `
+        INSERT OR IGNORE INTO alert_deliveries (user_id, property_id)
+        VALUES (?, ?)
+    `, userID, propertyID)
+	if err != nil {
+		return false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return inserted > 0, nil
+}
@@ -2,47 +2,206 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"log"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// Listing kinds distinguish whole properties from single rooms in shared houses.
+const (
+	ListingWhole = "whole"
+	ListingRoom  = "room"
+)
+
+// Listing statuses track a property through to being let.
+const (
+	StatusAvailable  = "available"
+	StatusUnderOffer = "under_offer"
+	StatusLetAgreed  = "let_agreed"
+	StatusWithdrawn  = "withdrawn"
+)
+
+// listedClause matches properties that are still on the market. Let and withdrawn
+// properties are left out of searches and new matches.
+const listedClause = "status NOT IN ('" + StatusLetAgreed + "', '" + StatusWithdrawn + "')"
+
+// DateLayout is the format used to store calendar dates such as availability and move-in dates.
+const DateLayout = "2006-01-02"
+
 // Property represents a rental property with its attributes.
+// For room listings, PricePerMonth is the rent for the room
+// and ParentPropertyID refers to the shared house the room belongs to.
+// A zero AvailableFrom means the property is available now,
+// and a zero tenancy length means there is no limit.
 type Property struct {
-	ID            int
-	Type          string
-	PricePerMonth int
-	Bedrooms      int
-	Furnished     bool
-	Location      string
-	Description   string
-	PhotoURLs     []string
-	WebLink       string
+	ID               int
+	Type             string
+	PricePerMonth    int
+	Bedrooms         int
+	Furnished        bool
+	Location         string
+	Description      string
+	PhotoURLs        []string
+	WebLink          string
+	ListingKind      string
+	ParentPropertyID int
+	BillsIncluded    bool
+	Housemates       int
+	Ensuite          bool
+	SharedFacilities []string
+	AvailableFrom    time.Time
+	MinTenancyMonths int
+	MaxTenancyMonths int
+	Latitude         float64 // zero if the location is unknown
+	Longitude        float64
+	Status           string // StatusAvailable unless set
+}
+
+// propertyColumns lists the properties columns in the order scanProperty expects.
+var propertyColumns = []string{
+	"id", "type", "price_per_month", "bedrooms", "furnished", "location", "description", "photo_urls", "web_link",
+	"listing_kind", "parent_property_id", "bills_included", "housemates", "ensuite", "shared_facilities",
+	"available_from", "min_tenancy_months", "max_tenancy_months", "latitude", "longitude", "status",
+}
+
+// propertyColumnList returns the property columns for a SELECT, qualified with the table alias if given.
+func propertyColumnList(alias string) string {
+	if alias == "" {
+		return strings.Join(propertyColumns, ", ")
+	}
+	qualified := make([]string, len(propertyColumns))
+	for i, column := range propertyColumns {
+		qualified[i] = alias + "." + column
+	}
+	return strings.Join(qualified, ", ")
+}
+
+// rowScanner is implemented by *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// Execer runs statements. It is implemented by *sql.DB and *sql.Tx, so functions that take one
+// can write on their own or as part of a transaction.
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// scanProperty reads a property selected with propertyColumnList.
+func scanProperty(row rowScanner) (Property, error) {
+	var p Property
+	var photoURLsJSON string
+	var listingKind, sharedFacilitiesJSON sql.NullString
+	var parentID sql.NullInt64
+	var billsIncluded, ensuite sql.NullBool
+	var housemates sql.NullInt64
+	var availableFrom sql.NullString
+	var minTenancy, maxTenancy sql.NullInt64
+	var latitude, longitude sql.NullFloat64
+	var status sql.NullString
+	err := row.Scan(&p.ID, &p.Type, &p.PricePerMonth, &p.Bedrooms, &p.Furnished, &p.Location, &p.Description, &photoURLsJSON, &p.WebLink,
+		&listingKind, &parentID, &billsIncluded, &housemates, &ensuite, &sharedFacilitiesJSON,
+		&availableFrom, &minTenancy, &maxTenancy, &latitude, &longitude, &status)
+	if err != nil {
+		return p, fmt.Errorf("error scanning row: %w", err)
+	}
+
+	err = json.Unmarshal([]byte(photoURLsJSON), &p.PhotoURLs)
+	if err != nil {
+		return p, fmt.Errorf("error unmarshaling photo URLs: %w", err)
+	}
+
+	p.ListingKind = ListingWhole
+	if listingKind.Valid && listingKind.String != "" {
+		p.ListingKind = listingKind.String
+	}
+	p.ParentPropertyID = int(parentID.Int64)
+	p.BillsIncluded = billsIncluded.Bool
+	p.Housemates = int(housemates.Int64)
+	p.Ensuite = ensuite.Bool
+	if sharedFacilitiesJSON.Valid && sharedFacilitiesJSON.String != "" {
+		err = json.Unmarshal([]byte(sharedFacilitiesJSON.String), &p.SharedFacilities)
+		if err != nil {
+			return p, fmt.Errorf("error unmarshaling shared facilities: %w", err)
+		}
+	}
+
+	p.AvailableFrom, err = dateFromNull(availableFrom)
+	if err != nil {
+		return p, fmt.Errorf("error parsing available from date: %w", err)
+	}
+	p.MinTenancyMonths = int(minTenancy.Int64)
+	p.MaxTenancyMonths = int(maxTenancy.Int64)
+	p.Latitude = latitude.Float64
+	p.Longitude = longitude.Float64
+	p.Status = StatusAvailable
+	if status.Valid && status.String != "" {
+		p.Status = status.String
+	}
+
+	return p, nil
 }
 
 // UserPreferences represents a user's search preferences for rental properties.
 type UserPreferences struct {
-	UserID         int64
-	PropertyTypes  map[string]bool
-	BedroomOptions map[string]bool
-	MinPrice       int
-	MaxPrice       int
-	Location       string
-	Furnished      map[string]bool
-	LastSearch     time.Time
+	UserID        int64
+	PropertyTypes map[string]bool
+	MinBedrooms   *int // nil means no lower bound
+	MaxBedrooms   *int // nil means no upper bound
+	MinPrice      int
+	MaxPrice      int
+	Location      string
+	Furnished     map[string]bool
+	ListingKind   string    // ListingWhole, ListingRoom or empty for either
+	MoveInDate    time.Time // zero means flexible
+	TenancyMonths int       // zero means any tenancy length
+	LastSearch    time.Time
+	AlertsEnabled bool // whether new properties matching the preferences are sent to the user
+}
+
+// PropertyType is an entry in the property type taxonomy.
+// Types that share a Group can be selected together, e.g. "Any house".
+type PropertyType struct {
+	Code      string
+	Label     string
+	Group     string
+	SortOrder int
+}
+
+// DefaultPropertyTypes is the taxonomy seeded into a new database.
+var DefaultPropertyTypes = []PropertyType{
+	{Code: "flat", Label: "Flat", Group: "flat", SortOrder: 10},
+	{Code: "studio", Label: "Studio", Group: "flat", SortOrder: 20},
+	{Code: "maisonette", Label: "Maisonette", Group: "flat", SortOrder: 30},
+	{Code: "house", Label: "House", Group: "house", SortOrder: 40},
+	{Code: "bungalow", Label: "Bungalow", Group: "house", SortOrder: 50},
+	{Code: "hmo", Label: "Shared house (HMO)", Group: "shared", SortOrder: 60},
+	{Code: "annexe", Label: "Annexe", Group: "other", SortOrder: 70},
 }
 
 var db *sql.DB // Global database connection
 
+// busyTimeoutMillis is how long a connection waits for another connection's write to finish
+// before giving up with "database is locked".
+const busyTimeoutMillis = 5000
+
+// dataSourceName adds the connection settings to a database path. Writers wait for each other
+// rather than failing, and in WAL mode readers don't wait for writers at all.
+func dataSourceName(dbPath string) string {
+	return fmt.Sprintf("%s?_busy_timeout=%d&_journal_mode=WAL", dbPath, busyTimeoutMillis)
+}
+
 // InitDB initializes the database connection and creates necessary tables.
 func InitDB(dbPath string) (*sql.DB, error) {
 	var err error
-	db, err = sql.Open("sqlite3", dbPath)
+	db, err = sql.Open("sqlite3", dataSourceName(dbPath))
 	if err != nil {
 		return nil, err
 	}
@@ -58,7 +217,19 @@ func InitDB(dbPath string) (*sql.DB, error) {
 			location TEXT,
 			description TEXT,
 			photo_urls TEXT,
-            web_link TEXT
+			web_link TEXT,
+			listing_kind TEXT NOT NULL DEFAULT 'whole',
+			parent_property_id INTEGER REFERENCES properties(id),
+			bills_included BOOLEAN NOT NULL DEFAULT 0,
+			housemates INTEGER NOT NULL DEFAULT 0,
+			ensuite BOOLEAN NOT NULL DEFAULT 0,
+			shared_facilities TEXT NOT NULL DEFAULT '[]',
+			available_from TEXT,
+			min_tenancy_months INTEGER NOT NULL DEFAULT 0,
+			max_tenancy_months INTEGER NOT NULL DEFAULT 0,
+			latitude REAL,
+			longitude REAL,
+			status TEXT NOT NULL DEFAULT 'available'
 		)
 	`)
 	if err != nil {
@@ -72,10 +243,15 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		    property_type TEXT,
 		    min_price INTEGER,
 		    max_price INTEGER,
-		    bedrooms INTEGER,
+		    min_bedrooms INTEGER,
+		    max_bedrooms INTEGER,
 		    furnished BOOLEAN,
 		    location TEXT,
-		    last_search TIMESTAMP
+		    listing_kind TEXT NOT NULL DEFAULT '',
+		    move_in_date TEXT,
+		    tenancy_months INTEGER NOT NULL DEFAULT 0,
+		    last_search TIMESTAMP,
+		    alerts_enabled BOOLEAN NOT NULL DEFAULT 1
 		)
 	`)
 
@@ -93,26 +269,160 @@ func InitDB(dbPath string) (*sql.DB, error) {
 		return nil, err
 	}
 
+	// Create the property_types reference table and seed the default taxonomy
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS property_types (
+		code TEXT PRIMARY KEY,
+		label TEXT NOT NULL,
+		group_name TEXT NOT NULL,
+		sort_order INTEGER NOT NULL DEFAULT 0
+	)
+	`)
 	if err != nil {
 		return nil, err
 	}
+	for _, t := range DefaultPropertyTypes {
+		_, err = db.Exec(`
+			INSERT OR IGNORE INTO property_types (code, label, group_name, sort_order)
+			VALUES (?, ?, ?, ?)
+		`, t.Code, t.Label, t.Group, t.SortOrder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err = createHiddenListingsTable(db); err != nil {
+		return nil, err
+	}
+	if err = createListingImpressionsTable(db); err != nil {
+		return nil, err
+	}
+	if err = createAlertTables(db); err != nil {
+		return nil, err
+	}
+	if err = createDigestSubscriptionsTable(db); err != nil {
+		return nil, err
+	}
+	if err = createNotificationTables(db); err != nil {
+		return nil, err
+	}
+	if err = createOutboxTable(db); err != nil {
+		return nil, err
+	}
+	if err = createJobTables(db); err != nil {
+		return nil, err
+	}
+
 	return db, nil
 }
 
-// AddProperty inserts a new property into the database.
-func AddProperty(db *sql.DB, p Property) error {
+// AddProperty inserts a new property into the database. The property and its event are written in one
+// transaction, so every new property is considered for alerts.
+func AddProperty(ctx context.Context, db *sql.DB, p Property) error {
+	args, err := propertyWriteArgs(p)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO properties (type, price_per_month, bedrooms, furnished, location, description, photo_urls, web_link,
+            listing_kind, parent_property_id, bills_included, housemates, ensuite, shared_facilities,
+            available_from, min_tenancy_months, max_tenancy_months, latitude, longitude, status)
+        VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+    `, args...)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if err = recordPropertyEvent(ctx, tx, PropertyEvent{PropertyID: int(id), Kind: PropertyAdded}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateProperty updates an existing property, so users can be alerted if it now matches their search.
+// The price and status before the update are kept with the event, so price drops and status changes can be reported.
+// The previous values are read, and the property and its event written, in one transaction, so concurrent updates
+// each record the values they replaced.
+// It returns sql.ErrNoRows if the property doesn't exist.
+func UpdateProperty(ctx context.Context, db *sql.DB, p Property) error {
+	args, err := propertyWriteArgs(p)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	previous, err := scanProperty(tx.QueryRowContext(ctx, "SELECT "+propertyColumnList("")+" FROM properties WHERE id = ?", p.ID))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE properties SET type = ?, price_per_month = ?, bedrooms = ?, furnished = ?, location = ?, description = ?,
+            photo_urls = ?, web_link = ?, listing_kind = ?, parent_property_id = ?, bills_included = ?, housemates = ?,
+            ensuite = ?, shared_facilities = ?, available_from = ?, min_tenancy_months = ?, max_tenancy_months = ?,
+            latitude = ?, longitude = ?, status = ?
+        WHERE id = ?
+    `, append(args, p.ID)...)
+	if err != nil {
+		return err
+	}
+
+	err = recordPropertyEvent(ctx, tx, PropertyEvent{
+		PropertyID: p.ID,
+		Kind:       PropertyUpdated,
+		OldPrice:   previous.PricePerMonth,
+		OldStatus:  previous.Status,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// propertyWriteArgs returns the values written for a property, in the column order
+// used by AddProperty and UpdateProperty.
+func propertyWriteArgs(p Property) ([]interface{}, error) {
 	validPhotoURLs := validatePhotoURLs(p.PhotoURLs)
 	photoURLsJSON, err := json.Marshal(validPhotoURLs)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, err = db.Exec(`
-        INSERT INTO properties (type, price_per_month, bedrooms, furnished, location, description, photo_urls, web_link)
-        VALUES(?, ?, ?, ?, ?, ?, ?, ?)
-    `, p.Type, p.PricePerMonth, p.Bedrooms, p.Furnished, p.Location, p.Description, string(photoURLsJSON), p.WebLink)
+	listingKind := p.ListingKind
+	if listingKind == "" {
+		listingKind = ListingWhole
+	}
+	sharedFacilitiesJSON, err := json.Marshal(p.SharedFacilities)
+	if err != nil {
+		return nil, err
+	}
+	var parentID sql.NullInt64
+	if p.ParentPropertyID > 0 {
+		parentID = sql.NullInt64{Int64: int64(p.ParentPropertyID), Valid: true}
+	}
+	status := p.Status
+	if status == "" {
+		status = StatusAvailable
+	}
 
-	return err
+	return []interface{}{p.Type, p.PricePerMonth, p.Bedrooms, p.Furnished, p.Location, p.Description, string(photoURLsJSON), p.WebLink,
+		listingKind, parentID, p.BillsIncluded, p.Housemates, p.Ensuite, string(sharedFacilitiesJSON),
+		nullableDate(p.AvailableFrom), p.MinTenancyMonths, p.MaxTenancyMonths, nullableCoordinate(p.Latitude), nullableCoordinate(p.Longitude), status}, nil
 }
 
 // validatePhotoURLs filters out invalid URLs from the given slice.
@@ -133,12 +443,22 @@ func isValidURL(urlString string) bool {
 		(strings.HasPrefix(u.Scheme, "http") || strings.HasPrefix(u.Scheme, "https"))
 }
 
+// GetProperty retrieves a single property by its ID.
+func GetProperty(ctx context.Context, db *sql.DB, id int) (Property, error) {
+	row := db.QueryRowContext(ctx, "SELECT "+propertyColumnList("")+" FROM properties WHERE id = ?", id)
+	return scanProperty(row)
+}
+
 // GetProperties retrieves properties from the database based on the provided filters.
 // It constructs a dynamic SQL query to apply the filters and returns matching properties.
-func GetProperties(db *sql.DB, filters map[string]interface{}) ([]Property, error) {
-	query := "SELECT id, type, price_per_month, bedrooms, furnished, location, description, photo_urls, web_link FROM properties WHERE 1=1"
+func GetProperties(ctx context.Context, db *sql.DB, filters map[string]interface{}) ([]Property, error) {
+	query := "SELECT " + propertyColumnList("") + " FROM properties WHERE 1=1"
 	var args []interface{}
 
+	if v, ok := filters["id"].(int); ok {
+		query += " AND id = ?"
+		args = append(args, v)
+	}
 	if v, ok := filters["types"].([]string); ok && len(v) > 0 {
 		placeholders := make([]string, len(v))
 		for i, t := range v {
@@ -156,13 +476,13 @@ func GetProperties(db *sql.DB, filters map[string]interface{}) ([]Property, erro
 		query += " AND price_per_month <= ?"
 		args = append(args, v)
 	}
-	if v, ok := filters["bedrooms"].([]int); ok && len(v) > 0 {
-		placeholders := make([]string, len(v))
-		for i, b := range v {
-			placeholders[i] = "?"
-			args = append(args, b)
-		}
-		query += " AND bedrooms IN (" + strings.Join(placeholders, ",") + ")"
+	if v, ok := filters["min_bedrooms"].(int); ok {
+		query += " AND bedrooms >= ?"
+		args = append(args, v)
+	}
+	if v, ok := filters["max_bedrooms"].(int); ok {
+		query += " AND bedrooms <= ?"
+		args = append(args, v)
 	}
 	if v, ok := filters["location"].(string); ok {
 		query += " AND LOWER(location) = LOWER(?)"
@@ -172,10 +492,47 @@ func GetProperties(db *sql.DB, filters map[string]interface{}) ([]Property, erro
 		query += " AND furnished = ?"
 		args = append(args, v)
 	}
+	if v, ok := filters["listing_kind"].(string); ok {
+		query += " AND listing_kind = ?"
+		args = append(args, v)
+	}
+	if v, ok := filters["parent_property_id"].(int); ok {
+		query += " AND parent_property_id = ?"
+		args = append(args, v)
+	}
+	if v, ok := filters["move_in"].(time.Time); ok && !v.IsZero() {
+		// Properties available within the tolerance window after the move-in date still match
+		tolerance, _ := filters["move_in_tolerance_days"].(int)
+		query += " AND (available_from IS NULL OR available_from <= ?)"
+		args = append(args, v.AddDate(0, 0, tolerance).Format(DateLayout))
+	}
+	if v, ok := filters["tenancy_months"].(int); ok && v > 0 {
+		query += " AND (min_tenancy_months = 0 OR min_tenancy_months <= ?) AND (max_tenancy_months = 0 OR max_tenancy_months >= ?)"
+		args = append(args, v, v)
+	}
+	// Leave out listings the user has hidden
+	if v, ok := filters["exclude_hidden_for"].(int64); ok {
+		query += " AND id NOT IN (SELECT property_id FROM hidden_listings WHERE user_id = ?)"
+		args = append(args, v)
+	}
+	// Leave out listings that are no longer on the market
+	if v, ok := filters["listed_only"].(bool); ok && v {
+		query += " AND " + listedClause
+	}
+	// As listed_only, but keep listings the user was already alerted about, so they can be told they were let
+	if v, ok := filters["listed_or_alerted_for"].(int64); ok {
+		query += " AND (" + listedClause + " OR id IN (SELECT property_id FROM alert_deliveries WHERE user_id = ?))"
+		args = append(args, v)
+	}
+	// Leave out listings the user has already been shown
+	if v, ok := filters["exclude_seen_by"].(int64); ok {
+		query += " AND id NOT IN (SELECT property_id FROM listing_impressions WHERE user_id = ?)"
+		args = append(args, v)
+	}
 
 	log.Printf("Executing query: %s with args: %v", query, args)
 
-	rows, err := db.Query(query, args...)
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error executing query: %w", err)
 	}
@@ -183,18 +540,10 @@ func GetProperties(db *sql.DB, filters map[string]interface{}) ([]Property, erro
 
 	var properties []Property
 	for rows.Next() {
-		var p Property
-		var photoURLsJSON string
-		err := rows.Scan(&p.ID, &p.Type, &p.PricePerMonth, &p.Bedrooms, &p.Furnished, &p.Location, &p.Description, &photoURLsJSON, &p.WebLink)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning row: %w", err)
-		}
-
-		err = json.Unmarshal([]byte(photoURLsJSON), &p.PhotoURLs)
+		p, err := scanProperty(rows)
 		if err != nil {
-			return nil, fmt.Errorf("error unmarshaling photo URLs: %w", err)
+			return nil, err
 		}
-
 		properties = append(properties, p)
 	}
 
@@ -210,7 +559,7 @@ func CloseDB() {
 
 // UpdateExistingDB updates the existing database schema if necessary.
 func UpdateExistingDB(dbPath string) error {
-	db, err := sql.Open("sqlite3", dbPath)
+	db, err := sql.Open("sqlite3", dataSourceName(dbPath))
 	if err != nil {
 		return err
 	}
@@ -241,16 +590,170 @@ func UpdateExistingDB(dbPath string) error {
 		}
 	}
 
+	// Bedroom preferences moved from a JSON set to min/max bounds
+	if err = addColumnIfMissing(db, "user_preferences", "min_bedrooms", "INTEGER"); err != nil {
+		return err
+	}
+	if err = addColumnIfMissing(db, "user_preferences", "max_bedrooms", "INTEGER"); err != nil {
+		return err
+	}
+	if err = migrateBedroomPreferences(db); err != nil {
+		return err
+	}
+
+	// Room listings, availability and coordinates
+	newPropertyColumns := []struct{ name, definition string }{
+		{"listing_kind", "TEXT NOT NULL DEFAULT 'whole'"},
+		{"parent_property_id", "INTEGER REFERENCES properties(id)"},
+		{"bills_included", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"housemates", "INTEGER NOT NULL DEFAULT 0"},
+		{"ensuite", "BOOLEAN NOT NULL DEFAULT 0"},
+		{"shared_facilities", "TEXT NOT NULL DEFAULT '[]'"},
+		{"available_from", "TEXT"},
+		{"min_tenancy_months", "INTEGER NOT NULL DEFAULT 0"},
+		{"max_tenancy_months", "INTEGER NOT NULL DEFAULT 0"},
+		{"latitude", "REAL"},
+		{"longitude", "REAL"},
+		{"status", "TEXT NOT NULL DEFAULT 'available'"},
+	}
+	for _, column := range newPropertyColumns {
+		if err = addColumnIfMissing(db, "properties", column.name, column.definition); err != nil {
+			return err
+		}
+	}
+	if err = addColumnIfMissing(db, "user_preferences", "listing_kind", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err = addColumnIfMissing(db, "user_preferences", "move_in_date", "TEXT"); err != nil {
+		return err
+	}
+	if err = addColumnIfMissing(db, "user_preferences", "tenancy_months", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = addColumnIfMissing(db, "user_preferences", "alerts_enabled", "BOOLEAN NOT NULL DEFAULT 1"); err != nil {
+		return err
+	}
+
+	// Price and status before an update, for digests
+	if err = addColumnIfMissing(db, "property_events", "old_price", "INTEGER"); err != nil {
+		return err
+	}
+	if err = addColumnIfMissing(db, "property_events", "old_status", "TEXT"); err != nil {
+		return err
+	}
+	// Failed attempts at processing an event
+	if err = addColumnIfMissing(db, "property_events", "attempts", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = addColumnIfMissing(db, "property_events", "last_error", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// SaveUserPreferences saves or updates a user's search preferences in the database.
-func SaveUserPreferences(db *sql.DB, prefs UserPreferences) error {
-	propertyTypesJSON, err := json.Marshal(prefs.PropertyTypes)
+// hasColumn reports whether a table has a column.
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count)
+	return count > 0, err
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is already present.
+func addColumnIfMissing(db *sql.DB, table, column, definition string) error {
+	exists, err := hasColumn(db, table, column)
+	if err != nil || exists {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
+
+// migrateBedroomPreferences copies bedroom preferences saved in the old bedrooms column into the
+// min/max range, then clears the old value so it's only copied once. The old column held either a
+// count or a JSON set of options such as {"2":true,"5+":true}.
+func migrateBedroomPreferences(db *sql.DB) error {
+	exists, err := hasColumn(db, "user_preferences", "bedrooms")
+	if err != nil || !exists {
+		return err
+	}
+
+	_, err = db.Exec(`
+		UPDATE user_preferences SET min_bedrooms = bedrooms, max_bedrooms = bedrooms, bedrooms = NULL
+		WHERE min_bedrooms IS NULL AND typeof(bedrooms) = 'integer'
+	`)
 	if err != nil {
 		return err
 	}
-	bedroomOptionsJSON, err := json.Marshal(prefs.BedroomOptions)
+
+	rows, err := db.Query("SELECT user_id, bedrooms FROM user_preferences WHERE min_bedrooms IS NULL AND typeof(bedrooms) = 'text'")
+	if err != nil {
+		return err
+	}
+	ranges := make(map[int64][2]sql.NullInt64)
+	for rows.Next() {
+		var userID int64
+		var options string
+		if err = rows.Scan(&userID, &options); err != nil {
+			rows.Close()
+			return err
+		}
+		min, max := legacyBedroomRange(options)
+		ranges[userID] = [2]sql.NullInt64{nullableInt(min), nullableInt(max)}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for userID, r := range ranges {
+		_, err = db.Exec("UPDATE user_preferences SET min_bedrooms = ?, max_bedrooms = ?, bedrooms = NULL WHERE user_id = ?", r[0], r[1], userID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// legacyBedroomRange converts a JSON set of bedroom options into the range they cover. "Studio" is
+// no bedrooms and an open-ended option such as "5+" removes the upper bound. Nothing selected, or a
+// value that can't be read, is any number of bedrooms.
+func legacyBedroomRange(options string) (min, max *int) {
+	var selected map[string]bool
+	if err := json.Unmarshal([]byte(options), &selected); err != nil {
+		return nil, nil
+	}
+	openEnded := false
+	for option, ok := range selected {
+		if !ok {
+			continue
+		}
+		count := 0
+		if option != "Studio" {
+			n, err := strconv.Atoi(strings.TrimSuffix(option, "+"))
+			if err != nil {
+				continue
+			}
+			count = n
+			openEnded = openEnded || strings.HasSuffix(option, "+")
+		}
+		if min == nil || count < *min {
+			min = &count
+		}
+		if max == nil || count > *max {
+			max = &count
+		}
+	}
+	if openEnded {
+		max = nil
+	}
+	return min, max
+}
+
+// SaveUserPreferences saves or updates a user's search preferences in the database.
+// Alerts are on for new preferences, and saving preferences again keeps the user's alert setting.
+func SaveUserPreferences(ctx context.Context, db *sql.DB, prefs UserPreferences) error {
+	propertyTypesJSON, err := json.Marshal(prefs.PropertyTypes)
 	if err != nil {
 		return err
 	}
@@ -258,32 +761,83 @@ func SaveUserPreferences(db *sql.DB, prefs UserPreferences) error {
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(`
+	_, err = db.ExecContext(ctx, `
 		INSERT OR REPLACE INTO user_preferences
-		(user_id, property_type, min_price, max_price, bedrooms, furnished, location, last_search)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
-	`, prefs.UserID, string(propertyTypesJSON), prefs.MinPrice, prefs.MaxPrice, string(bedroomOptionsJSON), string(furnishedJSON), prefs.Location, time.Now())
+		(user_id, property_type, min_price, max_price, min_bedrooms, max_bedrooms, furnished, location, listing_kind, move_in_date, tenancy_months, last_search, alerts_enabled)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, COALESCE((SELECT alerts_enabled FROM user_preferences WHERE user_id = ?), 1))
+	`, prefs.UserID, string(propertyTypesJSON), prefs.MinPrice, prefs.MaxPrice, nullableInt(prefs.MinBedrooms), nullableInt(prefs.MaxBedrooms), string(furnishedJSON), prefs.Location, prefs.ListingKind, nullableDate(prefs.MoveInDate), prefs.TenancyMonths, time.Now(), prefs.UserID)
 	return err
 }
 
+// nullableInt converts an optional integer to a value suitable for a nullable column.
+func nullableInt(v *int) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(*v), Valid: true}
+}
+
+// intFromNull converts a nullable column value back to an optional integer.
+func intFromNull(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	i := int(v.Int64)
+	return &i
+}
+
+// nullableDate converts a calendar date to a value suitable for a nullable date column.
+// The zero time is stored as NULL.
+func nullableDate(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: t.Format(DateLayout), Valid: true}
+}
+
+// nullableCoordinate stores an unknown (zero) coordinate as NULL.
+func nullableCoordinate(v float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: v, Valid: v != 0}
+}
+
+// dateFromNull converts a nullable date column value back to a calendar date.
+func dateFromNull(v sql.NullString) (time.Time, error) {
+	if !v.Valid || v.String == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(DateLayout, v.String)
+}
+
+// userPreferencesColumns are the columns read into UserPreferences, in scan order.
+const userPreferencesColumns = "user_id, property_type, min_price, max_price, min_bedrooms, max_bedrooms, furnished, location, listing_kind, move_in_date, tenancy_months, last_search, alerts_enabled"
+
 // GetUserPreferences retrieves a user's search preferences from the database.
-func GetUserPreferences(db *sql.DB, userID int64) (UserPreferences, error) {
-	var prefs UserPreferences
-	var propertyTypesJSON, bedroomOptionsJSON, furnishedJSON string
-	err := db.QueryRow(`
-		SELECT user_id, property_type, min_price, max_price, bedrooms, furnished, location, last_search
+func GetUserPreferences(ctx context.Context, db *sql.DB, userID int64) (UserPreferences, error) {
+	row := db.QueryRowContext(ctx, `
+		SELECT `+userPreferencesColumns+`
 		FROM user_preferences WHERE user_id = ?
-	`, userID).Scan(&prefs.UserID, &propertyTypesJSON, &prefs.MinPrice, &prefs.MaxPrice, &bedroomOptionsJSON, &furnishedJSON, &prefs.Location, &prefs.LastSearch)
+	`, userID)
+	return scanUserPreferences(row)
+}
+
+// scanUserPreferences reads a user_preferences row selected with userPreferencesColumns.
+func scanUserPreferences(row rowScanner) (UserPreferences, error) {
+	var prefs UserPreferences
+	var propertyTypesJSON, furnishedJSON string
+	var minBedrooms, maxBedrooms sql.NullInt64
+	var moveInDate sql.NullString
+	err := row.Scan(&prefs.UserID, &propertyTypesJSON, &prefs.MinPrice, &prefs.MaxPrice, &minBedrooms, &maxBedrooms, &furnishedJSON, &prefs.Location, &prefs.ListingKind, &moveInDate, &prefs.TenancyMonths, &prefs.LastSearch, &prefs.AlertsEnabled)
 	if err != nil {
 		return prefs, err
 	}
-
-	err = json.Unmarshal([]byte(propertyTypesJSON), &prefs.PropertyTypes)
+	prefs.MoveInDate, err = dateFromNull(moveInDate)
 	if err != nil {
 		return prefs, err
 	}
+	prefs.MinBedrooms = intFromNull(minBedrooms)
+	prefs.MaxBedrooms = intFromNull(maxBedrooms)
 
-	err = json.Unmarshal([]byte(bedroomOptionsJSON), &prefs.BedroomOptions)
+	err = json.Unmarshal([]byte(propertyTypesJSON), &prefs.PropertyTypes)
 	if err != nil {
 		return prefs, err
 	}
@@ -297,14 +851,14 @@ func GetUserPreferences(db *sql.DB, userID int64) (UserPreferences, error) {
 }
 
 // DeleteUserPreferences removes a user's search preferences from the database.
-func DeleteUserPreferences(db *sql.DB, userID int64) error {
-	_, err := db.Exec("DELETE FROM user_preferences WHERE user_id = ?", userID)
+func DeleteUserPreferences(ctx context.Context, db *sql.DB, userID int64) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM user_preferences WHERE user_id = ?", userID)
 	return err
 }
 
 // SaveListing saves a property for a user
-func SaveListing(db *sql.DB, userID int64, propertyID int) error {
-	_, err := db.Exec(`
+func SaveListing(ctx context.Context, db *sql.DB, userID int64, propertyID int) error {
+	_, err := db.ExecContext(ctx, `
         INSERT OR IGNORE INTO saved_listings (user_id, property_id)
         VALUES (?, ?)
     `, userID, propertyID)
@@ -312,9 +866,9 @@ func SaveListing(db *sql.DB, userID int64, propertyID int) error {
 }
 
 // GetSavedListings retrieves all saved listings for a user
-func GetSavedListings(db *sql.DB, userID int64) ([]Property, error) {
-	rows, err := db.Query(`
-        SELECT p.id, p.type, p.price_per_month, p.bedrooms, p.furnished, p.location, p.description, p.photo_urls, p.web_link
+func GetSavedListings(ctx context.Context, db *sql.DB, userID int64) ([]Property, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT `+propertyColumnList("p")+`
         FROM properties p
         JOIN saved_listings sl ON p.id = sl.property_id
         WHERE sl.user_id = ?
@@ -326,18 +880,10 @@ func GetSavedListings(db *sql.DB, userID int64) ([]Property, error) {
 
 	var properties []Property
 	for rows.Next() {
-		var p Property
-		var photoURLsJSON string
-		err := rows.Scan(&p.ID, &p.Type, &p.PricePerMonth, &p.Bedrooms, &p.Furnished, &p.Location, &p.Description, &photoURLsJSON, &p.WebLink)
+		p, err := scanProperty(rows)
 		if err != nil {
 			return nil, err
 		}
-
-		err = json.Unmarshal([]byte(photoURLsJSON), &p.PhotoURLs)
-		if err != nil {
-			return nil, err
-		}
-
 		properties = append(properties, p)
 	}
 
@@ -345,10 +891,58 @@ func GetSavedListings(db *sql.DB, userID int64) ([]Property, error) {
 }
 
 // DeleteSavedListing removes a specific saved listing for a user
-func DeleteSavedListing(db *sql.DB, userID int64, propertyID int) error {
-	_, err := db.Exec(`
+func DeleteSavedListing(ctx context.Context, db *sql.DB, userID int64, propertyID int) error {
+	_, err := db.ExecContext(ctx, `
         DELETE FROM saved_listings
         WHERE user_id = ? AND property_id = ?
     `, userID, propertyID)
 	return err
 }
+
+// GetListingWatchers returns the IDs of the users who saved a property
+func GetListingWatchers(ctx context.Context, db *sql.DB, propertyID int) ([]int64, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT user_id FROM saved_listings
+        WHERE property_id = ?
+        ORDER BY user_id
+    `, propertyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int64
+	for rows.Next() {
+		var userID int64
+		if err := rows.Scan(&userID); err != nil {
+			return nil, err
+		}
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// GetPropertyTypes retrieves the property type taxonomy in display order.
+func GetPropertyTypes(ctx context.Context, db *sql.DB) ([]PropertyType, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT code, label, group_name, sort_order
+		FROM property_types
+		ORDER BY sort_order, label
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var types []PropertyType
+	for rows.Next() {
+		var t PropertyType
+		if err := rows.Scan(&t.Code, &t.Label, &t.Group, &t.SortOrder); err != nil {
+			return nil, err
+		}
+		types = append(types, t)
+	}
+
+	return types, rows.Err()
+}
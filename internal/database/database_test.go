@@ -1,9 +1,14 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 var testDB *sql.DB
@@ -38,9 +43,54 @@ func TestInitDB(t *testing.T) {
 	}
 }
 
+// TestInitDBConnectionSettings verifies that a database file is opened in WAL mode with a busy timeout,
+// so connections wait for each other's writes instead of failing with "database is locked".
+func TestInitDBConnectionSettings(t *testing.T) {
+	db, err := InitDB(filepath.Join(t.TempDir(), "settings.db"))
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	var journalMode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&journalMode); err != nil {
+		t.Fatalf("Failed to query the journal mode: %v", err)
+	}
+	if journalMode != "wal" {
+		t.Errorf("Expected the wal journal mode, got %q", journalMode)
+	}
+	var busyTimeout int
+	if err := db.QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout); err != nil {
+		t.Fatalf("Failed to query the busy timeout: %v", err)
+	}
+	if busyTimeout != busyTimeoutMillis {
+		t.Errorf("Expected a busy timeout of %d, got %d", busyTimeoutMillis, busyTimeout)
+	}
+}
+
+// TestGetPropertyTypes verifies that the property type taxonomy is seeded and returned in display order.
+func TestGetPropertyTypes(t *testing.T) {
+	ctx := context.Background()
+	types, err := GetPropertyTypes(ctx, testDB)
+	if err != nil {
+		t.Fatalf("Failed to get property types: %v", err)
+	}
+
+	if len(types) != len(DefaultPropertyTypes) {
+		t.Fatalf("Expected %d property types, got %d", len(DefaultPropertyTypes), len(types))
+	}
+
+	for i := 1; i < len(types); i++ {
+		if types[i-1].SortOrder > types[i].SortOrder {
+			t.Errorf("Property types are not ordered: %s before %s", types[i-1].Code, types[i].Code)
+		}
+	}
+}
+
 // TestAddAndGetProperty tests the addition of properties to the database
 // and their subsequent retrieval using various filters.
 func TestAddAndGetProperty(t *testing.T) {
+	ctx := context.Background()
 	prop := Property{
 		Type:          "Apartment",
 		PricePerMonth: 1000,
@@ -52,13 +102,13 @@ func TestAddAndGetProperty(t *testing.T) {
 		WebLink:       "http://example.com/property",
 	}
 
-	err := AddProperty(testDB, prop)
+	err := AddProperty(ctx, testDB, prop)
 	if err != nil {
 		t.Fatalf("Failed to add property: %v", err)
 	}
 
 	// Retrieve the property
-	properties, err := GetProperties(testDB, map[string]interface{}{
+	properties, err := GetProperties(ctx, testDB, map[string]interface{}{
 		"types": []string{"Apartment"},
 	})
 	if err != nil {
@@ -81,24 +131,22 @@ func TestAddAndGetProperty(t *testing.T) {
 			"Apartment": false,
 			"House":     true,
 		},
-		BedroomOptions: map[string]bool{
-			"2": true,
-			"3": true,
-		},
-		MinPrice: 1000,
-		MaxPrice: 2000,
-		Location: "Updated City",
+		MinBedrooms: intPtr(2),
+		MaxBedrooms: intPtr(3),
+		MinPrice:    1000,
+		MaxPrice:    2000,
+		Location:    "Updated City",
 		Furnished: map[string]bool{
 			"Unfurnished": true,
 		},
 	}
 
-	err = SaveUserPreferences(testDB, updatedPrefs)
+	err = SaveUserPreferences(ctx, testDB, updatedPrefs)
 	if err != nil {
 		t.Fatalf("Failed to update user preferences: %v", err)
 	}
 
-	retrievedUpdatedPrefs, err := GetUserPreferences(testDB, 12345)
+	retrievedUpdatedPrefs, err := GetUserPreferences(ctx, testDB, 12345)
 	if err != nil {
 		t.Fatalf("Failed to get updated user preferences: %v", err)
 	}
@@ -111,30 +159,28 @@ func TestAddAndGetProperty(t *testing.T) {
 // TestSaveAndGetUserPreferences tests saving user preferences to the database,
 // retrieving them, and updating existing preferences.
 func TestSaveAndGetUserPreferences(t *testing.T) {
+	ctx := context.Background()
 	prefs := UserPreferences{
 		UserID: 12345,
 		PropertyTypes: map[string]bool{
 			"Apartment": true,
 			"House":     false,
 		},
-		BedroomOptions: map[string]bool{
-			"1": true,
-			"2": true,
-		},
-		MinPrice: 500,
-		MaxPrice: 1500,
-		Location: "Test City",
+		MinBedrooms: intPtr(1),
+		MinPrice:    500,
+		MaxPrice:    1500,
+		Location:    "Test City",
 		Furnished: map[string]bool{
 			"Furnished": true,
 		},
 	}
 
-	err := SaveUserPreferences(testDB, prefs)
+	err := SaveUserPreferences(ctx, testDB, prefs)
 	if err != nil {
 		t.Fatalf("Failed to save user preferences: %v", err)
 	}
 
-	retrievedPrefs, err := GetUserPreferences(testDB, 12345)
+	retrievedPrefs, err := GetUserPreferences(ctx, testDB, 12345)
 	if err != nil {
 		t.Fatalf("Failed to get user preferences: %v", err)
 	}
@@ -143,13 +189,17 @@ func TestSaveAndGetUserPreferences(t *testing.T) {
 		t.Errorf("Retrieved preferences do not match saved preferences")
 	}
 
+	if retrievedPrefs.MinBedrooms == nil || *retrievedPrefs.MinBedrooms != 1 || retrievedPrefs.MaxBedrooms != nil {
+		t.Errorf("Retrieved bedroom range does not match saved range: %v - %v", retrievedPrefs.MinBedrooms, retrievedPrefs.MaxBedrooms)
+	}
+
 	// Test saving duplicate listing
-	err = SaveListing(testDB, 12345, 1)
+	err = SaveListing(ctx, testDB, 12345, 1)
 	if err != nil {
 		t.Fatalf("Failed to save duplicate listing: %v", err)
 	}
 
-	savedListings, err := GetSavedListings(testDB, 12345)
+	savedListings, err := GetSavedListings(ctx, testDB, 12345)
 	if err != nil {
 		t.Fatalf("Failed to get saved listings after duplicate save: %v", err)
 	}
@@ -162,14 +212,15 @@ func TestSaveAndGetUserPreferences(t *testing.T) {
 // TestDeleteUserPreferences verifies that user preferences can be successfully
 // deleted from the database.
 func TestDeleteUserPreferences(t *testing.T) {
+	ctx := context.Background()
 	userID := int64(12345)
 
-	err := DeleteUserPreferences(testDB, userID)
+	err := DeleteUserPreferences(ctx, testDB, userID)
 	if err != nil {
 		t.Fatalf("Failed to delete user preferences: %v", err)
 	}
 
-	_, err = GetUserPreferences(testDB, userID)
+	_, err = GetUserPreferences(ctx, testDB, userID)
 	if err == nil {
 		t.Errorf("Expected error when getting deleted preferences, got nil")
 	}
@@ -178,15 +229,16 @@ func TestDeleteUserPreferences(t *testing.T) {
 // TestSaveAndGetSavedListings tests the functionality of saving property listings
 // for a user and retrieving those saved listings, including handling of duplicates.
 func TestSaveAndGetSavedListings(t *testing.T) {
+	ctx := context.Background()
 	userID := int64(12345)
 	propertyID := 1
 
-	err := SaveListing(testDB, userID, propertyID)
+	err := SaveListing(ctx, testDB, userID, propertyID)
 	if err != nil {
 		t.Fatalf("Failed to save listing: %v", err)
 	}
 
-	savedListings, err := GetSavedListings(testDB, userID)
+	savedListings, err := GetSavedListings(ctx, testDB, userID)
 	if err != nil {
 		t.Fatalf("Failed to get saved listings: %v", err)
 	}
@@ -199,15 +251,16 @@ func TestSaveAndGetSavedListings(t *testing.T) {
 // TestDeleteSavedListing verifies that a saved listing can be successfully
 // deleted from the database.
 func TestDeleteSavedListing(t *testing.T) {
+	ctx := context.Background()
 	userID := int64(12345)
 	propertyID := 1
 
-	err := DeleteSavedListing(testDB, userID, propertyID)
+	err := DeleteSavedListing(ctx, testDB, userID, propertyID)
 	if err != nil {
 		t.Fatalf("Failed to delete saved listing: %v", err)
 	}
 
-	savedListings, err := GetSavedListings(testDB, userID)
+	savedListings, err := GetSavedListings(ctx, testDB, userID)
 	if err != nil {
 		t.Fatalf("Failed to get saved listings: %v", err)
 	}
@@ -217,6 +270,118 @@ func TestDeleteSavedListing(t *testing.T) {
 	}
 }
 
+// TestHideAndUnhideListing verifies that hidden listings are left out of a user's
+// search results, but not other users', and come back when unhidden.
+func TestHideAndUnhideListing(t *testing.T) {
+	ctx := context.Background()
+	userID, otherUserID := int64(23456), int64(34567)
+
+	properties, err := GetProperties(ctx, testDB, map[string]interface{}{})
+	if err != nil || len(properties) == 0 {
+		t.Fatalf("Failed to get a property to hide: %v", err)
+	}
+	propertyID := properties[0].ID
+
+	if err := HideListing(ctx, testDB, userID, propertyID); err != nil {
+		t.Fatalf("Failed to hide listing: %v", err)
+	}
+	// Hiding twice is harmless
+	if err := HideListing(ctx, testDB, userID, propertyID); err != nil {
+		t.Fatalf("Failed to hide listing again: %v", err)
+	}
+
+	hidden, err := GetHiddenListings(ctx, testDB, userID)
+	if err != nil {
+		t.Fatalf("Failed to get hidden listings: %v", err)
+	}
+	if len(hidden) != 1 || hidden[0].ID != propertyID {
+		t.Errorf("GetHiddenListings(ctx, ) = %+v, want property %d", hidden, propertyID)
+	}
+
+	visible := func(userID int64) bool {
+		results, err := GetProperties(ctx, testDB, map[string]interface{}{"exclude_hidden_for": userID})
+		if err != nil {
+			t.Fatalf("Failed to get properties: %v", err)
+		}
+		for _, p := range results {
+			if p.ID == propertyID {
+				return true
+			}
+		}
+		return false
+	}
+
+	if visible(userID) {
+		t.Error("Hidden listing was returned to the user who hid it")
+	}
+	if !visible(otherUserID) {
+		t.Error("Listing hidden by one user was left out for another")
+	}
+
+	if err := UnhideListing(ctx, testDB, userID, propertyID); err != nil {
+		t.Fatalf("Failed to unhide listing: %v", err)
+	}
+	if !visible(userID) {
+		t.Error("Unhidden listing was not returned")
+	}
+}
+
+// TestListingImpressions verifies that impressions are recorded per user, that
+// seen listings can be left out of searches, and that the last search time is updated.
+func TestListingImpressions(t *testing.T) {
+	ctx := context.Background()
+	userID := int64(45678)
+
+	properties, err := GetProperties(ctx, testDB, map[string]interface{}{})
+	if err != nil || len(properties) == 0 {
+		t.Fatalf("Failed to get a property to show: %v", err)
+	}
+	propertyID := properties[0].ID
+
+	// Recording the same impression twice counts it once
+	for i := 0; i < 2; i++ {
+		if err := RecordImpression(ctx, testDB, userID, propertyID); err != nil {
+			t.Fatalf("Failed to record impression: %v", err)
+		}
+	}
+
+	seen, err := GetSeenListingIDs(ctx, testDB, userID)
+	if err != nil {
+		t.Fatalf("Failed to get seen listings: %v", err)
+	}
+	if len(seen) != 1 || !seen[propertyID] {
+		t.Errorf("GetSeenListingIDs(ctx, ) = %v, want only %d", seen, propertyID)
+	}
+
+	unseen, err := GetProperties(ctx, testDB, map[string]interface{}{"exclude_seen_by": userID})
+	if err != nil {
+		t.Fatalf("Failed to get unseen properties: %v", err)
+	}
+	if len(unseen) != len(properties)-1 {
+		t.Errorf("Got %d unseen properties, want %d", len(unseen), len(properties)-1)
+	}
+	for _, p := range unseen {
+		if p.ID == propertyID {
+			t.Error("Seen listing was returned as unseen")
+		}
+	}
+
+	if err := SaveUserPreferences(ctx, testDB, UserPreferences{UserID: userID}); err != nil {
+		t.Fatalf("Failed to save user preferences: %v", err)
+	}
+	lastSearch := time.Date(2024, 9, 1, 10, 0, 0, 0, time.UTC)
+	if err := UpdateLastSearch(ctx, testDB, userID, lastSearch); err != nil {
+		t.Fatalf("Failed to update last search: %v", err)
+	}
+	prefs, err := GetUserPreferences(ctx, testDB, userID)
+	if err != nil {
+		t.Fatalf("Failed to get user preferences: %v", err)
+	}
+	if !prefs.LastSearch.Equal(lastSearch) {
+		t.Errorf("LastSearch = %v, want %v", prefs.LastSearch, lastSearch)
+	}
+}
+
 // TestUpdateExistingDB checks if the database schema can be updated correctly,
 // specifically testing the addition of new columns to existing tables.
 func TestUpdateExistingDB(t *testing.T) {
@@ -251,6 +416,73 @@ func TestUpdateExistingDB(t *testing.T) {
 	}
 }
 
+// TestUpdateExistingDBBedroomPreferences checks that bedroom preferences saved in the old bedrooms
+// column are copied into the min/max range.
+func TestUpdateExistingDBBedroomPreferences(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "testdb")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	db, err := InitDB(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize test database: %v", err)
+	}
+	defer db.Close()
+
+	// Preferences saved before the range was added
+	_, err = db.Exec("ALTER TABLE user_preferences ADD COLUMN bedrooms")
+	if err != nil {
+		t.Fatalf("Failed to add the old bedrooms column: %v", err)
+	}
+	_, err = db.Exec(`INSERT INTO user_preferences (user_id, bedrooms, min_bedrooms, max_bedrooms) VALUES
+		(1, 2, NULL, NULL),
+		(2, '{"2":true,"3":true,"4":false}', NULL, NULL),
+		(3, '{"Studio":false,"4":true,"5+":true}', NULL, NULL),
+		(4, '{"1":false}', NULL, NULL),
+		(5, '{"1":true}', 3, 3)`)
+	if err != nil {
+		t.Fatalf("Failed to insert old preferences: %v", err)
+	}
+
+	if err = UpdateExistingDB(tmpfile.Name()); err != nil {
+		t.Fatalf("Failed to update existing database: %v", err)
+	}
+	// Running it again leaves the copied ranges alone
+	if err = UpdateExistingDB(tmpfile.Name()); err != nil {
+		t.Fatalf("Failed to update existing database again: %v", err)
+	}
+
+	testCases := []struct {
+		userID   int64
+		expected string
+	}{
+		{1, "2-2"},
+		{2, "2-3"},
+		{3, "4-"},
+		{4, "-"},
+		{5, "3-3"},
+	}
+	for _, tc := range testCases {
+		var min, max sql.NullInt64
+		err = db.QueryRow("SELECT min_bedrooms, max_bedrooms FROM user_preferences WHERE user_id = ?", tc.userID).Scan(&min, &max)
+		if err != nil {
+			t.Fatalf("Failed to query user %d: %v", tc.userID, err)
+		}
+		got := "-"
+		if min.Valid {
+			got = fmt.Sprintf("%d-", min.Int64)
+		}
+		if max.Valid {
+			got += fmt.Sprint(max.Int64)
+		}
+		if got != tc.expected {
+			t.Errorf("User %d: expected bedrooms %q, got %q", tc.userID, tc.expected, got)
+		}
+	}
+}
+
 // TestValidatePhotoURLs tests the function that filters and validates photo URLs,
 // ensuring only valid URLs are retained.
 func TestValidatePhotoURLs(t *testing.T) {
@@ -272,6 +504,45 @@ func TestValidatePhotoURLs(t *testing.T) {
 	}
 }
 
+// TestGetPropertiesBedroomRange tests that open-ended bedroom ranges include larger properties
+func TestGetPropertiesBedroomRange(t *testing.T) {
+	ctx := context.Background()
+	for _, bedrooms := range []int{3, 5, 6} {
+		err := AddProperty(ctx, testDB, Property{Type: "Bedroom Range Test", Bedrooms: bedrooms, Location: "Bath"})
+		if err != nil {
+			t.Fatalf("Failed to add property: %v", err)
+		}
+	}
+
+	properties, err := GetProperties(ctx, testDB, map[string]interface{}{
+		"types":        []string{"Bedroom Range Test"},
+		"min_bedrooms": 5,
+	})
+	if err != nil {
+		t.Fatalf("Failed to get properties: %v", err)
+	}
+	if len(properties) != 2 {
+		t.Errorf("Expected 2 properties with 5+ bedrooms, got %d", len(properties))
+	}
+
+	properties, err = GetProperties(ctx, testDB, map[string]interface{}{
+		"types":        []string{"Bedroom Range Test"},
+		"min_bedrooms": 3,
+		"max_bedrooms": 5,
+	})
+	if err != nil {
+		t.Fatalf("Failed to get properties: %v", err)
+	}
+	if len(properties) != 2 {
+		t.Errorf("Expected 2 properties with 3-5 bedrooms, got %d", len(properties))
+	}
+}
+
+// intPtr returns a pointer to a copy of v
+func intPtr(v int) *int {
+	return &v
+}
+
 // TestIsValidURL tests the isValidURL function with various input URLsgit
 func TestIsValidURL(t *testing.T) {
 	testCases := []struct {
@@ -292,3 +563,643 @@ func TestIsValidURL(t *testing.T) {
 		}
 	}
 }
+
+// TestAddAndGetRoom tests that room listings keep their room details and can be found by parent property
+func TestAddAndGetRoom(t *testing.T) {
+	ctx := context.Background()
+	room := Property{
+		Type:             "Room Test",
+		PricePerMonth:    550,
+		Location:         "Oldfield Park",
+		ListingKind:      ListingRoom,
+		ParentPropertyID: 42,
+		BillsIncluded:    true,
+		Housemates:       3,
+		Ensuite:          true,
+		SharedFacilities: []string{"Kitchen", "Garden"},
+	}
+	if err := AddProperty(ctx, testDB, room); err != nil {
+		t.Fatalf("Failed to add room: %v", err)
+	}
+
+	properties, err := GetProperties(ctx, testDB, map[string]interface{}{
+		"listing_kind":       ListingRoom,
+		"parent_property_id": 42,
+	})
+	if err != nil {
+		t.Fatalf("Failed to get rooms: %v", err)
+	}
+	if len(properties) != 1 {
+		t.Fatalf("Expected 1 room, got %d", len(properties))
+	}
+
+	got := properties[0]
+	if got.ListingKind != ListingRoom || !got.BillsIncluded || got.Housemates != 3 || !got.Ensuite || got.ParentPropertyID != 42 {
+		t.Errorf("Retrieved room does not match added room: %+v", got)
+	}
+	if len(got.SharedFacilities) != 2 || got.SharedFacilities[0] != "Kitchen" {
+		t.Errorf("Unexpected shared facilities: %v", got.SharedFacilities)
+	}
+
+	// Whole-property searches should not return rooms
+	properties, err = GetProperties(ctx, testDB, map[string]interface{}{
+		"types":        []string{"Room Test"},
+		"listing_kind": ListingWhole,
+	})
+	if err != nil {
+		t.Fatalf("Failed to get properties: %v", err)
+	}
+	if len(properties) != 0 {
+		t.Errorf("Expected no whole properties, got %d", len(properties))
+	}
+}
+
+// TestGetPropertiesAvailability tests filtering by move-in date tolerance and tenancy length
+func TestGetPropertiesAvailability(t *testing.T) {
+	ctx := context.Background()
+	moveIn := time.Date(2030, time.September, 1, 0, 0, 0, 0, time.UTC)
+	properties := []Property{
+		{Type: "Availability Test", Location: "Bath"},
+		{Type: "Availability Test", Location: "Bath", AvailableFrom: moveIn.AddDate(0, 0, 10), MinTenancyMonths: 6, MaxTenancyMonths: 12},
+		{Type: "Availability Test", Location: "Bath", AvailableFrom: moveIn.AddDate(0, 1, 0)},
+		{Type: "Availability Test", Location: "Bath", MinTenancyMonths: 12},
+	}
+	for _, p := range properties {
+		if err := AddProperty(ctx, testDB, p); err != nil {
+			t.Fatalf("Failed to add property: %v", err)
+		}
+	}
+
+	result, err := GetProperties(ctx, testDB, map[string]interface{}{
+		"types":                  []string{"Availability Test"},
+		"move_in":                moveIn,
+		"move_in_tolerance_days": 14,
+	})
+	if err != nil {
+		t.Fatalf("Failed to get properties: %v", err)
+	}
+	if len(result) != 3 {
+		t.Errorf("Expected 3 properties available within 14 days of moving in, got %d", len(result))
+	}
+
+	result, err = GetProperties(ctx, testDB, map[string]interface{}{
+		"types":          []string{"Availability Test"},
+		"tenancy_months": 6,
+	})
+	if err != nil {
+		t.Fatalf("Failed to get properties: %v", err)
+	}
+	if len(result) != 3 {
+		t.Errorf("Expected 3 properties allowing a 6 month tenancy, got %d", len(result))
+	}
+
+	var found bool
+	for _, p := range result {
+		if p.AvailableFrom.Equal(moveIn.AddDate(0, 0, 10)) && p.MinTenancyMonths == 6 && p.MaxTenancyMonths == 12 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Availability details were not retrieved: %+v", result)
+	}
+}
+
+// TestGetPropertiesListed tests that let and withdrawn properties are left out, unless the user
+// was already alerted about them.
+func TestGetPropertiesListed(t *testing.T) {
+	ctx := context.Background()
+	for _, status := range []string{StatusAvailable, StatusUnderOffer, StatusLetAgreed, StatusWithdrawn} {
+		if err := AddProperty(ctx, testDB, Property{Type: "Listed Test", Location: "Bath", Status: status}); err != nil {
+			t.Fatalf("Failed to add property: %v", err)
+		}
+	}
+
+	listed, err := GetProperties(ctx, testDB, map[string]interface{}{"types": []string{"Listed Test"}, "listed_only": true})
+	if err != nil {
+		t.Fatalf("Failed to get properties: %v", err)
+	}
+	if len(listed) != 2 {
+		t.Fatalf("Expected the available and under offer properties, got %+v", listed)
+	}
+	for _, p := range listed {
+		if p.Status == StatusLetAgreed || p.Status == StatusWithdrawn {
+			t.Errorf("Expected %s properties to be left out", p.Status)
+		}
+	}
+
+	all, err := GetProperties(ctx, testDB, map[string]interface{}{"types": []string{"Listed Test"}})
+	if err != nil {
+		t.Fatalf("Failed to get properties: %v", err)
+	}
+	var let Property
+	for _, p := range all {
+		if p.Status == StatusLetAgreed {
+			let = p
+		}
+	}
+	userID := int64(4242)
+	if _, err := RecordAlertDelivery(ctx, testDB, userID, let.ID); err != nil {
+		t.Fatalf("Failed to record alert delivery: %v", err)
+	}
+
+	alerted, err := GetProperties(ctx, testDB, map[string]interface{}{"types": []string{"Listed Test"}, "listed_or_alerted_for": userID})
+	if err != nil {
+		t.Fatalf("Failed to get properties: %v", err)
+	}
+	if len(alerted) != 3 {
+		t.Errorf("Expected the let property the user was alerted about to be kept, got %+v", alerted)
+	}
+}
+
+// TestGetProperty tests retrieving a single property by ID
+func TestGetProperty(t *testing.T) {
+	ctx := context.Background()
+	properties, err := GetProperties(ctx, testDB, map[string]interface{}{"types": []string{"Apartment"}})
+	if err != nil || len(properties) == 0 {
+		t.Fatalf("Failed to get a property to look up: %v", err)
+	}
+
+	property, err := GetProperty(ctx, testDB, properties[0].ID)
+	if err != nil {
+		t.Fatalf("Failed to get property: %v", err)
+	}
+	if property.ID != properties[0].ID || property.Type != "Apartment" {
+		t.Errorf("GetProperty(ctx, ) returned %+v, want property %d", property, properties[0].ID)
+	}
+
+	if _, err := GetProperty(ctx, testDB, -1); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetProperty(ctx, ) for a missing property returned %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+// TestPropertyAlerts verifies that added and updated properties are queued for alerts,
+// that alerts are recorded once per user and property, and that alerts can be turned off.
+func TestPropertyAlerts(t *testing.T) {
+	ctx := context.Background()
+	// Clear out the events queued by earlier tests
+	pending, err := GetPendingPropertyEvents(ctx, testDB, 1000)
+	if err != nil {
+		t.Fatalf("Failed to get property events: %v", err)
+	}
+	for _, e := range pending {
+		if err := MarkPropertyEventProcessed(ctx, testDB, e.ID); err != nil {
+			t.Fatalf("Failed to mark event processed: %v", err)
+		}
+	}
+
+	if err := AddProperty(ctx, testDB, Property{Type: "Alert Test", PricePerMonth: 900, Location: "Bath"}); err != nil {
+		t.Fatalf("Failed to add property: %v", err)
+	}
+	added, err := GetProperties(ctx, testDB, map[string]interface{}{"types": []string{"Alert Test"}})
+	if err != nil || len(added) != 1 {
+		t.Fatalf("Failed to get the added property: %v", err)
+	}
+	property := added[0]
+
+	property.PricePerMonth = 850
+	if err := UpdateProperty(ctx, testDB, property); err != nil {
+		t.Fatalf("Failed to update property: %v", err)
+	}
+	if err := UpdateProperty(ctx, testDB, Property{ID: -1}); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UpdateProperty(ctx, ) for a missing property returned %v, want %v", err, sql.ErrNoRows)
+	}
+
+	events, err := GetPendingPropertyEvents(ctx, testDB, 10)
+	if err != nil {
+		t.Fatalf("Failed to get property events: %v", err)
+	}
+	if len(events) != 2 || events[0].Kind != PropertyAdded || events[1].Kind != PropertyUpdated || events[1].PropertyID != property.ID {
+		t.Fatalf("Unexpected property events: %+v", events)
+	}
+
+	if err := MarkPropertyEventProcessed(ctx, testDB, events[0].ID); err != nil {
+		t.Fatalf("Failed to mark event processed: %v", err)
+	}
+	if events, _ = GetPendingPropertyEvents(ctx, testDB, 10); len(events) != 1 {
+		t.Errorf("Expected 1 pending event after processing one, got %d", len(events))
+	}
+
+	// A failing event is retried until it has failed maxAttempts times
+	for attempt, pending := range []int{1, 0} {
+		if err := RecordPropertyEventFailure(ctx, testDB, events[0].ID, "bad preferences", 2); err != nil {
+			t.Fatalf("Failed to record event failure: %v", err)
+		}
+		if events, _ := GetPendingPropertyEvents(ctx, testDB, 10); len(events) != pending {
+			t.Errorf("Expected %d pending events after %d failures, got %d", pending, attempt+1, len(events))
+		}
+	}
+	var attempts int
+	var lastError string
+	err = testDB.QueryRow("SELECT attempts, last_error FROM property_events WHERE id = ?", events[0].ID).Scan(&attempts, &lastError)
+	if err != nil || attempts != 2 || lastError != "bad preferences" {
+		t.Errorf("Expected the failures to be kept with the event, got %d %q (%v)", attempts, lastError, err)
+	}
+
+	userID := int64(56789)
+	for i, want := range []bool{true, false} {
+		isNew, err := RecordAlertDelivery(ctx, testDB, userID, property.ID)
+		if err != nil {
+			t.Fatalf("Failed to record alert delivery: %v", err)
+		}
+		if isNew != want {
+			t.Errorf("RecordAlertDelivery(ctx, ) call %d = %v, want %v", i+1, isNew, want)
+		}
+	}
+
+	// Alerts are on for new saved searches, and stay off when the search is saved again
+	if err := SetAlertsEnabled(ctx, testDB, userID, false); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SetAlertsEnabled(ctx, ) without a saved search returned %v, want %v", err, sql.ErrNoRows)
+	}
+	if err := SaveUserPreferences(ctx, testDB, UserPreferences{UserID: userID, Location: "Bath"}); err != nil {
+		t.Fatalf("Failed to save user preferences: %v", err)
+	}
+	if prefs, err := GetUserPreferences(ctx, testDB, userID); err != nil || !prefs.AlertsEnabled {
+		t.Errorf("Expected alerts to be on for a new saved search, got %v (%v)", prefs.AlertsEnabled, err)
+	}
+	if err := SetAlertsEnabled(ctx, testDB, userID, false); err != nil {
+		t.Fatalf("Failed to turn alerts off: %v", err)
+	}
+	if err := SaveUserPreferences(ctx, testDB, UserPreferences{UserID: userID, Location: "Bath"}); err != nil {
+		t.Fatalf("Failed to save user preferences: %v", err)
+	}
+	if prefs, err := GetUserPreferences(ctx, testDB, userID); err != nil || prefs.AlertsEnabled {
+		t.Errorf("Expected alerts to stay off when preferences are saved again, got %v (%v)", prefs.AlertsEnabled, err)
+	}
+
+	subscribers, err := GetAlertSubscribers(ctx, testDB)
+	if err != nil {
+		t.Fatalf("Failed to get alert subscribers: %v", err)
+	}
+	for _, s := range subscribers {
+		if s.UserID == userID {
+			t.Error("User with alerts turned off was returned as a subscriber")
+		}
+	}
+}
+
+// TestAddPropertyWithoutEvent checks that a property isn't added if its event can't be recorded,
+// so no property is left that users would never be alerted about.
+func TestAddPropertyWithoutEvent(t *testing.T) {
+	ctx := context.Background()
+	if _, err := testDB.Exec("ALTER TABLE property_events RENAME TO property_events_moved"); err != nil {
+		t.Fatalf("Failed to move the events table: %v", err)
+	}
+	defer testDB.Exec("ALTER TABLE property_events_moved RENAME TO property_events")
+
+	if err := AddProperty(ctx, testDB, Property{Type: "Eventless", PricePerMonth: 900, Location: "Bath"}); err == nil {
+		t.Fatal("Expected AddProperty to fail without the events table")
+	}
+	added, err := GetProperties(ctx, testDB, map[string]interface{}{"types": []string{"Eventless"}})
+	if err != nil || len(added) != 0 {
+		t.Errorf("Expected the property to be rolled back, got %d properties (%v)", len(added), err)
+	}
+}
+
+func TestDigestSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	userID := int64(67890)
+	if _, err := GetDigestSubscription(ctx, testDB, userID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetDigestSubscription(ctx, ) without a subscription returned %v, want %v", err, sql.ErrNoRows)
+	}
+	if err := SaveUserPreferences(ctx, testDB, UserPreferences{UserID: userID, Location: "Bath"}); err != nil {
+		t.Fatalf("Failed to save user preferences: %v", err)
+	}
+
+	// A new subscription starts from the latest property event
+	var latestEventID int
+	if err := testDB.QueryRow("SELECT COALESCE(MAX(id), 0) FROM property_events").Scan(&latestEventID); err != nil {
+		t.Fatalf("Failed to get the latest property event: %v", err)
+	}
+	sub := DigestSubscription{UserID: userID, Frequency: DigestWeekly, Weekday: time.Monday, SendTime: "08:00", Timezone: "Europe/London"}
+	if err := SaveDigestSubscription(ctx, testDB, sub); err != nil {
+		t.Fatalf("Failed to save digest subscription: %v", err)
+	}
+	saved, err := GetDigestSubscription(ctx, testDB, userID)
+	if err != nil {
+		t.Fatalf("Failed to get digest subscription: %v", err)
+	}
+	if saved.Frequency != DigestWeekly || saved.Weekday != time.Monday || saved.SendTime != "08:00" || saved.Timezone != "Europe/London" {
+		t.Errorf("Unexpected digest subscription: %+v", saved)
+	}
+	if saved.LastEventID != latestEventID || saved.LastSentAt.IsZero() {
+		t.Errorf("Expected a new subscription to start from event %d now, got event %d at %v", latestEventID, saved.LastEventID, saved.LastSentAt)
+	}
+
+	// Users with a digest don't get instant alerts
+	subscribers, err := GetAlertSubscribers(ctx, testDB)
+	if err != nil {
+		t.Fatalf("Failed to get alert subscribers: %v", err)
+	}
+	for _, s := range subscribers {
+		if s.UserID == userID {
+			t.Error("User with a digest was returned as an instant alert subscriber")
+		}
+	}
+
+	// Events after the last digest are returned, and sending a digest moves past them
+	properties, err := GetProperties(ctx, testDB, map[string]interface{}{})
+	if err != nil || len(properties) == 0 {
+		t.Fatalf("Failed to get a property to update: %v", err)
+	}
+	property := properties[0]
+	oldPrice := property.PricePerMonth
+	property.PricePerMonth = oldPrice - 50
+	property.Status = StatusUnderOffer
+	if err := UpdateProperty(ctx, testDB, property); err != nil {
+		t.Fatalf("Failed to update property: %v", err)
+	}
+	events, err := GetPropertyEventsAfter(ctx, testDB, saved.LastEventID)
+	if err != nil {
+		t.Fatalf("Failed to get property events: %v", err)
+	}
+	if len(events) != 1 || events[0].PropertyID != property.ID || events[0].OldPrice != oldPrice || events[0].OldStatus != StatusAvailable {
+		t.Fatalf("Unexpected property events: %+v", events)
+	}
+
+	sentAt := time.Date(2026, 1, 12, 8, 0, 0, 0, time.UTC)
+	if err := MarkDigestSent(ctx, testDB, userID, sentAt, events[0].ID); err != nil {
+		t.Fatalf("Failed to mark digest sent: %v", err)
+	}
+	digests, err := GetDigestSubscriptions(ctx, testDB)
+	if err != nil {
+		t.Fatalf("Failed to get digest subscriptions: %v", err)
+	}
+	found := false
+	for _, d := range digests {
+		if d.UserID == userID {
+			found = true
+			if !d.LastSentAt.Equal(sentAt) || d.LastEventID != events[0].ID {
+				t.Errorf("Unexpected digest subscription after sending: %+v", d)
+			}
+		}
+	}
+	if !found {
+		t.Error("Expected the subscription to be returned with the digests to send")
+	}
+
+	// Saving the subscription again keeps track of what was sent
+	sub.Frequency = DigestDaily
+	if err := SaveDigestSubscription(ctx, testDB, sub); err != nil {
+		t.Fatalf("Failed to update digest subscription: %v", err)
+	}
+	if saved, err = GetDigestSubscription(ctx, testDB, userID); err != nil || saved.Frequency != DigestDaily || saved.LastEventID != events[0].ID {
+		t.Errorf("Unexpected digest subscription after changing it: %+v (%v)", saved, err)
+	}
+
+	if err := DeleteDigestSubscription(ctx, testDB, userID); err != nil {
+		t.Fatalf("Failed to delete digest subscription: %v", err)
+	}
+	if _, err := GetDigestSubscription(ctx, testDB, userID); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetDigestSubscription(ctx, ) after deleting returned %v, want %v", err, sql.ErrNoRows)
+	}
+}
+
+func TestNotificationSettings(t *testing.T) {
+	ctx := context.Background()
+	userID := int64(78901)
+	settings, err := GetNotificationSettings(ctx, testDB, userID)
+	if err != nil {
+		t.Fatalf("Failed to get default notification settings: %v", err)
+	}
+	if settings.QuietStart != "" || settings.MaxPerDay != 0 || !settings.MutedUntil.IsZero() || !settings.CategoryEnabled(NotifyNewMatch) {
+		t.Errorf("Unexpected default notification settings: %+v", settings)
+	}
+
+	mutedUntil := time.Date(2026, 1, 12, 9, 0, 0, 0, time.UTC)
+	settings.QuietStart, settings.QuietEnd = "22:00", "07:00"
+	settings.MaxPerDay = 5
+	settings.DisabledCategories[NotifyPriceDrop] = true
+	settings.MutedUntil = mutedUntil
+	if err := SaveNotificationSettings(ctx, testDB, settings); err != nil {
+		t.Fatalf("Failed to save notification settings: %v", err)
+	}
+	saved, err := GetNotificationSettings(ctx, testDB, userID)
+	if err != nil {
+		t.Fatalf("Failed to get notification settings: %v", err)
+	}
+	if saved.QuietStart != "22:00" || saved.QuietEnd != "07:00" || saved.MaxPerDay != 5 || !saved.MutedUntil.Equal(mutedUntil) {
+		t.Errorf("Unexpected notification settings: %+v", saved)
+	}
+	if saved.CategoryEnabled(NotifyPriceDrop) || !saved.CategoryEnabled(NotifyLetAgreed) {
+		t.Errorf("Unexpected alert categories: %+v", saved.DisabledCategories)
+	}
+
+	// Notifications sent count from the given time, whatever timezone it's in
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatalf("Failed to load timezone: %v", err)
+	}
+	for _, sentAt := range []time.Time{
+		time.Date(2026, 1, 9, 23, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 10, 8, 0, 0, 0, time.UTC),
+		time.Date(2026, 1, 10, 9, 0, 0, 0, london),
+	} {
+		if err := RecordNotificationSent(ctx, testDB, userID, NotifyNewMatch, sentAt); err != nil {
+			t.Fatalf("Failed to record notification: %v", err)
+		}
+	}
+	if count, err := CountNotificationsSince(ctx, testDB, userID, time.Date(2026, 1, 10, 0, 0, 0, 0, london)); err != nil || count != 2 {
+		t.Errorf("CountNotificationsSince(ctx, ) = %d, %v, want 2", count, err)
+	}
+
+	for _, text := range []string{"First", "Second"} {
+		if err := QueueNotification(ctx, testDB, QueuedNotification{UserID: userID, Category: NotifyNewMatch, Text: text}); err != nil {
+			t.Fatalf("Failed to queue notification: %v", err)
+		}
+	}
+	queued, err := GetQueuedNotifications(ctx, testDB, 10)
+	if err != nil {
+		t.Fatalf("Failed to get queued notifications: %v", err)
+	}
+	if len(queued) != 2 || queued[0].Text != "First" || queued[1].Text != "Second" {
+		t.Fatalf("Unexpected queued notifications: %+v", queued)
+	}
+	if err := DeleteQueuedNotification(ctx, testDB, queued[0].ID); err != nil {
+		t.Fatalf("Failed to delete queued notification: %v", err)
+	}
+	if queued, _ = GetQueuedNotifications(ctx, testDB, 10); len(queued) != 1 || queued[0].Text != "Second" {
+		t.Errorf("Unexpected queued notifications after sending one: %+v", queued)
+	}
+}
+
+func TestGetListingWatchers(t *testing.T) {
+	ctx := context.Background()
+	propertyID := 2
+	for _, userID := range []int64{89013, 89012} {
+		if err := SaveListing(ctx, testDB, userID, propertyID); err != nil {
+			t.Fatalf("Failed to save listing: %v", err)
+		}
+	}
+
+	watchers, err := GetListingWatchers(ctx, testDB, propertyID)
+	if err != nil {
+		t.Fatalf("Failed to get listing watchers: %v", err)
+	}
+	found := 0
+	for _, userID := range watchers {
+		if userID == 89012 || userID == 89013 {
+			found++
+		}
+	}
+	if found != 2 {
+		t.Errorf("Expected both users who saved the listing to be watchers, got %v", watchers)
+	}
+}
+
+func TestScheduledJobs(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	if err := UpsertRecurringJob(ctx, testDB, "cleanup", "@every 1m", now); err != nil {
+		t.Fatalf("Failed to add recurring job: %v", err)
+	}
+	// Adding the job again with the same schedule keeps its run time
+	if err := UpsertRecurringJob(ctx, testDB, "cleanup", "@every 1m", now.Add(time.Hour)); err != nil {
+		t.Fatalf("Failed to update recurring job: %v", err)
+	}
+	if err := AddJob(ctx, testDB, "reminder", "89012", now.Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to add one-off job: %v", err)
+	}
+
+	jobs, err := ClaimDueJobs(ctx, testDB, "worker-1", now, now.Add(5*time.Minute), 10)
+	if err != nil {
+		t.Fatalf("Failed to claim jobs: %v", err)
+	}
+	if len(jobs) != 1 || jobs[0].Name != "cleanup" || !jobs[0].RunAt.Equal(now) {
+		t.Fatalf("Expected only the cleanup job to be due, got %+v", jobs)
+	}
+	if leased, _ := ClaimDueJobs(ctx, testDB, "worker-2", now, now.Add(5*time.Minute), 10); len(leased) != 0 {
+		t.Errorf("Expected a leased job not to be claimed again, got %+v", leased)
+	}
+	if err := CompleteJob(ctx, testDB, jobs[0].ID, "worker-2", now.Add(time.Minute)); err != ErrJobLeaseLost {
+		t.Errorf("Expected a worker without the lease not to complete the job, got %v", err)
+	}
+	if err := CompleteJob(ctx, testDB, jobs[0].ID, "worker-1", now.Add(time.Minute)); err != nil {
+		t.Fatalf("Failed to complete job: %v", err)
+	}
+
+	later := now.Add(time.Minute)
+	jobs, err = ClaimDueJobs(ctx, testDB, "worker-1", later, later.Add(5*time.Minute), 10)
+	if err != nil {
+		t.Fatalf("Failed to claim jobs: %v", err)
+	}
+	if len(jobs) != 2 {
+		t.Fatalf("Expected both jobs to be due, got %+v", jobs)
+	}
+	for _, job := range jobs {
+		if job.Name == "reminder" {
+			if job.Payload != "89012" {
+				t.Errorf("Expected the one-off job's payload, got %q", job.Payload)
+			}
+			if err := FailJob(ctx, testDB, job.ID, "worker-1", 5, "boom"); err != nil {
+				t.Fatalf("Failed to fail job: %v", err)
+			}
+		} else if err := CompleteJob(ctx, testDB, job.ID, "worker-1", later.Add(time.Minute)); err != nil {
+			t.Fatalf("Failed to complete job: %v", err)
+		}
+	}
+
+	jobs, err = GetJobs(ctx, testDB)
+	if err != nil {
+		t.Fatalf("Failed to get jobs: %v", err)
+	}
+	if len(jobs) != 2 || jobs[0].Status != JobFailed || jobs[0].LastError != "boom" || jobs[1].Status != JobScheduled {
+		t.Fatalf("Expected the one-off job to be kept as failed, got %+v", jobs)
+	}
+
+	for _, msg := range []string{"", "boom"} {
+		run := JobRun{JobID: jobs[1].ID, Name: "cleanup", StartedAt: now, FinishedAt: now.Add(time.Second), Error: msg}
+		if err := RecordJobRun(ctx, testDB, run); err != nil {
+			t.Fatalf("Failed to record job run: %v", err)
+		}
+	}
+	runs, err := GetJobRuns(ctx, testDB, "cleanup", 10)
+	if err != nil {
+		t.Fatalf("Failed to get job runs: %v", err)
+	}
+	if len(runs) != 2 || runs[0].Error != "boom" || runs[1].Error != "" {
+		t.Errorf("Expected the job history newest first, got %+v", runs)
+	}
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	// A message written in a transaction that is rolled back is never delivered
+	tx, err := testDB.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	if err := AddOutboxMessage(ctx, tx, OutboxMessage{UserID: 89012, Category: NotifyNewMatch, Text: "Rolled back"}, now); err != nil {
+		t.Fatalf("Failed to add outbox message: %v", err)
+	}
+	tx.Rollback()
+
+	for _, text := range []string{"First", "Second", "Third"} {
+		m := OutboxMessage{UserID: 89012, Category: NotifyNewMatch, Text: text, ReplyMarkup: `{"inline_keyboard":[]}`}
+		if err := AddOutboxMessage(ctx, testDB, m, now); err != nil {
+			t.Fatalf("Failed to add outbox message: %v", err)
+		}
+	}
+
+	due, err := GetDueOutboxMessages(ctx, testDB, now, 10)
+	if err != nil {
+		t.Fatalf("Failed to get due outbox messages: %v", err)
+	}
+	if len(due) != 3 || due[0].Text != "First" || due[0].Status != OutboxPending || due[0].ReplyMarkup != `{"inline_keyboard":[]}` {
+		t.Fatalf("Unexpected due outbox messages: %+v", due)
+	}
+
+	if err := MarkOutboxDelivered(ctx, testDB, due[0].ID, now); err != nil {
+		t.Fatalf("Failed to mark outbox message delivered: %v", err)
+	}
+	if err := RetryOutboxMessage(ctx, testDB, due[1].ID, 1, now.Add(time.Minute), "connection reset"); err != nil {
+		t.Fatalf("Failed to retry outbox message: %v", err)
+	}
+	if err := FailOutboxMessage(ctx, testDB, due[2].ID, 1, "bot was blocked"); err != nil {
+		t.Fatalf("Failed to fail outbox message: %v", err)
+	}
+
+	if due, _ = GetDueOutboxMessages(ctx, testDB, now, 10); len(due) != 0 {
+		t.Errorf("Expected no messages to be due before the retry, got %+v", due)
+	}
+	due, _ = GetDueOutboxMessages(ctx, testDB, now.Add(time.Minute), 10)
+	if len(due) != 1 || due[0].Text != "Second" || due[0].Attempts != 1 || due[0].LastError != "connection reset" {
+		t.Errorf("Expected the retried message to be due, got %+v", due)
+	}
+
+	stuck, err := GetStuckOutboxMessages(ctx, testDB, now, 10)
+	if err != nil {
+		t.Fatalf("Failed to get stuck outbox messages: %v", err)
+	}
+	if len(stuck) != 1 || stuck[0].Text != "Third" || stuck[0].Status != OutboxFailed {
+		t.Errorf("Expected only the failed message to be stuck, got %+v", stuck)
+	}
+	if stuck, _ = GetStuckOutboxMessages(ctx, testDB, now.Add(time.Hour), 10); len(stuck) != 2 {
+		t.Errorf("Expected the pending message to be stuck an hour later, got %+v", stuck)
+	}
+
+	// The newest stuck messages come first, so new failures aren't hidden behind old ones, and all are counted
+	before, err := CountStuckOutboxMessages(ctx, testDB, now)
+	if err != nil {
+		t.Fatalf("Failed to count stuck outbox messages: %v", err)
+	}
+	for i := 1; i <= 25; i++ {
+		if err := AddOutboxMessage(ctx, testDB, OutboxMessage{UserID: 89013, Category: NotifyNewMatch, Text: fmt.Sprintf("Failed %d", i)}, now); err != nil {
+			t.Fatalf("Failed to add outbox message: %v", err)
+		}
+	}
+	due, _ = GetDueOutboxMessages(ctx, testDB, now, 100)
+	for _, m := range due {
+		if err := FailOutboxMessage(ctx, testDB, m.ID, 1, "bot was blocked"); err != nil {
+			t.Fatalf("Failed to fail outbox message: %v", err)
+		}
+	}
+	if count, err := CountStuckOutboxMessages(ctx, testDB, now); err != nil || count != before+25 {
+		t.Errorf("Expected %d stuck messages, got %d (%v)", before+25, count, err)
+	}
+	stuck, _ = GetStuckOutboxMessages(ctx, testDB, now, 20)
+	if len(stuck) != 20 || stuck[0].Text != "Failed 25" || stuck[19].Text != "Failed 6" {
+		t.Errorf("Expected the 20 newest failed messages, newest first, got %d starting with %+v", len(stuck), stuck[0])
+	}
+}
@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Digest frequencies.
+const (
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// DigestSubscription is a user's choice to get the changes matching their saved search in one
+// summary message a day or a week, instead of an alert for each property.
+type DigestSubscription struct {
+	UserID    int64
+	Frequency string       // DigestDaily or DigestWeekly
+	Weekday   time.Weekday // the day weekly digests are sent
+	SendTime  string       // local time of day, as HH:MM
+	Timezone  string       // IANA timezone name, such as Europe/London
+	// LastSentAt is when the last digest was sent, or when the user subscribed if none has been sent yet
+	LastSentAt time.Time
+	// LastEventID is the last property event included in a digest
+	LastEventID int
+}
+
+// createDigestSubscriptionsTable creates the table of users who get digests instead of instant alerts.
+func createDigestSubscriptionsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS digest_subscriptions (
+		user_id INTEGER PRIMARY KEY,
+		frequency TEXT NOT NULL,
+		weekday INTEGER NOT NULL DEFAULT 0,
+		send_time TEXT NOT NULL,
+		timezone TEXT NOT NULL,
+		last_sent_at TIMESTAMP,
+		last_event_id INTEGER NOT NULL DEFAULT 0
+	)
+	`)
+	return err
+}
+
+// SaveDigestSubscription saves or updates a user's digest subscription.
+// A new subscription starts now and from the latest property event, so the first
+// digest only covers changes made after subscribing.
+func SaveDigestSubscription(ctx context.Context, db *sql.DB, sub DigestSubscription) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT OR REPLACE INTO digest_subscriptions
+        (user_id, frequency, weekday, send_time, timezone, last_sent_at, last_event_id)
+        VALUES (?, ?, ?, ?, ?,
+            COALESCE((SELECT last_sent_at FROM digest_subscriptions WHERE user_id = ?), CURRENT_TIMESTAMP),
+            COALESCE(
+                (SELECT last_event_id FROM digest_subscriptions WHERE user_id = ?),
+                (SELECT MAX(id) FROM property_events),
+                0))
+    `, sub.UserID, sub.Frequency, int(sub.Weekday), sub.SendTime, sub.Timezone, sub.UserID, sub.UserID)
+	return err
+}
+
+// GetDigestSubscription retrieves a user's digest subscription.
+// It returns sql.ErrNoRows if the user gets instant alerts.
+func GetDigestSubscription(ctx context.Context, db *sql.DB, userID int64) (DigestSubscription, error) {
+	row := db.QueryRowContext(ctx, `
+        SELECT `+digestSubscriptionColumns+`
+        FROM digest_subscriptions WHERE user_id = ?
+    `, userID)
+	return scanDigestSubscription(row)
+}
+
+// DeleteDigestSubscription switches a user back to instant alerts.
+func DeleteDigestSubscription(ctx context.Context, db *sql.DB, userID int64) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM digest_subscriptions WHERE user_id = ?", userID)
+	return err
+}
+
+// GetDigestSubscriptions returns the digest subscriptions of every user with alerts turned on.
+func GetDigestSubscriptions(ctx context.Context, db *sql.DB) ([]DigestSubscription, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT `+digestSubscriptionColumns+`
+        FROM digest_subscriptions
+        WHERE user_id IN (SELECT user_id FROM user_preferences WHERE alerts_enabled = 1)
+        ORDER BY user_id
+    `)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []DigestSubscription
+	for rows.Next() {
+		sub, err := scanDigestSubscription(rows)
+		if err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// MarkDigestSent records that a digest covering the events up to lastEventID was sent.
+func MarkDigestSent(ctx context.Context, db Execer, userID int64, sentAt time.Time, lastEventID int) error {
+	_, err := db.ExecContext(ctx, `
+        UPDATE digest_subscriptions SET last_sent_at = ?, last_event_id = ?
+        WHERE user_id = ?
+    `, sentAt, lastEventID, userID)
+	return err
+}
+
+const digestSubscriptionColumns = "user_id, frequency, weekday, send_time, timezone, last_sent_at, last_event_id"
+
+// scanDigestSubscription reads a digest subscription selected with digestSubscriptionColumns.
+func scanDigestSubscription(row rowScanner) (DigestSubscription, error) {
+	var sub DigestSubscription
+	var weekday int
+	var lastSentAt sql.NullTime
+	err := row.Scan(&sub.UserID, &sub.Frequency, &weekday, &sub.SendTime, &sub.Timezone, &lastSentAt, &sub.LastEventID)
+	if err != nil {
+		return DigestSubscription{}, err
+	}
+	sub.Weekday = time.Weekday(weekday)
+	sub.LastSentAt = lastSentAt.Time
+	return sub, nil
+}
@@ -0,0 +1,63 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+)
+
+// createHiddenListingsTable creates the table of listings each user doesn't want to see again.
+func createHiddenListingsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS hidden_listings (
+		user_id INTEGER NOT NULL,
+		property_id INTEGER NOT NULL,
+		hidden_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (user_id, property_id),
+		FOREIGN KEY (property_id) REFERENCES properties(id)
+	)
+	`)
+	return err
+}
+
+// HideListing hides a property from a user's searches, recommendations and alerts.
+func HideListing(ctx context.Context, db *sql.DB, userID int64, propertyID int) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT OR IGNORE INTO hidden_listings (user_id, property_id)
+        VALUES (?, ?)
+    `, userID, propertyID)
+	return err
+}
+
+// UnhideListing shows a previously hidden property to the user again.
+func UnhideListing(ctx context.Context, db *sql.DB, userID int64, propertyID int) error {
+	_, err := db.ExecContext(ctx, `
+        DELETE FROM hidden_listings
+        WHERE user_id = ? AND property_id = ?
+    `, userID, propertyID)
+	return err
+}
+
+// GetHiddenListings retrieves all listings a user has hidden, most recently hidden first.
+func GetHiddenListings(ctx context.Context, db *sql.DB, userID int64) ([]Property, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT `+propertyColumnList("p")+`
+        FROM properties p
+        JOIN hidden_listings hl ON p.id = hl.property_id
+        WHERE hl.user_id = ?
+        ORDER BY hl.hidden_at DESC, p.id DESC
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var properties []Property
+	for rows.Next() {
+		p, err := scanProperty(rows)
+		if err != nil {
+			return nil, err
+		}
+		properties = append(properties, p)
+	}
+
+	return properties, nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// createListingImpressionsTable creates the table recording which listings each user has been shown.
+func createListingImpressionsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS listing_impressions (
+		user_id INTEGER NOT NULL,
+		property_id INTEGER NOT NULL,
+		first_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		times_seen INTEGER NOT NULL DEFAULT 1,
+		PRIMARY KEY (user_id, property_id),
+		FOREIGN KEY (property_id) REFERENCES properties(id)
+	)
+	`)
+	return err
+}
+
+// RecordImpression records that a listing was shown to a user.
+func RecordImpression(ctx context.Context, db Execer, userID int64, propertyID int) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO listing_impressions (user_id, property_id)
+        VALUES (?, ?)
+        ON CONFLICT (user_id, property_id)
+        DO UPDATE SET last_seen_at = CURRENT_TIMESTAMP, times_seen = times_seen + 1
+    `, userID, propertyID)
+	return err
+}
+
+// GetSeenListingIDs returns the IDs of all listings a user has been shown.
+func GetSeenListingIDs(ctx context.Context, db *sql.DB, userID int64) (map[int]bool, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT property_id FROM listing_impressions
+        WHERE user_id = ?
+    `, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		seen[id] = true
+	}
+
+	return seen, rows.Err()
+}
+
+// UpdateLastSearch records when the user last searched. Users without saved
+// preferences have nowhere to record it, so nothing is updated for them.
+func UpdateLastSearch(ctx context.Context, db *sql.DB, userID int64, at time.Time) error {
+	_, err := db.ExecContext(ctx, `
+        UPDATE user_preferences SET last_search = ?
+        WHERE user_id = ?
+    `, at, userID)
+	return err
+}
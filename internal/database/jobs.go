@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Job statuses.
+const (
+	JobScheduled = "scheduled"
+	JobFailed    = "failed" // a one-off job that failed too many times, kept for inspection
+)
+
+// ErrJobLeaseLost is returned when a job's lease expired and another worker claimed the job.
+var ErrJobLeaseLost = errors.New("job lease lost")
+
+// Job is a unit of background work. Recurring jobs have a schedule and run again and again,
+// while one-off jobs run once at RunAt and are then removed.
+type Job struct {
+	ID        int
+	Name      string // the handler that runs the job
+	Schedule  string // when a recurring job runs, or empty for a one-off job
+	Payload   string
+	RunAt     time.Time
+	Attempts  int // failed attempts since the job last succeeded
+	Status    string
+	LastError string
+}
+
+// JobRun records one run of a job, for the job history.
+type JobRun struct {
+	ID         int
+	JobID      int
+	Name       string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Error      string // empty if the run succeeded
+}
+
+// createJobTables creates the tables of scheduled jobs and their run history.
+// Times are stored in UTC so they compare in order.
+func createJobTables(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS jobs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL,
+		recurring_key TEXT UNIQUE,
+		schedule TEXT NOT NULL DEFAULT '',
+		payload TEXT NOT NULL DEFAULT '',
+		run_at TIMESTAMP NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'scheduled',
+		last_error TEXT NOT NULL DEFAULT '',
+		lease_owner TEXT,
+		lease_until TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS job_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		job_id INTEGER NOT NULL,
+		name TEXT NOT NULL,
+		started_at TIMESTAMP NOT NULL,
+		finished_at TIMESTAMP NOT NULL,
+		error TEXT NOT NULL DEFAULT ''
+	)
+	`)
+	return err
+}
+
+// UpsertRecurringJob adds a recurring job, or updates its schedule if a job with the name already exists.
+// An existing job keeps its next run time unless its schedule changed, so restarts don't delay or repeat it.
+func UpsertRecurringJob(ctx context.Context, db *sql.DB, name, schedule string, firstRunAt time.Time) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO jobs (name, recurring_key, schedule, run_at)
+        VALUES (?, ?, ?, ?)
+        ON CONFLICT (recurring_key) DO UPDATE SET
+            run_at = CASE WHEN jobs.schedule = excluded.schedule THEN jobs.run_at ELSE excluded.run_at END,
+            schedule = excluded.schedule,
+            status = 'scheduled'
+    `, name, name, schedule, firstRunAt.UTC())
+	return err
+}
+
+// AddJob adds a one-off job that runs at the given time.
+func AddJob(ctx context.Context, db *sql.DB, name, payload string, runAt time.Time) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO jobs (name, payload, run_at)
+        VALUES (?, ?, ?)
+    `, name, payload, runAt.UTC())
+	return err
+}
+
+const jobColumns = "id, name, schedule, payload, run_at, attempts, status, last_error"
+
+// scanJob reads a job selected with jobColumns.
+func scanJob(row rowScanner) (Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.Name, &j.Schedule, &j.Payload, &j.RunAt, &j.Attempts, &j.Status, &j.LastError)
+	return j, err
+}
+
+// ClaimDueJobs leases up to limit jobs that are due to run, earliest first, so no other worker runs them
+// until the lease ends. Jobs whose lease ran out, because their worker stopped, are due again.
+func ClaimDueJobs(ctx context.Context, db *sql.DB, owner string, now, leaseUntil time.Time, limit int) ([]Job, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+        SELECT `+jobColumns+` FROM jobs
+        WHERE status = 'scheduled' AND run_at <= ? AND (lease_until IS NULL OR lease_until <= ?)
+        ORDER BY run_at, id
+        LIMIT ?
+    `, now.UTC(), now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	var jobs []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, j := range jobs {
+		_, err := tx.ExecContext(ctx, `
+            UPDATE jobs SET lease_owner = ?, lease_until = ?
+            WHERE id = ?
+        `, owner, leaseUntil.UTC(), j.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return jobs, tx.Commit()
+}
+
+// CompleteJob records that a leased job succeeded. A recurring job is rescheduled for nextRunAt,
+// and a one-off job, with a zero nextRunAt, is removed.
+// It returns ErrJobLeaseLost if the worker no longer holds the job's lease.
+func CompleteJob(ctx context.Context, db *sql.DB, id int, owner string, nextRunAt time.Time) error {
+	if nextRunAt.IsZero() {
+		return updateLeasedJob(ctx, db, "DELETE FROM jobs WHERE id = ? AND lease_owner = ?", id, owner)
+	}
+	return RetryJob(ctx, db, id, owner, 0, nextRunAt, "")
+}
+
+// RetryJob records that a leased job failed, and schedules it to run again at runAt.
+// It returns ErrJobLeaseLost if the worker no longer holds the job's lease.
+func RetryJob(ctx context.Context, db *sql.DB, id int, owner string, attempts int, runAt time.Time, lastError string) error {
+	return updateLeasedJob(ctx, db, `
+        UPDATE jobs SET attempts = ?, run_at = ?, last_error = ?, lease_owner = NULL, lease_until = NULL
+        WHERE id = ? AND lease_owner = ?
+    `, attempts, runAt.UTC(), lastError, id, owner)
+}
+
+// FailJob records that a leased one-off job failed for good. It is kept, but never run again.
+// It returns ErrJobLeaseLost if the worker no longer holds the job's lease.
+func FailJob(ctx context.Context, db *sql.DB, id int, owner string, attempts int, lastError string) error {
+	return updateLeasedJob(ctx, db, `
+        UPDATE jobs SET status = 'failed', attempts = ?, last_error = ?, lease_owner = NULL, lease_until = NULL
+        WHERE id = ? AND lease_owner = ?
+    `, attempts, lastError, id, owner)
+}
+
+// updateLeasedJob runs a statement that changes a job only while the worker holds its lease.
+func updateLeasedJob(ctx context.Context, db *sql.DB, query string, args ...interface{}) error {
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	changed, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if changed == 0 {
+		return ErrJobLeaseLost
+	}
+	return nil
+}
+
+// GetJobs returns every job, in the order they're due.
+func GetJobs(ctx context.Context, db *sql.DB) ([]Job, error) {
+	rows, err := db.QueryContext(ctx, `SELECT `+jobColumns+` FROM jobs ORDER BY run_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []Job
+	for rows.Next() {
+		j, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	return jobs, rows.Err()
+}
+
+// RecordJobRun adds a run to the job history.
+func RecordJobRun(ctx context.Context, db *sql.DB, run JobRun) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO job_runs (job_id, name, started_at, finished_at, error)
+        VALUES (?, ?, ?, ?, ?)
+    `, run.JobID, run.Name, run.StartedAt.UTC(), run.FinishedAt.UTC(), run.Error)
+	return err
+}
+
+// GetJobRuns returns the latest runs of the jobs with the given name, newest first.
+func GetJobRuns(ctx context.Context, db *sql.DB, name string, limit int) ([]JobRun, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT id, job_id, name, started_at, finished_at, error FROM job_runs
+        WHERE name = ?
+        ORDER BY id DESC
+        LIMIT ?
+    `, name, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []JobRun
+	for rows.Next() {
+		var r JobRun
+		if err := rows.Scan(&r.ID, &r.JobID, &r.Name, &r.StartedAt, &r.FinishedAt, &r.Error); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+
+	return runs, rows.Err()
+}
@@ -0,0 +1,207 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Notification categories. Users can turn each alert category off.
+const (
+	NotifyNewMatch        = "new_match"
+	NotifyPriceDrop       = "price_drop"
+	NotifyPriceRise       = "price_rise"
+	NotifyUnderOffer      = "under_offer"
+	NotifyLetAgreed       = "let_agreed"
+	NotifyWithdrawn       = "withdrawn"
+	NotifyViewingReminder = "viewing_reminder"
+	// NotifyDigest is for digests, which users turn off with /digest rather than as a category
+	NotifyDigest = "digest"
+)
+
+// AlertCategories are the notification categories users can turn on or off, in the order they're shown.
+var AlertCategories = []string{NotifyNewMatch, NotifyPriceDrop, NotifyPriceRise, NotifyUnderOffer, NotifyLetAgreed, NotifyWithdrawn, NotifyViewingReminder}
+
+// NotificationSettings control when and how often the bot may message a user unprompted.
+type NotificationSettings struct {
+	UserID     int64
+	QuietStart string // start of quiet hours as HH:MM, or empty for no quiet hours
+	QuietEnd   string // end of quiet hours as HH:MM
+	Timezone   string // IANA timezone name that quiet hours and days are in
+	MaxPerDay  int    // most notifications sent in a day, or 0 for no limit
+	// DisabledCategories are the alert categories the user turned off
+	DisabledCategories map[string]bool
+	// MutedUntil is when a mute ends, and is zero if the user hasn't muted notifications
+	MutedUntil time.Time
+}
+
+// CategoryEnabled reports whether the user wants notifications in a category.
+func (s NotificationSettings) CategoryEnabled(category string) bool {
+	return !s.DisabledCategories[category]
+}
+
+// DefaultNotificationSettings returns the settings for a user who hasn't changed them:
+// every category on, with no quiet hours, limit or mute.
+func DefaultNotificationSettings(userID int64) NotificationSettings {
+	return NotificationSettings{
+		UserID:             userID,
+		Timezone:           "Europe/London",
+		DisabledCategories: make(map[string]bool),
+	}
+}
+
+// QueuedNotification is a notification held back by the user's settings until it may be sent.
+type QueuedNotification struct {
+	ID       int
+	UserID   int64
+	Category string
+	Text     string
+	// ReplyMarkup is the message's inline keyboard as JSON, or empty for none
+	ReplyMarkup string
+}
+
+// createNotificationTables creates the tables for notification settings, the notifications
+// waiting to be sent and the notifications already sent, which count towards the daily limit.
+func createNotificationTables(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS notification_settings (
+		user_id INTEGER PRIMARY KEY,
+		quiet_start TEXT NOT NULL DEFAULT '',
+		quiet_end TEXT NOT NULL DEFAULT '',
+		timezone TEXT NOT NULL DEFAULT 'Europe/London',
+		max_per_day INTEGER NOT NULL DEFAULT 0,
+		disabled_categories TEXT NOT NULL DEFAULT '{}',
+		muted_until TIMESTAMP
+	)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS notification_queue (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		category TEXT NOT NULL,
+		text TEXT NOT NULL,
+		reply_markup TEXT NOT NULL DEFAULT '',
+		queued_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS notification_log (
+		user_id INTEGER NOT NULL,
+		category TEXT NOT NULL,
+		sent_at TIMESTAMP NOT NULL
+	)
+	`)
+	return err
+}
+
+// GetNotificationSettings retrieves a user's notification settings, or the defaults if they haven't changed them.
+func GetNotificationSettings(ctx context.Context, db *sql.DB, userID int64) (NotificationSettings, error) {
+	var s NotificationSettings
+	var disabledJSON string
+	var mutedUntil sql.NullTime
+	err := db.QueryRowContext(ctx, `
+        SELECT user_id, quiet_start, quiet_end, timezone, max_per_day, disabled_categories, muted_until
+        FROM notification_settings WHERE user_id = ?
+    `, userID).Scan(&s.UserID, &s.QuietStart, &s.QuietEnd, &s.Timezone, &s.MaxPerDay, &disabledJSON, &mutedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DefaultNotificationSettings(userID), nil
+	}
+	if err != nil {
+		return NotificationSettings{}, err
+	}
+
+	if err := json.Unmarshal([]byte(disabledJSON), &s.DisabledCategories); err != nil {
+		return NotificationSettings{}, err
+	}
+	if s.DisabledCategories == nil {
+		s.DisabledCategories = make(map[string]bool)
+	}
+	s.MutedUntil = mutedUntil.Time
+	return s, nil
+}
+
+// SaveNotificationSettings saves or updates a user's notification settings.
+func SaveNotificationSettings(ctx context.Context, db *sql.DB, s NotificationSettings) error {
+	disabledJSON, err := json.Marshal(s.DisabledCategories)
+	if err != nil {
+		return err
+	}
+	var mutedUntil sql.NullTime
+	if !s.MutedUntil.IsZero() {
+		mutedUntil = sql.NullTime{Time: s.MutedUntil, Valid: true}
+	}
+
+	_, err = db.ExecContext(ctx, `
+        INSERT OR REPLACE INTO notification_settings
+        (user_id, quiet_start, quiet_end, timezone, max_per_day, disabled_categories, muted_until)
+        VALUES (?, ?, ?, ?, ?, ?, ?)
+    `, s.UserID, s.QuietStart, s.QuietEnd, s.Timezone, s.MaxPerDay, string(disabledJSON), mutedUntil)
+	return err
+}
+
+// QueueNotification holds a notification back until the user's settings allow it to be sent.
+func QueueNotification(ctx context.Context, db Execer, n QueuedNotification) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO notification_queue (user_id, category, text, reply_markup)
+        VALUES (?, ?, ?, ?)
+    `, n.UserID, n.Category, n.Text, n.ReplyMarkup)
+	return err
+}
+
+// GetQueuedNotifications returns up to limit queued notifications, oldest first.
+func GetQueuedNotifications(ctx context.Context, db *sql.DB, limit int) ([]QueuedNotification, error) {
+	rows, err := db.QueryContext(ctx, `
+        SELECT id, user_id, category, text, reply_markup FROM notification_queue
+        ORDER BY id
+        LIMIT ?
+    `, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queued []QueuedNotification
+	for rows.Next() {
+		var n QueuedNotification
+		if err := rows.Scan(&n.ID, &n.UserID, &n.Category, &n.Text, &n.ReplyMarkup); err != nil {
+			return nil, err
+		}
+		queued = append(queued, n)
+	}
+
+	return queued, rows.Err()
+}
+
+// DeleteQueuedNotification removes a notification from the queue once it has been sent or dropped.
+func DeleteQueuedNotification(ctx context.Context, db Execer, id int) error {
+	_, err := db.ExecContext(ctx, "DELETE FROM notification_queue WHERE id = ?", id)
+	return err
+}
+
+// RecordNotificationSent records that a notification was sent, or added to the outbox to be sent,
+// so it counts towards the user's daily limit.
+func RecordNotificationSent(ctx context.Context, db Execer, userID int64, category string, sentAt time.Time) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO notification_log (user_id, category, sent_at)
+        VALUES (?, ?, ?)
+    `, userID, category, sentAt.UTC())
+	return err
+}
+
+// CountNotificationsSince returns how many notifications were sent to a user since the given time.
+// Times are stored in UTC so they compare in order.
+func CountNotificationsSince(ctx context.Context, db *sql.DB, userID int64, since time.Time) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, `
+        SELECT COUNT(*) FROM notification_log
+        WHERE user_id = ? AND sent_at >= ?
+    `, userID, since.UTC()).Scan(&count)
+	return count, err
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Outbox message statuses.
+const (
+	OutboxPending   = "pending"
+	OutboxDelivered = "delivered"
+	OutboxFailed    = "failed" // gave up on, kept for operators to look at
+)
+
+// OutboxMessage is a notification waiting to be delivered. It is written in the same transaction as
+// the change it tells the user about, so the notification isn't lost if sending it fails.
+type OutboxMessage struct {
+	ID       int
+	UserID   int64
+	Category string
+	Text     string
+	// ReplyMarkup is the message's inline keyboard as JSON, or empty for none
+	ReplyMarkup   string
+	Status        string
+	Attempts      int // failed attempts to deliver it
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+}
+
+// createOutboxTable creates the table of notifications waiting to be delivered.
+// Times are stored in UTC so they compare in order.
+func createOutboxTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS outbox (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		category TEXT NOT NULL,
+		text TEXT NOT NULL,
+		reply_markup TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		next_attempt_at TIMESTAMP NOT NULL,
+		last_error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP NOT NULL,
+		delivered_at TIMESTAMP
+	)
+	`)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox (status, next_attempt_at)")
+	return err
+}
+
+// AddOutboxMessage adds a message to the outbox, to be delivered straight away.
+func AddOutboxMessage(ctx context.Context, db Execer, m OutboxMessage, now time.Time) error {
+	_, err := db.ExecContext(ctx, `
+        INSERT INTO outbox (user_id, category, text, reply_markup, next_attempt_at, created_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+    `, m.UserID, m.Category, m.Text, m.ReplyMarkup, now.UTC(), now.UTC())
+	return err
+}
+
+const outboxColumns = "id, user_id, category, text, reply_markup, status, attempts, next_attempt_at, last_error, created_at"
+
+// getOutboxMessages returns the outbox messages selected by a query on outboxColumns.
+func getOutboxMessages(ctx context.Context, db *sql.DB, query string, args ...interface{}) ([]OutboxMessage, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []OutboxMessage
+	for rows.Next() {
+		var m OutboxMessage
+		err := rows.Scan(&m.ID, &m.UserID, &m.Category, &m.Text, &m.ReplyMarkup, &m.Status, &m.Attempts,
+			&m.NextAttemptAt, &m.LastError, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+
+	return messages, rows.Err()
+}
+
+// GetDueOutboxMessages returns up to limit pending messages that are due to be delivered, oldest first.
+func GetDueOutboxMessages(ctx context.Context, db *sql.DB, now time.Time, limit int) ([]OutboxMessage, error) {
+	return getOutboxMessages(ctx, db, `
+        SELECT `+outboxColumns+` FROM outbox
+        WHERE status = 'pending' AND next_attempt_at <= ?
+        ORDER BY id
+        LIMIT ?
+    `, now.UTC(), limit)
+}
+
+// stuckOutboxCondition selects messages that failed for good, or are still pending although they
+// were added before a given time.
+const stuckOutboxCondition = "status = 'failed' OR (status = 'pending' AND created_at < ?)"
+
+// GetStuckOutboxMessages returns up to limit messages that failed for good, or are still pending
+// although they were added before the given time, newest first, so recent problems aren't hidden
+// behind old ones.
+func GetStuckOutboxMessages(ctx context.Context, db *sql.DB, pendingSince time.Time, limit int) ([]OutboxMessage, error) {
+	return getOutboxMessages(ctx, db, `
+        SELECT `+outboxColumns+` FROM outbox
+        WHERE `+stuckOutboxCondition+`
+        ORDER BY id DESC
+        LIMIT ?
+    `, pendingSince.UTC(), limit)
+}
+
+// CountStuckOutboxMessages returns how many messages GetStuckOutboxMessages would return without a limit.
+func CountStuckOutboxMessages(ctx context.Context, db *sql.DB, pendingSince time.Time) (int, error) {
+	var count int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM outbox WHERE "+stuckOutboxCondition, pendingSince.UTC()).Scan(&count)
+	return count, err
+}
+
+// MarkOutboxDelivered records that a message was delivered.
+func MarkOutboxDelivered(ctx context.Context, db *sql.DB, id int, deliveredAt time.Time) error {
+	_, err := db.ExecContext(ctx, `
+        UPDATE outbox SET status = 'delivered', delivered_at = ?, last_error = ''
+        WHERE id = ?
+    `, deliveredAt.UTC(), id)
+	return err
+}
+
+// RetryOutboxMessage records that delivering a message failed, and tries again at nextAttemptAt.
+func RetryOutboxMessage(ctx context.Context, db *sql.DB, id, attempts int, nextAttemptAt time.Time, lastError string) error {
+	_, err := db.ExecContext(ctx, `
+        UPDATE outbox SET attempts = ?, next_attempt_at = ?, last_error = ?
+        WHERE id = ?
+    `, attempts, nextAttemptAt.UTC(), lastError, id)
+	return err
+}
+
+// FailOutboxMessage records that a message can't be delivered. It is kept, but never tried again.
+func FailOutboxMessage(ctx context.Context, db *sql.DB, id, attempts int, lastError string) error {
+	_, err := db.ExecContext(ctx, `
+        UPDATE outbox SET status = 'failed', attempts = ?, last_error = ?
+        WHERE id = ?
+    `, attempts, lastError, id)
+	return err
+}
@@ -0,0 +1,182 @@
+// Package dispatch handles updates with a fixed pool of workers.
+//
+// Each update has a key, such as the user it's from. Updates with the same key are handled one at a time,
+// in the order they were dispatched, so a user's updates never race each other, while updates with different
+// keys are handled in parallel. Once the queue is full, Dispatch waits for room, so a burst of updates slows
+// down the caller rather than piling up goroutines.
+package dispatch
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"sync"
+	"time"
+)
+
+// Default settings.
+const (
+	DefaultWorkers   = 16  // updates handled at once
+	DefaultQueueSize = 256 // updates waiting or being handled before Dispatch waits for room
+)
+
+// ErrClosed is returned by Dispatch once the dispatcher is closed.
+var ErrClosed = errors.New("dispatcher closed")
+
+// Handler handles an update.
+type Handler func(ctx context.Context, update tgbotapi.Update)
+
+// Stats are counters describing the dispatcher's work so far.
+type Stats struct {
+	Queued    int           // updates waiting to be handled
+	Handling  int           // updates being handled
+	Handled   int64         // updates handled
+	Throttled int64         // dispatches that had to wait for room in the queue
+	QueueTime time.Duration // total time updates waited before being handled
+	MaxQueue  time.Duration // longest time an update waited before being handled
+}
+
+// AverageQueueTime returns how long updates waited before being handled, on average.
+func (s Stats) AverageQueueTime() time.Duration {
+	if s.Handled == 0 {
+		return 0
+	}
+	return s.QueueTime / time.Duration(s.Handled)
+}
+
+// item is a dispatched update waiting to be handled.
+type item struct {
+	ctx    context.Context
+	update tgbotapi.Update
+	queued time.Time
+}
+
+// Dispatcher hands updates to its workers. Create one with New.
+type Dispatcher struct {
+	handle Handler
+	now    func() time.Time
+
+	slots    chan struct{} // one for each update waiting or being handled
+	ready    chan int64    // keys with updates waiting and none being handled, in the order they became ready
+	inFlight sync.WaitGroup
+
+	mu      sync.Mutex
+	pending map[int64][]item
+	closed  bool
+	stats   Stats
+}
+
+// New creates a dispatcher that handles updates with handle, using the given number of workers,
+// and holds up to queueSize updates before Dispatch waits for room.
+func New(handle Handler, workers, queueSize int) *Dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < workers {
+		queueSize = workers
+	}
+	d := &Dispatcher{
+		handle:  handle,
+		now:     time.Now,
+		slots:   make(chan struct{}, queueSize),
+		ready:   make(chan int64, queueSize),
+		pending: make(map[int64][]item),
+	}
+	for i := 0; i < workers; i++ {
+		go d.work()
+	}
+	return d
+}
+
+// Dispatch queues an update to be handled with ctx after the earlier updates with the same key.
+// If the queue is full it waits for room, and returns ctx's error if ctx is done first.
+func (d *Dispatcher) Dispatch(ctx context.Context, key int64, update tgbotapi.Update) error {
+	select {
+	case d.slots <- struct{}{}:
+	default:
+		d.mu.Lock()
+		d.stats.Throttled++
+		d.mu.Unlock()
+		select {
+		case d.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		<-d.slots
+		return ErrClosed
+	}
+	d.inFlight.Add(1)
+	d.stats.Queued++
+	queue := d.pending[key]
+	d.pending[key] = append(queue, item{ctx: ctx, update: update, queued: d.now()})
+	if len(queue) == 0 {
+		// The key isn't waiting or being handled, so it's ready. There's room, as each ready key holds a slot.
+		d.ready <- key
+	}
+	return nil
+}
+
+// work handles the next update of each ready key, until the dispatcher is closed and its updates are handled.
+func (d *Dispatcher) work() {
+	for key := range d.ready {
+		d.mu.Lock()
+		next := d.pending[key][0]
+		wait := d.now().Sub(next.queued)
+		d.stats.Queued--
+		d.stats.Handling++
+		d.stats.QueueTime += wait
+		if wait > d.stats.MaxQueue {
+			d.stats.MaxQueue = wait
+		}
+		d.mu.Unlock()
+
+		d.handle(next.ctx, next.update)
+
+		d.mu.Lock()
+		d.stats.Handling--
+		d.stats.Handled++
+		// The update stays at the front of its queue while it's handled, so later ones for the key wait
+		if rest := d.pending[key][1:]; len(rest) > 0 {
+			d.pending[key] = rest
+			d.ready <- key
+		} else {
+			delete(d.pending, key)
+		}
+		d.mu.Unlock()
+
+		<-d.slots
+		d.inFlight.Done()
+	}
+}
+
+// Close stops the dispatcher taking updates. The updates already queued are still handled,
+// after which the workers stop.
+func (d *Dispatcher) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		return
+	}
+	d.closed = true
+	go func() {
+		d.inFlight.Wait()
+		close(d.ready)
+	}()
+}
+
+// Wait waits until the queued updates have been handled.
+func (d *Dispatcher) Wait() {
+	d.inFlight.Wait()
+}
+
+// Stats returns the dispatcher's counters.
+func (d *Dispatcher) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
@@ -0,0 +1,159 @@
+package dispatch
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"sync"
+	"testing"
+	"time"
+)
+
+// update returns an update with the given ID
+func update(id int) tgbotapi.Update {
+	return tgbotapi.Update{UpdateID: id}
+}
+
+// waitOrFail waits for the channel to be closed, failing the test if it takes too long
+func waitOrFail(t *testing.T, done <-chan struct{}, what string) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for %s", what)
+	}
+}
+
+func TestSameKeyInOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := make(map[int64][]int)
+	active := make(map[int64]bool)
+	overlapped := false
+
+	d := New(func(ctx context.Context, u tgbotapi.Update) {
+		key := int64(u.UpdateID % 3)
+		mu.Lock()
+		if active[key] {
+			overlapped = true
+		}
+		active[key] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		active[key] = false
+		handled[key] = append(handled[key], u.UpdateID)
+		mu.Unlock()
+	}, 4, 8)
+
+	for id := 0; id < 30; id++ {
+		if err := d.Dispatch(context.Background(), int64(id%3), update(id)); err != nil {
+			t.Fatalf("Dispatch returned an error: %v", err)
+		}
+	}
+	d.Close()
+	d.Wait()
+
+	if overlapped {
+		t.Error("Expected updates with the same key to be handled one at a time")
+	}
+	for key, ids := range handled {
+		if len(ids) != 10 {
+			t.Errorf("Expected 10 updates for key %d, got %v", key, ids)
+		}
+		for i := 1; i < len(ids); i++ {
+			if ids[i] < ids[i-1] {
+				t.Errorf("Expected updates for key %d in order, got %v", key, ids)
+				break
+			}
+		}
+	}
+	if stats := d.Stats(); stats.Handled != 30 || stats.Queued != 0 || stats.Handling != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestDifferentKeysInParallel(t *testing.T) {
+	release := make(chan struct{})
+	var started sync.WaitGroup
+	started.Add(2)
+	d := New(func(ctx context.Context, u tgbotapi.Update) {
+		started.Done()
+		<-release
+	}, 2, 4)
+	defer d.Close()
+
+	d.Dispatch(context.Background(), 1, update(1))
+	d.Dispatch(context.Background(), 2, update(2))
+
+	both := make(chan struct{})
+	go func() {
+		started.Wait()
+		close(both)
+	}()
+	waitOrFail(t, both, "updates for two keys to be handled at once")
+	close(release)
+}
+
+func TestBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	d := New(func(ctx context.Context, u tgbotapi.Update) {
+		<-release
+	}, 1, 1)
+	defer d.Close()
+
+	if err := d.Dispatch(context.Background(), 1, update(1)); err != nil {
+		t.Fatalf("Dispatch returned an error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := d.Dispatch(ctx, 2, update(2)); err != context.DeadlineExceeded {
+		t.Errorf("Expected Dispatch to wait for room until the context was done, got %v", err)
+	}
+
+	dispatched := make(chan struct{})
+	go func() {
+		d.Dispatch(context.Background(), 2, update(3))
+		close(dispatched)
+	}()
+	close(release)
+	waitOrFail(t, dispatched, "room in the queue")
+
+	if stats := d.Stats(); stats.Throttled < 1 {
+		t.Errorf("Expected the dispatch that waited to be counted, got %+v", stats)
+	}
+}
+
+func TestClose(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var handled []int
+	d := New(func(ctx context.Context, u tgbotapi.Update) {
+		<-release
+		mu.Lock()
+		handled = append(handled, u.UpdateID)
+		mu.Unlock()
+	}, 1, 4)
+
+	d.Dispatch(context.Background(), 1, update(1))
+	d.Dispatch(context.Background(), 1, update(2))
+	d.Close()
+	if err := d.Dispatch(context.Background(), 1, update(3)); err != ErrClosed {
+		t.Errorf("Expected ErrClosed after closing, got %v", err)
+	}
+
+	close(release)
+	done := make(chan struct{})
+	go func() {
+		d.Wait()
+		close(done)
+	}()
+	waitOrFail(t, done, "the queued updates to be handled")
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(handled) != 2 || handled[0] != 1 || handled[1] != 2 {
+		t.Errorf("Expected the queued updates to be handled in order, got %v", handled)
+	}
+}
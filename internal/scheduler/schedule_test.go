@@ -0,0 +1,43 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{"@every 5m", time.Date(2026, 3, 2, 9, 0, 30, 0, time.UTC), time.Date(2026, 3, 2, 9, 5, 30, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 3, 2, 10, 7, 0, 0, time.UTC), time.Date(2026, 3, 2, 10, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 2, 10, 0, 0, 0, time.UTC), time.Date(2026, 3, 2, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 12, 31, 23, 59, 59, 0, time.UTC), time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		// Saturday, so the next weekday is Monday
+		{"30 7 * * 1-5", time.Date(2026, 3, 7, 8, 0, 0, 0, time.UTC), time.Date(2026, 3, 9, 7, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 8, 0, 0, 0, 0, time.UTC)},
+		{"0 9,17 * 6 *", time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)},
+		// The 13th or any Friday, whichever comes first
+		{"0 0 13 * 5", time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC), time.Date(2026, 3, 6, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		schedule, err := ParseSchedule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseSchedule(%q) returned error: %v", tt.spec, err)
+			continue
+		}
+		if got := schedule.Next(tt.after); !got.Equal(tt.want) {
+			t.Errorf("ParseSchedule(%q).Next(%v) = %v, want %v", tt.spec, tt.after, got, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *", "@every 0s", "@every soon", "@monthly"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) should have returned an error", spec)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+// Package scheduler runs background jobs that are persisted in the database, so they survive restarts.
+//
+// Jobs are either recurring, on a Schedule, or one-off. A worker leases the jobs that are due before
+// running them, so each job runs on one worker at a time. A job whose worker stops is run again once
+// its lease ends, so jobs run at least once and handlers must be safe to repeat. Failed jobs are retried
+// with exponential backoff, and every run is recorded in the job history.
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"imitation_project/internal/database"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Scheduler settings.
+const (
+	defaultLeaseDuration = 5 * time.Minute  // how long a worker may run a job before others may claim it
+	defaultPollInterval  = 10 * time.Second // how often due jobs are looked for
+	defaultMaxAttempts   = 5                // failed attempts before a job is given up on
+	claimBatchSize       = 10               // jobs claimed at a time
+	baseRetryDelay       = 30 * time.Second
+	maxRetryDelay        = time.Hour
+)
+
+// Clock tells the scheduler the time, so tests can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the real clock.
+type SystemClock struct{}
+
+// Now returns the current time.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Handler runs a job. A job whose handler returns an error is retried with backoff.
+type Handler func(ctx context.Context, job database.Job) error
+
+// Scheduler runs the jobs stored in the database with the handlers registered for them.
+type Scheduler struct {
+	db       *sql.DB
+	clock    Clock
+	owner    string // identifies this worker in job leases
+	mu       sync.Mutex
+	handlers map[string]Handler
+
+	leaseDuration time.Duration
+	pollInterval  time.Duration
+	maxAttempts   int
+}
+
+// New creates a scheduler that stores its jobs in the database and tells the time with the clock.
+func New(db *sql.DB, clock Clock) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		db:            db,
+		clock:         clock,
+		owner:         fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano()),
+		handlers:      make(map[string]Handler),
+		leaseDuration: defaultLeaseDuration,
+		pollInterval:  defaultPollInterval,
+		maxAttempts:   defaultMaxAttempts,
+	}
+}
+
+// Now returns the scheduler's current time.
+func (s *Scheduler) Now() time.Time {
+	return s.clock.Now()
+}
+
+// Register sets the handler that runs the jobs with the given name.
+func (s *Scheduler) Register(name string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[name] = handler
+}
+
+// Every adds a recurring job that runs on the given schedule, as described by ParseSchedule.
+// If the job already exists it keeps its next run time, unless the schedule changed.
+func (s *Scheduler) Every(ctx context.Context, name, spec string) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+	return database.UpsertRecurringJob(ctx, s.db, name, spec, schedule.Next(s.clock.Now()))
+}
+
+// Once adds a one-off job that runs at the given time.
+func (s *Scheduler) Once(ctx context.Context, name, payload string, at time.Time) error {
+	return database.AddJob(ctx, s.db, name, payload, at)
+}
+
+// Run runs due jobs until the context is cancelled.
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.RunDue(ctx); err != nil {
+			log.Printf("Error running scheduled jobs: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue runs every job that is due, and returns once they've all run.
+func (s *Scheduler) RunDue(ctx context.Context) error {
+	for ctx.Err() == nil {
+		now := s.clock.Now()
+		jobs, err := database.ClaimDueJobs(ctx, s.db, s.owner, now, now.Add(s.leaseDuration), claimBatchSize)
+		if err != nil {
+			return fmt.Errorf("error claiming jobs: %w", err)
+		}
+		for _, job := range jobs {
+			if err := s.runJob(ctx, job); err != nil {
+				log.Printf("Error finishing job %d (%s): %v", job.ID, job.Name, err)
+			}
+		}
+		if len(jobs) < claimBatchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
+
+// runJob runs a leased job, records the run and reschedules, retries or finishes the job.
+func (s *Scheduler) runJob(ctx context.Context, job database.Job) error {
+	started := s.clock.Now()
+	runErr := s.handle(ctx, job)
+	finished := s.clock.Now()
+	// The outcome is recorded even if the scheduler was stopped while the job ran, so it isn't run again
+	ctx = context.WithoutCancel(ctx)
+
+	run := database.JobRun{JobID: job.ID, Name: job.Name, StartedAt: started, FinishedAt: finished}
+	if runErr != nil {
+		run.Error = runErr.Error()
+	}
+	if err := database.RecordJobRun(ctx, s.db, run); err != nil {
+		log.Printf("Error recording run of job %d (%s): %v", job.ID, job.Name, err)
+	}
+
+	var next time.Time
+	if job.Schedule != "" {
+		schedule, err := ParseSchedule(job.Schedule)
+		if err != nil {
+			// Stop the job until Every sets a valid schedule
+			return database.FailJob(ctx, s.db, job.ID, s.owner, job.Attempts, err.Error())
+		}
+		next = schedule.Next(finished)
+	}
+
+	if runErr == nil {
+		return database.CompleteJob(ctx, s.db, job.ID, s.owner, next)
+	}
+
+	attempts := job.Attempts + 1
+	switch {
+	case attempts < s.maxAttempts:
+		return database.RetryJob(ctx, s.db, job.ID, s.owner, attempts, finished.Add(RetryDelay(attempts)), runErr.Error())
+	case job.Schedule != "":
+		// Give up on this run of a recurring job, and wait for its next one
+		return database.RetryJob(ctx, s.db, job.ID, s.owner, 0, next, runErr.Error())
+	default:
+		return database.FailJob(ctx, s.db, job.ID, s.owner, attempts, runErr.Error())
+	}
+}
+
+// handle runs a job with its handler, turning a panic into an error so it doesn't stop the scheduler.
+func (s *Scheduler) handle(ctx context.Context, job database.Job) (err error) {
+	s.mu.Lock()
+	handler, ok := s.handlers[job.Name]
+	s.mu.Unlock()
+	if !ok {
+		return errors.New("no handler registered for job " + job.Name)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// RetryDelay returns how long to wait before retrying work that has failed the given number of times.
+// The delay doubles with each attempt, up to maxRetryDelay.
+func RetryDelay(attempts int) time.Duration {
+	delay := baseRetryDelay
+	for i := 1; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
+}
@@ -0,0 +1,231 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"imitation_project/internal/database"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock the tests move forward by hand.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestScheduler(t *testing.T) (*Scheduler, *fakeClock, *sql.DB) {
+	t.Helper()
+	db, err := database.InitDB(filepath.Join(t.TempDir(), "jobs.db"))
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	clock := &fakeClock{now: time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)}
+	return New(db, clock), clock, db
+}
+
+func getJobs(t *testing.T, db *sql.DB) []database.Job {
+	ctx := context.Background()
+	t.Helper()
+	jobs, err := database.GetJobs(ctx, db)
+	if err != nil {
+		t.Fatalf("Failed to get jobs: %v", err)
+	}
+	return jobs
+}
+
+func TestRecurringJob(t *testing.T) {
+	ctx := context.Background()
+	s, clock, db := newTestScheduler(t)
+	runs := 0
+	s.Register("tick", func(ctx context.Context, job database.Job) error {
+		runs++
+		return nil
+	})
+	if err := s.Every(ctx, "tick", "@every 1m"); err != nil {
+		t.Fatalf("Every returned error: %v", err)
+	}
+
+	if err := s.RunDue(ctx); err != nil {
+		t.Fatalf("RunDue returned error: %v", err)
+	}
+	if runs != 0 {
+		t.Fatalf("Expected the job not to run before it's due, ran %d times", runs)
+	}
+
+	clock.Advance(time.Minute)
+	if err := s.RunDue(ctx); err != nil {
+		t.Fatalf("RunDue returned error: %v", err)
+	}
+	if runs != 1 {
+		t.Fatalf("Expected the job to run once, ran %d times", runs)
+	}
+	jobs := getJobs(t, db)
+	if len(jobs) != 1 || !jobs[0].RunAt.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("Expected the job to be rescheduled a minute later, got %+v", jobs)
+	}
+
+	// A restart adds the job again, which keeps its next run time
+	clock.Advance(30 * time.Second)
+	restarted := New(db, clock)
+	if err := restarted.Every(ctx, "tick", "@every 1m"); err != nil {
+		t.Fatalf("Every returned error: %v", err)
+	}
+	if again := getJobs(t, db); len(again) != 1 || !again[0].RunAt.Equal(jobs[0].RunAt) {
+		t.Errorf("Expected a restart to keep the job's run time, got %+v", again)
+	}
+
+	history, err := database.GetJobRuns(ctx, db, "tick", 10)
+	if err != nil {
+		t.Fatalf("Failed to get job runs: %v", err)
+	}
+	if len(history) != 1 || history[0].Error != "" || !history[0].StartedAt.Equal(clock.Now().Add(-30*time.Second)) {
+		t.Errorf("Expected one successful run in the history, got %+v", history)
+	}
+}
+
+func TestOneOffJobRetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	s, clock, db := newTestScheduler(t)
+	var payloads []string
+	s.Register("send", func(ctx context.Context, job database.Job) error {
+		payloads = append(payloads, job.Payload)
+		if len(payloads) < 3 {
+			return errors.New("network down")
+		}
+		return nil
+	})
+	if err := s.Once(ctx, "send", "89012", clock.Now()); err != nil {
+		t.Fatalf("Once returned error: %v", err)
+	}
+
+	start := clock.Now()
+	s.RunDue(ctx)
+	if jobs := getJobs(t, db); len(jobs) != 1 || jobs[0].Attempts != 1 || !jobs[0].RunAt.Equal(start.Add(30*time.Second)) {
+		t.Fatalf("Expected the job to be retried in 30 seconds, got %+v", jobs)
+	}
+
+	clock.Advance(10 * time.Second)
+	s.RunDue(ctx)
+	if len(payloads) != 1 {
+		t.Fatalf("Expected the job not to be retried early, ran %d times", len(payloads))
+	}
+
+	clock.Advance(20 * time.Second)
+	s.RunDue(ctx)
+	if jobs := getJobs(t, db); len(jobs) != 1 || jobs[0].Attempts != 2 || !jobs[0].RunAt.Equal(clock.Now().Add(time.Minute)) {
+		t.Fatalf("Expected the retry delay to double, got %+v", jobs)
+	}
+
+	clock.Advance(time.Minute)
+	s.RunDue(ctx)
+	if jobs := getJobs(t, db); len(jobs) != 0 {
+		t.Errorf("Expected the job to be removed once it succeeded, got %+v", jobs)
+	}
+	for _, payload := range payloads {
+		if payload != "89012" {
+			t.Errorf("Expected the handler to get the job's payload, got %q", payload)
+		}
+	}
+
+	history, _ := database.GetJobRuns(ctx, db, "send", 10)
+	if len(history) != 3 || history[0].Error != "" || history[1].Error != "network down" || history[2].Error != "network down" {
+		t.Errorf("Expected two failed runs and a successful one in the history, got %+v", history)
+	}
+}
+
+func TestOneOffJobGivesUp(t *testing.T) {
+	ctx := context.Background()
+	s, clock, db := newTestScheduler(t)
+	s.maxAttempts = 2
+	runs := 0
+	s.Register("send", func(ctx context.Context, job database.Job) error {
+		runs++
+		panic("bad payload")
+	})
+	s.Once(ctx, "send", "", clock.Now())
+
+	for i := 0; i < 3; i++ {
+		s.RunDue(ctx)
+		clock.Advance(time.Hour)
+	}
+	if runs != 2 {
+		t.Errorf("Expected the job to run %d times, ran %d times", s.maxAttempts, runs)
+	}
+	if jobs := getJobs(t, db); len(jobs) != 1 || jobs[0].Status != database.JobFailed || jobs[0].LastError != "job panicked: bad payload" {
+		t.Errorf("Expected the job to be kept as failed, got %+v", jobs)
+	}
+}
+
+func TestRecurringJobFailing(t *testing.T) {
+	ctx := context.Background()
+	s, clock, db := newTestScheduler(t)
+	s.maxAttempts = 1
+	s.Register("tick", func(ctx context.Context, job database.Job) error {
+		return errors.New("always fails")
+	})
+	s.Every(ctx, "tick", "@hourly")
+
+	clock.Advance(time.Hour)
+	s.RunDue(ctx)
+	jobs := getJobs(t, db)
+	if len(jobs) != 1 || jobs[0].Status != database.JobScheduled || jobs[0].Attempts != 0 || !jobs[0].RunAt.Equal(clock.Now().Add(time.Hour)) {
+		t.Errorf("Expected a recurring job that keeps failing to wait for its next run, got %+v", jobs)
+	}
+}
+
+func TestExpiredLeaseIsReclaimed(t *testing.T) {
+	ctx := context.Background()
+	s, clock, db := newTestScheduler(t)
+	runs := 0
+	s.Register("send", func(ctx context.Context, job database.Job) error {
+		runs++
+		return nil
+	})
+	s.Once(ctx, "send", "", clock.Now())
+
+	// Another worker claims the job and stops before finishing it
+	claimed, err := database.ClaimDueJobs(ctx, db, "stopped-worker", clock.Now(), clock.Now().Add(s.leaseDuration), 10)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("Failed to claim job: %v, %+v", err, claimed)
+	}
+	s.RunDue(ctx)
+	if runs != 0 {
+		t.Fatalf("Expected a leased job not to run, ran %d times", runs)
+	}
+
+	clock.Advance(s.leaseDuration)
+	s.RunDue(ctx)
+	if runs != 1 {
+		t.Fatalf("Expected the job to run once its lease ended, ran %d times", runs)
+	}
+	if err := database.CompleteJob(ctx, db, claimed[0].ID, "stopped-worker", time.Time{}); err != database.ErrJobLeaseLost {
+		t.Errorf("Expected the stopped worker to have lost the lease, got %v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		8:  time.Hour,
+		50: time.Hour,
+	}
+	for attempts, want := range tests {
+		if got := RetryDelay(attempts); got != want {
+			t.Errorf("RetryDelay(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
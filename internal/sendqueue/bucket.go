@@ -0,0 +1,53 @@
+package sendqueue
+
+import "time"
+
+// Rate is a token bucket limit: PerSecond requests a second on average, in bursts of up to Burst.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// bucket is a token bucket. It starts full, and refills at its rate up to its burst.
+type bucket struct {
+	rate    Rate
+	tokens  float64
+	updated time.Time
+}
+
+// newBucket creates a full bucket.
+func newBucket(rate Rate, now time.Time) *bucket {
+	return &bucket{rate: rate, tokens: float64(rate.Burst), updated: now}
+}
+
+// refill adds the tokens earned since the bucket was last updated.
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.updated) {
+		b.tokens += now.Sub(b.updated).Seconds() * b.rate.PerSecond
+		if b.tokens > float64(b.rate.Burst) {
+			b.tokens = float64(b.rate.Burst)
+		}
+		b.updated = now
+	}
+}
+
+// wait returns how long until the bucket has a token, or zero if it has one now.
+func (b *bucket) wait(now time.Time) time.Duration {
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate.PerSecond * float64(time.Second))
+}
+
+// take uses a token. The caller checks with wait that one is available.
+func (b *bucket) take(now time.Time) {
+	b.refill(now)
+	b.tokens--
+}
+
+// full reports whether the bucket has refilled completely, so forgetting it changes nothing.
+func (b *bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.rate.Burst)
+}
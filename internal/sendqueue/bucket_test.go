@@ -0,0 +1,30 @@
+package sendqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	start := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	b := newBucket(Rate{PerSecond: 2, Burst: 3}, start)
+
+	for i := 0; i < 3; i++ {
+		if wait := b.wait(start); wait != 0 {
+			t.Fatalf("Expected a burst of 3, but request %d had to wait %v", i+1, wait)
+		}
+		b.take(start)
+	}
+	if wait := b.wait(start); wait != 500*time.Millisecond {
+		t.Errorf("Expected to wait 500ms for the next token, got %v", wait)
+	}
+	if wait := b.wait(start.Add(250 * time.Millisecond)); wait != 250*time.Millisecond {
+		t.Errorf("Expected to wait 250ms after half a token refilled, got %v", wait)
+	}
+	if b.full(start.Add(time.Second)) {
+		t.Error("Expected the bucket not to be full after refilling 2 of 3 tokens")
+	}
+	if !b.full(start.Add(time.Hour)) || b.tokens != 3 {
+		t.Errorf("Expected the bucket to refill up to its burst, got %v tokens", b.tokens)
+	}
+}
@@ -0,0 +1,350 @@
+// Package sendqueue sends requests to Telegram through one queue that keeps within Telegram's rate limits.
+//
+// Every request takes a token from a global bucket, and requests to a chat also take one from that chat's
+// bucket, with a lower rate for group chats. Requests to the same chat are sent in order. Interactive
+// requests, such as replies to the user, go ahead of broadcasts, such as alerts. When Telegram answers
+// with 429 Too Many Requests, nothing is sent until it says to retry, and the request is tried again.
+package sendqueue
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"sync"
+	"time"
+)
+
+// Queue settings.
+const (
+	maxConcurrentSends = 8 // requests sent at once, to different chats
+	maxRateLimitRetry  = 3 // times a request is retried after a 429 before its error is returned
+	maxIdleBuckets     = 1000
+)
+
+// ErrStopped is returned for requests that weren't sent because the queue stopped.
+var ErrStopped = errors.New("send queue stopped")
+
+// API is the part of the Telegram bot API that sends requests.
+type API interface {
+	Send(c tgbotapi.Chattable) (tgbotapi.Message, error)
+	Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error)
+}
+
+// Limits are the rate limits the queue keeps to.
+type Limits struct {
+	Global   Rate // across all chats
+	PerChat  Rate // to one private chat
+	PerGroup Rate // to one group chat
+}
+
+// DefaultLimits are Telegram's documented limits: about 30 messages a second overall,
+// one a second to a chat, and 20 a minute to a group.
+var DefaultLimits = Limits{
+	Global:   Rate{PerSecond: 30, Burst: 30},
+	PerChat:  Rate{PerSecond: 1, Burst: 3},
+	PerGroup: Rate{PerSecond: 20.0 / 60, Burst: 5},
+}
+
+// Priorities of requests. Lower values are sent first.
+const (
+	interactive = iota
+	broadcast
+	priorities
+)
+
+// request is a call waiting in the queue.
+type request struct {
+	c        tgbotapi.Chattable
+	chatID   int64 // zero for requests that aren't to a chat, such as answering callbacks
+	send     bool  // whether to call Send rather than Request
+	priority int
+	retries  int
+	done     chan result
+}
+
+// result is what the API returned for a request.
+type result struct {
+	msg  tgbotapi.Message
+	resp *tgbotapi.APIResponse
+	err  error
+}
+
+// Queue sends requests through the API within the rate limits. Run must be running for requests to be sent.
+// Send and Request send interactive requests; use Broadcaster for broadcasts.
+type Queue struct {
+	api         API
+	limits      Limits
+	now         func() time.Time
+	concurrency int // requests sent at once
+
+	mu          sync.Mutex
+	pending     [priorities][]*request
+	global      *bucket
+	chats       map[int64]*bucket
+	inFlight    map[int64]bool // chats with a request being sent, so their next one waits for it
+	active      int
+	pausedUntil time.Time
+	stopped     bool
+	wake        chan struct{}
+	sending     sync.WaitGroup
+}
+
+// New creates a queue that sends through the API within the limits.
+func New(api API, limits Limits) *Queue {
+	now := time.Now()
+	return &Queue{
+		api:         api,
+		limits:      limits,
+		now:         time.Now,
+		concurrency: maxConcurrentSends,
+		global:      newBucket(limits.Global, now),
+		chats:       make(map[int64]*bucket),
+		inFlight:    make(map[int64]bool),
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// Send sends an interactive request and returns the message Telegram sent.
+func (q *Queue) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	r := q.enqueue(c, true, interactive)
+	return r.msg, r.err
+}
+
+// Request makes an interactive request and returns Telegram's response.
+func (q *Queue) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	r := q.enqueue(c, false, interactive)
+	return r.resp, r.err
+}
+
+// Broadcaster returns an API whose requests are sent only when no interactive request could be sent instead.
+func (q *Queue) Broadcaster() API {
+	return broadcaster{q}
+}
+
+// broadcaster sends requests through a queue as broadcasts.
+type broadcaster struct {
+	q *Queue
+}
+
+func (b broadcaster) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	r := b.q.enqueue(c, true, broadcast)
+	return r.msg, r.err
+}
+
+func (b broadcaster) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	r := b.q.enqueue(c, false, broadcast)
+	return r.resp, r.err
+}
+
+// enqueue adds a request to the queue and waits for it to be sent.
+func (q *Queue) enqueue(c tgbotapi.Chattable, send bool, priority int) result {
+	req := &request{c: c, chatID: chatID(c), send: send, priority: priority, done: make(chan result, 1)}
+	q.mu.Lock()
+	if q.stopped {
+		q.mu.Unlock()
+		return result{err: ErrStopped}
+	}
+	q.pending[priority] = append(q.pending[priority], req)
+	q.mu.Unlock()
+	q.signal()
+	return <-req.done
+}
+
+// signal wakes Run to look at the queue again.
+func (q *Queue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run sends queued requests until the context is cancelled. It then fails the requests still waiting
+// with ErrStopped, and returns once the requests being sent have finished.
+func (q *Queue) Run(ctx context.Context) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	defer q.stop()
+
+	for {
+		q.mu.Lock()
+		req, wait := q.next(q.now())
+		q.mu.Unlock()
+		if req != nil {
+			q.sending.Add(1)
+			go q.sendRequest(req)
+			continue
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if wait <= 0 {
+			wait = time.Hour
+		}
+		timer.Reset(wait)
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// stop stops taking requests, fails the ones waiting and waits for the ones being sent.
+func (q *Queue) stop() {
+	q.mu.Lock()
+	q.stopped = true
+	var waiting []*request
+	for priority := range q.pending {
+		waiting = append(waiting, q.pending[priority]...)
+		q.pending[priority] = nil
+	}
+	q.mu.Unlock()
+
+	for _, req := range waiting {
+		req.done <- result{err: ErrStopped}
+	}
+	q.sending.Wait()
+}
+
+// next takes the first request that may be sent now, highest priority first, and marks it as being sent.
+// If none may be sent, it returns how long until one might, or zero if only a new or finished request can change that.
+// The caller holds q.mu.
+func (q *Queue) next(now time.Time) (*request, time.Duration) {
+	if now.Before(q.pausedUntil) {
+		return nil, q.pausedUntil.Sub(now)
+	}
+	if q.active >= q.concurrency {
+		return nil, 0
+	}
+	if len(q.pending[interactive])+len(q.pending[broadcast]) == 0 {
+		q.forgetIdleChats(now)
+		return nil, 0
+	}
+	if wait := q.global.wait(now); wait > 0 {
+		return nil, wait
+	}
+
+	var soonest time.Duration
+	for priority := range q.pending {
+		// Once a chat's request has to wait, its later requests wait too, so they stay in order
+		waiting := make(map[int64]bool)
+		for i, req := range q.pending[priority] {
+			if req.chatID != 0 && (q.inFlight[req.chatID] || waiting[req.chatID]) {
+				waiting[req.chatID] = true
+				continue
+			}
+			if chat := q.chatBucket(req.chatID, now); chat != nil {
+				if wait := chat.wait(now); wait > 0 {
+					waiting[req.chatID] = true
+					if soonest == 0 || wait < soonest {
+						soonest = wait
+					}
+					continue
+				}
+				chat.take(now)
+			}
+			q.global.take(now)
+
+			q.pending[priority] = append(q.pending[priority][:i], q.pending[priority][i+1:]...)
+			if req.chatID != 0 {
+				q.inFlight[req.chatID] = true
+			}
+			q.active++
+			return req, 0
+		}
+	}
+	return nil, soonest
+}
+
+// chatBucket returns the bucket for a chat, creating it if needed, or nil for requests that aren't to a chat.
+// Group chats have negative IDs.
+func (q *Queue) chatBucket(chatID int64, now time.Time) *bucket {
+	if chatID == 0 {
+		return nil
+	}
+	if b, ok := q.chats[chatID]; ok {
+		return b
+	}
+	rate := q.limits.PerChat
+	if chatID < 0 {
+		rate = q.limits.PerGroup
+	}
+	b := newBucket(rate, now)
+	q.chats[chatID] = b
+	return b
+}
+
+// forgetIdleChats drops the buckets of chats that have refilled, once there are many of them.
+func (q *Queue) forgetIdleChats(now time.Time) {
+	if len(q.chats) < maxIdleBuckets {
+		return
+	}
+	for chatID, b := range q.chats {
+		if b.full(now) {
+			delete(q.chats, chatID)
+		}
+	}
+}
+
+// sendRequest sends a request, and puts it back at the front of the queue if Telegram asks to retry later.
+func (q *Queue) sendRequest(req *request) {
+	defer q.sending.Done()
+	var r result
+	if req.send {
+		r.msg, r.err = q.api.Send(req.c)
+	} else {
+		r.resp, r.err = q.api.Request(req.c)
+	}
+
+	q.mu.Lock()
+	q.active--
+	delete(q.inFlight, req.chatID)
+	retry := false
+	if wait := retryAfter(r.err); wait > 0 && req.retries < maxRateLimitRetry && !q.stopped {
+		if until := q.now().Add(wait); until.After(q.pausedUntil) {
+			q.pausedUntil = until
+		}
+		req.retries++
+		q.pending[req.priority] = append([]*request{req}, q.pending[req.priority]...)
+		retry = true
+	}
+	q.mu.Unlock()
+
+	if !retry {
+		req.done <- r
+	}
+	q.signal()
+}
+
+// retryAfter returns how long Telegram asked to wait before retrying, or zero if the error isn't a rate limit.
+func retryAfter(err error) time.Duration {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second
+	}
+	return 0
+}
+
+// chatID returns the chat a request is to, or zero if it isn't to a chat.
+func chatID(c tgbotapi.Chattable) int64 {
+	switch v := c.(type) {
+	case tgbotapi.MessageConfig:
+		return v.ChatID
+	case tgbotapi.MediaGroupConfig:
+		return v.ChatID
+	case tgbotapi.PhotoConfig:
+		return v.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		return v.ChatID
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		return v.ChatID
+	case tgbotapi.DeleteMessageConfig:
+		return v.ChatID
+	}
+	return 0
+}
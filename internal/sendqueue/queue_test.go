@@ -0,0 +1,232 @@
+package sendqueue
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingAPI records the text of each message sent, and can fail the first sends to a chat
+type recordingAPI struct {
+	mu       sync.Mutex
+	sent     []string
+	sentAt   []time.Time
+	failures map[int64][]error
+}
+
+func (a *recordingAPI) Send(c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	msg := c.(tgbotapi.MessageConfig)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if errs := a.failures[msg.ChatID]; len(errs) > 0 {
+		a.failures[msg.ChatID] = errs[1:]
+		return tgbotapi.Message{}, errs[0]
+	}
+	a.sent = append(a.sent, msg.Text)
+	a.sentAt = append(a.sentAt, time.Now())
+	return tgbotapi.Message{Text: msg.Text}, nil
+}
+
+func (a *recordingAPI) Request(c tgbotapi.Chattable) (*tgbotapi.APIResponse, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sent = append(a.sent, "request")
+	a.sentAt = append(a.sentAt, time.Now())
+	return &tgbotapi.APIResponse{Ok: true}, nil
+}
+
+func (a *recordingAPI) Sent() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.sent...)
+}
+
+var unlimited = Limits{
+	Global:   Rate{PerSecond: 1000, Burst: 1000},
+	PerChat:  Rate{PerSecond: 1000, Burst: 1000},
+	PerGroup: Rate{PerSecond: 1000, Burst: 1000},
+}
+
+// waitForPending waits until the queue holds n requests
+func waitForPending(t *testing.T, q *Queue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		q.mu.Lock()
+		pending := len(q.pending[interactive]) + len(q.pending[broadcast])
+		q.mu.Unlock()
+		if pending == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d queued requests", n)
+}
+
+func TestInteractiveBeforeBroadcast(t *testing.T) {
+	api := &recordingAPI{}
+	q := New(api, unlimited)
+	q.concurrency = 1
+
+	var wg sync.WaitGroup
+	send := func(sender API, chatID int64, text string) {
+		defer wg.Done()
+		if _, err := sender.Send(tgbotapi.NewMessage(chatID, text)); err != nil {
+			t.Errorf("Send(%q) returned error: %v", text, err)
+		}
+	}
+	wg.Add(3)
+	go send(q.Broadcaster(), 1, "alert")
+	waitForPending(t, q, 1)
+	go send(q.Broadcaster(), 2, "digest")
+	waitForPending(t, q, 2)
+	go send(q, 3, "reply")
+	waitForPending(t, q, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+	wg.Wait()
+
+	if sent := api.Sent(); len(sent) != 3 || sent[0] != "reply" {
+		t.Errorf("Expected the reply to be sent before the broadcasts, got %v", sent)
+	}
+}
+
+func TestPerChatLimitKeepsOrder(t *testing.T) {
+	api := &recordingAPI{}
+	limits := unlimited
+	limits.PerChat = Rate{PerSecond: 20, Burst: 1}
+	q := New(api, limits)
+
+	var wg sync.WaitGroup
+	for i, text := range []string{"first", "second", "third"} {
+		wg.Add(1)
+		go func(text string) {
+			defer wg.Done()
+			q.Send(tgbotapi.NewMessage(1, text))
+		}(text)
+		waitForPending(t, q, i+1)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+	// Another chat isn't held up by chat 1's limit
+	if _, err := q.Send(tgbotapi.NewMessage(2, "other chat")); err != nil {
+		t.Fatalf("Send returned error: %v", err)
+	}
+	wg.Wait()
+
+	sent := api.Sent()
+	if len(sent) != 4 {
+		t.Fatalf("Expected 4 messages, got %v", sent)
+	}
+	var chat1 []string
+	for _, text := range sent {
+		if text != "other chat" {
+			chat1 = append(chat1, text)
+		}
+	}
+	if chat1[0] != "first" || chat1[1] != "second" || chat1[2] != "third" {
+		t.Errorf("Expected chat 1's messages in order, got %v", chat1)
+	}
+	api.mu.Lock()
+	elapsed := api.sentAt[len(api.sentAt)-1].Sub(api.sentAt[0])
+	api.mu.Unlock()
+	if elapsed < 90*time.Millisecond {
+		t.Errorf("Expected 3 messages to one chat at 20 a second to take at least 100ms, took %v", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	rateLimited := &tgbotapi.Error{Code: 429, Message: "Too Many Requests: retry after 1", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 1}}
+	api := &recordingAPI{failures: map[int64][]error{1: {rateLimited}}}
+	q := New(api, unlimited)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	start := time.Now()
+	msg, err := q.Send(tgbotapi.NewMessage(1, "hello"))
+	if err != nil || msg.Text != "hello" {
+		t.Fatalf("Expected the message to be sent after the rate limit, got %+v, %v", msg, err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected the retry to wait for the rate limit, took %v", elapsed)
+	}
+}
+
+func TestErrorsAreReturned(t *testing.T) {
+	blocked := errors.New("Forbidden: bot was blocked by the user")
+	api := &recordingAPI{failures: map[int64][]error{1: {blocked}}}
+	q := New(api, unlimited)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	if _, err := q.Send(tgbotapi.NewMessage(1, "hello")); err != blocked {
+		t.Errorf("Expected the API's error, got %v", err)
+	}
+	if resp, err := q.Request(tgbotapi.NewCallback("1", "Saved")); err != nil || !resp.Ok {
+		t.Errorf("Expected the callback to be answered, got %+v, %v", resp, err)
+	}
+}
+
+func TestStopFailsWaitingRequests(t *testing.T) {
+	api := &recordingAPI{}
+	q := New(api, Limits{Global: unlimited.Global, PerChat: Rate{PerSecond: 0.1, Burst: 1}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		q.Run(ctx)
+		close(stopped)
+	}()
+
+	if _, err := q.Send(tgbotapi.NewMessage(1, "first")); err != nil {
+		t.Fatalf("Expected the first message to be sent, got %v", err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, err := q.Send(tgbotapi.NewMessage(1, "second"))
+		errs <- err
+	}()
+	waitForPending(t, q, 1)
+
+	cancel()
+	<-stopped
+	if err := <-errs; err != ErrStopped {
+		t.Errorf("Expected the waiting message to fail with ErrStopped, got %v", err)
+	}
+	if _, err := q.Send(tgbotapi.NewMessage(2, "late")); err != ErrStopped {
+		t.Errorf("Expected messages after stopping to fail with ErrStopped, got %v", err)
+	}
+	if sent := api.Sent(); len(sent) != 1 {
+		t.Errorf("Expected only the first message to be sent, got %v", sent)
+	}
+}
+
+func TestChatID(t *testing.T) {
+	testCases := []struct {
+		name string
+		c    tgbotapi.Chattable
+		want int64
+	}{
+		{"Message", tgbotapi.NewMessage(5, "hi"), 5},
+		{"Group message", tgbotapi.NewMessage(-100, "hi"), -100},
+		{"Edit", tgbotapi.NewEditMessageText(6, 1, "hi"), 6},
+		{"Media group", tgbotapi.NewMediaGroup(7, nil), 7},
+		{"Callback answer", tgbotapi.NewCallback("1", "ok"), 0},
+	}
+	for _, tc := range testCases {
+		if got := chatID(tc.c); got != tc.want {
+			t.Errorf("%s: chatID() = %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
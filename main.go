@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"imitation_project/internal/bot"
 	"imitation_project/internal/config"
 	"imitation_project/internal/database"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 )
 
 func main() {
@@ -32,6 +37,50 @@ func main() {
 		log.Fatalf("Failed to create bot API: %v", err)
 	}
 
-	b := bot.New(api, db, api.Self.UserName)
-	b.Start()
+	// Cancelled on SIGINT or SIGTERM, so the bot finishes its work and the database is closed before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	b := bot.New(ctx, api, db, api.Self.UserName)
+	if days, ok := config.GetEnvInt("MOVE_IN_TOLERANCE_DAYS"); ok {
+		b.SetMoveInTolerance(days)
+	}
+	b.SetAdmins(config.GetEnvIDs("ADMIN_USER_IDS"))
+	b.SetBlockedUsers(config.GetEnvIDs("BLOCKED_USER_IDS"))
+	if strategy := config.GetEnv("RELAXATION_STRATEGY"); strategy != "" {
+		if err := b.SetRelaxationStrategy(strings.Split(strategy, ",")); err != nil {
+			log.Printf("Error setting relaxation strategy, using the default: %v", err)
+		}
+	}
+	if err := b.RegisterCommands(); err != nil {
+		log.Printf("Error registering commands: %v", err)
+	}
+
+	switch mode := config.GetEnv("BOT_MODE"); mode {
+	case "", "polling":
+		// Telegram won't send updates by polling while a webhook is registered
+		if _, err := api.Request(tgbotapi.DeleteWebhookConfig{}); err != nil {
+			log.Printf("Error removing webhook: %v", err)
+		}
+		b.Start(ctx)
+	case "webhook":
+		cfg := bot.WebhookConfig{
+			URL:        config.GetEnv("WEBHOOK_URL"),
+			ListenAddr: config.GetEnv("WEBHOOK_LISTEN_ADDR"),
+			Secret:     config.GetEnv("WEBHOOK_SECRET"),
+		}
+		if cfg.ListenAddr == "" {
+			cfg.ListenAddr = ":8080"
+		}
+		if err := bot.RegisterWebhook(api, cfg); err != nil {
+			log.Fatalf("Failed to register webhook: %v", err)
+		}
+		if err := b.StartWebhook(ctx, cfg); err != nil {
+			db.Close()
+			log.Fatalf("Webhook server failed: %v", err)
+		}
+	default:
+		log.Fatalf("Unknown BOT_MODE %q, expected polling or webhook", mode)
+	}
+	log.Printf("Stopped")
 }
@@ -2,12 +2,14 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"database/sql"
 	"fmt"
 	"imitation_project/internal/database"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
@@ -24,31 +26,116 @@ func main() {
 		fmt.Printf("Error initialising database: %v\\n", err)
 	}
 
+	ctx := context.Background()
+	propertyTypes, err := database.GetPropertyTypes(ctx, db)
+	if err != nil {
+		fmt.Printf("Error loading property types: %v\n", err)
+		return
+	}
+	var typeCodes []string
+	validTypes := make(map[string]bool)
+	for _, t := range propertyTypes {
+		typeCodes = append(typeCodes, t.Code)
+		validTypes[t.Code] = true
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 
 	for {
 		var p database.Property
 
-		fmt.Print("Enter property type (flat/house): ")
-		p.Type, _ = reader.ReadString('\n')
-		p.Type = strings.TrimSpace(p.Type)
+		for {
+			fmt.Printf("Enter property type (%s): ", strings.Join(typeCodes, "/"))
+			p.Type, _ = reader.ReadString('\n')
+			p.Type = strings.ToLower(strings.TrimSpace(p.Type))
+			if validTypes[p.Type] {
+				break
+			}
+			fmt.Println("Unknown property type, please choose one of the listed types.")
+		}
+
+		fmt.Print("Is this a whole property or a room? (whole/room): ")
+		kind, _ := reader.ReadString('\n')
+		p.ListingKind = database.ListingWhole
+		if strings.ToLower(strings.TrimSpace(kind)) == database.ListingRoom {
+			p.ListingKind = database.ListingRoom
+		}
 
-		fmt.Print("Enter price per month: ")
+		if p.ListingKind == database.ListingRoom {
+			fmt.Print("Enter price per month for the room: ")
+		} else {
+			fmt.Print("Enter price per month: ")
+		}
 		priceStr, _ := reader.ReadString('\n')
 		p.PricePerMonth, _ = strconv.Atoi(strings.TrimSpace(priceStr))
 
-		fmt.Print("Enter number of bedrooms: ")
-		bedroomsStr, _ := reader.ReadString('\n')
-		p.Bedrooms, _ = strconv.Atoi(strings.TrimSpace(bedroomsStr))
+		if p.ListingKind == database.ListingRoom {
+			fmt.Print("Enter the ID of the shared house this room belongs to (empty if none): ")
+			parentStr, _ := reader.ReadString('\n')
+			p.ParentPropertyID, _ = strconv.Atoi(strings.TrimSpace(parentStr))
+
+			fmt.Print("Are bills included? (true/false): ")
+			billsStr, _ := reader.ReadString('\n')
+			p.BillsIncluded, _ = strconv.ParseBool(strings.TrimSpace(billsStr))
+
+			fmt.Print("Enter number of housemates: ")
+			housematesStr, _ := reader.ReadString('\n')
+			p.Housemates, _ = strconv.Atoi(strings.TrimSpace(housematesStr))
+
+			fmt.Print("Is it ensuite? (true/false): ")
+			ensuiteStr, _ := reader.ReadString('\n')
+			p.Ensuite, _ = strconv.ParseBool(strings.TrimSpace(ensuiteStr))
+
+			fmt.Print("Enter shared facilities (comma separated, e.g. kitchen, garden): ")
+			facilitiesStr, _ := reader.ReadString('\n')
+			for _, facility := range strings.Split(facilitiesStr, ",") {
+				if facility = strings.TrimSpace(facility); facility != "" {
+					p.SharedFacilities = append(p.SharedFacilities, facility)
+				}
+			}
+		} else {
+			fmt.Print("Enter number of bedrooms: ")
+			bedroomsStr, _ := reader.ReadString('\n')
+			p.Bedrooms, _ = strconv.Atoi(strings.TrimSpace(bedroomsStr))
+		}
 
 		fmt.Print("Is it furnished? (true/false): ")
 		furnishedStr, _ := reader.ReadString('\n')
 		p.Furnished, _ = strconv.ParseBool(strings.TrimSpace(furnishedStr))
 
+		for {
+			fmt.Print("Enter date available from (YYYY-MM-DD, empty if available now): ")
+			availableStr, _ := reader.ReadString('\n')
+			availableStr = strings.TrimSpace(availableStr)
+			if availableStr == "" {
+				break
+			}
+			p.AvailableFrom, err = time.Parse(database.DateLayout, availableStr)
+			if err == nil {
+				break
+			}
+			fmt.Println("Invalid date, please use the YYYY-MM-DD format.")
+		}
+
+		fmt.Print("Enter minimum tenancy length in months (0 for no minimum): ")
+		minTenancyStr, _ := reader.ReadString('\n')
+		p.MinTenancyMonths, _ = strconv.Atoi(strings.TrimSpace(minTenancyStr))
+
+		fmt.Print("Enter maximum tenancy length in months (0 for no maximum): ")
+		maxTenancyStr, _ := reader.ReadString('\n')
+		p.MaxTenancyMonths, _ = strconv.Atoi(strings.TrimSpace(maxTenancyStr))
+
 		fmt.Print("Enter location: ")
 		p.Location, _ = reader.ReadString('\n')
 		p.Location = strings.TrimSpace(p.Location)
 
+		fmt.Print("Enter latitude and longitude (e.g. 51.3811, -2.3590, empty if unknown): ")
+		coordinatesStr, _ := reader.ReadString('\n')
+		if coordinates := strings.Split(coordinatesStr, ","); len(coordinates) == 2 {
+			p.Latitude, _ = strconv.ParseFloat(strings.TrimSpace(coordinates[0]), 64)
+			p.Longitude, _ = strconv.ParseFloat(strings.TrimSpace(coordinates[1]), 64)
+		}
+
 		fmt.Print("Enter description: ")
 		p.Description, _ = reader.ReadString('\n')
 		p.Description = strings.TrimSpace(p.Description)
@@ -67,7 +154,7 @@ func main() {
 			p.PhotoURLs = append(p.PhotoURLs, photoURL)
 		}
 
-		err := database.AddProperty(db, p)
+		err := database.AddProperty(ctx, db, p)
 		if err != nil {
 			fmt.Printf("Error adding property: %v\n", err)
 		} else {